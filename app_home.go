@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+)
+
+const (
+	// maxHomeQuickDeploys is the maximum number of the quick-deploy buttons on the App Home,
+	// which keeps the view well below the limit of 100 blocks.
+	maxHomeQuickDeploys = 30
+
+	// homeHistoryDepth is how many recent events of each project phase are looked up
+	// to find the projects the user deploys.
+	homeHistoryDepth = 20
+
+	// maxSectionText is the maximum length of the text of a section block.
+	maxSectionText = 3000
+)
+
+// publishHome publishes the App Home tab of the user,
+// which privately shows the user's deploy requests waiting for the approval, the current deploy locks,
+// and the quick-deploy buttons for the user's projects.
+func (s *SlackListener) publishHome(userID string) error {
+	user := s.userList.FindBySlackUserID(userID)
+	lang := s.lang(user, "")
+
+	blocks := []slack.Block{
+		s.homeSection(lang.Text(i18n.HomePendingRequests), s.homePendingRequests(userID, lang)),
+		slack.NewDividerBlock(),
+		s.homeSection(lang.Text(i18n.HomeLocks), s.homeLocks(lang)),
+		slack.NewDividerBlock(),
+	}
+	blocks = append(blocks, s.homeQuickDeploys(user, lang)...)
+
+	view := slack.HomeTabViewRequest{
+		Type:   slack.VTHomeTab,
+		Blocks: slack.Blocks{BlockSet: blocks},
+	}
+	if _, err := s.client.PublishView(userID, view, ""); err != nil {
+		return fmt.Errorf("publish home of %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *SlackListener) homeSection(title string, body string) *slack.SectionBlock {
+	return s.homeText(truncate(title+"\n"+body, maxSectionText-len("...")))
+}
+
+// homePendingRequests describes the deploy requests of the user waiting for the approval.
+func (s *SlackListener) homePendingRequests(userID string, lang i18n.Lang) string {
+	if s.pending == nil {
+		return lang.Text(i18n.NoPendingRequests)
+	}
+
+	reqs, err := s.pending.List(context.Background())
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		return err.Error()
+	}
+
+	var lines []string
+	for _, req := range reqs {
+		if req.Requester != userID {
+			continue
+		}
+		lines = append(lines, lang.Sprintf(i18n.PendingRequestItem, req.Project, req.Phase, deployTarget(lang, req.Branch, req.Tag), req.CreatedAt.Local().Format("2006-01-02 15:04")))
+	}
+	if len(lines) == 0 {
+		return lang.Text(i18n.NoPendingRequests)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// homeLocks describes the current deploy locks as the `describe locks` command does.
+func (s *SlackListener) homeLocks(lang i18n.Lang) string {
+	projects, err := s.coordinator.DescribeLocks(context.Background())
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		return err.Error()
+	}
+
+	msg := deploy.FormatProjectDescs(projects, lang)
+	if msg == "" {
+		return lang.Text(i18n.NoLocks)
+	}
+	return msg
+}
+
+// homeQuickDeploys returns the deploy buttons for the project phases the user has deployed recently,
+// or all the project phases if the user has deployed none of them or the history is not configured.
+// The buttons work as the ones of `@bot deploy staging`.
+func (s *SlackListener) homeQuickDeploys(user User, lang i18n.Lang) []slack.Block {
+	title := s.homeText(lang.Text(i18n.HomeQuickDeploy))
+	if !user.IsDeveloper() {
+		return []slack.Block{title, s.homeText(lang.Text(i18n.NoQuickDeploys))}
+	}
+
+	var all, deployed []projectPhase
+	for _, pj := range s.projectList.Items {
+		for _, phase := range pj.Phases {
+			pp := projectPhase{pj, phase.Name}
+			all = append(all, pp)
+			if s.deployedBy(user, pp) {
+				deployed = append(deployed, pp)
+			}
+		}
+	}
+
+	targets := deployed
+	if len(targets) == 0 {
+		targets = all
+	}
+	if len(targets) == 0 {
+		return []slack.Block{title, s.homeText(lang.Text(i18n.NoQuickDeploys))}
+	}
+	if len(targets) > maxHomeQuickDeploys {
+		targets = targets[:maxHomeQuickDeploys]
+	}
+	// The buttons are grouped by the phase as the projects are listed by `@bot deploy staging`.
+	sort.SliceStable(targets, func(i, j int) bool {
+		return targets[i].phase < targets[j].phase
+	})
+
+	blocks := []slack.Block{title}
+	var lastPhase string
+	for _, t := range targets {
+		if t.phase != lastPhase {
+			blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", "*"+t.phase+"*", false, false)))
+			lastPhase = t.phase
+		}
+		blocks = append(blocks, createDeployButtonSection(t.project, t.phase, lang))
+	}
+	return blocks
+}
+
+func (s *SlackListener) homeText(text string) *slack.SectionBlock {
+	return slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", text, false, false), nil, nil)
+}
+
+// projectPhase is a phase of a project, which is the unit of deployments.
+type projectPhase struct {
+	project DeployProject
+	phase   string
+}
+
+// deployedBy returns true if the user has deployed or rolled back the project phase recently.
+func (s *SlackListener) deployedBy(user User, pp projectPhase) bool {
+	if s.history == nil {
+		return false
+	}
+
+	events, err := s.history.List(context.Background(), pp.project.ID, pp.phase, homeHistoryDepth)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		return false
+	}
+
+	for _, ev := range events {
+		if ev.User == user.SlackDisplayName && (ev.Action == deploy.EventActionDeploy || ev.Action == deploy.EventActionRollback) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+)
+
+func TestHomePendingRequests(t *testing.T) {
+	ctx := context.Background()
+	store := deploy.NewMemoryPendingRequestStore(deploy.DefaultPendingRequestTTL)
+	s := &SlackListener{pending: store}
+
+	require.Equal(t, "No deploy requests waiting for approval", s.homePendingRequests("U1", i18n.English))
+
+	_, err := store.Create(ctx, deploy.PendingRequest{Project: "myproject", Phase: "staging", Branch: "main", Requester: "U1"})
+	require.NoError(t, err)
+	_, err = store.Create(ctx, deploy.PendingRequest{Project: "myproject", Phase: "production", Tag: "v1.0.0", Requester: "U2"})
+	require.NoError(t, err)
+
+	require.Regexp(t, `^\*myproject staging\*: the \*main\* branch, requested at \d{4}-\d{2}-\d{2} \d{2}:\d{2}$`, s.homePendingRequests("U1", i18n.English))
+	require.Regexp(t, `^\*myproject production\*: \*v1.0.0\* タグ \(.+ にリクエスト\)$`, s.homePendingRequests("U2", i18n.Japanese))
+	require.Equal(t, "承認待ちのデプロイリクエストはありません", s.homePendingRequests("U3", i18n.Japanese))
+}
+
+func TestHomeQuickDeploys(t *testing.T) {
+	projectList := &ProjectList{Items: []DeployProject{
+		{ID: "api", Phases: []DeployPhase{{Name: "staging", Kind: "kustomize"}, {Name: "production", Kind: "kustomize"}}},
+		{ID: "web", DisableBranchDeploy: true, Phases: []DeployPhase{{Name: "staging", Kind: "jenkins"}}},
+	}}
+	developer := User{SlackUserID: "U1", SlackDisplayName: "user1", isDeveloper: true}
+
+	// values returns the values of the deploy buttons, and the phases as the group headers.
+	values := func(blocks []slack.Block) (values []string) {
+		for _, b := range blocks[1:] {
+			switch b := b.(type) {
+			case *slack.ContextBlock:
+				values = append(values, b.ContextElements.Elements[0].(*slack.TextBlockObject).Text)
+			case *slack.SectionBlock:
+				if b.Accessory == nil {
+					values = append(values, b.Text.Text)
+				} else {
+					values = append(values, b.Accessory.ButtonElement.Value)
+				}
+			}
+		}
+		return values
+	}
+
+	// All the projects are shown without the history.
+	s := &SlackListener{projectList: projectList}
+	require.Equal(t, []string{
+		"*production*",
+		"wizard|api_production",
+		"*staging*",
+		"wizard|api_staging",
+		`deploy_jenkins_request|{"project":"web","phase":"staging"}`,
+	}, values(s.homeQuickDeploys(developer, i18n.English)))
+
+	// Only the projects the user has deployed are shown with the history.
+	h := deploy.NewFileHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+	s.history = h
+	require.NoError(t, h.Record(context.Background(), deploy.Event{Project: "web", Phase: "staging", Action: deploy.EventActionDeploy, User: "user1"}))
+	require.NoError(t, h.Record(context.Background(), deploy.Event{Project: "api", Phase: "staging", Action: deploy.EventActionLock, User: "user1"}))
+	require.NoError(t, h.Record(context.Background(), deploy.Event{Project: "api", Phase: "production", Action: deploy.EventActionDeploy, User: "user2"}))
+	require.Equal(t, []string{
+		"*staging*",
+		`deploy_jenkins_request|{"project":"web","phase":"staging"}`,
+	}, values(s.homeQuickDeploys(developer, i18n.English)))
+
+	require.Equal(t, []string{"No projects you can deploy"}, values(s.homeQuickDeploys(User{SlackUserID: "U2"}, i18n.English)))
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// approvalPolicy returns the approval policy of the phase of the pending request, or nil if there's none.
+func (i InteractorContext) approvalPolicy(req deploy.PendingRequest) *ApprovalPolicy {
+	if i.projectList == nil {
+		return nil
+	}
+	return i.projectList.Find(req.Project).FindPhase(req.Phase).Approval
+}
+
+// collectApproval adds the approval by the user to the pending request, following the approval policy of the phase.
+//
+// It returns true if the request has got enough approvals so that the deployment can proceed.
+// Otherwise, it returns the blocks to replace the request message with,
+// which show who has approved so far and why the click didn't count if so.
+func (i InteractorContext) collectApproval(req deploy.PendingRequest, userID string) (bool, []slack.Block, error) {
+	policy := i.approvalPolicy(req)
+	if policy == nil {
+		return true, nil, nil
+	}
+
+	required := policy.RequiredApprovals
+	if required < 1 {
+		required = 1
+	}
+
+	lang := i.lang(userID, req.Channel)
+
+	var note string
+	switch {
+	case !policy.AllowSelfApproval && userID == req.Requester:
+		note = lang.Sprintf(i18n.CannotApproveOwnRequest, userID)
+	case !i.isApprover(policy, userID):
+		note = lang.Sprintf(i18n.NotApprover, userID, joinRoles(policy.Approvers))
+	default:
+		// The approval is appended to the current value of the request in the store rather than req,
+		// so that the approvals by multiple approvers at the same time are all counted.
+		// The approvals are stored even when they're enough,
+		// so that any approver can retry the deployment if it fails.
+		var approved bool
+		updated, err := i.pending.Modify(context.Background(), req.ID, func(r *deploy.PendingRequest) error {
+			if approved = r.ApprovedBy(userID); !approved {
+				r.Approvals = append(r.Approvals, deploy.Approval{User: userID, At: metav1.Now()})
+			}
+			return nil
+		})
+		if err != nil {
+			return false, nil, err
+		}
+		req = updated
+		if len(req.Approvals) >= required {
+			return true, nil, nil
+		}
+		if approved {
+			note = lang.Sprintf(i18n.AlreadyApproved, userID)
+		}
+	}
+
+	return false, i.approvalBlocks(lang, req, required, note), nil
+}
+
+// approvePendingRequest collects the approval by the user to the pending request identified by id,
+// and deploys the request with fn once it has got enough approvals.
+//
+// The request is claimed before fn is called, so that it's deployed only once
+// even if the approve button is clicked twice, or by multiple approvers at the same time.
+// It's deleted if fn succeeds, and released to be approved again otherwise.
+func (i InteractorContext) approvePendingRequest(id string, userID string, fn func(req deploy.PendingRequest) ([]slack.Block, error)) ([]slack.Block, error) {
+	req, err := i.pendingRequest(id)
+	if err != nil {
+		return nil, err
+	}
+	if ok, partial, err := i.collectApproval(req, userID); err != nil || !ok {
+		return partial, err
+	}
+
+	req, err = i.claimPendingRequest(req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := fn(req)
+	if err != nil {
+		i.releasePendingRequest(req.ID)
+		return blocks, err
+	}
+	i.deletePendingRequest(req.ID)
+	return blocks, nil
+}
+
+// rejectPendingRequest rejects the pending request identified by id, for the deployments that have nothing
+// to clean up on rejection unlike the GitOps ones closing their pull requests.
+//
+// The request is claimed first so that it can't be rejected while it's being deployed, and the rejection is recorded.
+// The root message of the deploy thread is updated if the request has started one, or the blocks to reply with are returned.
+func (i InteractorContext) rejectPendingRequest(id string, userID string) ([]slack.Block, error) {
+	req, err := i.claimPendingRequest(id)
+	if err != nil {
+		return nil, err
+	}
+	i.deletePendingRequest(req.ID)
+	i.recordEvent(userID, deploy.Event{
+		Project: req.Project,
+		Phase:   req.Phase,
+		Action:  deploy.EventActionReject,
+		Branch:  req.Branch,
+		Tag:     req.Tag,
+		Result:  deploy.EventResultSuccess,
+	})
+
+	lang := i.lang(userID, req.Channel)
+	blocks := i.plainBlocks(lang.Sprintf(i18n.RequestClosed, req.Project, req.Phase, userID))
+	thread := i.requestThread(req, req.Channel)
+	if !thread.started() {
+		return blocks, nil
+	}
+	if err := thread.update(slack.MsgOptionBlocks(blocks...)); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// releasePendingRequest lets the request claimed by approvePendingRequest be approved again.
+func (i InteractorContext) releasePendingRequest(id string) {
+	_, err := i.pending.Modify(context.Background(), id, func(r *deploy.PendingRequest) error {
+		r.Claimed = false
+		return nil
+	})
+	if err != nil && !errors.Is(err, deploy.ErrStaleRequest) {
+		log.Printf("[ERROR] Unable to release the pending deploy request %s: %s", id, err)
+	}
+}
+
+func (i InteractorContext) isApprover(policy *ApprovalPolicy, userID string) bool {
+	if len(policy.Approvers) == 0 {
+		return true
+	}
+
+	user := i.userList.FindBySlackUserID(userID)
+	for _, r := range policy.Approvers {
+		if user.HasRole(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// approvalBlocks renders the pending request waiting for more approvals, with the approve and reject buttons.
+func (i InteractorContext) approvalBlocks(lang i18n.Lang, req deploy.PendingRequest, required int, note string) []slack.Block {
+	var approvers []string
+	for _, a := range req.Approvals {
+		approvers = append(approvers, fmt.Sprintf("<@%s>", a.User))
+	}
+
+	lines := []string{
+		fmt.Sprintf("<@%s>", req.Requester),
+		fmt.Sprintf("*%s*", req.Project),
+		fmt.Sprintf("*%s*", req.Phase),
+		confirmDeploy(lang, req.Branch, req.Tag),
+	}
+	if req.PullRequestNumber != 0 {
+		lines = append(lines, i.pullRequestURL(req.PullRequestNumber))
+	}
+	if len(approvers) > 0 {
+		lines = append(lines, lang.Sprintf(i18n.ApprovedBy, strings.Join(approvers, ", "), len(approvers), required))
+	} else {
+		lines = append(lines, lang.Sprintf(i18n.WaitingForApprovals, required))
+	}
+	if note != "" {
+		lines = append(lines, note)
+	}
+
+	txt := slack.NewTextBlockObject("mrkdwn", strings.Join(lines, "\n"), false, false)
+	btnTxt := slack.NewTextBlockObject("plain_text", lang.Text(i18n.DeployButton), false, false)
+	btn := slack.NewButtonBlockElement("", fmt.Sprintf("%s|%s", i.actionHeader("approve"), req.ID), btnTxt)
+	section := slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn))
+
+	return []slack.Block{section, i.rejectButton(lang, req.ID)}
+}
+
+// rejectButton is the close button of the pending request identified by id.
+// Unlike CloseButton, it rejects the request with the interactor so that the request can't be approved anymore.
+func (i InteractorContext) rejectButton(lang i18n.Lang, id string) *slack.ActionBlock {
+	closeBtnTxt := slack.NewTextBlockObject("plain_text", lang.Text(i18n.CloseButton), false, false)
+	closeBtn := slack.NewButtonBlockElement("", fmt.Sprintf("%s|%s", i.actionHeader("reject"), id), closeBtnTxt)
+	return slack.NewActionBlock("", closeBtn)
+}
+
+func (i InteractorContext) pullRequestURL(number int) string {
+	return fmt.Sprintf("https://github.com/%s/%s/pull/%d", i.github.org, i.github.repo, number)
+}
+
+func joinRoles(roles []Role) string {
+	var s []string
+	for _, r := range roles {
+		s = append(s, string(r))
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+)
+
+func TestCollectApproval(t *testing.T) {
+	projectList := &ProjectList{Items: []DeployProject{
+		{
+			ID: "myproject",
+			Phases: []DeployPhase{
+				{Name: "staging"},
+				{Name: "production", Approval: &ApprovalPolicy{RequiredApprovals: 2, Approvers: []Role{RoleAdmin}}},
+				{Name: "sandbox", Approval: &ApprovalPolicy{AllowSelfApproval: true}},
+			},
+		},
+	}}
+	userList := &UserList{Items: []User{
+		{SlackUserID: "U1", isDeveloper: true},
+		{SlackUserID: "U2", isDeveloper: true},
+		{SlackUserID: "A1", isAdmin: true},
+		{SlackUserID: "A2", isAdmin: true},
+	}}
+
+	type click struct {
+		user string
+		ok   bool
+		// text is expected to be contained in the updated message if not ok.
+		text string
+	}
+
+	testcases := []struct {
+		phase     string
+		requester string
+		clicks    []click
+	}{
+		{
+			phase:     "staging",
+			requester: "U1",
+			clicks:    []click{{user: "U1", ok: true}},
+		},
+		{
+			phase:     "sandbox",
+			requester: "U1",
+			clicks:    []click{{user: "U1", ok: true}},
+		},
+		{
+			phase:     "production",
+			requester: "A1",
+			clicks: []click{
+				{user: "A1", text: "<@A1> cannot approve their own request"},
+				{user: "U2", text: "<@U2> is not allowed to approve this request. Approvers: Admin"},
+				{user: "A2", text: "Approved by <@A2> (1/2)"},
+				{user: "A2", text: "<@A2> has already approved this request"},
+			},
+		},
+		{
+			phase:     "production",
+			requester: "U1",
+			clicks: []click{
+				{user: "A1", text: "Approved by <@A1> (1/2)"},
+				{user: "A2", ok: true},
+				// Retrying after a failed deployment is allowed.
+				{user: "A1", ok: true},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.phase, func(t *testing.T) {
+			store := deploy.NewMemoryPendingRequestStore(deploy.DefaultPendingRequestTTL)
+			i := NewInteractorLambda(InteractorContext{projectList: projectList, userList: userList, pending: store, languages: NewLanguageList(i18n.English)})
+
+			req, err := store.Create(context.Background(), deploy.PendingRequest{Project: "myproject", Phase: tc.phase, Branch: "master", Requester: tc.requester})
+			require.NoError(t, err)
+
+			for _, c := range tc.clicks {
+				req, err = store.Get(context.Background(), req.ID)
+				require.NoError(t, err)
+
+				ok, blocks, err := i.collectApproval(req, c.user)
+				require.NoError(t, err)
+				require.Equal(t, c.ok, ok, c.user)
+				if c.ok {
+					continue
+				}
+
+				section := blocks[0].(*slack.SectionBlock)
+				require.Contains(t, section.Text.Text, c.text)
+				require.Equal(t, "deploy_lambda_approve|"+req.ID, section.Accessory.ButtonElement.Value)
+			}
+		})
+	}
+}
+
+func TestCollectApprovalConcurrently(t *testing.T) {
+	projectList := &ProjectList{Items: []DeployProject{
+		{ID: "myproject", Phases: []DeployPhase{{Name: "production", Approval: &ApprovalPolicy{RequiredApprovals: 3}}}},
+	}}
+	store := deploy.NewMemoryPendingRequestStore(deploy.DefaultPendingRequestTTL)
+	i := InteractorContext{projectList: projectList, userList: &UserList{}, pending: store, languages: NewLanguageList(i18n.English)}
+
+	req, err := store.Create(context.Background(), deploy.PendingRequest{Project: "myproject", Phase: "production", Requester: "U1"})
+	require.NoError(t, err)
+
+	// Both approvals are counted even though they're made on the request read before the other.
+	_, _, err = i.collectApproval(req, "A1")
+	require.NoError(t, err)
+	_, _, err = i.collectApproval(req, "A2")
+	require.NoError(t, err)
+
+	got, err := store.Get(context.Background(), req.ID)
+	require.NoError(t, err)
+	require.True(t, got.ApprovedBy("A1"))
+	require.True(t, got.ApprovedBy("A2"))
+}
+
+func TestApprovePendingRequest(t *testing.T) {
+	store := deploy.NewMemoryPendingRequestStore(deploy.DefaultPendingRequestTTL)
+	i := InteractorContext{pending: store}
+
+	req, err := store.Create(context.Background(), deploy.PendingRequest{Project: "myproject", Phase: "staging", Requester: "U1"})
+	require.NoError(t, err)
+
+	// The request being deployed can't be approved again.
+	var deployed int
+	_, err = i.approvePendingRequest(req.ID, "U1", func(r deploy.PendingRequest) ([]slack.Block, error) {
+		deployed++
+		require.True(t, r.Claimed)
+		_, err := i.approvePendingRequest(req.ID, "U2", func(deploy.PendingRequest) ([]slack.Block, error) {
+			deployed++
+			return nil, nil
+		})
+		require.ErrorIs(t, err, deploy.ErrRequestInProgress)
+		return nil, errors.New("failed")
+	})
+	require.EqualError(t, err, "failed")
+	require.Equal(t, 1, deployed)
+
+	// The request is released to be approved again after the failure, and deleted after the success.
+	_, err = i.approvePendingRequest(req.ID, "U1", func(deploy.PendingRequest) ([]slack.Block, error) {
+		deployed++
+		return nil, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, deployed)
+
+	_, err = store.Get(context.Background(), req.ID)
+	require.ErrorIs(t, err, deploy.ErrStaleRequest)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultArgoCDPollInterval = 10 * time.Second
+	DefaultArgoCDSyncTimeout  = 15 * time.Minute
+)
+
+const (
+	ArgoCDSyncStatusSynced     = "Synced"
+	ArgoCDHealthStatusHealthy  = "Healthy"
+	ArgoCDHealthStatusDegraded = "Degraded"
+)
+
+// ArgoCD is a client of the ArgoCD API, used to follow the sync of an application after a GitOps deployment.
+// A nil ArgoCD means that ArgoCD is not configured.
+type ArgoCD struct {
+	host   string
+	token  string
+	client *http.Client
+
+	// PollInterval is the interval between the requests for the application status in Watch.
+	PollInterval time.Duration
+	// Timeout is how long Watch waits for the application to become healthy.
+	Timeout time.Duration
+}
+
+// NewArgoCD returns the client of the ArgoCD at the host, or nil if either the host or the token is empty.
+// The token is an ArgoCD API token of an account that is allowed to get the applications.
+func NewArgoCD(host, token string) *ArgoCD {
+	if host == "" || token == "" {
+		return nil
+	}
+
+	return &ArgoCD{
+		host:         strings.TrimSuffix(host, "/"),
+		token:        token,
+		client:       &http.Client{Timeout: 30 * time.Second},
+		PollInterval: DefaultArgoCDPollInterval,
+		Timeout:      DefaultArgoCDSyncTimeout,
+	}
+}
+
+// ApplicationURL returns the URL of the application on the ArgoCD UI.
+func (a *ArgoCD) ApplicationURL(app string) string {
+	return a.host + "/applications/" + url.PathEscape(app)
+}
+
+// ArgoCDApplicationStatus is the subset of the status of an ArgoCD application that gocat reports to Slack.
+type ArgoCDApplicationStatus struct {
+	// Sync is the sync status like `Synced` and `OutOfSync`.
+	Sync string
+	// Revision is the commit of the manifest repository the application is synced to.
+	Revision string
+	// Health is the health status like `Healthy`, `Progressing` and `Degraded`.
+	Health string
+	// OperationPhase is the phase of the last sync operation like `Running`, `Succeeded` and `Failed`.
+	OperationPhase string
+	Message        string
+}
+
+func (s ArgoCDApplicationStatus) String() string {
+	str := fmt.Sprintf("Sync: %s / Health: %s", s.Sync, s.Health)
+	if s.OperationPhase != "" {
+		str += fmt.Sprintf(" / Operation: %s", s.OperationPhase)
+	}
+	if s.Message != "" {
+		str += "\n" + s.Message
+	}
+	return str
+}
+
+// Healthy returns true if the application is synced and healthy.
+func (s ArgoCDApplicationStatus) Healthy() bool {
+	return s.Sync == ArgoCDSyncStatusSynced && s.Health == ArgoCDHealthStatusHealthy
+}
+
+// Failed returns true if the application is degraded or the sync operation has failed.
+func (s ArgoCDApplicationStatus) Failed() bool {
+	return s.Health == ArgoCDHealthStatusDegraded || s.OperationPhase == "Failed" || s.OperationPhase == "Error"
+}
+
+type argoCDApplication struct {
+	Status struct {
+		Sync struct {
+			Status   string `json:"status"`
+			Revision string `json:"revision"`
+		} `json:"sync"`
+		Health struct {
+			Status string `json:"status"`
+		} `json:"health"`
+		OperationState *struct {
+			Phase   string `json:"phase"`
+			Message string `json:"message"`
+		} `json:"operationState"`
+	} `json:"status"`
+}
+
+// GetApplicationStatus returns the current status of the application.
+func (a *ArgoCD) GetApplicationStatus(ctx context.Context, app string) (ArgoCDApplicationStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.host+"/api/v1/applications/"+url.PathEscape(app), nil)
+	if err != nil {
+		return ArgoCDApplicationStatus{}, fmt.Errorf("[ERROR] Invalid ArgoCD host %q: %w", a.host, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return ArgoCDApplicationStatus{}, fmt.Errorf("[ERROR] Failed to get the ArgoCD application %s: %w", app, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ArgoCDApplicationStatus{}, fmt.Errorf("[ERROR] Failed to read the ArgoCD application %s: %w", app, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return ArgoCDApplicationStatus{}, fmt.Errorf("[ERROR] Unexpected status %d from ArgoCD for the application %s: %s", resp.StatusCode, app, truncate(string(body), 200))
+	}
+
+	var v argoCDApplication
+	if err := json.Unmarshal(body, &v); err != nil {
+		return ArgoCDApplicationStatus{}, fmt.Errorf("[ERROR] Invalid ArgoCD application %s: %w", app, err)
+	}
+
+	status := ArgoCDApplicationStatus{
+		Sync:     v.Status.Sync.Status,
+		Revision: v.Status.Sync.Revision,
+		Health:   v.Status.Health.Status,
+	}
+	if op := v.Status.OperationState; op != nil {
+		status.OperationPhase = op.Phase
+		status.Message = op.Message
+	}
+	return status, nil
+}
+
+// Watch polls the status of the application until it gets synced to the deployed revision
+// and becomes either healthy or failed, and returns the last status.
+//
+// before is the revision the application was synced to before the deployment,
+// and after is the revision deployed, like the merge commit of the pull request.
+// The deployed revision is either after or, as later commits may have been merged meanwhile, any revision other than before.
+// Either can be empty if unknown, but ErrUnknownRevision is returned if both are.
+// See poll for how onChange is called and the timeout is handled.
+func (a *ArgoCD) Watch(ctx context.Context, app string, before string, after string, onChange func(ArgoCDApplicationStatus)) (ArgoCDApplicationStatus, error) {
+	if before == "" && after == "" {
+		return ArgoCDApplicationStatus{}, ErrUnknownRevision
+	}
+
+	get := func(ctx context.Context) (ArgoCDApplicationStatus, error) {
+		return a.GetApplicationStatus(ctx, app)
+	}
+	status, err := poll(ctx, a.PollInterval, a.Timeout, get, onChange, func(status ArgoCDApplicationStatus) bool {
+		synced := (after != "" && status.Revision == after) || (before != "" && status.Revision != before)
+		return synced && (status.Healthy() || status.Failed())
+	})
+	if err != nil {
+		return status, fmt.Errorf("[ERROR] Timed out waiting for the ArgoCD application %s to be synced: %w", app, err)
+	}
+	return status, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/i18n"
+)
+
+// fakeArgoCD serves the statuses of the application `myapp` in order, repeating the last one.
+type fakeArgoCD struct {
+	mu       sync.Mutex
+	statuses []string
+}
+
+func (f *fakeArgoCD) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer mytoken" {
+		http.Error(w, `{"error": "invalid session"}`, http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path != "/api/v1/applications/myapp" {
+		http.Error(w, `{"error": "not found"}`, http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status := f.statuses[0]
+	if len(f.statuses) > 1 {
+		f.statuses = f.statuses[1:]
+	}
+	_, _ = w.Write([]byte(status))
+}
+
+func argoCDStatus(sync, revision, health, phase string) string {
+	return fmt.Sprintf(`{"metadata": {"name": "myapp"}, "status": {"sync": {"status": %q, "revision": %q}, "health": {"status": %q}, "operationState": {"phase": %q}}}`, sync, revision, health, phase)
+}
+
+func newTestArgoCD(t *testing.T, statuses ...string) *ArgoCD {
+	ts := httptest.NewServer(&fakeArgoCD{statuses: statuses})
+	t.Cleanup(ts.Close)
+
+	a := NewArgoCD(ts.URL+"/", "mytoken")
+	a.PollInterval = time.Millisecond
+	a.Timeout = time.Second
+	return a
+}
+
+func TestArgoCDGetApplicationStatus(t *testing.T) {
+	a := newTestArgoCD(t, argoCDStatus("Synced", "abc", "Healthy", "Succeeded"))
+
+	status, err := a.GetApplicationStatus(context.Background(), "myapp")
+	require.NoError(t, err)
+	require.Equal(t, ArgoCDApplicationStatus{Sync: "Synced", Revision: "abc", Health: "Healthy", OperationPhase: "Succeeded"}, status)
+	require.True(t, status.Healthy())
+
+	_, err = a.GetApplicationStatus(context.Background(), "unknown")
+	require.ErrorContains(t, err, "Unexpected status 404")
+
+	a.token = "invalid"
+	_, err = a.GetApplicationStatus(context.Background(), "myapp")
+	require.ErrorContains(t, err, "Unexpected status 401")
+
+	require.Nil(t, NewArgoCD("https://argocd.example.com", ""))
+	require.Equal(t, "https://argocd.example.com/applications/myapp", NewArgoCD("https://argocd.example.com/", "token").ApplicationURL("myapp"))
+}
+
+func TestArgoCDWatch(t *testing.T) {
+	testcases := []struct {
+		subject  string
+		statuses []string
+		before   string
+		after    string
+		want     string
+		changes  int
+		wantErr  error
+	}{
+		{
+			subject: "healthy",
+			before:  "abc",
+			statuses: []string{
+				// The previous revision is healthy until ArgoCD notices the merge.
+				argoCDStatus("Synced", "abc", "Healthy", "Succeeded"),
+				argoCDStatus("OutOfSync", "def", "Healthy", "Succeeded"),
+				argoCDStatus("Synced", "def", "Progressing", "Running"),
+				argoCDStatus("Synced", "def", "Progressing", "Running"),
+				argoCDStatus("Synced", "def", "Healthy", "Succeeded"),
+			},
+			want:    "Healthy",
+			changes: 4,
+		},
+		{
+			subject: "degraded",
+			before:  "abc",
+			statuses: []string{
+				argoCDStatus("Synced", "def", "Progressing", "Running"),
+				argoCDStatus("Synced", "def", "Degraded", "Succeeded"),
+			},
+			want:    "Degraded",
+			changes: 2,
+		},
+		{
+			subject: "timeout",
+			before:  "abc",
+			statuses: []string{
+				argoCDStatus("Synced", "abc", "Healthy", "Succeeded"),
+			},
+			want:    "Healthy",
+			changes: 1,
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			subject: "unknown revision before the deployment",
+			statuses: []string{
+				// The previous revision isn't reported as the deployment though it is healthy.
+				argoCDStatus("Synced", "abc", "Healthy", "Succeeded"),
+				argoCDStatus("Synced", "def", "Progressing", "Running"),
+				argoCDStatus("Synced", "def", "Healthy", "Succeeded"),
+			},
+			after:   "def",
+			want:    "Healthy",
+			changes: 3,
+		},
+		{
+			subject: "unknown revisions",
+			statuses: []string{
+				argoCDStatus("Synced", "abc", "Healthy", "Succeeded"),
+			},
+			changes: 0,
+			wantErr: ErrUnknownRevision,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.subject, func(t *testing.T) {
+			a := newTestArgoCD(t, tc.statuses...)
+			a.Timeout = 100 * time.Millisecond
+
+			var changes []ArgoCDApplicationStatus
+			status, err := a.Watch(context.Background(), "myapp", tc.before, tc.after, func(s ArgoCDApplicationStatus) {
+				changes = append(changes, s)
+			})
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.want, status.Health)
+			require.Len(t, changes, tc.changes)
+		})
+	}
+}
+
+func TestInteractorGitOpsTrackArgoCDSync(t *testing.T) {
+	type call struct {
+		method   string
+		threadTS string
+		text     string
+	}
+	var (
+		mu    sync.Mutex
+		calls []call
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		var blocks []block
+		_ = json.Unmarshal([]byte(r.FormValue("blocks")), &blocks)
+		calls = append(calls, call{method: r.URL.Path, threadTS: r.FormValue("thread_ts"), text: message{Blocks: blocks}.Text().(string)})
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"ok": true, "channel": "C1234", "ts": "1234.5678"}`))
+	}))
+	defer ts.Close()
+
+	a := newTestArgoCD(t,
+		argoCDStatus("OutOfSync", "def", "Healthy", "Running"),
+		argoCDStatus("Synced", "def", "Degraded", "Succeeded"),
+	)
+	i := NewInteractorKustomize(InteractorContext{client: NewSlackClient(slack.New("token", slack.OptionAPIURL(ts.URL+"/api/"))), argocd: a, languages: NewLanguageList(i18n.English)})
+
+	i.trackArgoCDSync(context.Background(), newDeployThread(i.client, "C1234", ""), nil, "myapp", "abc", "")
+
+	require.Len(t, calls, 4)
+	require.Equal(t, "/api/chat.postMessage", calls[0].method)
+	require.Contains(t, calls[0].text, "Waiting for ArgoCD to sync")
+	require.Equal(t, "/api/chat.postMessage", calls[1].method)
+	require.Equal(t, "1234.5678", calls[1].threadTS)
+	require.Contains(t, calls[1].text, "Sync: OutOfSync / Health: Healthy / Operation: Running")
+	require.Equal(t, "1234.5678", calls[2].threadTS)
+	require.Contains(t, calls[2].text, "Sync: Synced / Health: Degraded")
+	require.Equal(t, "/api/chat.update", calls[3].method)
+	require.Contains(t, calls[3].text, ":x: <"+a.ApplicationURL("myapp")+"|myapp> is Degraded")
+}
@@ -13,10 +13,13 @@ import (
 type slackConfig struct {
 	OauthToken          string `json:"SLACK_BOT_OAUTH_TOKEN"`
 	VerificationToken   string `json:"SLACK_BOT_API_VERIFICATION_TOKEN"`
+	SigningSecret       string `json:"SLACK_SIGNING_SECRET"`
+	AppToken            string `json:"SLACK_APP_TOKEN"`
 	JenkinsBotUserToken string `json:"JENKINS_BOT_USER_TOKEN"`
 	JenkinsJobToken     string `json:"JENKINS_JOB_TOKEN"`
 	GitHubBotUserToken  string `json:"GITHUB_BOT_USER_TOKEN"`
 	ArgoCDHost          string `json:"ARGOCD_HOST"`
+	ArgoCDToken         string `json:"ARGOCD_TOKEN"`
 
 	AppRepositoryGitHubAccessToken string `json:"APP_REPOSITORY_GITHUB_ACCESS_TOKEN"`
 }
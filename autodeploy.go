@@ -2,22 +2,41 @@ package main
 
 import (
 	"log"
+	"sync"
 	"time"
 
 	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type AutoDeploy struct {
-	client      *slack.Client
+	client      SlackClient
 	github      *GitHub
 	git         *GitOperator
 	projectList *ProjectList
 	modelList   *DeployModelList
+	history     deploy.History
+	guard       *DeployGuard
+	kubernetes  *deploy.Kubernetes
+	languages   *LanguageList
+
+	// skipped is the map from `<project>/<phase>` to the last skippedDeploy reported.
+	skipped *sync.Map
+}
+
+// skippedDeploy is the auto deployment of the tag that was skipped, and reported as the root message of its thread.
+// The thread is taken over by the deployment of the tag once it's no longer skipped.
+type skippedDeploy struct {
+	tag    string
+	cause  error
+	thread *deployThread
 }
 
-func NewAutoDeploy(client *slack.Client, github *GitHub, git *GitOperator, projectList *ProjectList) AutoDeploy {
-	ml := NewDeployModelList(github, git, projectList)
-	return AutoDeploy{client, github, git, projectList, ml}
+func NewAutoDeploy(client SlackClient, github *GitHub, git *GitOperator, projectList *ProjectList, history deploy.History, guard *DeployGuard, k *deploy.Kubernetes, languages *LanguageList) AutoDeploy {
+	ml := NewDeployModelList(github, git, projectList, k)
+	return AutoDeploy{client, github, git, projectList, ml, history, guard, k, languages, &sync.Map{}}
 }
 
 func (a AutoDeploy) Watch(sec int64) {
@@ -42,45 +61,118 @@ func (a AutoDeploy) CheckAndDeploy(sec int64, dp DeployProject, phase DeployPhas
 }
 
 func (a AutoDeploy) checkAndDeploy(dp DeployProject, phase DeployPhase) {
-	ecr, err := CreateECRInstance()
-	if err != nil {
-		log.Print(err)
-		return
-	}
-
-	currentTag, err := phase.Destination.GetCurrentRevision(GetCurrentRevisionInput{github: a.github})
+	currentTag, err := phase.Destination.GetCurrentRevision(GetCurrentRevisionInput{github: a.github, kubernetes: a.kubernetes, aws: dp.AWSConfig(phase.Name)})
 	if err != nil {
 		log.Print(err)
 		return
 	}
-	tag, err := ecr.FindImageTagByRegexp(dp.ECRRegistryId(), dp.ECRRepository(), dp.ImageTagRegexp(), dp.TargetRegexp(), ImageTagVars{Branch: dp.DefaultBranch()})
+	tag, err := findImageTag(dp, ImageTagVars{Branch: dp.DefaultBranch(), Phase: phase.Name})
 	if currentTag == tag || err != nil {
 		log.Printf("[INFO] Auto Deploy (%s:%s) is skipped", dp.ID, phase.Name)
 		return
 	}
 
+	if err := a.guard.Check(dp.ID, phase.Name, ""); err != nil {
+		log.Printf("[INFO] Auto Deploy (%s:%s) is skipped: %s", dp.ID, phase.Name, err)
+		a.reportSkipped(dp, phase, tag, err)
+		return
+	}
+
+	a.deploy(dp, phase, tag)
+}
+
+// deploy deploys the tag of the default branch to the phase.
+// The deployment is reported in a thread of the phase's channel,
+// whose root message is updated from in progress to the result, with the error replied in the thread if it fails.
+func (a AutoDeploy) deploy(dp DeployProject, phase DeployPhase, tag string) {
 	log.Printf("[INFO] Auto Deploy (%s:%s) is started", dp.ID, phase.Name)
 	model, err := a.modelList.Find(phase.Kind)
 	if err != nil {
 		log.Print(err)
 		return
 	}
+
+	lang := a.lang(phase)
+	fields := a.fields(lang, dp, phase, tag)
+	thread := a.thread(dp, phase, tag)
+	a.notify(thread, slack.Attachment{Color: "#daa038", Title: lang.Text(i18n.AutoDeploying), Fields: fields})
+
+	start := time.Now()
 	_, err = model.Deploy(dp, phase.Name, DeployOption{Branch: dp.DefaultBranch(), Wait: true})
+	recordEvent(a.history, deploy.Event{
+		Project:  dp.ID,
+		Phase:    phase.Name,
+		Action:   deploy.EventActionAutoDeploy,
+		User:     "autodeploy",
+		Tag:      tag,
+		Branch:   dp.DefaultBranch(),
+		Result:   eventResult(err),
+		Message:  errString(err),
+		Duration: metav1.Duration{Duration: time.Since(start)},
+	})
 	if err != nil {
 		log.Print(err)
+		a.notify(thread, slack.Attachment{Color: "#e01e5a", Title: lang.Text(i18n.AutoDeployFailed), Fields: fields})
+		if thread.started() {
+			thread.reply(slack.MsgOptionText(err.Error(), false))
+		}
 		return
 	}
-	if phase.NotifyChannel != "" {
-		fields := []slack.AttachmentField{
-			{Title: "Project", Value: dp.ID, Short: true},
-			{Title: "Phase", Value: phase.Name, Short: true},
-			{Title: "Tag", Value: tag, Short: true},
-		}
-		msg := slack.Attachment{Color: "#36a64f", Title: ":white_check_mark: Succeed to auto deploy", Fields: fields}
-		_, _, err = a.client.PostMessage(phase.NotifyChannel, slack.MsgOptionAttachments(msg))
-		if err != nil {
-			log.Print(err)
-			return
-		}
+	a.notify(thread, slack.Attachment{Color: "#36a64f", Title: lang.Text(i18n.AutoDeploySucceeded), Fields: fields})
+}
+
+// lang returns the language of the phase's channel to notify the auto deployments in.
+func (a AutoDeploy) lang(phase DeployPhase) i18n.Lang {
+	return a.languages.Lang("", phase.NotifyChannel)
+}
+
+// fields returns the fields of the notifications of the auto deployment of the tag to the phase.
+func (a AutoDeploy) fields(lang i18n.Lang, dp DeployProject, phase DeployPhase, tag string) []slack.AttachmentField {
+	return []slack.AttachmentField{
+		{Title: lang.Text(i18n.ProjectField), Value: dp.ID, Short: true},
+		{Title: lang.Text(i18n.PhaseField), Value: phase.Name, Short: true},
+		{Title: lang.Text(i18n.TagField), Value: tag, Short: true},
+	}
+}
+
+// notify updates the root message of the thread in the phase's channel with the state of the auto deployment.
+func (a AutoDeploy) notify(thread *deployThread, msg slack.Attachment) {
+	if thread.channel == "" {
+		return
 	}
+	if err := thread.update(slack.MsgOptionAttachments(msg)); err != nil {
+		log.Print(err)
+	}
+}
+
+// thread returns the thread of the auto deployment of the tag to the phase.
+// It's the thread where the deployment was reported to be skipped if any, with the reason replied in it,
+// so that the skipped deployment and the one after it take only one message in the channel.
+func (a AutoDeploy) thread(dp DeployProject, phase DeployPhase, tag string) *deployThread {
+	last, ok := a.skipped.LoadAndDelete(dp.ID + "/" + phase.Name)
+	if !ok || last.(skippedDeploy).tag != tag {
+		return newDeployThread(a.client, phase.NotifyChannel, "")
+	}
+
+	skipped := last.(skippedDeploy)
+	if skipped.thread.started() {
+		lang := a.lang(phase)
+		skipped.thread.reply(slack.MsgOptionText(lang.Sprintf(i18n.AutoDeploySkippedFor, errorText(lang, skipped.cause)), false))
+	}
+	return skipped.thread
+}
+
+// reportSkipped notifies the phase's channel that the auto deployment of the tag was skipped, in a new thread.
+// It is reported only once per tag, so that the channel is not flooded every interval while the phase is locked.
+func (a AutoDeploy) reportSkipped(dp DeployProject, phase DeployPhase, tag string, cause error) {
+	key := dp.ID + "/" + phase.Name
+	if last, ok := a.skipped.Load(key); ok && last.(skippedDeploy).tag == tag {
+		return
+	}
+	thread := newDeployThread(a.client, phase.NotifyChannel, "")
+	a.skipped.Store(key, skippedDeploy{tag: tag, cause: cause, thread: thread})
+
+	lang := a.lang(phase)
+	fields := append(a.fields(lang, dp, phase, tag), slack.AttachmentField{Title: lang.Text(i18n.ReasonField), Value: errorText(lang, cause)})
+	a.notify(thread, slack.Attachment{Color: "#daa038", Title: lang.Text(i18n.AutoDeploySkipped), Fields: fields})
 }
@@ -1,76 +1,147 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
-	"log"
-	"regexp"
-	"strings"
-	"text/template"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/aws/aws-sdk-go/service/ecs"
 	"github.com/aws/aws-sdk-go/service/lambda"
 )
 
-type ECRClient struct {
-	client *ecr.ECR
+const DefaultAWSRegion = "ap-northeast-1"
+
+// AWSConfig is the region and the role used to access AWS.
+// The empty fields are filled with the global settings when the clients are created.
+type AWSConfig struct {
+	Region string
+	// RoleArn is the ARN of the IAM role to assume. The credentials of gocat are used as is if empty.
+	RoleArn string
 }
 
-type ImageTagVars struct {
-	Branch string
-	Phase  string
+// Or returns the config with the empty fields filled with the ones of the other.
+func (c AWSConfig) Or(other AWSConfig) AWSConfig {
+	if c.Region == "" {
+		c.Region = other.Region
+	}
+	if c.RoleArn == "" {
+		c.RoleArn = other.RoleArn
+	}
+	return c
 }
 
-func (self ImageTagVars) Parse(s string) (string, error) {
-	b := bytes.NewBuffer([]byte(""))
-	tmpl, err := template.New("").Parse(s)
-	if err != nil {
-		return "", err
+// withRegionOf returns the config with the region of the resource ARN, if the ARN is valid and has a region,
+// as the resource can be accessed only in its region.
+func (c AWSConfig) withRegionOf(resource string) AWSConfig {
+	if a, err := arn.Parse(resource); err == nil && a.Region != "" {
+		c.Region = a.Region
+	}
+	return c
+}
+
+// AWSClients creates the AWS clients and caches them per region and role,
+// so that sessions and assumed role credentials are reused across deployments.
+type AWSClients struct {
+	mu       sync.Mutex
+	defaults AWSConfig
+	clients  map[AWSConfig]*awsClientSet
+}
+
+type awsClientSet struct {
+	ecr    *ecr.ECR
+	lambda *lambda.Lambda
+	ecs    *ecs.ECS
+}
+
+func NewAWSClients(defaults AWSConfig) *AWSClients {
+	return &AWSClients{
+		defaults: defaults.Or(AWSConfig{Region: DefaultAWSRegion}),
+		clients:  map[AWSConfig]*awsClientSet{},
 	}
-	err = tmpl.Execute(b, self)
-	return b.String(), err
 }
 
-func CreateECRInstance() (ECRClient, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String("ap-northeast-1")},
-	)
+// awsClients is shared by all the deployments. The defaults are set from CatConfig on startup.
+var awsClients = NewAWSClients(AWSConfig{})
+
+// SetDefaults sets the global region and role used when a project doesn't specify them.
+func (c *AWSClients) SetDefaults(defaults AWSConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.defaults = defaults.Or(AWSConfig{Region: DefaultAWSRegion})
+}
+
+func (c *AWSClients) get(config AWSConfig) (*awsClientSet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	config = config.Or(c.defaults)
+	if set, ok := c.clients[config]; ok {
+		return set, nil
+	}
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(config.Region)})
 	if err != nil {
-		return ECRClient{}, err
+		return nil, err
 	}
-	return ECRClient{client: ecr.New(sess, aws.NewConfig().WithRegion("ap-northeast-1"))}, nil
+	if config.RoleArn != "" {
+		// The credentials are refreshed by assuming the role again before they expire.
+		sess = sess.Copy(&aws.Config{Credentials: stscreds.NewCredentials(sess, config.RoleArn)})
+	}
+
+	set := &awsClientSet{ecr: ecr.New(sess), lambda: lambda.New(sess), ecs: ecs.New(sess)}
+	c.clients[config] = set
+	return set, nil
+}
+
+type ECRClient struct {
+	client *ecr.ECR
 }
 
-func (e ECRClient) FindImageTagByRegexp(registryId string, repo string, rawFilterRegexp string, rawTargetRegexp string, vars ImageTagVars) (string, error) {
-	vars.Branch = strings.Replace(vars.Branch, "/", "_", -1)
-	filterRegexp, err := vars.Parse(rawFilterRegexp)
+func CreateECRInstance(config AWSConfig) (ECRClient, error) {
+	set, err := awsClients.get(config)
 	if err != nil {
-		return "", fmt.Errorf("[ERROR] filterRegexp cannot be parsed: %s", rawFilterRegexp)
+		return ECRClient{}, err
 	}
-	targetRegexp, err := vars.Parse(rawTargetRegexp)
+	return ECRClient{client: set.ecr}, nil
+}
+
+// ECRRegistry is the ImageRegistry of the ECR repositories of a registry, which is an AWS account.
+type ECRRegistry struct {
+	ECRClient
+	RegistryID string
+}
+
+func (e ECRRegistry) ListImages(repository string, match func(tag string) bool, related func(tag string) bool) ([]Image, error) {
+	details, err := e.describeImages(&e.RegistryID, &repository, nil)
 	if err != nil {
-		return "", fmt.Errorf("[ERROR] targetRegexp cannot be parsed: %s", rawTargetRegexp)
+		return nil, err
 	}
-	arr := e.describeImages(&registryId, &repo, nil)
-	for _, v := range arr {
-		for _, vv1 := range v.ImageTags {
-			if regexp.MustCompile(filterRegexp).FindStringSubmatch(*vv1) == nil {
-				continue
-			}
-			for _, vv2 := range v.ImageTags {
-				if regexp.MustCompile(targetRegexp).FindStringSubmatch(*vv2) != nil {
-					return *vv2, nil
-				}
+
+	var images []Image
+	for _, d := range details {
+		image := Image{Digest: aws.StringValue(d.ImageDigest), PushedAt: aws.TimeValue(d.ImagePushedAt)}
+		var others []string
+		for _, tag := range d.ImageTags {
+			if match(*tag) {
+				image.Tags = append(image.Tags, *tag)
+			} else if related != nil && related(*tag) {
+				others = append(others, *tag)
 			}
 		}
+		if len(image.Tags) > 0 {
+			image.Tags = append(image.Tags, others...)
+			images = append(images, image)
+		}
 	}
-	return "", fmt.Errorf("[ERROR] NotFound specified image tag")
+	return images, nil
 }
 
-func (e ECRClient) describeImages(registryId *string, repo *string, nextToken *string) []*ecr.ImageDetail {
+func (e ECRClient) describeImages(registryId *string, repo *string, nextToken *string) ([]*ecr.ImageDetail, error) {
 	input := &ecr.DescribeImagesInput{
 		RegistryId:     registryId,
 		RepositoryName: repo,
@@ -78,27 +149,28 @@ func (e ECRClient) describeImages(registryId *string, repo *string, nextToken *s
 	}
 	outputs, err := e.client.DescribeImages(input)
 	if err != nil {
-		log.Printf("Failed to describe images: %v", err)
-		return []*ecr.ImageDetail{}
+		return nil, fmt.Errorf("[ERROR] Failed to describe images of %s: %w", *repo, err)
 	}
 	if outputs.NextToken != nil {
-		return append(outputs.ImageDetails, e.describeImages(registryId, repo, outputs.NextToken)...)
+		next, err := e.describeImages(registryId, repo, outputs.NextToken)
+		if err != nil {
+			return nil, err
+		}
+		return append(outputs.ImageDetails, next...), nil
 	}
-	return outputs.ImageDetails
+	return outputs.ImageDetails, nil
 }
 
 type LambdaClient struct {
 	client *lambda.Lambda
 }
 
-func CreateLambdaInstance() (LambdaClient, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String("ap-northeast-1")},
-	)
+func CreateLambdaInstance(config AWSConfig) (LambdaClient, error) {
+	set, err := awsClients.get(config)
 	if err != nil {
 		return LambdaClient{}, err
 	}
-	return LambdaClient{client: lambda.New(sess)}, nil
+	return LambdaClient{client: set.lambda}, nil
 }
 
 func (self LambdaClient) Invoke(funcName string, payload string) (*lambda.InvokeOutput, error) {
@@ -115,14 +187,12 @@ type ECSClient struct {
 	client *ecs.ECS
 }
 
-func CreateECSInstance() (ECSClient, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String("ap-northeast-1")},
-	)
+func CreateECSInstance(config AWSConfig) (ECSClient, error) {
+	set, err := awsClients.get(config)
 	if err != nil {
 		return ECSClient{}, err
 	}
-	return ECSClient{client: ecs.New(sess)}, nil
+	return ECSClient{client: set.ecs}, nil
 }
 
 func (self ECSClient) DescribeTaskDefinition(arn string) (*ecs.TaskDefinition, error) {
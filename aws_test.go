@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAWSClients(t *testing.T) {
+	clients := NewAWSClients(AWSConfig{})
+
+	a, err := clients.get(AWSConfig{})
+	require.NoError(t, err)
+	require.Equal(t, DefaultAWSRegion, *a.ecr.Config.Region)
+
+	b, err := clients.get(AWSConfig{Region: DefaultAWSRegion})
+	require.NoError(t, err)
+	require.Same(t, a, b, "the clients are cached per region and role")
+
+	clients.SetDefaults(AWSConfig{Region: "us-east-1", RoleArn: "arn:aws:iam::123456789012:role/gocat"})
+	c, err := clients.get(AWSConfig{})
+	require.NoError(t, err)
+	require.NotSame(t, a, c)
+	require.Equal(t, "us-east-1", *c.lambda.Config.Region)
+
+	d, err := clients.get(AWSConfig{Region: "us-east-1", RoleArn: "arn:aws:iam::123456789012:role/gocat"})
+	require.NoError(t, err)
+	require.Same(t, c, d)
+
+	e, err := clients.get(AWSConfig{RoleArn: "arn:aws:iam::210987654321:role/ecr-reader"})
+	require.NoError(t, err)
+	require.NotSame(t, c, e)
+	require.Equal(t, "us-east-1", *e.ecs.Config.Region)
+}
+
+func TestDeployProjectAWSConfig(t *testing.T) {
+	pj := DeployProject{
+		awsRegion:  "ap-northeast-1",
+		awsRoleArn: "arn:aws:iam::123456789012:role/deploy",
+		Phases: []DeployPhase{
+			{Name: "staging"},
+			{Name: "production", AWSRegion: "us-east-1"},
+			{Name: "sandbox", AWSRoleArn: "arn:aws:iam::210987654321:role/deploy"},
+		},
+	}
+
+	require.Equal(t, AWSConfig{Region: "ap-northeast-1", RoleArn: "arn:aws:iam::123456789012:role/deploy"}, pj.AWSConfig("staging"))
+	require.Equal(t, AWSConfig{Region: "us-east-1", RoleArn: "arn:aws:iam::123456789012:role/deploy"}, pj.AWSConfig("production"))
+	require.Equal(t, AWSConfig{Region: "ap-northeast-1", RoleArn: "arn:aws:iam::210987654321:role/deploy"}, pj.AWSConfig("sandbox"))
+	require.Equal(t, AWSConfig{}, DeployProject{}.AWSConfig("staging"))
+
+	require.Equal(t, "eu-west-1", AWSConfig{Region: "us-east-1"}.withRegionOf("arn:aws:ecs:eu-west-1:123456789012:task-definition/myapp:1").Region)
+	require.Equal(t, "us-east-1", AWSConfig{Region: "us-east-1"}.withRegionOf("myapp:1").Region)
+}
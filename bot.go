@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 
 	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
 	"github.com/zaiminc/gocat/deploy"
 )
 
@@ -16,10 +18,13 @@ func main() {
 		log.Fatal(err)
 	}
 
-	client := slack.New(
+	api := slack.New(
 		config.SlackOAuthToken,
 		slack.OptionLog(log.New(os.Stdout, "slack-bot: ", log.Lshortfile|log.LstdFlags)),
+		slack.OptionAppLevelToken(config.SlackAppToken),
 	)
+	client := NewSlackClient(api)
+	awsClients.SetDefaults(config.AWSConfig())
 	github := CreateGitHubInstance("", config.GitHubAccessToken, config.ManifestRepositoryOrg, config.ManifestRepositoryName, config.GitHubDefaultBranch,
 		config,
 	)
@@ -30,32 +35,73 @@ func main() {
 		config.GitHubDefaultBranch,
 		os.Getenv("GOCAT_GITROOT"),
 	)
-	userList := UserList{github: github, slackClient: client}
-	projectList := NewProjectList()
-	interactorContext := InteractorContext{projectList: &projectList, userList: &userList, github: github, git: git, client: client, config: *config}
+	// k is the Kubernetes cluster gocat runs in, shared by everything that talks to it.
+	k := &deploy.Kubernetes{}
+	source := newConfigSource(config, k)
+	userList := UserList{github: github, slackClient: client, source: source}
+	projectList := NewProjectList(source)
+	languages := NewLanguageList(config.Language)
+	languages.source = source
+	if err := languages.Reload(); err != nil {
+		log.Print(err)
+	}
+	history := newHistory(config, k)
+	coordinator := deploy.NewCoordinator(k, config.Namespace, config.LocksConfigMapName)
+	coordinator.History = history
+	guard := NewDeployGuard(coordinator, &userList, &projectList)
+	pending := newPendingRequestStore(config, k)
+	interactorContext := InteractorContext{projectList: &projectList, userList: &userList, github: github, git: git, client: client, config: *config, history: history, guard: guard, pending: pending, argocd: NewArgoCD(config.ArgoCDHost, config.ArgoCDToken), rollout: newKubernetesRolloutWatcher(k), kubernetes: k, languages: languages}
 	interactorFactory := NewInteractorFactory(interactorContext)
-	autoDeploy := NewAutoDeploy(client, &github, &git, &projectList)
+	autoDeploy := NewAutoDeploy(client, &github, &git, &projectList, history, guard, k, languages)
+
+	verifier := NewSlackRequestVerifier(config.SlackSigningSecret, config.SlackVerificationToken)
+	slackListener := &SlackListener{
+		client:            client,
+		verifier:          verifier,
+		projectList:       &projectList,
+		userList:          &userList,
+		interactorFactory: &interactorFactory,
+		languages:         languages,
+		coordinator:       coordinator,
+		history:           history,
+		guard:             guard,
+		pending:           pending,
+	}
+	// The handovers are notified by the listener, which must be set before the lock reaper starts to hand over the expired locks.
+	coordinator.OnHandover = slackListener.handover
 
 	log.SetOutput(os.Stdout)
 	if config.EnableAutoDeploy {
 		autoDeploy.Watch(60)
 	}
+	// The locks can't expire without the ConfigMap to store them.
+	if config.LocksConfigMapName != "" {
+		NewLockReaper(client, coordinator, languages).Watch(60)
+	}
 
-	http.Handle("/events", SlackListener{
+	interactions := interactionHandler{
+		verifier:          verifier,
 		client:            client,
-		verificationToken: config.SlackVerificationToken,
 		projectList:       &projectList,
 		userList:          &userList,
 		interactorFactory: &interactorFactory,
-		coordinator:       deploy.NewCoordinator(config.Namespace, config.LocksConfigMapName),
-	})
-	http.Handle("/interaction", interactionHandler{
-		verificationToken: config.SlackVerificationToken,
-		client:            client,
-		projectList:       &projectList,
-		userList:          &userList,
-		interactorFactory: &interactorFactory,
-	})
+		github:            github,
+		languages:         languages,
+	}
+
+	switch config.SlackMode {
+	case SlackModeSocket:
+		runner := NewSocketModeRunner(socketmode.New(api), slackListener, interactions)
+		go func() {
+			if err := runner.Run(context.Background()); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	default:
+		http.Handle("/events", slackListener)
+		http.Handle("/interaction", interactions)
+		http.Handle("/command", slashCommandHandler{verifier: verifier, events: slackListener})
+	}
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "hello")
 	})
@@ -5,8 +5,10 @@ import (
 	"log"
 	"os"
 	"regexp"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/zaiminc/gocat/i18n"
 )
 
 type CatConfig struct {
@@ -34,15 +36,54 @@ type CatConfig struct {
 	GitHubDefaultBranch    string
 	SlackOAuthToken        string
 	SlackVerificationToken string
-	JenkinsHost            string
-	JenkinsBotToken        string
-	JenkinsJobToken        string
-	ArgoCDHost             string
-	EnableAutoDeploy       bool // optional (default: false)
+	// SlackSigningSecret is used to verify the signatures of the requests from Slack.
+	// The deprecated SlackVerificationToken is used instead if it's empty.
+	SlackSigningSecret string
+	// SlackMode is either "http" (default) or "socket".
+	// In the socket mode, gocat receives events over Socket Mode instead of the /events and /interaction endpoints.
+	SlackMode string
+	// SlackAppToken is the app-level token required by the socket mode.
+	SlackAppToken    string
+	JenkinsHost      string
+	JenkinsBotToken  string
+	JenkinsJobToken  string
+	ArgoCDHost       string
+	EnableAutoDeploy bool // optional (default: false)
+
+	// ArgoCDToken is the ArgoCD API token used to follow the sync of the applications after GitOps deployments.
+	// The sync is not followed if it's empty.
+	ArgoCDToken string
+
+	// AWSRegion and AWSRoleArn are used to access AWS unless the project or the phase overrides them.
+	// AWSRegion defaults to DefaultAWSRegion.
+	AWSRegion  string
+	AWSRoleArn string
 
 	// For deploy.Coordinator
 	Namespace          string
 	LocksConfigMapName string
+
+	// For ConfigSource.
+	// The ConfigMaps configuring gocat are read from the files in ConfigMapDir if set,
+	// or listed in Namespace otherwise.
+	ConfigMapDir string
+
+	// For deploy.History.
+	// HistoryConfigMapName takes precedence over HistoryFile when both are set.
+	// If neither is set, deploy events are not recorded.
+	HistoryConfigMapName string
+	HistoryFile          string
+
+	// For deploy.PendingRequestStore.
+	// Deploy requests waiting for the approval are kept in memory if PendingRequestsConfigMapName is empty.
+	// PendingRequestTTL defaults to deploy.DefaultPendingRequestTTL.
+	PendingRequestsConfigMapName string
+	PendingRequestTTL            time.Duration
+
+	// Language is the default language of the messages to Slack users,
+	// which can be overridden per user or channel by the language configmaps. See LanguageList.
+	// i18n.DefaultLang is used if it's empty.
+	Language i18n.Lang
 }
 
 func (c *CatConfig) GetAppRepositoryOrg() string {
@@ -52,6 +93,10 @@ func (c *CatConfig) GetAppRepositoryOrg() string {
 	return c.AppRepositoryOrg
 }
 
+func (c *CatConfig) AWSConfig() AWSConfig {
+	return AWSConfig{Region: c.AWSRegion, RoleArn: c.AWSRoleArn}
+}
+
 func (c *CatConfig) GetAppRepositoryGitHubAccessToken() string {
 	if c.AppRepositoryGitHubAccessToken == "" {
 		return c.GitHubAccessToken
@@ -93,6 +138,8 @@ func initConfig(getSecretValue func(string) (*secretsmanager.GetSecretValueOutpu
 	Config.EnableAutoDeploy = getenv("CONFIG_ENABLE_AUTO_DEPLOY") == "true"
 	Config.ArgoCDHost = getenv("CONFIG_ARGOCD_HOST")
 	Config.JenkinsHost = getenv("CONFIG_JENKINS_HOST")
+	Config.AWSRegion = getenv("CONFIG_AWS_REGION")
+	Config.AWSRoleArn = getenv("CONFIG_AWS_ROLE_ARN")
 	Config.GitHubUserName = getenv("CONFIG_GITHUB_USER_NAME")
 	Config.GitHubDefaultBranch = getenv("CONFIG_GITHUB_DEFAULT_BRANCH")
 	Config.ManifestRepositoryName = findRepositoryName(Config.ManifestRepository)
@@ -106,10 +153,39 @@ func initConfig(getSecretValue func(string) (*secretsmanager.GetSecretValueOutpu
 	if Config.Namespace == "" {
 		log.Printf("[WARNING] CONFIG_NAMESPACE environment variable is not set. Lock-related features will not work.")
 	}
+	Config.ConfigMapDir = getenv("CONFIG_CONFIGMAP_DIR")
 	Config.LocksConfigMapName = getenv("CONFIG_LOCKS_CONFIGMAP_NAME")
 	if Config.LocksConfigMapName == "" {
 		log.Printf("[WARNING] CONFIG_LOCKS_CONFIGMAP_NAME environment variable is not set. Lock-related features will not work.")
 	}
+	Config.HistoryConfigMapName = getenv("CONFIG_HISTORY_CONFIGMAP_NAME")
+	Config.HistoryFile = getenv("CONFIG_HISTORY_FILE")
+
+	Config.PendingRequestsConfigMapName = getenv("CONFIG_PENDING_REQUESTS_CONFIGMAP_NAME")
+	if ttl := getenv("CONFIG_PENDING_REQUEST_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("CONFIG_PENDING_REQUEST_TTL is invalid: %w", err)
+		}
+		Config.PendingRequestTTL = d
+	}
+
+	if lang := getenv("CONFIG_LANGUAGE"); lang != "" {
+		l, err := i18n.ParseLang(lang)
+		if err != nil {
+			return nil, fmt.Errorf("CONFIG_LANGUAGE is invalid: %w", err)
+		}
+		Config.Language = l
+	}
+
+	Config.SlackMode = getenv("CONFIG_SLACK_MODE")
+	switch Config.SlackMode {
+	case "":
+		Config.SlackMode = SlackModeHTTP
+	case SlackModeHTTP, SlackModeSocket:
+	default:
+		return nil, fmt.Errorf("CONFIG_SLACK_MODE must be either %q or %q: %q", SlackModeHTTP, SlackModeSocket, Config.SlackMode)
+	}
 
 	switch getenv("SECRET_STORE") {
 	case "aws/secrets-manager":
@@ -125,8 +201,17 @@ func initConfig(getSecretValue func(string) (*secretsmanager.GetSecretValueOutpu
 		Config.AppRepositoryGitHubAccessToken = secret.AppRepositoryGitHubAccessToken
 		Config.SlackOAuthToken = secret.OauthToken
 		Config.SlackVerificationToken = secret.VerificationToken
+		Config.SlackSigningSecret = secret.SigningSecret
 		Config.JenkinsBotToken = secret.JenkinsBotUserToken
 		Config.JenkinsJobToken = secret.JenkinsJobToken
+		Config.SlackAppToken = secret.AppToken
+		Config.ArgoCDToken = secret.ArgoCDToken
+		if Config.ArgoCDHost == "" {
+			Config.ArgoCDHost = secret.ArgoCDHost
+		}
+		if err := Config.validateSlackMode(); err != nil {
+			return nil, err
+		}
 		return Config, nil
 
 	default:
@@ -141,8 +226,24 @@ func initConfig(getSecretValue func(string) (*secretsmanager.GetSecretValueOutpu
 		Config.AppRepositoryGitHubAccessToken = getenv("CONFIG_APP_REPOSITORY_GITHUB_ACCESS_TOKEN")
 		Config.SlackOAuthToken = getenv("CONFIG_SLACK_OAUTH_TOKEN")
 		Config.SlackVerificationToken = getenv("CONFIG_SLACK_VERIFICATION_TOKEN")
+		Config.SlackSigningSecret = getenv("CONFIG_SLACK_SIGNING_SECRET")
 		Config.JenkinsBotToken = getenv("CONFIG_JENKINS_BOT_TOKEN")
 		Config.JenkinsJobToken = getenv("CONFIG_JENKINS_JOB_TOKEN")
+		Config.SlackAppToken = getenv("CONFIG_SLACK_APP_TOKEN")
+		Config.ArgoCDToken = getenv("CONFIG_ARGOCD_TOKEN")
+		if err := Config.validateSlackMode(); err != nil {
+			return nil, err
+		}
 		return Config, nil
 	}
 }
+
+func (c *CatConfig) validateSlackMode() error {
+	if c.SlackMode == SlackModeSocket && c.SlackAppToken == "" {
+		return fmt.Errorf("Set the Slack app-level token to use the socket mode")
+	}
+	if c.SlackMode == SlackModeHTTP && c.SlackSigningSecret == "" {
+		log.Printf("[WARNING] Slack signing secret is not set. Requests from Slack are verified by the deprecated verification token.")
+	}
+	return nil
+}
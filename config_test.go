@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/i18n"
 )
 
 func TestConfigInitGet(t *testing.T) {
@@ -22,7 +23,8 @@ func TestConfigInitGet(t *testing.T) {
 	secrets := map[string]string{
 		"mysecret": `{
   "GITHUB_BOT_USER_TOKEN": "mysecret_token",
-  "APP_REPOSITORY_GITHUB_ACCESS_TOKEN": "mysecret_apptoken"
+  "APP_REPOSITORY_GITHUB_ACCESS_TOKEN": "mysecret_apptoken",
+  "SLACK_SIGNING_SECRET": "mysecret_signingsecret"
 }`,
 	}
 
@@ -40,6 +42,7 @@ func TestConfigInitGet(t *testing.T) {
 				ManifestRepositoryOrg:  "org",
 				GitHubAccessToken:      "mytoken",
 				GitHubUserName:         "gocat",
+				SlackMode:              "http",
 			},
 			wantAppRepositoryOrg:   "org",
 			wantAppRepositoryToken: "mytoken",
@@ -51,6 +54,7 @@ func TestConfigInitGet(t *testing.T) {
 				"CONFIG_GITHUB_ACCESS_TOKEN":                "mytoken",
 				"CONFIG_APP_REPOSITORY_ORG":                 "apporg",
 				"CONFIG_APP_REPOSITORY_GITHUB_ACCESS_TOKEN": "apptoken",
+				"CONFIG_SLACK_SIGNING_SECRET":               "signingsecret",
 			},
 			secrets: secrets,
 			want: CatConfig{
@@ -61,6 +65,8 @@ func TestConfigInitGet(t *testing.T) {
 				GitHubUserName:                 "gocat",
 				AppRepositoryOrg:               "apporg",
 				AppRepositoryGitHubAccessToken: "apptoken",
+				SlackSigningSecret:             "signingsecret",
+				SlackMode:                      "http",
 			},
 			wantAppRepositoryOrg:   "apporg",
 			wantAppRepositoryToken: "apptoken",
@@ -84,10 +90,53 @@ func TestConfigInitGet(t *testing.T) {
 				GitHubUserName:                 "gocat",
 				AppRepositoryOrg:               "apporg",
 				AppRepositoryGitHubAccessToken: "mysecret_apptoken",
+				SlackSigningSecret:             "mysecret_signingsecret",
+				SlackMode:                      "http",
 			},
 			wantAppRepositoryOrg:   "apporg",
 			wantAppRepositoryToken: "mysecret_apptoken",
 		},
+		{
+			subject: "socket mode",
+			env: map[string]string{
+				"CONFIG_MANIFEST_REPOSITORY": "https://github.com/org/manifests.git",
+				"CONFIG_GITHUB_ACCESS_TOKEN": "mytoken",
+				"CONFIG_SLACK_MODE":          "socket",
+				"CONFIG_SLACK_APP_TOKEN":     "xapp-mytoken",
+			},
+			secrets: secrets,
+			want: CatConfig{
+				ManifestRepository:     "https://github.com/org/manifests.git",
+				ManifestRepositoryName: "manifests",
+				ManifestRepositoryOrg:  "org",
+				GitHubAccessToken:      "mytoken",
+				GitHubUserName:         "gocat",
+				SlackMode:              "socket",
+				SlackAppToken:          "xapp-mytoken",
+			},
+			wantAppRepositoryOrg:   "org",
+			wantAppRepositoryToken: "mytoken",
+		},
+		{
+			subject: "with language",
+			env: map[string]string{
+				"CONFIG_MANIFEST_REPOSITORY": "https://github.com/org/manifests.git",
+				"CONFIG_GITHUB_ACCESS_TOKEN": "mytoken",
+				"CONFIG_LANGUAGE":            "en",
+			},
+			secrets: secrets,
+			want: CatConfig{
+				ManifestRepository:     "https://github.com/org/manifests.git",
+				ManifestRepositoryName: "manifests",
+				ManifestRepositoryOrg:  "org",
+				GitHubAccessToken:      "mytoken",
+				GitHubUserName:         "gocat",
+				SlackMode:              "http",
+				Language:               i18n.English,
+			},
+			wantAppRepositoryOrg:   "org",
+			wantAppRepositoryToken: "mytoken",
+		},
 	}
 
 	for _, tc := range tcs {
@@ -107,3 +156,25 @@ func TestConfigInitGet(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigInitSlackModeError(t *testing.T) {
+	getSecretValue := func(secretName string) (*secretsmanager.GetSecretValueOutput, error) {
+		return nil, nil
+	}
+
+	_, err := initConfig(getSecretValue, func(s string) string {
+		return map[string]string{
+			"CONFIG_MANIFEST_REPOSITORY": "https://github.com/org/manifests.git",
+			"CONFIG_SLACK_MODE":          "websocket",
+		}[s]
+	})
+	require.EqualError(t, err, `CONFIG_SLACK_MODE must be either "http" or "socket": "websocket"`)
+
+	_, err = initConfig(getSecretValue, func(s string) string {
+		return map[string]string{
+			"CONFIG_MANIFEST_REPOSITORY": "https://github.com/org/manifests.git",
+			"CONFIG_SLACK_MODE":          "socket",
+		}[s]
+	})
+	require.EqualError(t, err, "Set the Slack app-level token to use the socket mode")
+}
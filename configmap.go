@@ -3,48 +3,136 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 
-	"k8s.io/api/core/v1"
+	"github.com/zaiminc/gocat/deploy"
+	v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 )
 
-func getConfigMapList(t string) (cml *v1.ConfigMapList) {
-	client, err := newKubernetesClient()
+// ConfigMapTypeLabel is the label of the ConfigMaps configuring gocat,
+// whose value is the type of the configuration like `project`, `rolebinding` and `policy`.
+const ConfigMapTypeLabel = "gocat.zaim.net/configmap-type"
+
+// ConfigSource provides the ConfigMaps configuring gocat, like the projects, the role bindings and the policies,
+// which are selected by the type in their ConfigMapTypeLabel label.
+//
+// We currently have two implementations:
+// - KubernetesConfigSource reads the ConfigMaps from the namespace of the cluster gocat runs in.
+// - FileConfigSource reads the manifests of the ConfigMaps from a directory, to run gocat without a cluster.
+type ConfigSource interface {
+	ConfigMaps(ctx context.Context, configMapType string) (*v1.ConfigMapList, error)
+}
+
+// newConfigSource returns the FileConfigSource if the directory of the ConfigMaps is configured,
+// or the KubernetesConfigSource reading them from the cluster otherwise.
+func newConfigSource(config *CatConfig, k *deploy.Kubernetes) ConfigSource {
+	if config.ConfigMapDir != "" {
+		log.Printf("[INFO] Reading the ConfigMaps from %s", config.ConfigMapDir)
+		return NewFileConfigSource(config.ConfigMapDir)
+	}
+	return NewKubernetesConfigSource(k, config.Namespace)
+}
+
+// KubernetesConfigSource is a ConfigSource that lists the ConfigMaps in the namespace of the Kubernetes cluster.
+type KubernetesConfigSource struct {
+	*deploy.Kubernetes
+
+	// Namespace is the namespace of the ConfigMaps. Defaults to `default`.
+	Namespace string
+}
+
+func NewKubernetesConfigSource(k *deploy.Kubernetes, ns string) *KubernetesConfigSource {
+	return &KubernetesConfigSource{Kubernetes: k, Namespace: ns}
+}
+
+func (s *KubernetesConfigSource) ConfigMaps(ctx context.Context, configMapType string) (*v1.ConfigMapList, error) {
+	client, err := s.ClientSet()
 	if err != nil {
-		log.Print("[ERROR] ", err)
-		return
+		return nil, fmt.Errorf("[ERROR] Unable to connect to the Kubernetes cluster: %w", err)
 	}
 
-	ns := os.Getenv("CONFIG_NAMESPACE")
+	ns := s.Namespace
 	if ns == "" {
 		ns = "default"
 	}
 
-	cml, err = client.CoreV1().ConfigMaps(ns).List(context.Background(), meta_v1.ListOptions{LabelSelector: fmt.Sprintf("gocat.zaim.net/configmap-type=%s", t)})
+	cml, err := client.CoreV1().ConfigMaps(ns).List(ctx, meta_v1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", ConfigMapTypeLabel, configMapType)})
 	if err != nil {
-		log.Print("[ERROR] ", err)
-		return
+		return nil, fmt.Errorf("[ERROR] Unable to list the %s ConfigMaps in %s: %w", configMapType, ns, err)
 	}
 
-	return cml
+	return cml, nil
+}
+
+// FileConfigSource is a ConfigSource that reads the ConfigMaps from the YAML files in the directory,
+// in the same format as the manifests applied to the cluster.
+//
+// A file can contain multiple ConfigMaps separated by `---`. The other kinds of resources are ignored.
+type FileConfigSource struct {
+	Dir string
+}
+
+func NewFileConfigSource(dir string) *FileConfigSource {
+	return &FileConfigSource{Dir: dir}
 }
 
-func newKubernetesClient() (kubernetes.Interface, error) {
-	if os.Getenv("LOCAL") != "" {
-		config, err := clientcmd.BuildConfigFromFlags("", clientcmd.RecommendedHomeFile)
+func (s *FileConfigSource) ConfigMaps(ctx context.Context, configMapType string) (*v1.ConfigMapList, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] Unable to read the ConfigMaps directory: %w", err)
+	}
+
+	var paths []string
+	for _, e := range entries {
+		if ext := filepath.Ext(e.Name()); !e.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			paths = append(paths, filepath.Join(s.Dir, e.Name()))
+		}
+	}
+	sort.Strings(paths)
+
+	cml := &v1.ConfigMapList{}
+	for _, path := range paths {
+		configMaps, err := readConfigMaps(path)
 		if err != nil {
 			return nil, err
 		}
-		return kubernetes.NewForConfig(config)
+		for _, cm := range configMaps {
+			if cm.Labels[ConfigMapTypeLabel] == configMapType {
+				cml.Items = append(cml.Items, cm)
+			}
+		}
 	}
-	config, err := rest.InClusterConfig()
+
+	return cml, nil
+}
+
+// readConfigMaps reads the ConfigMaps in the YAML file.
+func readConfigMaps(path string) ([]v1.ConfigMap, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("[ERROR] Unable to read the ConfigMaps: %w", err)
 	}
-	return kubernetes.NewForConfig(config)
+	defer f.Close()
+
+	var configMaps []v1.ConfigMap
+	dec := utilyaml.NewYAMLOrJSONDecoder(f, 4096)
+	for {
+		var cm v1.ConfigMap
+		if err := dec.Decode(&cm); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("[ERROR] Unable to parse the ConfigMaps in %s: %w", path, err)
+		}
+		if cm.Kind == "ConfigMap" {
+			configMaps = append(configMaps, cm)
+		}
+	}
+
+	return configMaps, nil
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/deploy"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeConfigSource is a ConfigSource returning the ConfigMaps of each type, or the error.
+type fakeConfigSource struct {
+	configMaps map[string][]v1.ConfigMap
+	err        error
+}
+
+func (f *fakeConfigSource) ConfigMaps(ctx context.Context, configMapType string) (*v1.ConfigMapList, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &v1.ConfigMapList{Items: f.configMaps[configMapType]}, nil
+}
+
+func configMapNames(cml *v1.ConfigMapList) (names []string) {
+	for _, cm := range cml.Items {
+		names = append(names, cm.Name)
+	}
+	return names
+}
+
+func TestKubernetesConfigSource(t *testing.T) {
+	configMap := func(ns, name, configMapType string) *v1.ConfigMap {
+		return &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name, Labels: map[string]string{ConfigMapTypeLabel: configMapType}}}
+	}
+	client := fake.NewSimpleClientset(
+		configMap("gocat", "api", "project"),
+		configMap("gocat", "web", "project"),
+		configMap("gocat", "developers", "rolebinding"),
+		configMap("default", "other", "project"),
+	)
+
+	source := NewKubernetesConfigSource(deploy.NewKubernetes(client), "gocat")
+	cml, err := source.ConfigMaps(context.Background(), "project")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"api", "web"}, configMapNames(cml))
+
+	// The namespace defaults to default.
+	cml, err = NewKubernetesConfigSource(deploy.NewKubernetes(client), "").ConfigMaps(context.Background(), "project")
+	require.NoError(t, err)
+	require.Equal(t, []string{"other"}, configMapNames(cml))
+
+	client.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	_, err = source.ConfigMaps(context.Background(), "project")
+	require.EqualError(t, err, "[ERROR] Unable to list the project ConfigMaps in gocat: forbidden")
+}
+
+func TestFileConfigSource(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	write("projects.yaml", `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+  labels:
+    gocat.zaim.net/configmap-type: project
+data:
+  Alias: ^web$
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: token
+  labels:
+    gocat.zaim.net/configmap-type: project
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: developers
+  labels:
+    gocat.zaim.net/configmap-type: rolebinding
+data:
+  Developer: user1
+`)
+	write("api.yml", `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: api
+  labels:
+    gocat.zaim.net/configmap-type: project
+data:
+  Kind: kustomize
+`)
+	write("README.md", "not a manifest")
+
+	source := NewFileConfigSource(dir)
+	cml, err := source.ConfigMaps(context.Background(), "project")
+	require.NoError(t, err)
+	require.Equal(t, []string{"api", "web"}, configMapNames(cml))
+	require.Equal(t, "kustomize", cml.Items[0].Data["Kind"])
+
+	cml, err = source.ConfigMaps(context.Background(), "rolebinding")
+	require.NoError(t, err)
+	require.Equal(t, []string{"developers"}, configMapNames(cml))
+
+	cml, err = source.ConfigMaps(context.Background(), "policy")
+	require.NoError(t, err)
+	require.Empty(t, cml.Items)
+
+	write("broken.yaml", "kind: [")
+	_, err = source.ConfigMaps(context.Background(), "project")
+	require.ErrorContains(t, err, "Unable to parse the ConfigMaps in "+filepath.Join(dir, "broken.yaml"))
+
+	_, err = NewFileConfigSource(filepath.Join(dir, "missing")).ConfigMaps(context.Background(), "project")
+	require.Error(t, err)
+}
@@ -16,6 +16,22 @@ import (
 type Phase struct {
 	Locked      bool              `json:"locked"`
 	LockHistory []LockHistoryItem `json:"lockHistory"`
+	// Queue is the list of users waiting for the lock, in order.
+	// When the phase is unlocked, the lock is handed over to the first user in the queue.
+	Queue []QueueItem `json:"queue,omitempty"`
+}
+
+// QueueItem is a user waiting for the lock of a phase to deploy.
+type QueueItem struct {
+	// User is the user who gets the lock on handover.
+	User string `json:"user"`
+	// UserID is the Slack user ID of the user, used to ping the user on handover.
+	UserID string `json:"userID,omitempty"`
+	// Branch is the branch to deploy. Empty means the default branch of the project.
+	Branch string `json:"branch,omitempty"`
+	// Channel is the ID of the Slack channel in which the user queued.
+	Channel string      `json:"channel,omitempty"`
+	At      metav1.Time `json:"at"`
 }
 
 type LockHistoryItem struct {
@@ -23,6 +39,22 @@ type LockHistoryItem struct {
 	Action LockAction  `json:"action"`
 	At     metav1.Time `json:"at"`
 	Reason string      `json:"reason"`
+	// ExpiresAt is the time when the lock is automatically released.
+	// Nil means the lock never expires.
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+	// Channel is the ID of the Slack channel in which the lock was taken.
+	// It is used to notify the automatic unlock on expiry.
+	Channel string `json:"channel,omitempty"`
+}
+
+// Expired returns true if the phase is locked with an expiry, and the expiry has passed at the given time.
+func (p Phase) Expired(now metav1.Time) bool {
+	if !p.Locked || len(p.LockHistory) == 0 {
+		return false
+	}
+
+	last := p.LockHistory[len(p.LockHistory)-1]
+	return last.Action == LockActionLock && last.ExpiresAt != nil && !now.Before(last.ExpiresAt)
 }
 
 type LockAction string
@@ -32,6 +64,13 @@ const (
 	LockActionUnlock LockAction = "unlock"
 
 	MaxHistoryItems = 3
+
+	// AutoUnlockUser is the user recorded for the unlocks of expired locks.
+	AutoUnlockUser = "gocat"
+	// AutoUnlockReason is the reason recorded for the unlocks of expired locks.
+	AutoUnlockReason = "expired"
+	// QueuedLockReason is the reason recorded for the locks handed over to the queued users.
+	QueuedLockReason = "queued deployment"
 )
 
 func configMapValueToStr(value Phase) (string, error) {
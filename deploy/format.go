@@ -1,8 +1,15 @@
 package deploy
 
-import "strings"
+import (
+	"strings"
+	"time"
 
-func FormatProjectDescs(projects []ProjectDesc) string {
+	"github.com/zaiminc/gocat/i18n"
+)
+
+// FormatProjectDescs formats the locks and the queues of the projects returned by Coordinator.DescribeLocks
+// into a human-readable text in the language.
+func FormatProjectDescs(projects []ProjectDesc, lang i18n.Lang) string {
 	var buf strings.Builder
 	for _, pj := range projects {
 		var wroteProjectHeader bool
@@ -22,16 +29,74 @@ func FormatProjectDescs(projects []ProjectDesc) string {
 			buf.WriteString("  ")
 			buf.WriteString(env)
 			buf.WriteString(": ")
-			buf.WriteString("Locked")
+			buf.WriteString(lang.Text(i18n.LockState))
 			if len(lock.LockHistory) > 0 {
-				buf.WriteString(" (by ")
-				buf.WriteString(lock.LockHistory[len(lock.LockHistory)-1].User)
-				buf.WriteString(", for ")
-				buf.WriteString(lock.LockHistory[len(lock.LockHistory)-1].Reason)
+				last := lock.LockHistory[len(lock.LockHistory)-1]
+				buf.WriteString(lang.Sprintf(i18n.LockedBy, last.User, last.Reason))
+				if expiresAt := last.ExpiresAt; expiresAt != nil {
+					buf.WriteString(lang.Sprintf(i18n.LockedByUntil, expiresAt.Format("2006-01-02 15:04")))
+				}
 				buf.WriteString(")")
 			}
 			buf.WriteString("\n")
+
+			if len(lock.Queue) > 0 {
+				buf.WriteString(lang.Text(i18n.LockQueue))
+				for i, q := range lock.Queue {
+					if i > 0 {
+						buf.WriteString(", ")
+					}
+					buf.WriteString(q.User)
+					if q.Branch != "" {
+						buf.WriteString(" (")
+						buf.WriteString(q.Branch)
+						buf.WriteString(")")
+					}
+				}
+				buf.WriteString("\n")
+			}
+		}
+	}
+
+	return buf.String()
+}
+
+// FormatEvents formats the events returned by History.List into a human-readable text in the language,
+// one event per line.
+func FormatEvents(events []Event, lang i18n.Lang) string {
+	if len(events) == 0 {
+		return lang.Text(i18n.NoHistory) + "\n"
+	}
+
+	var buf strings.Builder
+	for _, ev := range events {
+		buf.WriteString(ev.At.Format("2006-01-02 15:04:05"))
+		buf.WriteString(" ")
+		buf.WriteString(string(ev.Action))
+		buf.WriteString(" ")
+		buf.WriteString(string(ev.Result))
+		if ev.User != "" {
+			buf.WriteString(lang.Sprintf(i18n.EventBy, ev.User))
+		}
+		if ev.Tag != "" {
+			buf.WriteString(lang.Sprintf(i18n.EventTag, ev.Tag))
+		}
+		if ev.Branch != "" {
+			buf.WriteString(lang.Sprintf(i18n.EventBranch, ev.Branch))
+		}
+		if ev.Duration.Duration > 0 {
+			buf.WriteString(lang.Sprintf(i18n.EventTook, ev.Duration.Duration.Round(time.Second).String()))
+		}
+		if ev.Message != "" {
+			buf.WriteString(" (")
+			buf.WriteString(ev.Message)
+			buf.WriteString(")")
+		}
+		if ev.PullRequestURL != "" {
+			buf.WriteString(" ")
+			buf.WriteString(ev.PullRequestURL)
 		}
+		buf.WriteString("\n")
 	}
 
 	return buf.String()
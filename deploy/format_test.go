@@ -2,8 +2,11 @@ package deploy
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/i18n"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestFormatProjectDescs(t *testing.T) {
@@ -75,5 +78,70 @@ myproject1-api
   staging: Locked (by user2, for for deployment of revision b)
 myproject2
   prod: Locked
-`, FormatProjectDescs(projects))
+`, FormatProjectDescs(projects, i18n.English))
+
+	expiresAt := metav1.NewTime(time.Date(2026, 10, 20, 18, 0, 0, 0, time.UTC))
+	projects = []ProjectDesc{
+		{
+			Name: "myproject1",
+			Phases: []PhaseDesc{
+				{
+					Name: "prod",
+					Phase: Phase{
+						Locked:      true,
+						LockHistory: []LockHistoryItem{{User: "user1", Reason: "release freeze", ExpiresAt: &expiresAt}},
+						Queue:       []QueueItem{{User: "user2", Branch: "feature-a"}, {User: "user3"}},
+					},
+				},
+			},
+		},
+	}
+	require.Equal(t, `myproject1
+  prod: Locked (by user1, for release freeze, until 2026-10-20 18:00)
+    queue: user2 (feature-a), user3
+`, FormatProjectDescs(projects, i18n.English))
+	require.Equal(t, `myproject1
+  prod: ロック中 (user1 によるロック, 理由: release freeze, 期限: 2026-10-20 18:00)
+    待ち行列: user2 (feature-a), user3
+`, FormatProjectDescs(projects, i18n.Japanese))
+}
+
+func TestFormatEvents(t *testing.T) {
+	now, err := time.Parse(time.RFC3339, "2021-09-01T00:00:00Z")
+	require.NoError(t, err)
+
+	events := []Event{
+		{
+			Action:         EventActionDeploy,
+			Result:         EventResultSuccess,
+			User:           "user1",
+			Tag:            "abc1234",
+			Branch:         "master",
+			PullRequestURL: "https://github.com/org/repo/pull/1",
+			At:             metav1.NewTime(now),
+			Duration:       metav1.Duration{Duration: 1500 * time.Millisecond},
+		},
+		{
+			Action:  EventActionLock,
+			Result:  EventResultSuccess,
+			User:    "user2",
+			Message: "release freeze",
+			At:      metav1.NewTime(now),
+		},
+		{
+			Action:  EventActionAutoDeploy,
+			Result:  EventResultFailure,
+			User:    "autodeploy",
+			Message: "NotFound specified image tag",
+			At:      metav1.NewTime(now),
+		},
+	}
+
+	require.Equal(t, `2021-09-01 00:00:00 deploy success by user1, tag abc1234, branch master, took 2s https://github.com/org/repo/pull/1
+2021-09-01 00:00:00 lock success by user2 (release freeze)
+2021-09-01 00:00:00 autodeploy failure by autodeploy (NotFound specified image tag)
+`, FormatEvents(events, i18n.English))
+
+	require.Equal(t, "No history found\n", FormatEvents(nil, i18n.English))
+	require.Equal(t, "履歴はありません\n", FormatEvents(nil, i18n.Japanese))
 }
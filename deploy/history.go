@@ -0,0 +1,296 @@
+package deploy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Event is a record of something that happened to a project phase, like a deployment or a lock.
+//
+// Events are recorded to a History by interactors, AutoDeploy, and Coordinator,
+// so that we can later answer "who deployed what and when" via `@bot history`.
+type Event struct {
+	Project string      `json:"project"`
+	Phase   string      `json:"phase"`
+	Action  EventAction `json:"action"`
+	// User is the Slack display name of the user who triggered the event.
+	User           string      `json:"user"`
+	Tag            string      `json:"tag,omitempty"`
+	Branch         string      `json:"branch,omitempty"`
+	PullRequestURL string      `json:"pullRequestURL,omitempty"`
+	Result         EventResult `json:"result"`
+	// Message is an optional human-readable detail like the error message or the lock reason.
+	Message string      `json:"message,omitempty"`
+	At      metav1.Time `json:"at"`
+	// Duration is how long the action took, if known.
+	Duration metav1.Duration `json:"duration,omitempty"`
+}
+
+type EventAction string
+
+const (
+	EventActionDeploy     EventAction = "deploy"
+	EventActionAutoDeploy EventAction = "autodeploy"
+	EventActionRollback   EventAction = "rollback"
+	EventActionReject     EventAction = "reject"
+	EventActionLock       EventAction = "lock"
+	EventActionUnlock     EventAction = "unlock"
+	// EventActionOverride is an admin's override of the deploy schedule of the phase.
+	EventActionOverride EventAction = "override"
+)
+
+// IsDeploy returns true if the action changed the deployed revision when it succeeded.
+func (a EventAction) IsDeploy() bool {
+	switch a {
+	case EventActionDeploy, EventActionAutoDeploy, EventActionRollback:
+		return true
+	default:
+		return false
+	}
+}
+
+type EventResult string
+
+const (
+	EventResultSuccess EventResult = "success"
+	EventResultFailure EventResult = "failure"
+)
+
+// History is a durable store of deploy events.
+type History interface {
+	// Record appends the event to the history.
+	Record(ctx context.Context, ev Event) error
+	// List returns the last n events for the project phase, newest first.
+	// If n is zero or negative, all the stored events are returned.
+	List(ctx context.Context, project, phase string, n int) ([]Event, error)
+}
+
+const (
+	// MaxHistoryEventsPerPhase is the maximum number of events kept per project phase by ConfigMapHistory.
+	// This keeps the ConfigMap well below the 1MiB limit.
+	MaxHistoryEventsPerPhase = 100
+
+	HistoryConfigMapType = "deploy-history"
+)
+
+// ConfigMapHistory is a History backed by a Kubernetes ConfigMap.
+//
+// Like the locks ConfigMap managed by Coordinator, each key of the ConfigMap is `<project>-<phase>`,
+// and the value is a JSON array of events, oldest first.
+// Only the last MaxHistoryEventsPerPhase events are kept for each project phase.
+type ConfigMapHistory struct {
+	// Namespace is the namespace in which the ConfigMap is created.
+	Namespace string
+
+	// ConfigMapName is the name of the ConfigMap.
+	ConfigMapName string
+
+	*Kubernetes
+}
+
+func NewConfigMapHistory(k *Kubernetes, ns, configMap string) *ConfigMapHistory {
+	return &ConfigMapHistory{
+		Namespace:     ns,
+		ConfigMapName: configMap,
+		Kubernetes:    k,
+	}
+}
+
+// Record appends the event to the ConfigMap.
+//
+// Under the hood, this retries to update the ConfigMap if the update fails due to a conflict.
+func (h *ConfigMapHistory) Record(ctx context.Context, ev Event) error {
+	var retried int
+	for {
+		err := h.record(ctx, ev)
+		if err == nil {
+			return nil
+		}
+
+		if kerrors.IsConflict(err) {
+			if retried >= MaxConfigMapUpdateRetries {
+				return fmt.Errorf("unable to record event after %d retries: %w", MaxConfigMapUpdateRetries, err)
+			}
+
+			retried++
+			continue
+		} else {
+			return err
+		}
+	}
+}
+
+func (h *ConfigMapHistory) record(ctx context.Context, ev Event) error {
+	clientset, err := h.ClientSet()
+	if err != nil {
+		return err
+	}
+
+	configMaps := clientset.CoreV1().ConfigMaps(h.Namespace)
+
+	configMap, err := configMaps.Get(ctx, h.ConfigMapName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		configMap, err = configMaps.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: h.ConfigMapName,
+				Labels: map[string]string{
+					"gocat.zaim.net/configmap-type": HistoryConfigMapType,
+				},
+			},
+		}, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get or create configmap: %w", err)
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+
+	key := configMapKey(ev.Project, ev.Phase)
+	events, err := strToEvents(configMap.Data[key])
+	if err != nil {
+		return err
+	}
+
+	events = append(events, ev)
+	if n := len(events); n > MaxHistoryEventsPerPhase {
+		events = events[n-MaxHistoryEventsPerPhase:]
+	}
+
+	data, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	configMap.Data[key] = string(data)
+
+	_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
+
+func (h *ConfigMapHistory) List(ctx context.Context, project, phase string, n int) ([]Event, error) {
+	clientset, err := h.ClientSet()
+	if err != nil {
+		return nil, err
+	}
+
+	configMap, err := clientset.CoreV1().ConfigMaps(h.Namespace).Get(ctx, h.ConfigMapName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	events, err := strToEvents(configMap.Data[configMapKey(project, phase)])
+	if err != nil {
+		return nil, err
+	}
+
+	return lastEvents(events, n), nil
+}
+
+func strToEvents(data string) ([]Event, error) {
+	if data == "" {
+		return nil, nil
+	}
+
+	var events []Event
+	if err := json.Unmarshal([]byte(data), &events); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal events: %w", err)
+	}
+
+	return events, nil
+}
+
+// FileHistory is a History backed by an append-only file of JSON lines.
+//
+// This is handy when you run gocat locally, or on a host with a persistent volume.
+type FileHistory struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func NewFileHistory(path string) *FileHistory {
+	return &FileHistory{Path: path}
+}
+
+func (h *FileHistory) Record(ctx context.Context, ev Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(h.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open history file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("unable to write history file: %w", err)
+	}
+
+	return nil
+}
+
+func (h *FileHistory) List(ctx context.Context, project, phase string, n int) ([]Event, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, err := os.Open(h.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to open history file: %w", err)
+	}
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var ev Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal event: %w", err)
+		}
+
+		if ev.Project == project && ev.Phase == phase {
+			events = append(events, ev)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read history file: %w", err)
+	}
+
+	return lastEvents(events, n), nil
+}
+
+// lastEvents returns the last n events in the reverse order, so that the newest event comes first.
+func lastEvents(events []Event, n int) []Event {
+	if n <= 0 || n > len(events) {
+		n = len(events)
+	}
+
+	res := make([]Event, 0, n)
+	for i := len(events) - 1; i >= len(events)-n; i-- {
+		res = append(res, events[i])
+	}
+
+	return res
+}
@@ -0,0 +1,69 @@
+package deploy
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFileHistory(t *testing.T) {
+	h := NewFileHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+	ctx := context.Background()
+
+	events, err := h.List(ctx, "myproject1", "prod", 10)
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	now, err := time.Parse(time.RFC3339, "2021-09-01T00:00:00Z")
+	require.NoError(t, err)
+
+	for i, tag := range []string{"a", "b", "c"} {
+		require.NoError(t, h.Record(ctx, Event{
+			Project:  "myproject1",
+			Phase:    "prod",
+			Action:   EventActionDeploy,
+			User:     "user1",
+			Tag:      tag,
+			Result:   EventResultSuccess,
+			At:       metav1.NewTime(now.Add(time.Duration(i) * time.Minute)),
+			Duration: metav1.Duration{Duration: time.Second},
+		}))
+	}
+	require.NoError(t, h.Record(ctx, Event{
+		Project: "myproject1-api",
+		Phase:   "prod",
+		Action:  EventActionLock,
+		User:    "user2",
+		Result:  EventResultSuccess,
+		At:      metav1.NewTime(now),
+	}))
+
+	events, err = h.List(ctx, "myproject1", "prod", 2)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, "c", events[0].Tag)
+	require.Equal(t, "b", events[1].Tag)
+	require.Equal(t, time.Second, events[0].Duration.Duration)
+
+	events, err = h.List(ctx, "myproject1", "prod", 0)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+
+	events, err = h.List(ctx, "myproject1-api", "prod", 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, EventActionLock, events[0].Action)
+}
+
+func TestLastEvents(t *testing.T) {
+	events := []Event{{Tag: "a"}, {Tag: "b"}, {Tag: "c"}}
+
+	require.Equal(t, []Event{{Tag: "c"}, {Tag: "b"}}, lastEvents(events, 2))
+	require.Equal(t, []Event{{Tag: "c"}, {Tag: "b"}, {Tag: "a"}}, lastEvents(events, 5))
+	require.Equal(t, []Event{{Tag: "c"}, {Tag: "b"}, {Tag: "a"}}, lastEvents(events, 0))
+	require.Equal(t, []Event{}, lastEvents(nil, 3))
+}
@@ -1,7 +1,9 @@
 package deploy
 
 import (
+	"errors"
 	"os"
+	"sync"
 
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -10,20 +12,21 @@ import (
 
 // Kubernetes is a helper struct that provides a way to create and cache a Kubernetes API client.
 // The client is created using the KUBECONFIG file if exists, or the in-cluster configuration.
-// The client is cached to avoid creating multiple clients.
+// The client is cached to avoid creating multiple clients,
+// so a single Kubernetes is meant to be shared by everything that accesses the cluster.
 //
 // Usage:
 //
-//	k := Kubernetes{}
+//	k := &Kubernetes{}
 //	clientset, err := k.ClientSet()
 //	if err != nil {
 //	  return err
 //	}
 //	// Use the clientset
 //
-//	// Or you can embed Kubernetes in your struct:
+//	// Or you can embed the shared Kubernetes in your struct:
 //	type MyStruct struct {
-//	  Kubernetes
+//	  *Kubernetes
 //	}
 //	func (m *MyStruct) MyMethod() error {
 //	  clientset, err := m.ClientSet()
@@ -33,9 +36,19 @@ import (
 //	  // Use the clientset
 //	}
 type Kubernetes struct {
+	mu        sync.Mutex
 	clientset clientset.Interface
 }
 
+// NewKubernetes returns the Kubernetes using the clientset instead of creating one,
+// which is mostly for testing with a fake clientset.
+func NewKubernetes(clientset clientset.Interface) *Kubernetes {
+	return &Kubernetes{clientset: clientset}
+}
+
+// ErrNoKubernetes is returned by the nil Kubernetes, which means that gocat runs without a cluster.
+var ErrNoKubernetes = errors.New("the Kubernetes cluster is not configured")
+
 // kubeconfigPath returns the path to the KUBECONFIG file,
 // which is either specified by the KUBECONFIG environment variable,
 // or the default path ~/.kube/config.
@@ -51,6 +64,13 @@ func (c *Kubernetes) kubeconfigPath() string {
 // kubernetesClientSet creates a Kubernetes API client
 // that uses either the KUBECONFIG file if exists, or the in-cluster configuration.
 func (c *Kubernetes) ClientSet() (clientset.Interface, error) {
+	if c == nil {
+		return nil, ErrNoKubernetes
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.clientset != nil {
 		return c.clientset, nil
 	}
@@ -3,6 +3,7 @@ package deploy
 import (
 	"context"
 	"fmt"
+	"log"
 	"sort"
 
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
@@ -29,8 +30,15 @@ type Coordinator struct {
 	// ConfigMapName is the name of the ConfigMap.
 	ConfigMapName string
 
+	// History is an optional store to record lock and unlock events to.
+	History History
+
+	// OnHandover is an optional callback called when the lock is handed over to the first user in the queue,
+	// on unlock or on expiry.
+	OnHandover func(ctx context.Context, h Handover)
+
 	Clock
-	Kubernetes
+	*Kubernetes
 }
 
 type Clock interface {
@@ -43,29 +51,55 @@ func (systemClock) Now() metav1.Time {
 	return metav1.Now()
 }
 
-func NewCoordinator(ns, configMap string) *Coordinator {
+func NewCoordinator(k *Kubernetes, ns, configMap string) *Coordinator {
 	return &Coordinator{
 		Namespace:     ns,
 		ConfigMapName: configMap,
 		Clock:         systemClock{},
+		Kubernetes:    k,
 	}
 }
 
 var ErrAlreadyLocked = fmt.Errorf("deployment is already locked")
 var ErrAlreadyUnlocked = fmt.Errorf("deployment is already unlocked")
+var ErrNotLocked = fmt.Errorf("deployment is not locked")
+var ErrLockHolder = fmt.Errorf("you already have the lock")
+var ErrAlreadyQueued = fmt.Errorf("you are already in the queue")
+var ErrNotQueued = fmt.Errorf("you are not in the queue")
 
 const (
 	MaxConfigMapUpdateRetries = 3
 )
 
+// LockOption customizes the lock history item recorded by Lock.
+type LockOption func(*LockHistoryItem)
+
+// WithExpiry makes the lock expire at the given time.
+// Expired locks are treated as unlocked, and released by ReapExpiredLocks.
+func WithExpiry(at metav1.Time) LockOption {
+	return func(item *LockHistoryItem) {
+		item.ExpiresAt = &at
+	}
+}
+
+// WithChannel records the Slack channel in which the lock was taken,
+// so that the automatic unlock on expiry can be notified to the channel.
+func WithChannel(channel string) LockOption {
+	return func(item *LockHistoryItem) {
+		item.Channel = channel
+	}
+}
+
 // Lock acquires a lock for the given project and environment.
 //
 // Under the hood, this retries to update the ConfigMap if the update fails due to a conflict.
-func (c *Coordinator) Lock(ctx context.Context, project, environment, user, reason string) error {
+func (c *Coordinator) Lock(ctx context.Context, project, environment, user, reason string, opts ...LockOption) error {
 	var retried int
 	for {
-		err := c.lock(ctx, project, environment, user, reason)
+		handovers, err := c.lock(ctx, project, environment, user, reason, opts...)
 		if err == nil {
+			c.handover(ctx, handovers)
+			c.record(ctx, Event{Project: project, Phase: environment, Action: EventActionLock, User: user, Message: reason})
 			return nil
 		}
 
@@ -82,23 +116,10 @@ func (c *Coordinator) Lock(ctx context.Context, project, environment, user, reas
 	}
 }
 
-func (c *Coordinator) lock(ctx context.Context, project, environment, user, reason string) error {
-	configMap, err := c.getOrCreateConfigMap(ctx)
-	if err != nil {
-		return fmt.Errorf("unable to get or create configmap: %w", err)
-	}
-
-	enc := &keysAndValuesEncoding{data: configMap.Data}
-	if err := enc.lock(project, environment, user, reason, c.Now()); err != nil {
-		return err
-	}
-
-	_, err = c.updateConfigMap(ctx, configMap)
-	if err != nil {
-		return err
-	}
-
-	return nil
+func (c *Coordinator) lock(ctx context.Context, project, environment, user, reason string, opts ...LockOption) ([]Handover, error) {
+	return c.modify(ctx, func(enc *keysAndValuesEncoding) error {
+		return enc.lock(project, environment, user, reason, c.Now(), opts...)
+	})
 }
 
 // Unlock releases the lock for the given project and environment.
@@ -109,8 +130,14 @@ func (c *Coordinator) lock(ctx context.Context, project, environment, user, reas
 func (c *Coordinator) Unlock(ctx context.Context, project, environment, user string, force bool) error {
 	var retried int
 	for {
-		err := c.unlock(ctx, project, environment, user, force)
+		handovers, err := c.unlock(ctx, project, environment, user, force)
 		if err == nil {
+			var msg string
+			if force {
+				msg = "forced"
+			}
+			c.record(ctx, Event{Project: project, Phase: environment, Action: EventActionUnlock, User: user, Message: msg})
+			c.handover(ctx, handovers)
 			return nil
 		}
 
@@ -127,23 +154,126 @@ func (c *Coordinator) Unlock(ctx context.Context, project, environment, user str
 	}
 }
 
-func (c *Coordinator) unlock(ctx context.Context, project, environment, user string, force bool) error {
+func (c *Coordinator) unlock(ctx context.Context, project, environment, user string, force bool) ([]Handover, error) {
+	return c.modify(ctx, func(enc *keysAndValuesEncoding) error {
+		return enc.unlock(project, environment, user, force, c.Now())
+	})
+}
+
+// Handover is a lock handed over to the first user in the queue.
+type Handover struct {
+	Project     string
+	Environment string
+	QueueItem
+
+	// ExpiresAt is the time when the lock handed over expires.
+	ExpiresAt metav1.Time
+}
+
+// Enqueue adds the user to the queue of the locked project and environment,
+// and returns the 1-based position in the queue.
+//
+// When the lock is released, the lock is handed over to the first user in the queue,
+// and OnHandover is called.
+//
+// Under the hood, this retries to update the ConfigMap if the update fails due to a conflict.
+func (c *Coordinator) Enqueue(ctx context.Context, project, environment string, item QueueItem) (int, error) {
+	var retried int
+	for {
+		var pos int
+		item.At = c.Now()
+		handovers, err := c.modify(ctx, func(enc *keysAndValuesEncoding) error {
+			var err error
+			pos, err = enc.enqueue(project, environment, item)
+			return err
+		})
+		if err == nil {
+			c.handover(ctx, handovers)
+			return pos, nil
+		}
+
+		if kerrors.IsConflict(err) {
+			if retried >= MaxConfigMapUpdateRetries {
+				return 0, fmt.Errorf("unable to enqueue after %d retries: %w", MaxConfigMapUpdateRetries, err)
+			}
+
+			retried++
+			continue
+		} else {
+			return 0, err
+		}
+	}
+}
+
+// Dequeue removes the user from the queue of the project and environment.
+//
+// Under the hood, this retries to update the ConfigMap if the update fails due to a conflict.
+func (c *Coordinator) Dequeue(ctx context.Context, project, environment, user string) error {
+	var retried int
+	for {
+		_, err := c.modify(ctx, func(enc *keysAndValuesEncoding) error {
+			return enc.dequeue(project, environment, user)
+		})
+		if err == nil {
+			return nil
+		}
+
+		if kerrors.IsConflict(err) {
+			if retried >= MaxConfigMapUpdateRetries {
+				return fmt.Errorf("unable to dequeue after %d retries: %w", MaxConfigMapUpdateRetries, err)
+			}
+
+			retried++
+			continue
+		} else {
+			return err
+		}
+	}
+}
+
+// modify applies fn to the encoding of the ConfigMap, and updates the ConfigMap.
+// It returns the locks handed over to the queued users by fn.
+func (c *Coordinator) modify(ctx context.Context, fn func(enc *keysAndValuesEncoding) error) ([]Handover, error) {
 	configMap, err := c.getOrCreateConfigMap(ctx)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("unable to get or create configmap: %w", err)
 	}
 
 	enc := &keysAndValuesEncoding{data: configMap.Data}
-	if err := enc.unlock(project, environment, user, force, c.Now()); err != nil {
-		return err
+	if err := fn(enc); err != nil {
+		return nil, err
 	}
 
 	_, err = c.updateConfigMap(ctx, configMap)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return enc.handovers, nil
+}
+
+// handover records the lock events of the handovers, and calls OnHandover for each of them.
+func (c *Coordinator) handover(ctx context.Context, handovers []Handover) {
+	for _, h := range handovers {
+		c.record(ctx, Event{Project: h.Project, Phase: h.Environment, Action: EventActionLock, User: h.User, Message: QueuedLockReason})
+		if c.OnHandover != nil {
+			c.OnHandover(ctx, h)
+		}
+	}
+}
+
+// record records the event to the history if it's configured.
+// The failure to record is logged but not returned, because it shouldn't fail the lock operation itself.
+func (c *Coordinator) record(ctx context.Context, ev Event) {
+	if c.History == nil {
+		return
+	}
+
+	ev.Result = EventResultSuccess
+	ev.At = c.Now()
+	if err := c.History.Record(ctx, ev); err != nil {
+		log.Printf("[ERROR] Failed to record %s event for %s %s: %v", ev.Action, ev.Project, ev.Phase, err)
+	}
 }
 
 type PhaseDesc struct {
@@ -219,9 +349,82 @@ func (c *Coordinator) FetchLocks(ctx context.Context, projectFilter, phaseFilter
 		return nil, err
 	}
 
+	// Expired locks are treated as unlocked even before ReapExpiredLocks releases them.
+	now := c.Now()
+	for _, phases := range locks {
+		for name, phase := range phases {
+			if phase.Expired(now) {
+				phase.Locked = false
+				phases[name] = phase
+			}
+		}
+	}
+
 	return locks, nil
 }
 
+// ExpiredLock is a lock released by ReapExpiredLocks.
+type ExpiredLock struct {
+	Project     string
+	Environment string
+	// Lock is the lock history item of the expired lock.
+	Lock LockHistoryItem
+}
+
+// ReapExpiredLocks releases all the expired locks, and returns them.
+//
+// Each released lock gets an unlock history item by AutoUnlockUser,
+// and an unlock event is recorded to the History if configured.
+//
+// Under the hood, this retries to update the ConfigMap if the update fails due to a conflict.
+func (c *Coordinator) ReapExpiredLocks(ctx context.Context) ([]ExpiredLock, error) {
+	var retried int
+	for {
+		expired, handovers, err := c.reapExpiredLocks(ctx)
+		if err == nil {
+			for _, l := range expired {
+				c.record(ctx, Event{Project: l.Project, Phase: l.Environment, Action: EventActionUnlock, User: AutoUnlockUser, Message: AutoUnlockReason})
+			}
+			c.handover(ctx, handovers)
+			return expired, nil
+		}
+
+		if kerrors.IsConflict(err) {
+			if retried >= MaxConfigMapUpdateRetries {
+				return nil, fmt.Errorf("unable to release expired locks after %d retries: %w", MaxConfigMapUpdateRetries, err)
+			}
+
+			retried++
+			continue
+		} else {
+			return nil, err
+		}
+	}
+}
+
+func (c *Coordinator) reapExpiredLocks(ctx context.Context) ([]ExpiredLock, []Handover, error) {
+	configMap, err := c.getOrCreateConfigMap(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	enc := &keysAndValuesEncoding{data: configMap.Data}
+	expired, err := enc.expireLocks(c.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(expired) == 0 {
+		return nil, nil, nil
+	}
+
+	if _, err := c.updateConfigMap(ctx, configMap); err != nil {
+		return nil, nil, err
+	}
+
+	return expired, enc.handovers, nil
+}
+
 type NotAllowedTounlockError struct {
 	User string
 }
@@ -233,3 +436,38 @@ func (e NotAllowedTounlockError) Error() string {
 func newNotAllowedToUnlockError(user string) NotAllowedTounlockError {
 	return NotAllowedTounlockError{User: user}
 }
+
+// LockedError is returned by CheckDeploy when the deployment is locked by another user.
+type LockedError struct {
+	Project     string
+	Environment string
+	// User is the user who holds the lock.
+	User string
+}
+
+func (e LockedError) Error() string {
+	return fmt.Sprintf("Deployment failed: locked by %s", e.User)
+}
+
+// CheckDeploy returns a LockedError if the given project and environment is locked by a user other than the given user.
+//
+// Pass an empty user for deployments that are not triggered by a user, like auto deployments,
+// so that any lock prevents the deployment.
+func (c *Coordinator) CheckDeploy(ctx context.Context, project, environment, user string) error {
+	locks, err := c.FetchLocks(ctx, project, environment)
+	if err != nil {
+		return err
+	}
+
+	lock, ok := locks[project][environment]
+	if !ok || !lock.Locked || len(lock.LockHistory) == 0 {
+		// Missing lock means it has never been locked.
+		return nil
+	}
+
+	if holder := lock.LockHistory[len(lock.LockHistory)-1].User; user == "" || holder != user {
+		return LockedError{Project: project, Environment: environment, User: holder}
+	}
+
+	return nil
+}
@@ -2,35 +2,45 @@ package deploy
 
 import (
 	"fmt"
+	"sort"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type keysAndValuesEncoding struct {
 	data map[string]string
+
+	// handovers is the list of locks handed over to the queued users by the operations on the encoding.
+	handovers []Handover
 }
 
-func (e *keysAndValuesEncoding) lock(project, environment, user, reason string, at metav1.Time) error {
+func (e *keysAndValuesEncoding) lock(project, environment, user, reason string, at metav1.Time, opts ...LockOption) error {
 	key := configMapKey(project, environment)
 	value, err := strToConfigMapValue(e.data[key])
 	if err != nil {
 		return fmt.Errorf("unable to unmarshal str into value: %w", err)
 	}
 
-	if value.Locked {
-		return ErrAlreadyLocked
+	if value.Expired(at) {
+		e.expire(project, environment, &value, at)
 	}
 
-	if n := len(value.LockHistory); n >= MaxHistoryItems {
-		value.LockHistory = value.LockHistory[n-MaxHistoryItems+1:]
+	if value.Locked {
+		return ErrAlreadyLocked
 	}
 
-	value.LockHistory = append(value.LockHistory, LockHistoryItem{
+	item := LockHistoryItem{
 		User:   user,
 		Action: LockActionLock,
 		At:     at,
 		Reason: reason,
-	})
+	}
+	for _, opt := range opts {
+		opt(&item)
+	}
+
+	appendLockHistory(&value, item)
 
 	value.Locked = true
 
@@ -49,7 +59,7 @@ func (e *keysAndValuesEncoding) unlock(project, environment, user string, force
 		return err
 	}
 
-	if !value.Locked {
+	if !value.Locked || value.Expired(at) {
 		return ErrAlreadyUnlocked
 	}
 
@@ -60,18 +70,183 @@ func (e *keysAndValuesEncoding) unlock(project, environment, user string, force
 			return newNotAllowedToUnlockError(user)
 		}
 
-		if n := len(value.LockHistory); n >= MaxHistoryItems {
-			value.LockHistory = value.LockHistory[n-MaxHistoryItems+1:]
-		}
-
 		value.Locked = false
-		value.LockHistory = append(value.LockHistory, LockHistoryItem{
+		appendLockHistory(&value, LockHistoryItem{
 			User:   user,
 			Action: LockActionUnlock,
 			At:     at,
 		})
 	}
 
+	e.handover(project, environment, &value, at)
+
+	e.data[key], err = configMapValueToStr(value)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// expireLocks releases the locks expired at the given time, and returns the lock history items of the released locks.
+//
+// Each released lock gets an unlock history item by AutoUnlockUser at the time of the expiry.
+func (e *keysAndValuesEncoding) expireLocks(at metav1.Time) ([]ExpiredLock, error) {
+	var expired []ExpiredLock
+	for k, v := range e.data {
+		value, err := strToConfigMapValue(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if !value.Expired(at) {
+			continue
+		}
+
+		project, environment := splitConfigMapKey(k)
+		expired = append(expired, ExpiredLock{
+			Project:     project,
+			Environment: environment,
+			Lock:        value.LockHistory[len(value.LockHistory)-1],
+		})
+
+		e.expire(project, environment, &value, at)
+
+		e.data[k], err = configMapValueToStr(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		return configMapKey(expired[i].Project, expired[i].Environment) < configMapKey(expired[j].Project, expired[j].Environment)
+	})
+
+	return expired, nil
+}
+
+// expire releases the expired lock of the phase by appending an unlock history item by AutoUnlockUser,
+// and hands over the lock to the first user in the queue if any.
+func (e *keysAndValuesEncoding) expire(project, environment string, value *Phase, at metav1.Time) {
+	last := value.LockHistory[len(value.LockHistory)-1]
+
+	value.Locked = false
+	appendLockHistory(value, LockHistoryItem{
+		User:   AutoUnlockUser,
+		Action: LockActionUnlock,
+		At:     *last.ExpiresAt,
+		Reason: AutoUnlockReason,
+	})
+
+	e.handover(project, environment, value, at)
+}
+
+// DefaultHandoverLockDuration is how long the lock handed over to the first user in the queue lasts,
+// if the lock released before it had no expiry.
+const DefaultHandoverLockDuration = 2 * time.Hour
+
+// handover locks the unlocked phase for the first user in the queue, if any.
+// The lock expires after the same duration as the lock released before it, or DefaultHandoverLockDuration,
+// so that the phase is not locked forever by the user who never deploys nor unlocks.
+func (e *keysAndValuesEncoding) handover(project, environment string, value *Phase, at metav1.Time) {
+	if value.Locked || len(value.Queue) == 0 {
+		return
+	}
+
+	next := value.Queue[0]
+	value.Queue = value.Queue[1:]
+
+	expiresAt := metav1.NewTime(at.Add(lastLockDuration(value)))
+	value.Locked = true
+	appendLockHistory(value, LockHistoryItem{
+		User:      next.User,
+		Action:    LockActionLock,
+		At:        at,
+		Reason:    QueuedLockReason,
+		ExpiresAt: &expiresAt,
+		Channel:   next.Channel,
+	})
+
+	e.handovers = append(e.handovers, Handover{Project: project, Environment: environment, QueueItem: next, ExpiresAt: expiresAt})
+}
+
+// lastLockDuration returns the duration of the last lock of the phase, or DefaultHandoverLockDuration if it had no expiry.
+func lastLockDuration(value *Phase) time.Duration {
+	for i := len(value.LockHistory) - 1; i >= 0; i-- {
+		item := value.LockHistory[i]
+		if item.Action != LockActionLock {
+			continue
+		}
+		if item.ExpiresAt != nil && item.ExpiresAt.After(item.At.Time) {
+			return item.ExpiresAt.Sub(item.At.Time)
+		}
+		break
+	}
+	return DefaultHandoverLockDuration
+}
+
+// enqueue adds the user to the end of the queue of the locked phase, and returns the 1-based position in the queue.
+func (e *keysAndValuesEncoding) enqueue(project, environment string, item QueueItem) (int, error) {
+	key := configMapKey(project, environment)
+	value, err := strToConfigMapValue(e.data[key])
+	if err != nil {
+		return 0, err
+	}
+
+	if value.Expired(item.At) {
+		e.expire(project, environment, &value, item.At)
+	}
+
+	if !value.Locked {
+		return 0, ErrNotLocked
+	}
+
+	if len(value.LockHistory) > 0 && value.LockHistory[len(value.LockHistory)-1].User == item.User {
+		return 0, ErrLockHolder
+	}
+
+	for _, q := range value.Queue {
+		if q.User == item.User {
+			return 0, ErrAlreadyQueued
+		}
+	}
+
+	value.Queue = append(value.Queue, item)
+
+	e.data[key], err = configMapValueToStr(value)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(value.Queue), nil
+}
+
+// dequeue removes the user from the queue of the phase.
+func (e *keysAndValuesEncoding) dequeue(project, environment, user string) error {
+	key := configMapKey(project, environment)
+	value, err := strToConfigMapValue(e.data[key])
+	if err != nil {
+		return err
+	}
+
+	var (
+		queue []QueueItem
+		found bool
+	)
+	for _, q := range value.Queue {
+		if q.User == user {
+			found = true
+			continue
+		}
+		queue = append(queue, q)
+	}
+
+	if !found {
+		return ErrNotQueued
+	}
+
+	value.Queue = queue
+
 	e.data[key], err = configMapValueToStr(value)
 	if err != nil {
 		return err
@@ -80,6 +255,15 @@ func (e *keysAndValuesEncoding) unlock(project, environment, user string, force
 	return nil
 }
 
+// appendLockHistory appends the item to the lock history, keeping the last MaxHistoryItems items.
+func appendLockHistory(value *Phase, item LockHistoryItem) {
+	if n := len(value.LockHistory); n >= MaxHistoryItems {
+		value.LockHistory = value.LockHistory[n-MaxHistoryItems+1:]
+	}
+
+	value.LockHistory = append(value.LockHistory, item)
+}
+
 func (e *keysAndValuesEncoding) describeLocks(projectFilter, phaseFilter string) (map[string]map[string]Phase, error) {
 	locks := make(map[string]map[string]Phase)
 	for k, v := range e.data {
@@ -80,3 +80,64 @@ func TestKeysAndValuesEncoding(t *testing.T) {
 		"myproject2-api-prod": `{"locked":true,"lockHistory":[{"user":"user1","action":"lock","at":"2021-09-01T00:00:00Z","reason":"for deployment of revision 3a"}]}`,
 	}, data)
 }
+
+func TestKeysAndValuesEncodingExpiry(t *testing.T) {
+	data := map[string]string{}
+	enc := &keysAndValuesEncoding{
+		data: data,
+	}
+
+	now, err := time.Parse(time.RFC3339, "2021-09-01T00:00:00Z")
+	require.NoError(t, err)
+
+	kNow := metav1.NewTime(now.Local())
+	kExpiry := metav1.NewTime(now.Add(2 * time.Hour).Local())
+	kLater := metav1.NewTime(now.Add(3 * time.Hour).Local())
+
+	require.NoError(t, enc.lock("myproject1", "prod", "user1", "release freeze", kNow, WithExpiry(kExpiry), WithChannel("C1234")))
+	require.NoError(t, enc.lock("myproject2", "prod", "user1", "release freeze", kNow))
+
+	require.ErrorIs(t, enc.lock("myproject1", "prod", "user2", "hotfix", kNow), ErrAlreadyLocked)
+	require.ErrorIs(t, enc.unlock("myproject1", "prod", "user1", false, kLater), ErrAlreadyUnlocked)
+
+	expired, err := enc.expireLocks(kNow)
+	require.NoError(t, err)
+	require.Empty(t, expired)
+
+	expired, err = enc.expireLocks(kLater)
+	require.NoError(t, err)
+	require.Equal(t, []ExpiredLock{
+		{
+			Project:     "myproject1",
+			Environment: "prod",
+			Lock: LockHistoryItem{
+				User:      "user1",
+				Action:    LockActionLock,
+				At:        kNow,
+				Reason:    "release freeze",
+				ExpiresAt: &kExpiry,
+				Channel:   "C1234",
+			},
+		},
+	}, expired)
+
+	assert.Equal(t, map[string]string{
+		"myproject1-prod": `{"locked":false,"lockHistory":[{"user":"user1","action":"lock","at":"2021-09-01T00:00:00Z","reason":"release freeze","expiresAt":"2021-09-01T02:00:00Z","channel":"C1234"},{"user":"gocat","action":"unlock","at":"2021-09-01T02:00:00Z","reason":"expired"}]}`,
+		"myproject2-prod": `{"locked":true,"lockHistory":[{"user":"user1","action":"lock","at":"2021-09-01T00:00:00Z","reason":"release freeze"}]}`,
+	}, data)
+
+	expired, err = enc.expireLocks(kLater)
+	require.NoError(t, err)
+	require.Empty(t, expired)
+
+	// Locking an expired but not yet reaped lock releases it first.
+	require.NoError(t, enc.lock("myproject3", "prod", "user1", "release freeze", kNow, WithExpiry(kExpiry)))
+	require.NoError(t, enc.lock("myproject3", "prod", "user2", "hotfix", kLater))
+	locks, err := enc.describeLocks("myproject3", "prod")
+	require.NoError(t, err)
+	require.Equal(t, []LockHistoryItem{
+		{User: "user1", Action: LockActionLock, At: kNow, Reason: "release freeze", ExpiresAt: &kExpiry},
+		{User: AutoUnlockUser, Action: LockActionUnlock, At: kExpiry, Reason: AutoUnlockReason},
+		{User: "user2", Action: LockActionLock, At: kLater, Reason: "hotfix"},
+	}, locks["myproject3"]["prod"].LockHistory)
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 
 	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/i18n"
 )
 
 func TestLockUnlock(t *testing.T) {
@@ -19,7 +22,7 @@ func TestLockUnlock(t *testing.T) {
 		t.Skip("GOCAT_TEST_KUBECONFIG is not set")
 	}
 
-	c := NewCoordinator("default", "gocat-test")
+	c := NewCoordinator(&Kubernetes{}, "default", "gocat-test")
 	defer func() {
 		if c, _ := c.ClientSet(); c != nil {
 			if err := c.CoreV1().ConfigMaps("default").Delete(context.Background(), "gocat-test", metav1.DeleteOptions{}); err != nil {
@@ -54,6 +57,154 @@ func TestLockUnlock(t *testing.T) {
 	}
 }
 
+func TestCheckDeploy(t *testing.T) {
+	c := NewCoordinator(NewKubernetes(fake.NewSimpleClientset()), "default", "gocat-test")
+
+	ctx := context.Background()
+
+	require.NoError(t, c.CheckDeploy(ctx, "myproject1", "prod", "user1"))
+	require.NoError(t, c.CheckDeploy(ctx, "myproject1", "prod", ""))
+
+	require.NoError(t, c.Lock(ctx, "myproject1", "prod", "user1", "for deployment of revision a"))
+	require.NoError(t, c.CheckDeploy(ctx, "myproject1", "prod", "user1"))
+	require.NoError(t, c.CheckDeploy(ctx, "myproject1", "staging", "user2"))
+	require.Equal(t, LockedError{Project: "myproject1", Environment: "prod", User: "user1"}, c.CheckDeploy(ctx, "myproject1", "prod", "user2"))
+	require.EqualError(t, c.CheckDeploy(ctx, "myproject1", "prod", ""), "Deployment failed: locked by user1")
+
+	require.NoError(t, c.Unlock(ctx, "myproject1", "prod", "user1", false))
+	require.NoError(t, c.CheckDeploy(ctx, "myproject1", "prod", "user2"))
+}
+
+func TestReapExpiredLocks(t *testing.T) {
+	now, err := time.Parse(time.RFC3339, "2021-09-01T00:00:00Z")
+	require.NoError(t, err)
+
+	clock := &fakeClock{now: metav1.NewTime(now)}
+	history := NewFileHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+
+	c := NewCoordinator(NewKubernetes(fake.NewSimpleClientset()), "default", "gocat-test")
+	c.Clock = clock
+	c.History = history
+
+	ctx := context.Background()
+
+	require.NoError(t, c.Lock(ctx, "myproject1", "prod", "user1", "release freeze", WithExpiry(metav1.NewTime(now.Add(2*time.Hour))), WithChannel("C1234")))
+	require.Equal(t, LockedError{Project: "myproject1", Environment: "prod", User: "user1"}, c.CheckDeploy(ctx, "myproject1", "prod", "user2"))
+
+	expired, err := c.ReapExpiredLocks(ctx)
+	require.NoError(t, err)
+	require.Empty(t, expired)
+
+	clock.now = metav1.NewTime(now.Add(2 * time.Hour))
+
+	// The expired lock is treated as unlocked even before it is reaped.
+	require.NoError(t, c.CheckDeploy(ctx, "myproject1", "prod", "user2"))
+	projects, err := c.DescribeLocks(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "", FormatProjectDescs(projects, i18n.English))
+
+	expired, err = c.ReapExpiredLocks(ctx)
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	require.Equal(t, "myproject1", expired[0].Project)
+	require.Equal(t, "prod", expired[0].Environment)
+	require.Equal(t, "C1234", expired[0].Lock.Channel)
+
+	expired, err = c.ReapExpiredLocks(ctx)
+	require.NoError(t, err)
+	require.Empty(t, expired)
+
+	events, err := history.List(ctx, "myproject1", "prod", 1)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, EventActionUnlock, events[0].Action)
+	require.Equal(t, AutoUnlockUser, events[0].User)
+	require.Equal(t, AutoUnlockReason, events[0].Message)
+}
+
+func TestQueue(t *testing.T) {
+	now, err := time.Parse(time.RFC3339, "2021-09-01T00:00:00Z")
+	require.NoError(t, err)
+
+	c := NewCoordinator(NewKubernetes(fake.NewSimpleClientset()), "default", "gocat-test")
+	c.Clock = &fakeClock{now: metav1.NewTime(now)}
+
+	var handovers []Handover
+	c.OnHandover = func(ctx context.Context, h Handover) {
+		handovers = append(handovers, h)
+	}
+
+	ctx := context.Background()
+
+	_, err = c.Enqueue(ctx, "myproject1", "staging", QueueItem{User: "user2"})
+	require.ErrorIs(t, err, ErrNotLocked)
+
+	require.NoError(t, c.Lock(ctx, "myproject1", "staging", "user1", "testing"))
+
+	_, err = c.Enqueue(ctx, "myproject1", "staging", QueueItem{User: "user1"})
+	require.ErrorIs(t, err, ErrLockHolder)
+
+	pos, err := c.Enqueue(ctx, "myproject1", "staging", QueueItem{User: "user2", UserID: "U2", Branch: "feature-a", Channel: "C1234"})
+	require.NoError(t, err)
+	require.Equal(t, 1, pos)
+
+	_, err = c.Enqueue(ctx, "myproject1", "staging", QueueItem{User: "user2"})
+	require.ErrorIs(t, err, ErrAlreadyQueued)
+
+	pos, err = c.Enqueue(ctx, "myproject1", "staging", QueueItem{User: "user3", UserID: "U3"})
+	require.NoError(t, err)
+	require.Equal(t, 2, pos)
+
+	pos, err = c.Enqueue(ctx, "myproject1", "staging", QueueItem{User: "user4", UserID: "U4"})
+	require.NoError(t, err)
+	require.Equal(t, 3, pos)
+
+	require.NoError(t, c.Dequeue(ctx, "myproject1", "staging", "user3"))
+	require.ErrorIs(t, c.Dequeue(ctx, "myproject1", "staging", "user3"), ErrNotQueued)
+
+	projects, err := c.DescribeLocks(ctx)
+	require.NoError(t, err)
+	require.Equal(t, `myproject1
+  staging: Locked (by user1, for testing)
+    queue: user2 (feature-a), user4
+`, FormatProjectDescs(projects, i18n.English))
+
+	require.NoError(t, c.Unlock(ctx, "myproject1", "staging", "user1", false))
+	// The lock without expiry is handed over with the default expiry.
+	require.Equal(t, []Handover{
+		{
+			Project:     "myproject1",
+			Environment: "staging",
+			QueueItem:   QueueItem{User: "user2", UserID: "U2", Branch: "feature-a", Channel: "C1234", At: metav1.NewTime(now.Local())},
+			ExpiresAt:   metav1.NewTime(now.Add(DefaultHandoverLockDuration)),
+		},
+	}, handovers)
+
+	require.Equal(t, LockedError{Project: "myproject1", Environment: "staging", User: "user2"}, c.CheckDeploy(ctx, "myproject1", "staging", "user1"))
+	require.NoError(t, c.CheckDeploy(ctx, "myproject1", "staging", "user2"))
+
+	require.NoError(t, c.Unlock(ctx, "myproject1", "staging", "user3", true))
+	require.Len(t, handovers, 2)
+	require.Equal(t, "user4", handovers[1].User)
+
+	require.NoError(t, c.Unlock(ctx, "myproject1", "staging", "user4", false))
+	require.Len(t, handovers, 2)
+
+	// The lock is handed over with the same duration as the lock released before it.
+	require.NoError(t, c.Lock(ctx, "myproject1", "staging", "user1", "testing", WithExpiry(metav1.NewTime(now.Add(30*time.Minute)))))
+	_, err = c.Enqueue(ctx, "myproject1", "staging", QueueItem{User: "user2", UserID: "U2"})
+	require.NoError(t, err)
+	c.Clock = &fakeClock{now: metav1.NewTime(now.Add(10 * time.Minute))}
+	require.NoError(t, c.Unlock(ctx, "myproject1", "staging", "user1", false))
+	require.Len(t, handovers, 3)
+	require.True(t, now.Add(40*time.Minute).Equal(handovers[2].ExpiresAt.Time))
+	require.NoError(t, c.Unlock(ctx, "myproject1", "staging", "user2", false))
+
+	projects, err = c.DescribeLocks(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "", FormatProjectDescs(projects, i18n.English))
+}
+
 type fakeClock struct {
 	now metav1.Time
 }
@@ -69,7 +220,7 @@ func TestDescribeLocks(t *testing.T) {
 		t.Skip("GOCAT_TEST_KUBECONFIG is not set")
 	}
 
-	c := NewCoordinator("default", "gocat-test")
+	c := NewCoordinator(&Kubernetes{}, "default", "gocat-test")
 	defer func() {
 		if c, _ := c.ClientSet(); c != nil {
 			if err := c.CoreV1().ConfigMaps("default").Delete(context.Background(), "gocat-test", metav1.DeleteOptions{}); err != nil {
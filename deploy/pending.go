@@ -0,0 +1,435 @@
+package deploy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PendingRequest is a deploy request waiting for the approval.
+//
+// Interactors store the request when they ask for the approval,
+// and put only the ID of the request into the values of the approve and reject buttons.
+// The request is looked up by the ID when the button is clicked.
+type PendingRequest struct {
+	ID      string `json:"id"`
+	Project string `json:"project"`
+	Phase   string `json:"phase"`
+	Branch  string `json:"branch,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+	// Action is the action recorded to the history on approval, which defaults to EventActionDeploy.
+	Action EventAction `json:"action,omitempty"`
+	// Requester is the Slack user ID of the user who requested the deployment.
+	Requester string `json:"requester"`
+	// Channel is the Slack channel ID in which the deployment was requested.
+	Channel string `json:"channel,omitempty"`
+	// ThreadTS is the timestamp of the root message of the Slack thread of the deployment in the channel,
+	// which is updated as the deployment proceeds.
+	ThreadTS string `json:"threadTS,omitempty"`
+	// PullRequestID is the node ID of the pull request created for GitOps deployments.
+	PullRequestID     string `json:"pullRequestID,omitempty"`
+	PullRequestNumber int    `json:"pullRequestNumber,omitempty"`
+	// PullRequestBranch is the head branch of the pull request, deleted on rejection.
+	PullRequestBranch string `json:"pullRequestBranch,omitempty"`
+	// Approvals is the list of approvals collected so far, when the phase requires multiple approvals.
+	Approvals []Approval `json:"approvals,omitempty"`
+	// Claimed is true while the request is being deployed after it has got enough approvals, or being rejected.
+	// The request is claimed this way so that it's deployed only once, even if the approve button is clicked twice.
+	Claimed   bool        `json:"claimed,omitempty"`
+	CreatedAt metav1.Time `json:"createdAt"`
+}
+
+// Approval is an approval of a pending request.
+type Approval struct {
+	// User is the Slack user ID of the approver.
+	User string      `json:"user"`
+	At   metav1.Time `json:"at"`
+}
+
+// ApprovedBy returns true if the user has already approved the request.
+func (r PendingRequest) ApprovedBy(user string) bool {
+	for _, a := range r.Approvals {
+		if a.User == user {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired returns true if the request was created more than ttl before the given time.
+// A zero or negative ttl means the request never expires.
+func (r PendingRequest) Expired(ttl time.Duration, at metav1.Time) bool {
+	return ttl > 0 && at.Sub(r.CreatedAt.Time) > ttl
+}
+
+// ErrStaleRequest is returned when the pending request is unknown, expired, or already approved or rejected.
+// This happens when someone clicks a button of an old message.
+var ErrStaleRequest = errors.New("This deploy request has expired or has already been handled. Please request the deployment again")
+
+// ErrRequestInProgress is returned when the pending request is already being deployed or rejected.
+// This happens when the approve button is clicked twice, or by multiple approvers at the same time.
+var ErrRequestInProgress = errors.New("This deploy request is already being handled")
+
+const (
+	// DefaultPendingRequestTTL is how long a deploy request can be approved after it's requested.
+	DefaultPendingRequestTTL = 24 * time.Hour
+
+	PendingRequestsConfigMapType = "pending-requests"
+)
+
+// PendingRequestStore stores deploy requests waiting for the approval.
+type PendingRequestStore interface {
+	// Create stores the request and returns it with the newly assigned ID and CreatedAt.
+	Create(ctx context.Context, req PendingRequest) (PendingRequest, error)
+	// Get returns the request, or ErrStaleRequest if it's unknown or expired.
+	Get(ctx context.Context, id string) (PendingRequest, error)
+	// Modify applies fn to the current value of the request and stores it, and returns the modified request.
+	// The request is read and written atomically, so that concurrent modifications are not lost.
+	// ErrStaleRequest is returned if the request is unknown or expired, and the error of fn as is.
+	Modify(ctx context.Context, id string, fn func(*PendingRequest) error) (PendingRequest, error)
+	// Delete removes the request so that it can't be approved or rejected again.
+	// Deleting an unknown request is not an error.
+	Delete(ctx context.Context, id string) error
+	// List returns the requests that are not expired, oldest first.
+	List(ctx context.Context) ([]PendingRequest, error)
+}
+
+// sortPendingRequests sorts the requests by CreatedAt, and then by ID for the requests created at the same time.
+func sortPendingRequests(reqs []PendingRequest) {
+	sort.Slice(reqs, func(i, j int) bool {
+		if !reqs[i].CreatedAt.Equal(&reqs[j].CreatedAt) {
+			return reqs[i].CreatedAt.Before(&reqs[j].CreatedAt)
+		}
+		return reqs[i].ID < reqs[j].ID
+	})
+}
+
+// newPendingRequestID returns a random opaque ID that is also valid as a ConfigMap key.
+func newPendingRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("unable to generate request ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// MemoryPendingRequestStore is a PendingRequestStore that keeps the requests in memory.
+// The requests are lost when gocat restarts.
+type MemoryPendingRequestStore struct {
+	// TTL is how long the requests are kept. Zero means forever.
+	TTL time.Duration
+
+	Clock
+
+	mu       sync.Mutex
+	requests map[string]PendingRequest
+}
+
+func NewMemoryPendingRequestStore(ttl time.Duration) *MemoryPendingRequestStore {
+	return &MemoryPendingRequestStore{
+		TTL:      ttl,
+		Clock:    systemClock{},
+		requests: make(map[string]PendingRequest),
+	}
+}
+
+func (s *MemoryPendingRequestStore) Create(ctx context.Context, req PendingRequest) (PendingRequest, error) {
+	id, err := newPendingRequestID()
+	if err != nil {
+		return PendingRequest{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Now()
+	for k, r := range s.requests {
+		if r.Expired(s.TTL, now) {
+			delete(s.requests, k)
+		}
+	}
+
+	req.ID = id
+	req.CreatedAt = now
+	s.requests[id] = req
+
+	return req, nil
+}
+
+func (s *MemoryPendingRequestStore) Get(ctx context.Context, id string) (PendingRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, ok := s.requests[id]
+	if !ok || req.Expired(s.TTL, s.Now()) {
+		return PendingRequest{}, ErrStaleRequest
+	}
+
+	return req, nil
+}
+
+func (s *MemoryPendingRequestStore) Modify(ctx context.Context, id string, fn func(*PendingRequest) error) (PendingRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, ok := s.requests[id]
+	if !ok || req.Expired(s.TTL, s.Now()) {
+		return PendingRequest{}, ErrStaleRequest
+	}
+	if err := fn(&req); err != nil {
+		return PendingRequest{}, err
+	}
+	s.requests[id] = req
+
+	return req, nil
+}
+
+func (s *MemoryPendingRequestStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.requests, id)
+
+	return nil
+}
+
+func (s *MemoryPendingRequestStore) List(ctx context.Context) ([]PendingRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Now()
+	var reqs []PendingRequest
+	for _, r := range s.requests {
+		if !r.Expired(s.TTL, now) {
+			reqs = append(reqs, r)
+		}
+	}
+	sortPendingRequests(reqs)
+
+	return reqs, nil
+}
+
+// ConfigMapPendingRequestStore is a PendingRequestStore backed by a Kubernetes ConfigMap,
+// so that the requests survive restarts of gocat.
+//
+// Each key of the ConfigMap is the ID of the request, and the value is the request in JSON.
+// Expired requests are removed when a new request is created.
+type ConfigMapPendingRequestStore struct {
+	// Namespace is the namespace in which the ConfigMap is created.
+	Namespace string
+
+	// ConfigMapName is the name of the ConfigMap.
+	ConfigMapName string
+
+	// TTL is how long the requests are kept. Zero means forever.
+	TTL time.Duration
+
+	Clock
+	*Kubernetes
+}
+
+func NewConfigMapPendingRequestStore(k *Kubernetes, ns, configMap string, ttl time.Duration) *ConfigMapPendingRequestStore {
+	return &ConfigMapPendingRequestStore{
+		Namespace:     ns,
+		ConfigMapName: configMap,
+		TTL:           ttl,
+		Clock:         systemClock{},
+		Kubernetes:    k,
+	}
+}
+
+func (s *ConfigMapPendingRequestStore) Create(ctx context.Context, req PendingRequest) (PendingRequest, error) {
+	id, err := newPendingRequestID()
+	if err != nil {
+		return PendingRequest{}, err
+	}
+
+	req.ID = id
+	req.CreatedAt = s.Now()
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return PendingRequest{}, err
+	}
+
+	err = s.modify(ctx, func(configMap *corev1.ConfigMap) error {
+		for k, v := range configMap.Data {
+			var r PendingRequest
+			if err := json.Unmarshal([]byte(v), &r); err != nil || r.Expired(s.TTL, req.CreatedAt) {
+				delete(configMap.Data, k)
+			}
+		}
+
+		configMap.Data[id] = string(data)
+		return nil
+	})
+	if err != nil {
+		return PendingRequest{}, err
+	}
+
+	return req, nil
+}
+
+func (s *ConfigMapPendingRequestStore) Get(ctx context.Context, id string) (PendingRequest, error) {
+	clientset, err := s.ClientSet()
+	if err != nil {
+		return PendingRequest{}, err
+	}
+
+	configMap, err := clientset.CoreV1().ConfigMaps(s.Namespace).Get(ctx, s.ConfigMapName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return PendingRequest{}, ErrStaleRequest
+	} else if err != nil {
+		return PendingRequest{}, err
+	}
+
+	v, ok := configMap.Data[id]
+	if !ok {
+		return PendingRequest{}, ErrStaleRequest
+	}
+
+	var req PendingRequest
+	if err := json.Unmarshal([]byte(v), &req); err != nil {
+		return PendingRequest{}, fmt.Errorf("unable to unmarshal pending request: %w", err)
+	}
+
+	if req.Expired(s.TTL, s.Now()) {
+		return PendingRequest{}, ErrStaleRequest
+	}
+
+	return req, nil
+}
+
+// Modify applies fn to the request read from the ConfigMap on each try of the update,
+// so that the retry on a conflict doesn't overwrite the modification made by someone else.
+func (s *ConfigMapPendingRequestStore) Modify(ctx context.Context, id string, fn func(*PendingRequest) error) (PendingRequest, error) {
+	var req PendingRequest
+	err := s.modify(ctx, func(configMap *corev1.ConfigMap) error {
+		v, ok := configMap.Data[id]
+		if !ok {
+			return ErrStaleRequest
+		}
+
+		req = PendingRequest{}
+		if err := json.Unmarshal([]byte(v), &req); err != nil {
+			return fmt.Errorf("unable to unmarshal pending request: %w", err)
+		}
+		if req.Expired(s.TTL, s.Now()) {
+			return ErrStaleRequest
+		}
+
+		if err := fn(&req); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		configMap.Data[id] = string(data)
+		return nil
+	})
+	if err != nil {
+		return PendingRequest{}, err
+	}
+
+	return req, nil
+}
+
+func (s *ConfigMapPendingRequestStore) Delete(ctx context.Context, id string) error {
+	return s.modify(ctx, func(configMap *corev1.ConfigMap) error {
+		delete(configMap.Data, id)
+		return nil
+	})
+}
+
+func (s *ConfigMapPendingRequestStore) List(ctx context.Context) ([]PendingRequest, error) {
+	clientset, err := s.ClientSet()
+	if err != nil {
+		return nil, err
+	}
+
+	configMap, err := clientset.CoreV1().ConfigMaps(s.Namespace).Get(ctx, s.ConfigMapName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := s.Now()
+	var reqs []PendingRequest
+	for _, v := range configMap.Data {
+		var req PendingRequest
+		if err := json.Unmarshal([]byte(v), &req); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal pending request: %w", err)
+		}
+		if !req.Expired(s.TTL, now) {
+			reqs = append(reqs, req)
+		}
+	}
+	sortPendingRequests(reqs)
+
+	return reqs, nil
+}
+
+// modify gets or creates the ConfigMap, applies fn to it, and updates it.
+//
+// Under the hood, this retries to update the ConfigMap if the update fails due to a conflict.
+func (s *ConfigMapPendingRequestStore) modify(ctx context.Context, fn func(*corev1.ConfigMap) error) error {
+	clientset, err := s.ClientSet()
+	if err != nil {
+		return err
+	}
+
+	configMaps := clientset.CoreV1().ConfigMaps(s.Namespace)
+
+	var retried int
+	for {
+		configMap, err := configMaps.Get(ctx, s.ConfigMapName, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			configMap, err = configMaps.Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: s.ConfigMapName,
+					Labels: map[string]string{
+						"gocat.zaim.net/configmap-type": PendingRequestsConfigMapType,
+					},
+				},
+			}, metav1.CreateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("unable to get or create configmap: %w", err)
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+
+		if err := fn(configMap); err != nil {
+			return err
+		}
+
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+		if err == nil {
+			return nil
+		}
+
+		if !kerrors.IsConflict(err) {
+			return err
+		}
+
+		if retried >= MaxConfigMapUpdateRetries {
+			return fmt.Errorf("unable to update configmap after %d retries: %w", MaxConfigMapUpdateRetries, err)
+		}
+		retried++
+	}
+}
@@ -0,0 +1,138 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPendingRequestStore(t *testing.T) {
+	now, err := time.Parse(time.RFC3339, "2021-09-01T00:00:00Z")
+	require.NoError(t, err)
+
+	type store struct {
+		name  string
+		store func(clock Clock) PendingRequestStore
+	}
+
+	stores := []store{
+		{
+			name: "memory",
+			store: func(clock Clock) PendingRequestStore {
+				s := NewMemoryPendingRequestStore(time.Hour)
+				s.Clock = clock
+				return s
+			},
+		},
+		{
+			name: "configmap",
+			store: func(clock Clock) PendingRequestStore {
+				s := NewConfigMapPendingRequestStore(NewKubernetes(fake.NewSimpleClientset()), "default", "pending", time.Hour)
+				s.Clock = clock
+				return s
+			},
+		},
+	}
+
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			ctx := context.Background()
+			clock := &fakeClock{now: metav1.NewTime(now.Local())}
+			s := st.store(clock)
+
+			// Project IDs and branches containing underscores are kept as is.
+			req, err := s.Create(ctx, PendingRequest{
+				Project:           "my_project",
+				Phase:             "staging",
+				Branch:            "feature_x",
+				Requester:         "U1234",
+				PullRequestID:     "PR_kwDOabc_123",
+				PullRequestNumber: 2,
+				PullRequestBranch: "bot/docker-image-tag-my_project-staging-abc1234",
+			})
+			require.NoError(t, err)
+			require.NotEmpty(t, req.ID)
+			require.Equal(t, clock.now, req.CreatedAt)
+
+			got, err := s.Get(ctx, req.ID)
+			require.NoError(t, err)
+			require.Equal(t, req, got)
+
+			list, err := s.List(ctx)
+			require.NoError(t, err)
+			require.Equal(t, []PendingRequest{req}, list)
+
+			_, err = s.Get(ctx, "unknown")
+			require.ErrorIs(t, err, ErrStaleRequest)
+
+			approve := func(user string) func(*PendingRequest) error {
+				return func(r *PendingRequest) error {
+					r.Approvals = append(r.Approvals, Approval{User: user, At: clock.now})
+					return nil
+				}
+			}
+			modified, err := s.Modify(ctx, req.ID, approve("U5678"))
+			require.NoError(t, err)
+			require.True(t, modified.ApprovedBy("U5678"))
+			got, err = s.Get(ctx, req.ID)
+			require.NoError(t, err)
+			require.Equal(t, modified, got)
+			require.False(t, got.ApprovedBy("U1234"))
+
+			// The modification is applied to the current value rather than the one read before.
+			modified, err = s.Modify(ctx, req.ID, approve("U9012"))
+			require.NoError(t, err)
+			require.True(t, modified.ApprovedBy("U5678"))
+			require.True(t, modified.ApprovedBy("U9012"))
+
+			// The error of the modification is returned as is, without storing the request.
+			_, err = s.Modify(ctx, req.ID, func(r *PendingRequest) error {
+				r.Claimed = true
+				return ErrRequestInProgress
+			})
+			require.ErrorIs(t, err, ErrRequestInProgress)
+			got, err = s.Get(ctx, req.ID)
+			require.NoError(t, err)
+			require.False(t, got.Claimed)
+
+			_, err = s.Modify(ctx, "unknown", approve("U5678"))
+			require.ErrorIs(t, err, ErrStaleRequest)
+
+			require.NoError(t, s.Delete(ctx, req.ID))
+			_, err = s.Get(ctx, req.ID)
+			require.ErrorIs(t, err, ErrStaleRequest)
+			require.NoError(t, s.Delete(ctx, req.ID))
+
+			old, err := s.Create(ctx, PendingRequest{Project: "myproject", Phase: "production"})
+			require.NoError(t, err)
+
+			clock.now = metav1.NewTime(clock.now.Add(time.Hour + time.Second))
+			_, err = s.Get(ctx, old.ID)
+			require.ErrorIs(t, err, ErrStaleRequest)
+
+			// Creating a request removes the expired ones.
+			latest, err := s.Create(ctx, PendingRequest{Project: "myproject", Phase: "production"})
+			require.NoError(t, err)
+			clock.now = metav1.NewTime(now.Local())
+			_, err = s.Get(ctx, old.ID)
+			require.ErrorIs(t, err, ErrStaleRequest)
+
+			// The requests are listed oldest first.
+			older, err := s.Create(ctx, PendingRequest{Project: "myproject", Phase: "staging"})
+			require.NoError(t, err)
+			list, err = s.List(ctx)
+			require.NoError(t, err)
+			require.Equal(t, []PendingRequest{older, latest}, list)
+
+			// The expired requests are not listed.
+			clock.now = metav1.NewTime(latest.CreatedAt.Add(time.Minute))
+			list, err = s.List(ctx)
+			require.NoError(t, err)
+			require.Equal(t, []PendingRequest{latest}, list)
+		})
+	}
+}
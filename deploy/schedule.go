@@ -0,0 +1,277 @@
+package deploy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule restricts when a project phase can be deployed.
+//
+// Deployments are allowed only within one of the deploy windows, if any, and never during a freeze.
+type Schedule struct {
+	// DeployWindows are the periods in which deployments are allowed. Any time is allowed if empty.
+	DeployWindows []DeployWindow `yaml:"deployWindows"`
+	// Freezes are the periods in which deployments are not allowed, even within the deploy windows.
+	Freezes []Freeze `yaml:"freezes"`
+}
+
+// DeployWindow is a cron-like expression of the minutes in which deployments are allowed.
+//
+// The expression has the five fields of cron, `minute hour day-of-month month day-of-week`.
+// Each field accepts `*`, numbers, ranges like `1-5`, steps like `*/15`, and lists like `1,3,5`.
+// Months and days of week also accept names like `Jan` and `Mon`.
+//
+// For example, the following allows deployments from 10:00 to 17:59 on Monday to Thursday in Japan:
+//
+//	deployWindows:
+//	- cron: "* 10-17 * * Mon-Thu"
+//	  timeZone: Asia/Tokyo
+type DeployWindow struct {
+	Cron string `yaml:"cron"`
+	// TimeZone is the IANA time zone name in which the expression is evaluated. Defaults to the local time zone.
+	TimeZone string `yaml:"timeZone"`
+}
+
+func (w DeployWindow) String() string {
+	if w.TimeZone == "" {
+		return fmt.Sprintf("`%s`", w.Cron)
+	}
+	return fmt.Sprintf("`%s` (%s)", w.Cron, w.TimeZone)
+}
+
+// Contains returns true if the minute of t matches the expression.
+func (w DeployWindow) Contains(t time.Time) (bool, error) {
+	spec, err := parseCron(w.Cron)
+	if err != nil {
+		return false, err
+	}
+
+	if w.TimeZone != "" {
+		loc, err := time.LoadLocation(w.TimeZone)
+		if err != nil {
+			return false, fmt.Errorf("invalid time zone of deploy window %q: %w", w.Cron, err)
+		}
+		t = t.In(loc)
+	}
+
+	return spec.matches(t), nil
+}
+
+// Freeze is an absolute period in which deployments are not allowed.
+type Freeze struct {
+	From   time.Time `yaml:"from"`
+	To     time.Time `yaml:"to"`
+	Reason string    `yaml:"reason"`
+}
+
+// Contains returns true if t is within [From, To).
+func (f Freeze) Contains(t time.Time) bool {
+	return !t.Before(f.From) && t.Before(f.To)
+}
+
+// Merge returns the schedule combined with the other, less specific schedule.
+//
+// The deploy windows of s take precedence over the other's, so that a phase can have its own windows
+// instead of the global ones. The freezes of both schedules apply.
+func (s Schedule) Merge(other Schedule) Schedule {
+	merged := Schedule{DeployWindows: s.DeployWindows}
+	if len(merged.DeployWindows) == 0 {
+		merged.DeployWindows = other.DeployWindows
+	}
+	merged.Freezes = append(append([]Freeze{}, s.Freezes...), other.Freezes...)
+	return merged
+}
+
+// Validate returns an error if a deploy window has an invalid expression or time zone,
+// or if a freeze doesn't end after it starts.
+func (s Schedule) Validate() error {
+	for _, w := range s.DeployWindows {
+		if _, err := w.Contains(time.Time{}); err != nil {
+			return err
+		}
+	}
+	for _, f := range s.Freezes {
+		if !f.To.After(f.From) {
+			return fmt.Errorf("invalid freeze %q: it must end after it starts", f.Reason)
+		}
+	}
+	return nil
+}
+
+// Check returns a ScheduleError if deployments into the project phase are not allowed at t.
+// Deployments are not allowed if the schedule is invalid either, as it can't tell whether they are.
+func (s Schedule) Check(project, environment string, t time.Time) error {
+	for _, f := range s.Freezes {
+		if f.Contains(t) {
+			freeze := f
+			return ScheduleError{Project: project, Environment: environment, Freeze: &freeze}
+		}
+	}
+
+	if len(s.DeployWindows) == 0 {
+		return nil
+	}
+
+	for _, w := range s.DeployWindows {
+		ok, err := w.Contains(t)
+		if err != nil {
+			return ScheduleError{Project: project, Environment: environment, Invalid: err}
+		}
+		if ok {
+			return nil
+		}
+	}
+
+	return ScheduleError{Project: project, Environment: environment, DeployWindows: s.DeployWindows}
+}
+
+// ScheduleError is returned when a deployment is attempted during a freeze or outside the deploy windows.
+type ScheduleError struct {
+	Project     string
+	Environment string
+	// Freeze is the freeze in effect, or nil if the deployment is outside the deploy windows.
+	Freeze        *Freeze
+	DeployWindows []DeployWindow
+	// Invalid is the error of the schedule, if the deployment is refused as the schedule is invalid.
+	Invalid error
+}
+
+func (e ScheduleError) Error() string {
+	if e.Invalid != nil {
+		return fmt.Sprintf("Deployment failed: %s %s has an invalid deploy schedule: %s", e.Project, e.Environment, e.Invalid)
+	}
+	if e.Freeze != nil {
+		msg := fmt.Sprintf("Deployment failed: %s %s is frozen until %s", e.Project, e.Environment, e.Freeze.To.Local().Format("2006-01-02 15:04"))
+		if e.Freeze.Reason != "" {
+			msg += ": " + e.Freeze.Reason
+		}
+		return msg
+	}
+
+	var windows []string
+	for _, w := range e.DeployWindows {
+		windows = append(windows, w.String())
+	}
+	return fmt.Sprintf("Deployment failed: %s %s is outside the deploy windows %s", e.Project, e.Environment, strings.Join(windows, ", "))
+}
+
+func (e ScheduleError) Unwrap() error {
+	return e.Invalid
+}
+
+// cronSpec is a parsed cron expression. Each field is a bit set of the allowed values.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are true if the day-of-month and the day-of-week fields are `*`.
+	domStar, dowStar bool
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dowNames   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+func parseCron(expr string) (cronSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSpec{}, fmt.Errorf("invalid deploy window %q: expected 5 fields of `minute hour day-of-month month day-of-week`", expr)
+	}
+
+	var (
+		spec cronSpec
+		err  error
+	)
+	parsers := []struct {
+		dst      *uint64
+		min, max int
+		names    map[string]int
+	}{
+		{&spec.minute, 0, 59, nil},
+		{&spec.hour, 0, 23, nil},
+		{&spec.dom, 1, 31, nil},
+		{&spec.month, 1, 12, monthNames},
+		{&spec.dow, 0, 7, dowNames},
+	}
+	for i, p := range parsers {
+		*p.dst, err = parseCronField(fields[i], p.min, p.max, p.names)
+		if err != nil {
+			return cronSpec{}, fmt.Errorf("invalid deploy window %q: %w", expr, err)
+		}
+	}
+
+	// 7 is also Sunday.
+	if spec.dow&(1<<7) != 0 {
+		spec.dow |= 1
+	}
+	spec.domStar = fields[2] == "*"
+	spec.dowStar = fields[4] == "*"
+
+	return spec, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if r, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+			rng, step = r, n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = parseCronValue(a, min, max, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = parseCronValue(b, min, max, names); err != nil {
+					return 0, err
+				}
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("value %q must be between %d and %d", s, min, max)
+	}
+	return v, nil
+}
+
+// matches returns true if the minute of t matches the spec.
+// Like cron, if both day-of-month and day-of-week are restricted, either of them needs to match.
+func (s cronSpec) matches(t time.Time) bool {
+	has := func(bits uint64, v int) bool { return bits&(1<<uint(v)) != 0 }
+
+	if !has(s.minute, t.Minute()) || !has(s.hour, t.Hour()) || !has(s.month, int(t.Month())) {
+		return false
+	}
+
+	dom, dow := has(s.dom, t.Day()), has(s.dow, int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
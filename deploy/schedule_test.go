@@ -0,0 +1,105 @@
+package deploy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeployWindowContains(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+
+	// 2021-09-03 is a Friday.
+	at := func(s string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", s, tokyo)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+
+	testcases := []struct {
+		cron string
+		at   time.Time
+		want bool
+	}{
+		{cron: "* * * * *", at: at("2021-09-03 18:00"), want: true},
+		{cron: "* 10-17 * * Mon-Thu", at: at("2021-09-02 10:00"), want: true},
+		{cron: "* 10-17 * * Mon-Thu", at: at("2021-09-02 17:59"), want: true},
+		{cron: "* 10-17 * * Mon-Thu", at: at("2021-09-02 18:00"), want: false},
+		{cron: "* 10-17 * * Mon-Thu", at: at("2021-09-03 11:00"), want: false},
+		{cron: "* 10-17 * * 1-4", at: at("2021-09-03 11:00"), want: false},
+		{cron: "* 10-17 * * mon,fri", at: at("2021-09-03 11:00"), want: true},
+		{cron: "*/30 * * * *", at: at("2021-09-03 11:30"), want: true},
+		{cron: "*/30 * * * *", at: at("2021-09-03 11:31"), want: false},
+		{cron: "* * * Sep *", at: at("2021-09-03 11:31"), want: true},
+		{cron: "* * * * 7", at: at("2021-09-05 00:00"), want: true},
+		// Either day-of-month or day-of-week matches like cron.
+		{cron: "* * 1 * Fri", at: at("2021-09-03 00:00"), want: true},
+		{cron: "* * 1 * Fri", at: at("2021-09-01 00:00"), want: true},
+		{cron: "* * 1 * Fri", at: at("2021-09-02 00:00"), want: false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.cron+" "+tc.at.String(), func(t *testing.T) {
+			got, err := DeployWindow{Cron: tc.cron, TimeZone: "Asia/Tokyo"}.Contains(tc.at.UTC())
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+
+	for _, cron := range []string{"* * * *", "60 * * * *", "* 18-10 * * *", "* * * * Foo", "*/0 * * * *"} {
+		_, err := DeployWindow{Cron: cron}.Contains(time.Now())
+		require.Error(t, err, cron)
+	}
+
+	_, err = DeployWindow{Cron: "* * * * *", TimeZone: "Nowhere/Unknown"}.Contains(time.Now())
+	require.Error(t, err)
+}
+
+func TestScheduleCheck(t *testing.T) {
+	now, err := time.Parse(time.RFC3339, "2021-09-03T09:00:00Z")
+	require.NoError(t, err)
+
+	phase := Schedule{
+		Freezes: []Freeze{{From: now.Add(time.Hour), To: now.Add(2 * time.Hour), Reason: "release"}},
+	}
+	global := Schedule{
+		DeployWindows: []DeployWindow{{Cron: "* 0-8 * * *", TimeZone: "UTC"}},
+		Freezes:       []Freeze{{From: now.Add(-time.Hour), To: now.Add(time.Hour), Reason: "year-end"}},
+	}
+
+	s := phase.Merge(global)
+	require.Len(t, s.Freezes, 2)
+
+	err = s.Check("myproject", "production", now)
+	var scheduleErr ScheduleError
+	require.ErrorAs(t, err, &scheduleErr)
+	require.Equal(t, "year-end", scheduleErr.Freeze.Reason)
+
+	err = s.Check("myproject", "production", now.Add(90*time.Minute))
+	require.ErrorAs(t, err, &scheduleErr)
+	require.Equal(t, "release", scheduleErr.Freeze.Reason)
+
+	err = s.Check("myproject", "production", now.Add(3*time.Hour))
+	require.EqualError(t, err, "Deployment failed: myproject production is outside the deploy windows `* 0-8 * * *` (UTC)")
+
+	require.NoError(t, s.Check("myproject", "production", now.Add(-2*time.Hour)))
+
+	// The deploy windows of the phase take precedence over the global ones.
+	phase.DeployWindows = []DeployWindow{{Cron: "* * * * *"}}
+	require.NoError(t, phase.Merge(global).Check("myproject", "production", now.Add(3*time.Hour)))
+
+	require.NoError(t, Schedule{}.Check("myproject", "production", now))
+
+	// The deployments are refused if the schedule is invalid.
+	invalid := Schedule{DeployWindows: []DeployWindow{{Cron: "* * * * *", TimeZone: "Nowhere/Unknown"}}}
+	require.Error(t, invalid.Validate())
+	require.ErrorAs(t, invalid.Check("myproject", "production", now), &scheduleErr)
+	require.Error(t, scheduleErr.Invalid)
+
+	require.EqualError(t, Schedule{Freezes: []Freeze{{From: now, To: now.Add(-time.Hour), Reason: "release"}}}.Validate(), `invalid freeze "release": it must end after it starts`)
+	require.NoError(t, s.Validate())
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+
+	"github.com/slack-go/slack"
+)
+
+// slackMessenger is the part of the Slack client to post and update messages,
+// which is implemented by *slack.Client and faked in tests.
+type slackMessenger interface {
+	PostMessage(channelID string, options ...slack.MsgOption) (string, string, error)
+	UpdateMessage(channelID, timestamp string, options ...slack.MsgOption) (string, string, string, error)
+}
+
+// deployThread is the Slack thread of a deployment.
+//
+// The root message of the thread shows the current state of the deployment, like requested, approved and merged,
+// and is updated in place as the deployment proceeds, while the details are replied in the thread.
+// This way a deployment takes only one message in the channel.
+type deployThread struct {
+	client  slackMessenger
+	channel string
+	// ts is the timestamp of the root message, which is empty until the root message is posted.
+	ts string
+}
+
+// newDeployThread returns the thread of the root message identified by ts in the channel.
+// ts is empty for a deployment whose root message is not posted yet.
+func newDeployThread(client slackMessenger, channel string, ts string) *deployThread {
+	return &deployThread{client: client, channel: channel, ts: ts}
+}
+
+// started returns true if the root message has been posted.
+func (t *deployThread) started() bool {
+	return t.ts != ""
+}
+
+// update replaces the root message with the new state of the deployment,
+// or posts it as the root message if the thread hasn't started yet.
+func (t *deployThread) update(options ...slack.MsgOption) error {
+	if !t.started() {
+		_, ts, err := t.client.PostMessage(t.channel, options...)
+		if err != nil {
+			return err
+		}
+		t.ts = ts
+		return nil
+	}
+
+	_, _, _, err := t.client.UpdateMessage(t.channel, t.ts, options...)
+	return err
+}
+
+// reply posts the details of the deployment to the thread,
+// or to the channel if the thread hasn't started yet.
+func (t *deployThread) reply(options ...slack.MsgOption) {
+	if t.started() {
+		options = append(options, slack.MsgOptionTS(t.ts))
+	}
+	if _, _, err := t.client.PostMessage(t.channel, options...); err != nil {
+		log.Printf("Failed to post message: %s", err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+)
+
+func TestDeployThread(t *testing.T) {
+	client := &fakeSlackClient{}
+	thread := newDeployThread(client, "C1", "")
+	require.False(t, thread.started())
+
+	require.NoError(t, thread.update(slack.MsgOptionText("requested", false)))
+	require.True(t, thread.started())
+	require.NoError(t, thread.update(slack.MsgOptionText("approved", false)))
+	thread.reply(slack.MsgOptionText("details", false))
+
+	require.Equal(t, []fakeSlackCall{
+		{method: "chat.postMessage", channel: "C1", texts: []string{"requested"}},
+		{method: "chat.update", channel: "C1", ts: "1000.0000", texts: []string{"approved"}},
+		{method: "chat.postMessage", channel: "C1", threadTS: "1000.0000", texts: []string{"details"}},
+	}, client.Calls())
+
+	// The details are posted to the channel if the thread hasn't started.
+	client = &fakeSlackClient{}
+	newDeployThread(client, "C1", "").reply(slack.MsgOptionText("details", false))
+	require.Equal(t, []fakeSlackCall{{method: "chat.postMessage", channel: "C1", texts: []string{"details"}}}, client.Calls())
+}
+
+func TestFollowInThread(t *testing.T) {
+	client := &fakeSlackClient{}
+	i := InteractorContext{client: client}
+
+	// The progress is replied in the thread of the deployment, whose root message gets the result.
+	i.followInThread(newDeployThread(client, "C1", "1000.0000"), i.plainBlocks("merged"), "waiting", func(progress func(string)) string {
+		progress("syncing")
+		return "synced"
+	})
+	require.Equal(t, []fakeSlackCall{
+		{method: "chat.postMessage", channel: "C1", threadTS: "1000.0000", texts: []string{"waiting"}},
+		{method: "chat.postMessage", channel: "C1", threadTS: "1000.0000", texts: []string{"syncing"}},
+		{method: "chat.update", channel: "C1", ts: "1000.0000", texts: []string{"merged", "synced"}},
+	}, client.Calls())
+}
+
+func TestInteractorJobRequestThread(t *testing.T) {
+	client := &fakeSlackClient{}
+	store := deploy.NewMemoryPendingRequestStore(deploy.DefaultPendingRequestTTL)
+	i := NewInteractorJob(InteractorContext{client: client, pending: store, languages: NewLanguageList(i18n.English)})
+	pj := DeployProject{ID: "myjob", Phases: []DeployPhase{{Name: "staging", Kind: "job", Path: "jobs/myjob"}}}
+
+	blocks, err := i.Request(pj, "staging", "main", "U1", "C1")
+	require.NoError(t, err)
+	require.Empty(t, blocks)
+
+	// The root message is posted, and then updated with the buttons for the request.
+	calls := client.Calls()
+	require.Len(t, calls, 2)
+	require.Equal(t, "chat.postMessage", calls[0].method)
+	require.Equal(t, "chat.update", calls[1].method)
+	require.Equal(t, "1000.0000", calls[1].ts)
+	require.Equal(t, []string{"*jobs/myjob*\n*staging*\nDo you want to deploy the *main* branch?"}, calls[1].texts)
+
+	reqs, err := store.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, reqs, 1)
+	require.Equal(t, "1000.0000", reqs[0].ThreadTS)
+	require.Equal(t, "C1", reqs[0].Channel)
+}
+
+// fakeDeployModel is a DeployModel that returns the output or the error without deploying anything.
+type fakeDeployModel struct {
+	output DeployOutput
+	err    error
+}
+
+func (m fakeDeployModel) Deploy(pj DeployProject, phase string, option DeployOption) (DeployOutput, error) {
+	return m.output, m.err
+}
+
+func TestAutoDeployThread(t *testing.T) {
+	pj := DeployProject{ID: "api"}
+	phase := DeployPhase{Name: "staging", Kind: "fake", NotifyChannel: "C1"}
+
+	client := &fakeSlackClient{}
+	a := AutoDeploy{client: client, modelList: &DeployModelList{"fake": fakeDeployModel{}}, skipped: &sync.Map{}, languages: NewLanguageList(i18n.English)}
+	a.deploy(pj, phase, "v1")
+	require.Equal(t, []fakeSlackCall{
+		{method: "chat.postMessage", channel: "C1", texts: []string{":hourglass_flowing_sand: Auto deploying"}},
+		{method: "chat.update", channel: "C1", ts: "1000.0000", texts: []string{":white_check_mark: Succeed to auto deploy"}},
+	}, client.Calls())
+
+	// The error is replied in the thread.
+	client = &fakeSlackClient{}
+	a = AutoDeploy{client: client, modelList: &DeployModelList{"fake": fakeDeployModel{err: errors.New("boom")}}, skipped: &sync.Map{}, languages: NewLanguageList(i18n.English)}
+	a.deploy(pj, phase, "v1")
+	require.Equal(t, []fakeSlackCall{
+		{method: "chat.postMessage", channel: "C1", texts: []string{":hourglass_flowing_sand: Auto deploying"}},
+		{method: "chat.update", channel: "C1", ts: "1000.0000", texts: []string{":x: Failed to auto deploy"}},
+		{method: "chat.postMessage", channel: "C1", threadTS: "1000.0000", texts: []string{"boom"}},
+	}, client.Calls())
+
+	// The deployment skipped before goes on in the thread where it was reported to be skipped.
+	client = &fakeSlackClient{}
+	a = AutoDeploy{client: client, modelList: &DeployModelList{"fake": fakeDeployModel{}}, skipped: &sync.Map{}, languages: NewLanguageList(i18n.English)}
+	a.reportSkipped(pj, phase, "v1", errors.New("locked"))
+	a.reportSkipped(pj, phase, "v1", errors.New("locked"))
+	a.deploy(pj, phase, "v1")
+	require.Equal(t, []fakeSlackCall{
+		{method: "chat.postMessage", channel: "C1", texts: []string{":no_entry: Skipped auto deploy"}},
+		{method: "chat.postMessage", channel: "C1", threadTS: "1000.0000", texts: []string{":no_entry: Skipped auto deploy: locked"}},
+		{method: "chat.update", channel: "C1", ts: "1000.0000", texts: []string{":hourglass_flowing_sand: Auto deploying"}},
+		{method: "chat.update", channel: "C1", ts: "1000.0000", texts: []string{":white_check_mark: Succeed to auto deploy"}},
+	}, client.Calls())
+
+	// The notifications are in the language of the channel.
+	client = &fakeSlackClient{}
+	languages := NewLanguageList(i18n.English)
+	languages.Settings.Channels = map[string]i18n.Lang{"C1": i18n.Japanese}
+	a = AutoDeploy{client: client, modelList: &DeployModelList{"fake": fakeDeployModel{}}, skipped: &sync.Map{}, languages: languages}
+	a.reportSkipped(pj, phase, "v1", deploy.LockedError{Project: "api", Environment: "staging", User: "user1"})
+	a.deploy(pj, phase, "v1")
+	require.Equal(t, []fakeSlackCall{
+		{method: "chat.postMessage", channel: "C1", texts: []string{":no_entry: 自動デプロイをスキップしました"}},
+		{method: "chat.postMessage", channel: "C1", threadTS: "1000.0000", texts: []string{":no_entry: 自動デプロイをスキップしました: デプロイできません: user1 がロックしています"}},
+		{method: "chat.update", channel: "C1", ts: "1000.0000", texts: []string{":hourglass_flowing_sand: 自動デプロイしています"}},
+		{method: "chat.update", channel: "C1", ts: "1000.0000", texts: []string{":white_check_mark: 自動デプロイに成功しました"}},
+	}, client.Calls())
+
+	// Nothing is posted without the channel to notify.
+	client = &fakeSlackClient{}
+	a = AutoDeploy{client: client, modelList: &DeployModelList{"fake": fakeDeployModel{}}, skipped: &sync.Map{}, languages: NewLanguageList(i18n.English)}
+	a.deploy(pj, DeployPhase{Name: "staging", Kind: "fake"}, "v1")
+	require.Empty(t, client.Calls())
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+)
+
+// The deploy wizard is a modal to request a deployment in one step,
+// instead of the chained messages of `@bot deploy staging`, which post a new message for every choice.
+// See https://api.slack.com/surfaces/modals for more details about modals.
+//
+// The branch field is an external select, whose options are loaded by the block_suggestion interactions,
+// so the Options Load URL of the Slack app must be set to the same URL as the Request URL of the interactivity.
+const (
+	wizardCallbackID = "deploy_wizard"
+
+	// The block and the action of each field share the same ID.
+	wizardProject = "project"
+	wizardPhase   = "phase"
+	wizardBranch  = "branch"
+	wizardTag     = "tag"
+	wizardReason  = "reason"
+
+	// maxWizardOptions is the maximum number of the options of a select.
+	maxWizardOptions = 100
+)
+
+// branchSearcher searches the branches of a repository by a part of the name.
+type branchSearcher interface {
+	SearchBranches(repo string, query string) ([]string, error)
+}
+
+// wizardActionValue returns the value of the button to open the deploy wizard,
+// prefilled with the project and the phase if given.
+func wizardActionValue(projectID string, phase string) string {
+	if projectID == "" {
+		return "wizard|"
+	}
+	return "wizard|" + projectID + "_" + phase
+}
+
+// parseWizardActionValue returns the project and the phase to prefill the deploy wizard with.
+// The phase is after the last underscore, as the project ID may contain underscores.
+func parseWizardActionValue(value string) (projectID string, phase string) {
+	_, target, _ := strings.Cut(value, "|")
+	i := strings.LastIndex(target, "_")
+	if i < 0 {
+		return "", ""
+	}
+	return target[:i], target[i+1:]
+}
+
+// openWizardSection returns the section with the button to open the deploy wizard.
+func openWizardSection(lang i18n.Lang) *slack.SectionBlock {
+	txt := slack.NewTextBlockObject("mrkdwn", lang.Text(i18n.OpenWizard), false, false)
+	btnTxt := slack.NewTextBlockObject("plain_text", lang.Text(i18n.OpenWizardButton), false, false)
+	btn := slack.NewButtonBlockElement("", wizardActionValue("", ""), btnTxt)
+	return slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn))
+}
+
+func (h interactionHandler) lang(userID string, channel string) i18n.Lang {
+	return h.languages.Lang(h.userList.FindBySlackUserID(userID).SlackDisplayName, channel)
+}
+
+// openWizard opens the deploy wizard for the user who clicked the button.
+func (h interactionHandler) openWizard(interactionRequest slack.InteractionCallback) {
+	projectID, phase := parseWizardActionValue(interactionRequest.ActionCallback.BlockActions[0].Value)
+	channel := interactionRequest.Channel.ID
+	if channel == "" {
+		// The buttons on the App Home are not in any channel,
+		// so the deployment is requested in the direct messages with the bot.
+		channel = interactionRequest.User.ID
+	}
+
+	view := h.wizardView(h.lang(interactionRequest.User.ID, channel), projectID, phase, channel)
+	if _, err := h.client.OpenView(interactionRequest.TriggerID, view); err != nil {
+		log.Printf("[ERROR] Failed to open deploy wizard: %v", err)
+	}
+}
+
+// wizardView builds the deploy wizard, which requests the deployment in the channel.
+func (h interactionHandler) wizardView(lang i18n.Lang, projectID string, phase string, channel string) slack.ModalViewRequest {
+	text := func(s string) *slack.TextBlockObject {
+		return slack.NewTextBlockObject("plain_text", s, false, false)
+	}
+	option := func(s string) *slack.OptionBlockObject {
+		return slack.NewOptionBlockObject(s, text(s), nil)
+	}
+
+	var projectOptions, phaseOptions []*slack.OptionBlockObject
+	var initialProject, initialPhase, initialBranch *slack.OptionBlockObject
+	seen := map[string]bool{}
+	for _, pj := range h.projectList.Items {
+		if len(projectOptions) < maxWizardOptions {
+			projectOptions = append(projectOptions, option(pj.ID))
+		}
+		if pj.ID == projectID {
+			initialProject = option(pj.ID)
+			if !pj.DisableBranchDeploy {
+				initialBranch = option(pj.DefaultBranch())
+			}
+		}
+		for _, p := range pj.Phases {
+			if seen[p.Name] || len(phaseOptions) >= maxWizardOptions {
+				continue
+			}
+			seen[p.Name] = true
+			phaseOptions = append(phaseOptions, option(p.Name))
+			if p.Name == phase {
+				initialPhase = option(p.Name)
+			}
+		}
+	}
+
+	projectSelect := slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, nil, wizardProject, projectOptions...)
+	projectSelect.InitialOption = initialProject
+	phaseSelect := slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, nil, wizardPhase, phaseOptions...)
+	phaseSelect.InitialOption = initialPhase
+	branchSelect := slack.NewOptionsSelectBlockElement(slack.OptTypeExternal, nil, wizardBranch)
+	branchSelect.InitialOption = initialBranch
+	// The branches are listed as soon as the select is opened.
+	minQueryLength := 0
+	branchSelect.MinQueryLength = &minQueryLength
+
+	branch := slack.NewInputBlock(wizardBranch, text(lang.Text(i18n.WizardBranch)), text(lang.Text(i18n.WizardBranchHint)), branchSelect)
+	branch.Optional = true
+	tag := slack.NewInputBlock(wizardTag, text(lang.Text(i18n.WizardTag)), text(lang.Text(i18n.WizardTagHint)), slack.NewPlainTextInputBlockElement(nil, wizardTag))
+	tag.Optional = true
+	reasonInput := slack.NewPlainTextInputBlockElement(nil, wizardReason)
+	reasonInput.Multiline = true
+	reason := slack.NewInputBlock(wizardReason, text(lang.Text(i18n.WizardReason)), nil, reasonInput)
+	reason.Optional = true
+
+	return slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      wizardCallbackID,
+		PrivateMetadata: channel,
+		Title:           text(lang.Text(i18n.DeployButton)),
+		Submit:          text(lang.Text(i18n.DeployButton)),
+		Close:           text(lang.Text(i18n.CloseButton)),
+		Blocks: slack.Blocks{BlockSet: []slack.Block{
+			slack.NewInputBlock(wizardProject, text(lang.Text(i18n.WizardProject)), nil, projectSelect),
+			slack.NewInputBlock(wizardPhase, text(lang.Text(i18n.WizardPhase)), nil, phaseSelect),
+			branch,
+			tag,
+			reason,
+		}},
+	}
+}
+
+// suggestBranches returns the branches of the selected project whose names contain the typed text.
+func (h interactionHandler) suggestBranches(interactionRequest slack.InteractionCallback) *slack.OptionsResponse {
+	resp := &slack.OptionsResponse{Options: []*slack.OptionBlockObject{}}
+	if interactionRequest.ActionID != wizardBranch || h.github == nil {
+		return resp
+	}
+
+	projectID := wizardSelected(interactionRequest.View.State, wizardProject)
+	if projectID == "" {
+		return resp
+	}
+	pj := h.projectList.Find(projectID)
+	if pj.ID == "" {
+		return resp
+	}
+
+	branches, err := h.github.SearchBranches(pj.GitHubRepository(), strings.TrimSpace(interactionRequest.Value))
+	if err != nil {
+		log.Printf("[ERROR] Failed to search branches: %v", err)
+		return resp
+	}
+	for i, branch := range branches {
+		if i >= maxWizardOptions {
+			break
+		}
+		resp.Options = append(resp.Options, slack.NewOptionBlockObject(branch, slack.NewTextBlockObject("plain_text", branch, false, false), nil))
+	}
+	return resp
+}
+
+// submitWizard validates the deploy wizard and requests the deployment.
+// It returns the errors to show on the fields, or nil to close the wizard.
+func (h interactionHandler) submitWizard(interactionRequest slack.InteractionCallback) *slack.ViewSubmissionResponse {
+	userID := interactionRequest.User.ID
+	channel := interactionRequest.View.PrivateMetadata
+	if channel == "" {
+		channel = userID
+	}
+	user := h.userList.FindBySlackUserID(userID)
+	lang := h.lang(userID, channel)
+
+	state := interactionRequest.View.State
+	branch := wizardSelected(state, wizardBranch)
+	tag := strings.TrimSpace(wizardValue(state, wizardTag))
+	reason := strings.TrimSpace(wizardValue(state, wizardReason))
+
+	if !user.IsDeveloper() {
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{wizardProject: lang.Sprintf(i18n.DeployForbidden, user.SlackDisplayName)})
+	}
+	pj, err := h.projectList.FindByAlias(wizardSelected(state, wizardProject))
+	if err != nil {
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{wizardProject: err.Error()})
+	}
+	p, err := pj.FindPhaseByAlias(wizardSelected(state, wizardPhase))
+	if err != nil {
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{wizardPhase: err.Error()})
+	}
+	phase := p.Name
+	if branch != "" && tag != "" {
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{wizardTag: lang.Text(i18n.BranchOrTag)})
+	}
+
+	interactor := h.interactorFactory.Get(pj, phase)
+	var request func() ([]slack.Block, error)
+	if tag != "" {
+		tagInteractor, ok := interactor.(TagDeployUsecase)
+		if !ok {
+			return slack.NewErrorsViewSubmissionResponse(map[string]string{wizardTag: lang.Sprintf(i18n.TagDeployUnsupported, p.Kind)})
+		}
+		if _, err := findImageByTag(pj, phase, tag); err != nil {
+			log.Println("[ERROR] ", err)
+			return slack.NewErrorsViewSubmissionResponse(map[string]string{wizardTag: err.Error()})
+		}
+		request = func() ([]slack.Block, error) {
+			return tagInteractor.RequestTag(pj, phase, tag, userID, channel)
+		}
+	} else {
+		if branch == "" || pj.DisableBranchDeploy {
+			branch = pj.DefaultBranch()
+		}
+		request = func() ([]slack.Block, error) {
+			return interactor.Request(pj, phase, branch, userID, channel)
+		}
+	}
+
+	// The wizard is closed without waiting for the request,
+	// as Slack shows an error unless the submission is responded within 3 seconds.
+	go h.postWizardRequest(channel, lang, reason, request)
+	return nil
+}
+
+// postWizardRequest posts the result of the request from the deploy wizard to the channel, with the reason if given.
+func (h interactionHandler) postWizardRequest(channel string, lang i18n.Lang, reason string, request func() ([]slack.Block, error)) {
+	blocks, err := request()
+	if err != nil {
+		var (
+			lockedErr   deploy.LockedError
+			scheduleErr deploy.ScheduleError
+		)
+		if !errors.As(err, &lockedErr) && !errors.As(err, &scheduleErr) {
+			log.Println("[ERROR] ", err)
+		}
+		blocks = []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", errorText(lang, err), false, false), nil, nil)}
+	}
+	if len(blocks) == 0 && reason == "" {
+		// The deployment is reported in its own thread.
+		return
+	}
+	if reason != "" {
+		blocks = append([]slack.Block{slack.NewContextBlock("", slack.NewTextBlockObject("mrkdwn", lang.Sprintf(i18n.DeployReason, reason), false, false))}, blocks...)
+	}
+
+	if _, _, err := h.client.PostMessage(channel, slack.MsgOptionBlocks(blocks...)); err != nil {
+		log.Printf("[ERROR] Failed to post deploy wizard request: %v", err)
+	}
+}
+
+// wizardSelected returns the value of the selected option of the field.
+func wizardSelected(state *slack.ViewState, id string) string {
+	if state == nil {
+		return ""
+	}
+	return state.Values[id][id].SelectedOption.Value
+}
+
+// wizardValue returns the text of the input field.
+func wizardValue(state *slack.ViewState, id string) string {
+	if state == nil {
+		return ""
+	}
+	return state.Values[id][id].Value
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/i18n"
+)
+
+type fakeBranchSearcher struct {
+	repo     string
+	query    string
+	branches []string
+}
+
+func (f *fakeBranchSearcher) SearchBranches(repo string, query string) ([]string, error) {
+	f.repo, f.query = repo, query
+	return f.branches, nil
+}
+
+func newTestWizardHandler(github branchSearcher) interactionHandler {
+	return interactionHandler{
+		projectList: &ProjectList{Items: []DeployProject{
+			{ID: "my_api", Alias: "^(my_api|api)$", gitHubRepository: "api", defaultBranch: "main", Phases: []DeployPhase{{Name: "staging", Kind: "kustomize"}, {Name: "production", Kind: "kustomize"}}},
+			{ID: "web", Alias: "^web$", Phases: []DeployPhase{{Name: "staging", Kind: "jenkins"}}},
+		}},
+		userList: &UserList{Items: []User{
+			{SlackUserID: "U1", SlackDisplayName: "user1", isDeveloper: true},
+			{SlackUserID: "U2", SlackDisplayName: "user2"},
+		}},
+		interactorFactory: &InteractorFactory{},
+		github:            github,
+		languages:         NewLanguageList(i18n.English),
+	}
+}
+
+func wizardState(values map[string]string) *slack.ViewState {
+	state := &slack.ViewState{Values: map[string]map[string]slack.BlockAction{}}
+	for id, v := range values {
+		action := slack.BlockAction{Value: v}
+		if id == wizardProject || id == wizardPhase || id == wizardBranch {
+			action = slack.BlockAction{SelectedOption: slack.OptionBlockObject{Value: v}}
+		}
+		state.Values[id] = map[string]slack.BlockAction{id: action}
+	}
+	return state
+}
+
+func TestWizardActionValue(t *testing.T) {
+	require.Equal(t, "wizard|", wizardActionValue("", ""))
+
+	projectID, phase := parseWizardActionValue(wizardActionValue("my_api", "staging"))
+	require.Equal(t, "my_api", projectID)
+	require.Equal(t, "staging", phase)
+
+	projectID, phase = parseWizardActionValue("wizard|")
+	require.Empty(t, projectID)
+	require.Empty(t, phase)
+}
+
+func TestWizardView(t *testing.T) {
+	h := newTestWizardHandler(nil)
+
+	view := h.wizardView(i18n.English, "my_api", "production", "C1")
+	require.Equal(t, wizardCallbackID, view.CallbackID)
+	require.Equal(t, "C1", view.PrivateMetadata)
+	require.Len(t, view.Blocks.BlockSet, 5)
+
+	project := view.Blocks.BlockSet[0].(*slack.InputBlock).Element.(*slack.SelectBlockElement)
+	require.Len(t, project.Options, 2)
+	require.Equal(t, "my_api", project.InitialOption.Value)
+
+	phase := view.Blocks.BlockSet[1].(*slack.InputBlock).Element.(*slack.SelectBlockElement)
+	require.Len(t, phase.Options, 2)
+	require.Equal(t, "production", phase.InitialOption.Value)
+
+	branch := view.Blocks.BlockSet[2].(*slack.InputBlock)
+	require.True(t, branch.Optional)
+	require.Equal(t, slack.OptTypeExternal, branch.Element.(*slack.SelectBlockElement).Type)
+	require.Equal(t, "main", branch.Element.(*slack.SelectBlockElement).InitialOption.Value)
+
+	// Nothing is prefilled by the `wizard` command.
+	view = h.wizardView(i18n.English, "", "", "C1")
+	require.Nil(t, view.Blocks.BlockSet[0].(*slack.InputBlock).Element.(*slack.SelectBlockElement).InitialOption)
+	require.Nil(t, view.Blocks.BlockSet[2].(*slack.InputBlock).Element.(*slack.SelectBlockElement).InitialOption)
+}
+
+func TestWizardSuggestBranches(t *testing.T) {
+	github := &fakeBranchSearcher{branches: []string{"feature/a", "feature/b"}}
+	h := newTestWizardHandler(github)
+
+	resp := h.handleInteraction(slack.InteractionCallback{
+		Type:     slack.InteractionTypeBlockSuggestion,
+		ActionID: wizardBranch,
+		Value:    " feature ",
+		View:     slack.View{State: wizardState(map[string]string{wizardProject: "my_api"})},
+	}).(*slack.OptionsResponse)
+	require.Equal(t, "api", github.repo)
+	require.Equal(t, "feature", github.query)
+	require.Len(t, resp.Options, 2)
+	require.Equal(t, "feature/a", resp.Options[0].Value)
+
+	// No branches are suggested until the project is selected.
+	resp = h.handleInteraction(slack.InteractionCallback{
+		Type:     slack.InteractionTypeBlockSuggestion,
+		ActionID: wizardBranch,
+		View:     slack.View{State: wizardState(nil)},
+	}).(*slack.OptionsResponse)
+	require.Empty(t, resp.Options)
+}
+
+func TestWizardSubmitValidation(t *testing.T) {
+	h := newTestWizardHandler(nil)
+
+	submit := func(userID string, values map[string]string) map[string]string {
+		resp := h.handleInteraction(slack.InteractionCallback{
+			Type: slack.InteractionTypeViewSubmission,
+			User: slack.User{ID: userID},
+			View: slack.View{CallbackID: wizardCallbackID, PrivateMetadata: "C1", State: wizardState(values)},
+		})
+		require.NotNil(t, resp)
+		return resp.(*slack.ViewSubmissionResponse).Errors
+	}
+
+	require.Equal(t, map[string]string{wizardProject: `you are not allowed to deploy projects: "user2" is missing the Developer role`},
+		submit("U2", map[string]string{wizardProject: "my_api", wizardPhase: "staging"}))
+	require.Contains(t, submit("U1", map[string]string{wizardProject: "unknown", wizardPhase: "staging"}), wizardProject)
+	require.Contains(t, submit("U1", map[string]string{wizardProject: "web", wizardPhase: "production"}), wizardPhase)
+	require.Equal(t, map[string]string{wizardTag: "Specify either the branch or the tag, not both"},
+		submit("U1", map[string]string{wizardProject: "api", wizardPhase: "staging", wizardBranch: "main", wizardTag: "v1.0.0"}))
+
+	// Other modals are ignored.
+	require.Nil(t, h.handleInteraction(slack.InteractionCallback{Type: slack.InteractionTypeViewSubmission, View: slack.View{CallbackID: "other"}}))
+}
@@ -1,8 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/zaiminc/gocat/deploy"
 )
 
 type IDestination interface {
@@ -11,6 +19,10 @@ type IDestination interface {
 
 type GetCurrentRevisionInput struct {
 	github *GitHub
+	// kubernetes is the cluster of the kubernetes destination.
+	kubernetes *deploy.Kubernetes
+	// aws is the AWS config of the project phase used by the ecs destination.
+	aws AWSConfig
 }
 
 type DestinationKustomize struct {
@@ -37,7 +49,7 @@ type DestinationECS struct {
 }
 
 func (self DestinationECS) GetCurrentRevision(input GetCurrentRevisionInput) (string, error) {
-	ecs, err := CreateECSInstance()
+	ecs, err := CreateECSInstance(input.aws.withRegionOf(self.TaskDefinitionArn))
 	if err != nil {
 		return "", err
 	}
@@ -59,20 +71,162 @@ func (self DestinationECS) GetCurrentRevision(input GetCurrentRevisionInput) (st
 	return "", fmt.Errorf("[ERROR] NotFound specified image")
 }
 
+// DestinationAPI reads the current revision from an HTTP endpoint,
+// typically a `/version` endpoint exposed by the running service itself.
+//
+// The revision is extracted from the response body in the following order:
+//
+//   - If JSONPath is set, the body is parsed as JSON and the value at the path is used.
+//     The path is a dot-separated list of object keys and array indices like `$.build.tag` or `.versions[0]`.
+//   - If Regexp is set, the first capture group of the first match is used.
+//     If the regexp has no capture group, the whole match is used.
+//   - Otherwise, the whole body with leading and trailing spaces trimmed is used.
+//
+// JSONPath and Regexp can be combined, in which case Regexp is applied to the value found by JSONPath.
 type DestinationAPI struct {
-	RevisionURL string `yaml:"revisionURL"`
+	RevisionURL string            `yaml:"revisionURL"`
+	Headers     map[string]string `yaml:"headers"`
+	// Timeout is the timeout of the whole request in the Go duration format like `5s`.
+	// Defaults to DefaultDestinationAPITimeout.
+	Timeout  string `yaml:"timeout"`
+	JSONPath string `yaml:"jsonPath"`
+	Regexp   string `yaml:"regexp"`
 }
 
+const DefaultDestinationAPITimeout = 10 * time.Second
+
 func (self DestinationAPI) GetCurrentRevision(input GetCurrentRevisionInput) (string, error) {
-	return "", fmt.Errorf("[ERROR] API is not supported")
+	if self.RevisionURL == "" {
+		return "", fmt.Errorf("[ERROR] revisionURL is not set for the api destination")
+	}
+
+	timeout := DefaultDestinationAPITimeout
+	if self.Timeout != "" {
+		d, err := time.ParseDuration(self.Timeout)
+		if err != nil {
+			return "", fmt.Errorf("[ERROR] Invalid timeout %q: %w", self.Timeout, err)
+		}
+		timeout = d
+	}
+
+	req, err := http.NewRequest(http.MethodGet, self.RevisionURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("[ERROR] Invalid revisionURL %q: %w", self.RevisionURL, err)
+	}
+	for k, v := range self.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("[ERROR] Failed to get the current revision from %s: %w", self.RevisionURL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("[ERROR] Failed to read the response from %s: %w", self.RevisionURL, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("[ERROR] Unexpected status %d from %s: %s", resp.StatusCode, self.RevisionURL, truncate(string(body), 200))
+	}
+
+	return self.extractRevision(body)
+}
+
+func (self DestinationAPI) extractRevision(body []byte) (string, error) {
+	rev := strings.TrimSpace(string(body))
+
+	if self.JSONPath != "" {
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return "", fmt.Errorf("[ERROR] The response from %s is not a valid JSON: %w", self.RevisionURL, err)
+		}
+		found, err := lookupJSONPath(v, self.JSONPath)
+		if err != nil {
+			return "", err
+		}
+		switch found := found.(type) {
+		case string:
+			rev = found
+		case float64, bool:
+			rev = fmt.Sprint(found)
+		default:
+			return "", fmt.Errorf("[ERROR] The value at %s is not a scalar: %v", self.JSONPath, found)
+		}
+	}
+
+	if self.Regexp != "" {
+		re, err := regexp.Compile(self.Regexp)
+		if err != nil {
+			return "", fmt.Errorf("[ERROR] Invalid regexp %q: %w", self.Regexp, err)
+		}
+		match := re.FindStringSubmatch(rev)
+		if match == nil {
+			return "", fmt.Errorf("[ERROR] The revision does not match %q", self.Regexp)
+		}
+		if len(match) > 1 {
+			rev = match[1]
+		} else {
+			rev = match[0]
+		}
+	}
+
+	if rev == "" {
+		return "", fmt.Errorf("[ERROR] Empty revision returned from %s", self.RevisionURL)
+	}
 
+	return rev, nil
+}
+
+// lookupJSONPath returns the value at the path within v, which is a value decoded by encoding/json.
+//
+// The path is a subset of JSONPath that supports only child and index accessors,
+// like `$.build.tag`, `.build.tag`, `build.tag`, and `.images[0].tag`.
+func lookupJSONPath(v interface{}, path string) (interface{}, error) {
+	p := strings.TrimPrefix(path, "$")
+	p = strings.ReplaceAll(p, "[", ".")
+	p = strings.ReplaceAll(p, "]", "")
+	cur := v
+	for _, key := range strings.Split(p, ".") {
+		if key == "" {
+			continue
+		}
+		switch c := cur.(type) {
+		case map[string]interface{}:
+			next, ok := c[key]
+			if !ok {
+				return nil, fmt.Errorf("[ERROR] NotFound %q in the response at %s", key, path)
+			}
+			cur = next
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil, fmt.Errorf("[ERROR] Invalid index %q in the response at %s", key, path)
+			}
+			cur = c[i]
+		default:
+			return nil, fmt.Errorf("[ERROR] NotFound %q in the response at %s", key, path)
+		}
+	}
+	return cur, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
 }
 
 type Destination struct {
-	Kind      string               `yaml:"kind"`
-	Kustomize DestinationKustomize `yaml:"kustomize"`
-	ECS       DestinationECS       `yaml:"ecs"`
-	API       DestinationAPI       `yaml:"api"`
+	Kind       string                `yaml:"kind"`
+	Kustomize  DestinationKustomize  `yaml:"kustomize"`
+	ECS        DestinationECS        `yaml:"ecs"`
+	API        DestinationAPI        `yaml:"api"`
+	Kubernetes DestinationKubernetes `yaml:"kubernetes"`
 }
 
 func (self Destination) GetDest() IDestination {
@@ -81,6 +235,10 @@ func (self Destination) GetDest() IDestination {
 		return self.Kustomize
 	case "ecs":
 		return self.ECS
+	case "api":
+		return self.API
+	case "kubernetes":
+		return self.Kubernetes
 	default:
 		return self.API
 	}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/zaiminc/gocat/deploy"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DestinationKubernetes reads the current revision from the image tag of a container of a workload
+// running in the Kubernetes cluster gocat has access to.
+//
+//	destination:
+//	  kind: kubernetes
+//	  kubernetes:
+//	    namespace: myapp
+//	    kind: Deployment
+//	    name: myapp
+//	    container: app
+type DestinationKubernetes struct {
+	Namespace string `yaml:"namespace"`
+	// Kind is either Deployment, StatefulSet or DaemonSet. Defaults to Deployment.
+	Kind string `yaml:"kind"`
+	Name string `yaml:"name"`
+	// Container is the name of the container to read the image tag from.
+	// It can be omitted if the pod has only one container.
+	Container string `yaml:"container"`
+}
+
+func (self DestinationKubernetes) GetCurrentRevision(input GetCurrentRevisionInput) (string, error) {
+	client, err := input.kubernetes.ClientSet()
+	if err != nil {
+		return "", fmt.Errorf("[ERROR] Unable to get the current revision of %s: %w", self, err)
+	}
+
+	status, err := self.rolloutStatus(context.Background(), client)
+	if err != nil {
+		return "", err
+	}
+	return imageTag(status.Image), nil
+}
+
+func (self DestinationKubernetes) String() string {
+	return fmt.Sprintf("%s %s/%s", self.kind(), self.Namespace, self.Name)
+}
+
+func (self DestinationKubernetes) kind() string {
+	if self.Kind == "" {
+		return "Deployment"
+	}
+	return self.Kind
+}
+
+// KubernetesRolloutStatus is the progress of the rollout of a workload.
+type KubernetesRolloutStatus struct {
+	// Image is the image of the container in the pod template.
+	Image     string
+	Desired   int32
+	Updated   int32
+	Available int32
+	// Done is true if all the pods run the latest pod template.
+	Done bool
+	// Failed is true if the rollout has exceeded the progress deadline.
+	Failed  bool
+	Message string
+}
+
+func (s KubernetesRolloutStatus) String() string {
+	str := fmt.Sprintf("Updated: %d/%d / Available: %d/%d", s.Updated, s.Desired, s.Available, s.Desired)
+	if s.Message != "" {
+		str += "\n" + s.Message
+	}
+	return str
+}
+
+// rolloutStatus gets the workload and returns the progress of its rollout,
+// in the same way as `kubectl rollout status`.
+func (self DestinationKubernetes) rolloutStatus(ctx context.Context, client kubernetes.Interface) (KubernetesRolloutStatus, error) {
+	var (
+		status   KubernetesRolloutStatus
+		template corev1.PodTemplateSpec
+	)
+
+	switch self.kind() {
+	case "Deployment":
+		d, err := client.AppsV1().Deployments(self.Namespace).Get(ctx, self.Name, metav1.GetOptions{})
+		if err != nil {
+			return status, fmt.Errorf("[ERROR] Failed to get %s: %w", self, err)
+		}
+		template = d.Spec.Template
+		status = deploymentRolloutStatus(d)
+	case "StatefulSet":
+		s, err := client.AppsV1().StatefulSets(self.Namespace).Get(ctx, self.Name, metav1.GetOptions{})
+		if err != nil {
+			return status, fmt.Errorf("[ERROR] Failed to get %s: %w", self, err)
+		}
+		template = s.Spec.Template
+		status = statefulSetRolloutStatus(s)
+	case "DaemonSet":
+		d, err := client.AppsV1().DaemonSets(self.Namespace).Get(ctx, self.Name, metav1.GetOptions{})
+		if err != nil {
+			return status, fmt.Errorf("[ERROR] Failed to get %s: %w", self, err)
+		}
+		template = d.Spec.Template
+		status = daemonSetRolloutStatus(d)
+	default:
+		return status, fmt.Errorf("[ERROR] Unsupported kind %q of the kubernetes destination. It must be one of Deployment, StatefulSet and DaemonSet", self.Kind)
+	}
+
+	image, err := self.image(template)
+	if err != nil {
+		return status, err
+	}
+	status.Image = image
+
+	return status, nil
+}
+
+func (self DestinationKubernetes) image(template corev1.PodTemplateSpec) (string, error) {
+	containers := template.Spec.Containers
+	if self.Container == "" && len(containers) == 1 {
+		return containers[0].Image, nil
+	}
+	for _, c := range containers {
+		if c.Name == self.Container {
+			return c.Image, nil
+		}
+	}
+	return "", fmt.Errorf("[ERROR] NotFound container %q in %s", self.Container, self)
+}
+
+func deploymentRolloutStatus(d *appsv1.Deployment) KubernetesRolloutStatus {
+	s := KubernetesRolloutStatus{
+		Desired:   replicas(d.Spec.Replicas),
+		Updated:   d.Status.UpdatedReplicas,
+		Available: d.Status.AvailableReplicas,
+	}
+
+	if d.Generation > d.Status.ObservedGeneration {
+		s.Message = "Waiting for the deployment spec update to be observed"
+		return s
+	}
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+			s.Failed = true
+			s.Message = c.Message
+			return s
+		}
+	}
+
+	switch {
+	case d.Status.UpdatedReplicas < s.Desired:
+		s.Message = "Waiting for the new replicas to be updated"
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		s.Message = "Waiting for the old replicas to be terminated"
+	case d.Status.AvailableReplicas < d.Status.UpdatedReplicas:
+		s.Message = "Waiting for the updated replicas to be available"
+	default:
+		s.Done = true
+	}
+	return s
+}
+
+func statefulSetRolloutStatus(sts *appsv1.StatefulSet) KubernetesRolloutStatus {
+	s := KubernetesRolloutStatus{
+		Desired:   replicas(sts.Spec.Replicas),
+		Updated:   sts.Status.UpdatedReplicas,
+		Available: sts.Status.AvailableReplicas,
+	}
+
+	switch {
+	case sts.Generation > sts.Status.ObservedGeneration:
+		s.Message = "Waiting for the statefulset spec update to be observed"
+	case sts.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType:
+		// The pods are updated only when they're deleted, so there's nothing to wait for.
+		s.Done = true
+	case sts.Status.ReadyReplicas < s.Desired:
+		s.Message = "Waiting for the pods to be ready"
+	case sts.Status.UpdateRevision != sts.Status.CurrentRevision:
+		s.Message = "Waiting for the pods to be updated"
+	default:
+		s.Done = true
+	}
+	return s
+}
+
+func daemonSetRolloutStatus(ds *appsv1.DaemonSet) KubernetesRolloutStatus {
+	s := KubernetesRolloutStatus{
+		Desired:   ds.Status.DesiredNumberScheduled,
+		Updated:   ds.Status.UpdatedNumberScheduled,
+		Available: ds.Status.NumberAvailable,
+	}
+
+	switch {
+	case ds.Generation > ds.Status.ObservedGeneration:
+		s.Message = "Waiting for the daemonset spec update to be observed"
+	case ds.Spec.UpdateStrategy.Type == appsv1.OnDeleteDaemonSetStrategyType:
+		s.Done = true
+	case ds.Status.UpdatedNumberScheduled < s.Desired:
+		s.Message = "Waiting for the pods to be updated"
+	case ds.Status.NumberAvailable < s.Desired:
+		s.Message = "Waiting for the updated pods to be available"
+	default:
+		s.Done = true
+	}
+	return s
+}
+
+func replicas(r *int32) int32 {
+	if r == nil {
+		return 1
+	}
+	return *r
+}
+
+// imageTag returns the tag of the image reference like `registry:5000/repo:tag`,
+// or the digest if the reference is pinned by digest.
+func imageTag(image string) string {
+	if _, digest, ok := strings.Cut(image, "@"); ok {
+		return digest
+	}
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return ""
+	}
+	return image[i+1:]
+}
+
+const (
+	DefaultKubernetesRolloutPollInterval = 5 * time.Second
+	DefaultKubernetesRolloutTimeout      = 15 * time.Minute
+)
+
+// KubernetesRolloutWatcher follows the rollouts of the kubernetes destinations after deployments.
+// A nil KubernetesRolloutWatcher means that the rollouts are not followed.
+type KubernetesRolloutWatcher struct {
+	client kubernetes.Interface
+
+	PollInterval time.Duration
+	Timeout      time.Duration
+}
+
+func NewKubernetesRolloutWatcher(client kubernetes.Interface) *KubernetesRolloutWatcher {
+	return &KubernetesRolloutWatcher{
+		client:       client,
+		PollInterval: DefaultKubernetesRolloutPollInterval,
+		Timeout:      DefaultKubernetesRolloutTimeout,
+	}
+}
+
+// newKubernetesRolloutWatcher returns the watcher using the cluster gocat runs in,
+// or nil if the cluster is not available.
+func newKubernetesRolloutWatcher(k *deploy.Kubernetes) *KubernetesRolloutWatcher {
+	client, err := k.ClientSet()
+	if err != nil {
+		log.Printf("[WARNING] Rollouts of the kubernetes destinations will not be followed: %s", err)
+		return nil
+	}
+	return NewKubernetesRolloutWatcher(client)
+}
+
+// CurrentTag returns the image tag currently set to the workload.
+func (w *KubernetesRolloutWatcher) CurrentTag(ctx context.Context, dest DestinationKubernetes) (string, error) {
+	status, err := dest.rolloutStatus(ctx, w.client)
+	if err != nil {
+		return "", err
+	}
+	return imageTag(status.Image), nil
+}
+
+// Watch polls the workload until the rollout of the deployed image tag completes or fails, and returns the last status.
+//
+// before is the image tag of the workload before the deployment, and after is the image tag deployed.
+// The deployed tag is after if known, as it may be the same as before on a redeploy, or any tag other than before otherwise,
+// like the one built by a Jenkins job.
+// This allows to watch deployments that update the workload asynchronously, like GitOps and Jenkins jobs.
+// ErrUnknownRevision is returned if neither is known.
+// See poll for how onChange is called and the timeout is handled.
+func (w *KubernetesRolloutWatcher) Watch(ctx context.Context, dest DestinationKubernetes, before string, after string, onChange func(KubernetesRolloutStatus)) (KubernetesRolloutStatus, error) {
+	if before == "" && after == "" {
+		return KubernetesRolloutStatus{}, ErrUnknownRevision
+	}
+
+	get := func(ctx context.Context) (KubernetesRolloutStatus, error) {
+		return dest.rolloutStatus(ctx, w.client)
+	}
+	status, err := poll(ctx, w.PollInterval, w.Timeout, get, onChange, func(status KubernetesRolloutStatus) bool {
+		tag := imageTag(status.Image)
+		deployed := (after != "" && tag == after) || (after == "" && tag != before)
+		return deployed && (status.Done || status.Failed)
+	})
+	if err != nil {
+		return status, fmt.Errorf("[ERROR] Timed out waiting for the rollout of %s: %w", dest, err)
+	}
+	return status, nil
+}
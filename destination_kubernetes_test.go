@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func podTemplate(images ...string) corev1.PodTemplateSpec {
+	var containers []corev1.Container
+	for i, image := range images {
+		containers = append(containers, corev1.Container{Name: []string{"app", "sidecar"}[i], Image: image})
+	}
+	return corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: containers}}
+}
+
+func TestDestinationKubernetesGetCurrentRevision(t *testing.T) {
+	three := int32(3)
+	client := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "myapp"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &three, Template: podTemplate("123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/myapp:main-abc1234", "envoy:v1.27")},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "mydb"},
+			Spec:       appsv1.StatefulSetSpec{Template: podTemplate("registry.example.com:5000/mydb:v2")},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "myagent"},
+			Spec:       appsv1.DaemonSetSpec{Template: podTemplate("myagent@sha256:0123")},
+		},
+	)
+
+	testcases := []struct {
+		subject string
+		dest    DestinationKubernetes
+		want    string
+		wantErr string
+	}{
+		{
+			subject: "deployment",
+			dest:    DestinationKubernetes{Namespace: "myns", Name: "myapp", Container: "app"},
+			want:    "main-abc1234",
+		},
+		{
+			subject: "sidecar",
+			dest:    DestinationKubernetes{Namespace: "myns", Kind: "Deployment", Name: "myapp", Container: "sidecar"},
+			want:    "v1.27",
+		},
+		{
+			subject: "single container with registry port",
+			dest:    DestinationKubernetes{Namespace: "myns", Kind: "StatefulSet", Name: "mydb"},
+			want:    "v2",
+		},
+		{
+			subject: "digest",
+			dest:    DestinationKubernetes{Namespace: "myns", Kind: "DaemonSet", Name: "myagent"},
+			want:    "sha256:0123",
+		},
+		{
+			subject: "container required",
+			dest:    DestinationKubernetes{Namespace: "myns", Name: "myapp"},
+			wantErr: `NotFound container "" in Deployment myns/myapp`,
+		},
+		{
+			subject: "not found",
+			dest:    DestinationKubernetes{Namespace: "myns", Name: "unknown"},
+			wantErr: "not found",
+		},
+		{
+			subject: "unsupported kind",
+			dest:    DestinationKubernetes{Namespace: "myns", Kind: "CronJob", Name: "myapp"},
+			wantErr: `Unsupported kind "CronJob"`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.subject, func(t *testing.T) {
+			got, err := Destination{Kind: "kubernetes", Kubernetes: tc.dest}.GetCurrentRevision(GetCurrentRevisionInput{kubernetes: deploy.NewKubernetes(client)})
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestKubernetesRolloutWatcher(t *testing.T) {
+	three := int32(3)
+	deployment := func(image string, generation int64, status appsv1.DeploymentStatus) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "myapp", Generation: generation},
+			Spec:       appsv1.DeploymentSpec{Replicas: &three, Template: podTemplate("myapp:" + image)},
+			Status:     status,
+		}
+	}
+
+	testcases := []struct {
+		subject string
+		// steps are the states of the deployment returned by the API server in order, repeating the last one.
+		steps   []*appsv1.Deployment
+		before  string
+		after   string
+		want    KubernetesRolloutStatus
+		changes int
+		wantErr error
+	}{
+		{
+			subject: "complete",
+			before:  "v1",
+			steps: []*appsv1.Deployment{
+				// The image isn't updated yet by the asynchronous deployment.
+				deployment("v1", 1, appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}),
+				deployment("v2", 2, appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}),
+				deployment("v2", 2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: 1, AvailableReplicas: 3}),
+				deployment("v2", 2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: 3, AvailableReplicas: 3}),
+				deployment("v2", 2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}),
+			},
+			want:    KubernetesRolloutStatus{Image: "myapp:v2", Desired: 3, Updated: 3, Available: 3, Done: true},
+			changes: 5,
+		},
+		{
+			subject: "progress deadline exceeded",
+			before:  "v1",
+			steps: []*appsv1.Deployment{
+				deployment("v2", 2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: 1, AvailableReplicas: 3, Conditions: []appsv1.DeploymentCondition{
+					{Type: appsv1.DeploymentProgressing, Reason: "ProgressDeadlineExceeded", Message: `ReplicaSet "myapp-abc" has timed out progressing.`},
+				}}),
+			},
+			want:    KubernetesRolloutStatus{Image: "myapp:v2", Desired: 3, Updated: 1, Available: 3, Failed: true, Message: `ReplicaSet "myapp-abc" has timed out progressing.`},
+			changes: 1,
+		},
+		{
+			subject: "timeout",
+			before:  "v1",
+			steps: []*appsv1.Deployment{
+				deployment("v1", 1, appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}),
+			},
+			want:    KubernetesRolloutStatus{Image: "myapp:v1", Desired: 3, Updated: 3, Available: 3, Done: true},
+			changes: 1,
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			subject: "redeploy",
+			steps: []*appsv1.Deployment{
+				deployment("v1", 1, appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}),
+			},
+			before:  "v1",
+			after:   "v1",
+			want:    KubernetesRolloutStatus{Image: "myapp:v1", Desired: 3, Updated: 3, Available: 3, Done: true},
+			changes: 1,
+		},
+		{
+			subject: "unknown tag before the deployment",
+			steps: []*appsv1.Deployment{
+				deployment("v1", 1, appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}),
+				deployment("v2", 2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}),
+			},
+			after:   "v2",
+			want:    KubernetesRolloutStatus{Image: "myapp:v2", Desired: 3, Updated: 3, Available: 3, Done: true},
+			changes: 2,
+		},
+		{
+			subject: "unknown tags",
+			steps: []*appsv1.Deployment{
+				deployment("v1", 1, appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}),
+			},
+			changes: 0,
+			wantErr: ErrUnknownRevision,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.subject, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			steps := tc.steps
+			client.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				d := steps[0]
+				if len(steps) > 1 {
+					steps = steps[1:]
+				}
+				return true, d, nil
+			})
+
+			w := NewKubernetesRolloutWatcher(client)
+			w.PollInterval = time.Millisecond
+			w.Timeout = 100 * time.Millisecond
+
+			var changes []KubernetesRolloutStatus
+			got, err := w.Watch(context.Background(), DestinationKubernetes{Namespace: "myns", Name: "myapp"}, tc.before, tc.after, func(s KubernetesRolloutStatus) {
+				changes = append(changes, s)
+			})
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.want, got)
+			require.Len(t, changes, tc.changes)
+		})
+	}
+}
+
+func TestInteractorTrackRollout(t *testing.T) {
+	type call struct {
+		method   string
+		threadTS string
+		text     string
+	}
+	var (
+		mu    sync.Mutex
+		calls []call
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		var blocks []block
+		_ = json.Unmarshal([]byte(r.FormValue("blocks")), &blocks)
+		calls = append(calls, call{method: r.URL.Path, threadTS: r.FormValue("thread_ts"), text: message{Blocks: blocks}.Text().(string)})
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"ok": true, "channel": "C1234", "ts": "1234.5678"}`))
+	}))
+	defer ts.Close()
+
+	client := fake.NewSimpleClientset(&appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "myns", Name: "mydb", Generation: 1},
+		Spec:       appsv1.StatefulSetSpec{Template: podTemplate("mydb:v2")},
+		Status:     appsv1.StatefulSetStatus{ObservedGeneration: 1, Replicas: 1, ReadyReplicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1, CurrentRevision: "mydb-2", UpdateRevision: "mydb-2"},
+	})
+	w := NewKubernetesRolloutWatcher(client)
+	w.PollInterval = time.Millisecond
+	i := InteractorContext{client: NewSlackClient(slack.New("token", slack.OptionAPIURL(ts.URL+"/api/"))), rollout: w, languages: NewLanguageList(i18n.English)}
+
+	i.trackRollout(context.Background(), newDeployThread(i.client, "C1234", ""), nil, DestinationKubernetes{Namespace: "myns", Kind: "StatefulSet", Name: "mydb"}, "v1", "")
+
+	require.Len(t, calls, 3)
+	require.Equal(t, "/api/chat.postMessage", calls[0].method)
+	require.Equal(t, ":hourglass_flowing_sand: Waiting for the rollout of StatefulSet myns/mydb", calls[0].text)
+	require.Equal(t, "1234.5678", calls[1].threadTS)
+	require.Equal(t, "Updated: 1/1 / Available: 1/1", calls[1].text)
+	require.Equal(t, "/api/chat.update", calls[2].method)
+	require.Equal(t, ":white_check_mark: StatefulSet myns/mydb has been rolled out to `mydb:v2`", calls[2].text)
+}
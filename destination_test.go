@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDestinationAPIGetCurrentRevision(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/plain":
+			_, _ = w.Write([]byte("abc1234\n"))
+		case "/json":
+			_, _ = w.Write([]byte(`{"build": {"tag": "main-abc1234", "images": [{"tag": "def5678"}]}}`))
+		case "/auth":
+			if r.Header.Get("Authorization") != "Bearer mytoken" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte("unauthorized"))
+				return
+			}
+			_, _ = w.Write([]byte("abc1234"))
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	type testcase struct {
+		subject string
+		dest    DestinationAPI
+		want    string
+		wantErr string
+	}
+
+	tcs := []testcase{
+		{
+			subject: "plain",
+			dest:    DestinationAPI{RevisionURL: ts.URL + "/plain"},
+			want:    "abc1234",
+		},
+		{
+			subject: "json path",
+			dest:    DestinationAPI{RevisionURL: ts.URL + "/json", JSONPath: "$.build.tag"},
+			want:    "main-abc1234",
+		},
+		{
+			subject: "json path with index",
+			dest:    DestinationAPI{RevisionURL: ts.URL + "/json", JSONPath: ".build.images[0].tag"},
+			want:    "def5678",
+		},
+		{
+			subject: "json path and regexp",
+			dest:    DestinationAPI{RevisionURL: ts.URL + "/json", JSONPath: "build.tag", Regexp: `-([0-9a-f]+)$`},
+			want:    "abc1234",
+		},
+		{
+			subject: "regexp without group",
+			dest:    DestinationAPI{RevisionURL: ts.URL + "/plain", Regexp: `[0-9a-f]{7}`},
+			want:    "abc1234",
+		},
+		{
+			subject: "headers",
+			dest:    DestinationAPI{RevisionURL: ts.URL + "/auth", Headers: map[string]string{"Authorization": "Bearer mytoken"}},
+			want:    "abc1234",
+		},
+		{
+			subject: "non-2xx",
+			dest:    DestinationAPI{RevisionURL: ts.URL + "/auth"},
+			wantErr: "[ERROR] Unexpected status 401 from " + ts.URL + "/auth: unauthorized",
+		},
+		{
+			subject: "missing json path",
+			dest:    DestinationAPI{RevisionURL: ts.URL + "/json", JSONPath: "$.build.sha"},
+			wantErr: `[ERROR] NotFound "sha" in the response at $.build.sha`,
+		},
+		{
+			subject: "invalid timeout",
+			dest:    DestinationAPI{RevisionURL: ts.URL + "/plain", Timeout: "ten seconds"},
+			wantErr: `[ERROR] Invalid timeout "ten seconds": time: invalid duration "ten seconds"`,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.subject, func(t *testing.T) {
+			got, err := Destination{Kind: "api", API: tc.dest}.GetCurrentRevision(GetCurrentRevisionInput{})
+			if tc.wantErr != "" {
+				require.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -273,6 +273,64 @@ func (g GitOperator) createAndCheckoutNewBranch(branch string) (*git.Worktree, e
 	return w, nil
 }
 
+// ImageTagHistory returns the image tags that have been set for the image in the kustomization.yaml at the path,
+// newest first, by walking the history of the default branch.
+//
+// Consecutive duplicates are removed, so that the second item is the tag that was deployed
+// before the current one. At most limit tags are returned.
+func (g GitOperator) ImageTagHistory(path string, image string, limit int) ([]string, error) {
+	if _, err := g.checkoutMainBranch(); err != nil {
+		return nil, err
+	}
+
+	head, err := g.repository.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := g.repository.Log(&git.LogOptions{From: head.Hash(), FileName: &path})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var tags []string
+	for len(tags) < limit {
+		c, err := iter.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		f, err := c.File(path)
+		if err != nil {
+			// The file was deleted in this commit.
+			continue
+		}
+		contents, err := f.Contents()
+		if err != nil {
+			return nil, err
+		}
+
+		obj := types.Kustomization{}
+		if err := yaml.Unmarshal([]byte(contents), &obj); err != nil {
+			fmt.Printf("[WARNING] Skipped unparsable %s at %s: %v\n", path, c.Hash, err)
+			continue
+		}
+		for _, im := range obj.Images {
+			if im.Name == image && im.NewTag != "" {
+				if len(tags) == 0 || tags[len(tags)-1] != im.NewTag {
+					tags = append(tags, im.NewTag)
+				}
+				break
+			}
+		}
+	}
+
+	return tags, nil
+}
+
 type ConfigMap struct {
 	APIVersion string            `yaml:"apiVersion"`
 	Kind       string            `yaml:"kind"`
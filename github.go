@@ -153,6 +153,35 @@ func (g GitHub) ListBranch(name string) ([]string, error) {
 	return arr, nil
 }
 
+// SearchBranches returns up to 100 branches, the maximum options of a Slack select, of the repository whose names contain the query,
+// or the first ones if the query is empty. This backs the type-ahead branch field of the deploy wizard.
+func (g GitHub) SearchBranches(name string, q string) ([]string, error) {
+	type refs struct {
+		Name string
+	}
+	var query struct {
+		Repository struct {
+			Refs struct {
+				Nodes []refs
+			} `graphql:"refs(first: 100, refPrefix: \"refs/heads/\", query: $query)"`
+		} `graphql:"repository(owner: $org, name: $name)"`
+	}
+	variables := map[string]interface{}{
+		"name":  githubv4.String(name),
+		"org":   githubv4.String(g.appOrg),
+		"query": githubv4.String(q),
+	}
+
+	if err := g.appClient.Query(context.Background(), &query, variables); err != nil {
+		return nil, err
+	}
+	var arr []string
+	for _, v := range query.Repository.Refs.Nodes {
+		arr = append(arr, v.Name)
+	}
+	return arr, nil
+}
+
 func (g GitHub) GitHash(branch string) (string, error) {
 	var query struct {
 		Repository struct {
@@ -282,18 +311,25 @@ func (g GitHub) RequestReviews(prID string, assigneeIDs string) error {
 	return g.client.Mutate(context.Background(), &mutate, input, nil)
 }
 
-func (g GitHub) MergePullRequest(prID string) error {
+// MergePullRequest merges the pull request and returns the SHA of the merge commit.
+func (g GitHub) MergePullRequest(prID string) (string, error) {
 	var mutate struct {
 		MergePullRequest struct {
 			PullRequest struct {
-				ID string
+				ID          string
+				MergeCommit struct {
+					Oid string
+				}
 			}
 		} `graphql:"mergePullRequest(input:$input)"`
 	}
 	input := githubv4.MergePullRequestInput{
 		PullRequestID: prID,
 	}
-	return g.client.Mutate(context.Background(), &mutate, input, nil)
+	if err := g.client.Mutate(context.Background(), &mutate, input, nil); err != nil {
+		return "", err
+	}
+	return mutate.MergePullRequest.PullRequest.MergeCommit.Oid, nil
 }
 
 func (g GitHub) ClosePullRequest(prID string) error {
@@ -418,10 +454,12 @@ type GitHubGetPullRequestInput struct {
 }
 
 type PullRequest struct {
-	ID       string
-	Number   int
-	Body     string
-	BodyHTML string `graphql:"bodyHTML"`
+	ID          string
+	Number      int
+	Title       string
+	HeadRefName string
+	Body        string
+	BodyHTML    string `graphql:"bodyHTML"`
 }
 
 func (g GitHub) GetPullRequest(input GitHubGetPullRequestInput) (PullRequest, error) {
@@ -35,11 +35,8 @@ func (k GitOpsPluginKanvas) Prepare(pj DeployProject, phase string, branch strin
 
 	o.status = DeployStatusFail
 	if tag == "" {
-		ecr, err := CreateECRInstance()
-		if err != nil {
-			return o, err
-		}
-		tag, err = ecr.FindImageTagByRegexp(pj.ECRRegistryId(), pj.ECRRepository(), pj.ImageTagRegexp(), pj.TargetRegexp(), ImageTagVars{Branch: branch, Phase: phase})
+		var err error
+		tag, err = findImageTag(pj, ImageTagVars{Branch: branch, Phase: phase})
 		if err != nil {
 			return o, err
 		}
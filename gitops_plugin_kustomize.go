@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"strings"
+
+	"github.com/zaiminc/gocat/deploy"
 )
 
 // GitOpsPluginKustomize is a gocat gitops plugin to prepare
@@ -11,29 +13,26 @@ import (
 // with a chatops interface, while using kustomize along with
 // the gocat native features as a deployment tool.
 type GitOpsPluginKustomize struct {
-	github *GitHub
-	git    *GitOperator
+	github     *GitHub
+	git        *GitOperator
+	kubernetes *deploy.Kubernetes
 }
 
-func NewGitOpsPluginKustomize(github *GitHub, git *GitOperator) GitOpsPlugin {
-	return &GitOpsPluginKustomize{github: github, git: git}
+func NewGitOpsPluginKustomize(github *GitHub, git *GitOperator, k *deploy.Kubernetes) GitOpsPlugin {
+	return &GitOpsPluginKustomize{github: github, git: git, kubernetes: k}
 }
 
 func (k GitOpsPluginKustomize) Prepare(pj DeployProject, phase string, branch string, assigner User, tag string) (o GitOpsPrepareOutput, err error) {
 	o.status = DeployStatusFail
 	if tag == "" {
-		ecr, err := CreateECRInstance()
-		if err != nil {
-			return o, err
-		}
-		tag, err = ecr.FindImageTagByRegexp(pj.ECRRegistryId(), pj.ECRRepository(), pj.ImageTagRegexp(), pj.TargetRegexp(), ImageTagVars{Branch: branch, Phase: phase})
+		tag, err = findImageTag(pj, ImageTagVars{Branch: branch, Phase: phase})
 		if err != nil {
 			return o, err
 		}
 	}
 
 	ph := pj.FindPhase(phase)
-	currentTag, err := ph.Destination.GetCurrentRevision(GetCurrentRevisionInput{github: k.github})
+	currentTag, err := ph.Destination.GetCurrentRevision(GetCurrentRevisionInput{github: k.github, kubernetes: k.kubernetes, aws: pj.AWSConfig(phase)})
 	if err != nil {
 		return
 	}
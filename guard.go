@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/zaiminc/gocat/deploy"
+)
+
+// DeployGuard decides whether a deployment into a project phase may proceed.
+//
+// Every deploy entry point goes through the guard, including mentions, interactive buttons,
+// approvals of pull requests created before a lock was taken, rollbacks, and AutoDeploy.
+// A nil DeployGuard allows every deployment.
+type DeployGuard struct {
+	coordinator *deploy.Coordinator
+	userList    *UserList
+	projectList *ProjectList
+
+	mu sync.Mutex
+	// overrides are the schedule overrides by admins, keyed by `<project>/<phase>`.
+	// They are kept in memory, so they are lost when gocat restarts.
+	overrides map[string]ScheduleOverride
+	now       func() time.Time
+}
+
+// ScheduleOverride allows deployments into a project phase during a freeze or outside the deploy windows.
+type ScheduleOverride struct {
+	// User is the Slack display name of the admin who overrode the schedule.
+	User   string
+	Reason string
+	Until  time.Time
+}
+
+func NewDeployGuard(coordinator *deploy.Coordinator, userList *UserList, projectList *ProjectList) *DeployGuard {
+	return &DeployGuard{
+		coordinator: coordinator,
+		userList:    userList,
+		projectList: projectList,
+		overrides:   map[string]ScheduleOverride{},
+		now:         time.Now,
+	}
+}
+
+// Check returns a deploy.LockedError if the project phase is locked by someone other than the user,
+// or a deploy.ScheduleError if the phase is frozen or outside the deploy windows and no admin has overridden it.
+//
+// userID is the Slack user ID of the user who triggers the deployment.
+// Pass an empty userID for deployments that are not triggered by a user, like AutoDeploy.
+//
+// Failing to fetch the locks does not prevent the deployment, so that gocat keeps working
+// while the locks ConfigMap is unavailable. The same goes for invalid schedules.
+func (g *DeployGuard) Check(project, phase, userID string) error {
+	if g == nil {
+		return nil
+	}
+
+	if err := g.checkLock(project, phase, userID); err != nil {
+		return err
+	}
+
+	return g.checkSchedule(project, phase)
+}
+
+func (g *DeployGuard) checkLock(project, phase, userID string) error {
+	if g.coordinator == nil {
+		return nil
+	}
+
+	var user string
+	if userID != "" {
+		user = g.userList.FindBySlackUserID(userID).SlackDisplayName
+	}
+
+	err := g.coordinator.CheckDeploy(context.Background(), project, phase, user)
+	if _, ok := err.(deploy.LockedError); ok {
+		return err
+	} else if err != nil {
+		log.Printf("[ERROR] Unable to check the deployment lock of %s %s: %s", project, phase, err)
+	}
+
+	return nil
+}
+
+func (g *DeployGuard) checkSchedule(project, phase string) error {
+	if g.projectList == nil {
+		return nil
+	}
+
+	p := g.projectList.Find(project).FindPhase(phase)
+	schedule, err := g.projectList.Schedule(p)
+	if err != nil {
+		// The deployment is refused, as the schedule is unknown.
+		log.Printf("[ERROR] Unable to check the deploy schedule of %s %s: %s", project, phase, err)
+		err = deploy.ScheduleError{Project: project, Environment: phase, Invalid: err}
+	} else if err = schedule.Check(project, phase, g.now()); err == nil {
+		return nil
+	}
+
+	if o, ok := g.override(project, phase); ok {
+		log.Printf("[INFO] Allowing the deployment of %s %s overridden by %s: %s", project, phase, o.User, o.Reason)
+		return nil
+	}
+
+	return err
+}
+
+// Override allows deployments into the project phase until the given time regardless of the schedule.
+func (g *DeployGuard) Override(project, phase string, o ScheduleOverride) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.overrides[project+"/"+phase] = o
+}
+
+func (g *DeployGuard) override(project, phase string) (ScheduleOverride, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := project + "/" + phase
+	o, ok := g.overrides[key]
+	if !ok {
+		return ScheduleOverride{}, false
+	}
+	if !g.now().Before(o.Until) {
+		delete(g.overrides, key)
+		return ScheduleOverride{}, false
+	}
+	return o, true
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/deploy"
+)
+
+func TestDeployGuardSchedule(t *testing.T) {
+	now, err := time.Parse(time.RFC3339, "2021-09-03T09:00:00Z")
+	require.NoError(t, err)
+
+	projectList := &ProjectList{
+		Items: []DeployProject{{ID: "myproject", Phases: []DeployPhase{
+			{Name: "staging"},
+			{Name: "production", Schedule: deploy.Schedule{
+				DeployWindows: []deploy.DeployWindow{{Cron: "* 0-8 * * *", TimeZone: "UTC"}},
+			}},
+		}}},
+		Policies: []DeployPolicy{{
+			Name: "freeze",
+			Schedule: deploy.Schedule{
+				Freezes: []deploy.Freeze{{From: now.Add(time.Hour), To: now.Add(2 * time.Hour), Reason: "release"}},
+			},
+		}},
+	}
+
+	g := NewDeployGuard(nil, &UserList{}, projectList)
+	g.now = func() time.Time { return now }
+
+	require.NoError(t, g.Check("myproject", "staging", "U1234"))
+	require.EqualError(t, g.Check("myproject", "production", "U1234"), "Deployment failed: myproject production is outside the deploy windows `* 0-8 * * *` (UTC)")
+
+	// AutoDeploy is refused in the same way.
+	var scheduleErr deploy.ScheduleError
+	require.ErrorAs(t, g.Check("myproject", "production", ""), &scheduleErr)
+
+	g.now = func() time.Time { return now.Add(90 * time.Minute) }
+	require.ErrorAs(t, g.Check("myproject", "staging", ""), &scheduleErr)
+	require.Equal(t, "release", scheduleErr.Freeze.Reason)
+
+	g.Override("myproject", "staging", ScheduleOverride{User: "admin", Reason: "hotfix", Until: now.Add(100 * time.Minute)})
+	require.NoError(t, g.Check("myproject", "staging", ""))
+	require.ErrorAs(t, g.Check("myproject", "production", ""), &scheduleErr)
+
+	// The override expires.
+	g.now = func() time.Time { return now.Add(110 * time.Minute) }
+	require.ErrorAs(t, g.Check("myproject", "staging", ""), &scheduleErr)
+
+	// The deployments to the phases an invalid policy applies to are refused.
+	projectList.Policies[0].Phases = []string{"production"}
+	projectList.Policies[0].err = errors.New("failed to parse freezes")
+	require.EqualError(t, g.Check("myproject", "production", ""), "Deployment failed: myproject production has an invalid deploy schedule: the policy freeze is invalid: failed to parse freezes")
+	require.NoError(t, g.Check("myproject", "staging", ""))
+
+	var nilGuard *DeployGuard
+	require.NoError(t, nilGuard.Check("myproject", "production", ""))
+}
@@ -1,47 +1,60 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
 
 	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
 )
 
 // interactionHandler is a http.Handler that can handle slack interaction callbacks.
 // See https://api.slack.com/interactivity/handling for more details about interactions.
 type interactionHandler struct {
-	verificationToken string
-	client            *slack.Client
+	verifier          *SlackRequestVerifier
+	client            SlackClient
 	projectList       *ProjectList
 	userList          *UserList
 	interactorFactory *InteractorFactory
+	github            branchSearcher
+	languages         *LanguageList
 }
 
-func getSlackError(system, msg string, user string) []byte {
+func getSlackError(lang i18n.Lang, m i18n.Message, user string) []byte {
+	responseBytes, _ := json.Marshal(slackError(lang, m, user))
+
+	return responseBytes
+}
+
+func slackError(lang i18n.Lang, m i18n.Message, user string) slack.Message {
 	respoonse := slack.Message{
 		Msg: slack.Msg{
 			ResponseType: "in_channel",
-			Text:         fmt.Sprintf("%s: %s actioned by <@%s>", system, msg, user),
+			Text:         lang.Sprintf(m, user),
 		},
 	}
 
 	respoonse.ReplaceOriginal = true
 
-	responseBytes, _ := json.Marshal(respoonse)
-
-	return responseBytes
+	return respoonse
 }
 
 func (h interactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.verifier.Verify(r); err != nil {
+		log.Printf("[ERROR] Failed to verify the request: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
 	// Parse input from request
 	if err := r.ParseForm(); err != nil {
 		log.Printf("[ERROR] Failed to parse form: %s", err)
 		// getSlackError is a helper to quickly render errors back to slack
-		responseBytes := getSlackError("Server Error", "An unknown error occurred", "unkown")
+		responseBytes := getSlackError(h.lang("", ""), i18n.ServerError, "unknown")
 		_, _ = w.Write(responseBytes) // not display message on slack
 		return
 	}
@@ -49,11 +62,43 @@ func (h interactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	interactionRequest := slack.InteractionCallback{}
 	if err := json.Unmarshal([]byte(r.PostForm.Get("payload")), &interactionRequest); err != nil {
 		log.Printf("[ERROR] Failed to unmarshal interaction request: %s", err)
-		responseBytes := getSlackError("Server Error", "An unknown error occurred", "unkown")
+		responseBytes := getSlackError(h.lang("", ""), i18n.ServerError, "unknown")
 		_, _ = w.Write(responseBytes) // not display message on slack
 		return
 	}
 
+	if resp := h.handleInteraction(interactionRequest); resp != nil {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("[ERROR] Failed to write interaction response: %v", err)
+		}
+	}
+}
+
+// handleInteraction handles the interaction callback delivered either over HTTP or Socket Mode.
+// The results are posted to the response URL of the interaction, which is available in both transports.
+// It returns the response to send back to Slack synchronously, like the branches for the deploy wizard,
+// or nil if the interaction needs none.
+func (h interactionHandler) handleInteraction(interactionRequest slack.InteractionCallback) interface{} {
+	switch interactionRequest.Type {
+	case slack.InteractionTypeBlockSuggestion:
+		return h.suggestBranches(interactionRequest)
+	case slack.InteractionTypeViewSubmission:
+		if interactionRequest.View.CallbackID != wizardCallbackID {
+			return nil
+		}
+		// A nil *ViewSubmissionResponse must not be returned as a non-nil interface{}.
+		if resp := h.submitWizard(interactionRequest); resp != nil {
+			return resp
+		}
+		return nil
+	}
+
+	if len(interactionRequest.ActionCallback.BlockActions) == 0 {
+		log.Printf("[INFO] Ignoring interaction without block actions: %s", interactionRequest.Type)
+		return nil
+	}
+
 	// Get the action from the request, it'll always be the first one provided in my case
 	var actionValue string
 	switch interactionRequest.ActionCallback.BlockActions[0].Type {
@@ -63,37 +108,39 @@ func (h interactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		actionValue = interactionRequest.ActionCallback.BlockActions[0].SelectedOption.Value
 	}
 	userID := interactionRequest.User.ID
-	// Handle close action
-	if strings.Contains(actionValue, "close") {
-
-		// Found this on stack overflow, unsure if this exists in the package
-		closeStr := fmt.Sprintf(`{
-		'response_type': 'in_channel',
-		'text': 'closed by <@%s>',
-		'replace_original': true,
-		'delete_original': true
-		}`, userID)
-
-		// Post close json back to response URL to close the message
-		if _, err := http.Post(interactionRequest.ResponseURL, "application/json", bytes.NewBuffer([]byte(closeStr))); err != nil {
+	// Handle close action of the messages other than the deploy requests, which are rejected by the interactors instead.
+	if actionValue == "close" {
+		closed := slack.Message{
+			Msg: slack.Msg{
+				ResponseType:    "in_channel",
+				Text:            h.lang(userID, interactionRequest.Channel.ID).Sprintf(i18n.ClosedBy, userID),
+				ReplaceOriginal: true,
+				DeleteOriginal:  true,
+			},
+		}
+		if err := h.client.Respond(interactionRequest.ResponseURL, closed); err != nil {
 			log.Printf("[ERROR] Failed to post close action response: %v", err)
 		}
-		return
+		return nil
 	}
 	log.Printf("[INFO] Action Value: %s", actionValue)
+	if strings.HasPrefix(actionValue, "wizard") {
+		h.openWizard(interactionRequest)
+		return nil
+	}
 	if strings.HasPrefix(actionValue, "deploy") {
-		h.Deploy(w, interactionRequest)
-		return
+		h.Deploy(interactionRequest)
+		return nil
 	}
 
 	log.Print("[ERROR] An unknown error occurred")
-	responseBytes := getSlackError("Server Error", "An unknown error occurred", userID)
-	if _, err := http.Post(interactionRequest.ResponseURL, "application/json", bytes.NewBuffer([]byte(responseBytes))); err != nil {
+	if err := h.client.Respond(interactionRequest.ResponseURL, slackError(h.lang(userID, interactionRequest.Channel.ID), i18n.ServerError, userID)); err != nil {
 		log.Printf("[ERROR] Failed to post unknown error response: %v", err)
 	}
+	return nil
 }
 
-func (h interactionHandler) Deploy(w http.ResponseWriter, interactionRequest slack.InteractionCallback) {
+func (h interactionHandler) Deploy(interactionRequest slack.InteractionCallback) {
 	actionValue := interactionRequest.ActionCallback.BlockActions[0].Value
 	if actionValue == "" {
 		actionValue = interactionRequest.ActionCallback.BlockActions[0].SelectedOption.Value
@@ -101,63 +148,99 @@ func (h interactionHandler) Deploy(w http.ResponseWriter, interactionRequest sla
 	userID := interactionRequest.User.ID
 	user := h.userList.FindBySlackUserID(userID)
 	if !user.IsDeveloper() {
-		h.postForbiddenError(interactionRequest.ResponseURL, userID)
+		h.postForbiddenError(interactionRequest, userID)
 		return
 	}
-	params := strings.Split(actionValue, "|")
+	// The params may contain "|" as they are JSON for some actions.
+	params := strings.SplitN(actionValue, "|", 2)
 	if len(params) != 2 {
-		h.postInternalServerError(interactionRequest.ResponseURL, userID)
+		h.postInternalServerError(interactionRequest, userID)
 		return
 	}
+	channel := interactionRequest.Channel.ID
+	if channel == "" {
+		// The buttons on the App Home are not in any channel,
+		// so the deployment is requested in the direct messages with the bot.
+		channel = userID
+	}
 	interactor := h.interactorFactory.GetByParams(params[0])
 	var blocks []slack.Block
 	var err error
+	var requested bool
 	switch {
 	case strings.Contains(params[0], "request"):
-		p := strings.Split(params[1], "_")
-		if len(p) != 2 {
-			err = fmt.Errorf("Invalid Arguments")
+		var target actionTarget
+		if target, err = parseActionTarget(params[1]); err != nil {
 			break
 		}
-		pj := h.projectList.Find(p[0])
-		blocks, err = interactor.Request(pj, p[1], pj.DefaultBranch(), userID, interactionRequest.Channel.ID)
+		pj := h.projectList.Find(target.Project)
+		blocks, err = interactor.Request(pj, target.Phase, pj.DefaultBranch(), userID, channel)
+		requested = err == nil
 	case strings.Contains(params[0], "approve"):
-		blocks, err = interactor.Approve(params[1], userID, interactionRequest.Channel.ID)
+		blocks, err = interactor.Approve(params[1], userID, channel)
 	case strings.Contains(params[0], "reject"):
 		blocks, err = interactor.Reject(params[1], userID)
 	case strings.Contains(params[0], "selectbranch"):
-		blocks, err = interactor.SelectBranch(params[1], interactionRequest.ActionCallback.BlockActions[0].SelectedOption.Text.Text, userID, interactionRequest.Channel.ID)
+		blocks, err = interactor.SelectBranch(params[1], interactionRequest.ActionCallback.BlockActions[0].SelectedOption.Text.Text, userID, channel)
+		requested = err == nil
 	case strings.Contains(params[0], "branchlist"):
-		blocks, err = interactor.BranchListFromRaw(params[1])
+		blocks, err = interactor.BranchListFromRaw(params[1], userID, channel)
 	default:
-		h.postInternalServerError(interactionRequest.ResponseURL, userID)
+		h.postInternalServerError(interactionRequest, userID)
 		return
 	}
+	var (
+		lockedErr   deploy.LockedError
+		scheduleErr deploy.ScheduleError
+	)
+	if errors.As(err, &lockedErr) || errors.As(err, &scheduleErr) || errors.Is(err, deploy.ErrStaleRequest) || errors.Is(err, deploy.ErrRequestInProgress) {
+		log.Print(err)
+		blocks, err = []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", errorText(h.lang(userID, channel), err), false, false), nil, nil)}, nil
+	}
 	if err != nil {
 		log.Print(err)
-		h.postInternalServerError(interactionRequest.ResponseURL, userID)
+		h.postInternalServerError(interactionRequest, userID)
+		return
+	}
+	if len(blocks) == 0 {
+		// The deployment is reported in its own thread, whose root message has been updated by the interactor.
 		return
 	}
 	responseData := slack.NewBlockMessage(blocks...)
 	responseData.ReplaceOriginal = true
-	responseBytes, _ := json.Marshal(responseData)
-	if _, err := http.Post(interactionRequest.ResponseURL, "application/json", bytes.NewBuffer(responseBytes)); err != nil {
+	if requested && interactionRequest.Container.IsEphemeral {
+		// The ephemeral replies to the slash commands are visible only to the requester,
+		// so the deploy request is posted in the channel instead for the approvers to click its buttons.
+		responseData.ReplaceOriginal = false
+		responseData.ResponseType = slack.ResponseTypeInChannel
+	}
+	if err := h.respond(interactionRequest, responseData); err != nil {
 		log.Printf("[ERROR] Failed to post deploy action response: %v", err)
 	}
 }
 
-func (h interactionHandler) postForbiddenError(responseURL string, userID string) {
+// respond replaces the message of the interaction with the response.
+// The interactions on the App Home have no message to replace,
+// so the response is posted to the direct messages with the bot instead.
+func (h interactionHandler) respond(interactionRequest slack.InteractionCallback, response slack.Message) error {
+	if interactionRequest.ResponseURL == "" {
+		_, _, err := h.client.PostMessage(interactionRequest.User.ID, slack.MsgOptionText(response.Text, false), slack.MsgOptionBlocks(response.Blocks.BlockSet...))
+		return err
+	}
+
+	return h.client.Respond(interactionRequest.ResponseURL, response)
+}
+
+func (h interactionHandler) postForbiddenError(interactionRequest slack.InteractionCallback, userID string) {
 	log.Print("[ERROR] Forbidden Error")
-	responseBytes := getSlackError("Forbidden Error", "Please contact admin.", userID)
-	if _, err := http.Post(responseURL, "application/json", bytes.NewBuffer(responseBytes)); err != nil {
+	if err := h.respond(interactionRequest, slackError(h.lang(userID, interactionRequest.Channel.ID), i18n.ForbiddenError, userID)); err != nil {
 		log.Printf("[ERROR] Failed to post forbidden error response: %v", err)
 	}
 }
 
-func (h interactionHandler) postInternalServerError(responseURL string, userID string) {
+func (h interactionHandler) postInternalServerError(interactionRequest slack.InteractionCallback, userID string) {
 	log.Print("[ERROR] Internal Server Error")
-	responseBytes := getSlackError("Internal Server Error", "Please contact admin.", userID)
-	if _, err := http.Post(responseURL, "application/json", bytes.NewBuffer(responseBytes)); err != nil {
+	if err := h.respond(interactionRequest, slackError(h.lang(userID, interactionRequest.Channel.ID), i18n.InternalServerError, userID)); err != nil {
 		log.Printf("[ERROR] Failed to post internal server error response: %v", err)
 	}
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+)
+
+func TestInteractionHandlerDeployTarget(t *testing.T) {
+	pj := DeployProject{
+		ID:                  "my_api",
+		Alias:               "^my_api$",
+		defaultBranch:       "main",
+		DisableBranchDeploy: true,
+		Phases:              []DeployPhase{{Name: "pre_production", Kind: "jenkins"}},
+	}
+	store := deploy.NewMemoryPendingRequestStore(deploy.DefaultPendingRequestTTL)
+	c := InteractorContext{
+		kind:        "jenkins",
+		projectList: &ProjectList{Items: []DeployProject{pj}},
+		client:      &fakeSlackClient{},
+		pending:     store,
+		languages:   NewLanguageList(i18n.English),
+	}
+	h := interactionHandler{
+		client:            c.client,
+		projectList:       c.projectList,
+		userList:          &UserList{Items: []User{{SlackUserID: "U1", SlackDisplayName: "user1", isDeveloper: true}}},
+		interactorFactory: &InteractorFactory{jenkins: NewInteractorJenkins(c)},
+		languages:         c.languages,
+	}
+
+	// click clicks the button or selects the option with the value, and returns the deploy request made by it.
+	click := func(value string, selected string) deploy.PendingRequest {
+		t.Helper()
+		cb := slack.InteractionCallback{
+			User:        slack.User{ID: "U1"},
+			ResponseURL: "https://hooks.slack.com/actions/1",
+			ActionCallback: slack.ActionCallbacks{BlockActions: []*slack.BlockAction{{
+				Value:          value,
+				SelectedOption: slack.OptionBlockObject{Text: &slack.TextBlockObject{Text: selected}},
+			}}},
+		}
+		cb.Channel.ID = "C1"
+		h.Deploy(cb)
+
+		reqs, err := store.List(context.Background())
+		require.NoError(t, err)
+		require.Len(t, reqs, 1)
+		require.NoError(t, store.Delete(context.Background(), reqs[0].ID))
+		return reqs[0]
+	}
+
+	// The project IDs and the phase names may contain underscores.
+	section := createDeployButtonSection(pj, "pre_production", i18n.English)
+	req := click(section.Accessory.ButtonElement.Value, "")
+	require.Equal(t, "my_api", req.Project)
+	require.Equal(t, "pre_production", req.Phase)
+	require.Equal(t, "main", req.Branch)
+
+	value := c.actionHeader("selectbranch") + "|" + actionTarget{Project: pj.ID, Phase: "pre_production", Index: 1}.value()
+	req = click(value, "feature_x")
+	require.Equal(t, "my_api", req.Project)
+	require.Equal(t, "pre_production", req.Phase)
+	require.Equal(t, "feature_x", req.Branch)
+
+	// The values of the old format are rejected instead of panicking.
+	_, _, err := c.findActionTarget("my_api")
+	require.EqualError(t, err, `[ERROR] Invalid action target "my_api"`)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/zaiminc/gocat/deploy"
+)
+
+// newHistory returns the deploy.History configured by the config,
+// or nil if no history store is configured.
+func newHistory(config *CatConfig, k *deploy.Kubernetes) deploy.History {
+	switch {
+	case config.HistoryConfigMapName != "":
+		return deploy.NewConfigMapHistory(k, config.Namespace, config.HistoryConfigMapName)
+	case config.HistoryFile != "":
+		return deploy.NewFileHistory(config.HistoryFile)
+	default:
+		log.Printf("[WARNING] Neither CONFIG_HISTORY_CONFIGMAP_NAME nor CONFIG_HISTORY_FILE is set. Deploy history will not be recorded.")
+		return nil
+	}
+}
+
+// recordEvent records the event to the history if it's configured.
+//
+// Recording is best-effort. We don't want a broken history store to block deployments,
+// so the failure is only logged.
+func recordEvent(h deploy.History, ev deploy.Event) {
+	if h == nil {
+		return
+	}
+
+	if ev.Project == "" || ev.Phase == "" {
+		log.Printf("[WARNING] Skipped recording %s event as the project or the phase is unknown: %+v", ev.Action, ev)
+		return
+	}
+
+	if ev.At.IsZero() {
+		ev.At.Time = time.Now()
+	}
+
+	if err := h.Record(context.Background(), ev); err != nil {
+		log.Printf("[ERROR] Failed to record %s event for %s %s: %v", ev.Action, ev.Project, ev.Phase, err)
+	}
+}
+
+// eventResult returns the event result corresponding to the error.
+func eventResult(err error) deploy.EventResult {
+	if err != nil {
+		return deploy.EventResultFailure
+	}
+	return deploy.EventResultSuccess
+}
+
+// errString returns the error message, or an empty string if err is nil.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,90 @@
+// Package i18n provides the catalog of the messages that gocat shows to Slack users,
+// and chooses the language of them per user and channel.
+package i18n
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lang is a language of the messages, identified by its ISO 639-1 code.
+type Lang string
+
+const (
+	Japanese Lang = "ja"
+	English  Lang = "en"
+
+	// DefaultLang is the language used when none is configured,
+	// as gocat has talked to users in Japanese since before the messages became translatable.
+	DefaultLang = Japanese
+)
+
+// Langs are all the supported languages. Every message in the catalog has a translation to each of them.
+var Langs = []Lang{Japanese, English}
+
+// ParseLang returns the language of the code like `ja` or `en`, or DefaultLang if the code is empty.
+func ParseLang(code string) (Lang, error) {
+	if code == "" {
+		return DefaultLang, nil
+	}
+
+	for _, l := range Langs {
+		if string(l) == strings.ToLower(code) {
+			return l, nil
+		}
+	}
+
+	return "", fmt.Errorf("[ERROR] Unknown language %q. Supported languages are %s", code, strings.Join(langCodes(), ", "))
+}
+
+func langCodes() []string {
+	codes := make([]string, len(Langs))
+	for i, l := range Langs {
+		codes[i] = string(l)
+	}
+	return codes
+}
+
+// Message is a message translated to each language.
+// The message may be a format string for fmt.Sprintf, whose verbs can be indexed like `%[2]s`
+// to follow the word order of the language.
+type Message map[Lang]string
+
+// Text returns the message in the language, falling back to DefaultLang if the message has no translation to it.
+func (l Lang) Text(m Message) string {
+	if s, ok := m[l]; ok {
+		return s
+	}
+	return m[DefaultLang]
+}
+
+// Sprintf formats the message in the language with the arguments.
+func (l Lang) Sprintf(m Message, args ...interface{}) string {
+	return fmt.Sprintf(l.Text(m), args...)
+}
+
+// Settings chooses the language of the messages to a Slack user in a Slack channel.
+type Settings struct {
+	// Default is the language used unless the user or the channel overrides it.
+	Default Lang
+	// Users are the languages of the Slack users keyed by their display names.
+	Users map[string]Lang
+	// Channels are the languages of the Slack channels keyed by their IDs.
+	Channels map[string]Lang
+}
+
+// Lang returns the language of the user in the channel.
+// The language of the user takes precedence over the one of the channel,
+// and either of the user and the channel can be empty if unknown.
+func (s Settings) Lang(user string, channel string) Lang {
+	if l, ok := s.Users[user]; ok && user != "" {
+		return l
+	}
+	if l, ok := s.Channels[channel]; ok && channel != "" {
+		return l
+	}
+	if s.Default == "" {
+		return DefaultLang
+	}
+	return s.Default
+}
@@ -0,0 +1,80 @@
+package i18n
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLang(t *testing.T) {
+	l, err := ParseLang("")
+	require.NoError(t, err)
+	require.Equal(t, Japanese, l)
+
+	l, err = ParseLang("EN")
+	require.NoError(t, err)
+	require.Equal(t, English, l)
+
+	_, err = ParseLang("fr")
+	require.EqualError(t, err, `[ERROR] Unknown language "fr". Supported languages are ja, en`)
+}
+
+var verbRegexp = regexp.MustCompile(`%(?:\[(\d+)\])?[-+# 0]*\d*(?:\.\d+)?([a-zA-Z%])`)
+
+// verbs returns the verbs of the format string keyed by the index of the argument.
+func verbs(t *testing.T, format string) map[int]string {
+	t.Helper()
+
+	verbs := map[int]string{}
+	next := 1
+	for _, m := range verbRegexp.FindAllStringSubmatch(format, -1) {
+		if m[2] == "%" {
+			continue
+		}
+		if m[1] != "" {
+			n, err := strconv.Atoi(m[1])
+			require.NoError(t, err)
+			next = n
+		}
+		verbs[next] = m[2]
+		next++
+	}
+	return verbs
+}
+
+func TestCatalog(t *testing.T) {
+	require.NotEmpty(t, catalog)
+
+	for _, m := range catalog {
+		want := verbs(t, m[DefaultLang])
+		for _, l := range Langs {
+			require.Contains(t, m, l, "%q is not translated to %s", m[DefaultLang], l)
+			require.Equal(t, want, verbs(t, m[l]), "%q in %s has the different arguments from %q", m[l], l, m[DefaultLang])
+		}
+	}
+}
+
+func TestSprintf(t *testing.T) {
+	require.Equal(t, "Locked myproject staging", English.Sprintf(Locked, "myproject", "staging"))
+	require.Equal(t, "myproject staging をロックしました", Japanese.Sprintf(Locked, "myproject", "staging"))
+	require.Equal(t, "user1 による myproject staging のロック (理由: release) は期限が来たため自動で解除されました", Japanese.Sprintf(LockExpired, "myproject", "staging", "user1", "release"))
+
+	// The message without the translation falls back to DefaultLang.
+	require.Equal(t, "ja", English.Text(Message{Japanese: "ja"}))
+}
+
+func TestSettingsLang(t *testing.T) {
+	s := Settings{
+		Default:  English,
+		Users:    map[string]Lang{"user1": Japanese},
+		Channels: map[string]Lang{"C1": Japanese, "C2": English},
+	}
+
+	require.Equal(t, Japanese, s.Lang("user1", "C2"))
+	require.Equal(t, Japanese, s.Lang("user2", "C1"))
+	require.Equal(t, English, s.Lang("user2", "C3"))
+	require.Equal(t, English, s.Lang("", ""))
+	require.Equal(t, DefaultLang, Settings{}.Lang("user1", "C1"))
+}
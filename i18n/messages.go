@@ -0,0 +1,191 @@
+package i18n
+
+// catalog is every message defined by message, to test that all of them are translated.
+var catalog []Message
+
+// message defines a message in the catalog with the translations to all the languages.
+func message(ja, en string) Message {
+	m := Message{Japanese: ja, English: en}
+	catalog = append(catalog, m)
+	return m
+}
+
+// Buttons.
+var (
+	DeployButton = message("デプロイ", "Deploy")
+	CloseButton  = message("閉じる", "Close")
+)
+
+// Deploy requests and their approvals.
+var (
+	// TargetBranch and TargetTag describe what is going to be deployed, put into ConfirmDeploy.
+	TargetBranch = message("*%s* ブランチ", "the *%s* branch")
+	TargetTag    = message("*%s* タグ", "the *%s* tag")
+
+	ConfirmDeploy   = message("%sをデプロイしますか?", "Do you want to deploy %s?")
+	ConfirmRollback = message("*%s* にロールバックしますか?", "Do you want to roll back to *%s*?")
+
+	// ProductionBranchWarning is shown when a branch other than the default one is going to be deployed to production.
+	ProductionBranchWarning = message("本番環境に %s ブランチ以外をデプロイしようとしています", "You are going to deploy a branch other than %s to production")
+
+	BranchList = message("*%s* のブランチ一覧", "*%s* branch list")
+
+	CannotApproveOwnRequest = message("<@%s> は自分のリクエストを承認できません。他の人に承認を依頼してください。", "<@%s> cannot approve their own request. Please ask someone else to approve it.")
+	NotApprover             = message("<@%s> はこのリクエストを承認できません。承認できるロール: %s", "<@%s> is not allowed to approve this request. Approvers: %s")
+	AlreadyApproved         = message("<@%s> はすでにこのリクエストを承認しています。", "<@%s> has already approved this request.")
+	ApprovedBy              = message("%s が承認しました (%d/%d)", "Approved by %s (%d/%d)")
+	WaitingForApprovals     = message("承認待ちです (0/%d)", "Waiting for approvals (0/%d)")
+)
+
+// Deployments.
+var (
+	ActionedBy = message("実行者: <@%s>", "by <@%s>")
+
+	NowDeploying           = message("デプロイしています...", "Now deploying ...")
+	NowCreatingPullRequest = message("プルリクエストを作成しています...", "Now creating pull request...")
+	AlreadyDeployed        = message("このリビジョンはデプロイ済みです", "Already Deployed in this revision")
+
+	DeploySucceeded = message("%s %s のデプロイに成功しました", "Succeed to deploy %s %s")
+	DeployFailed    = message("%s %s のデプロイに失敗しました", "Failed to deploy %s %s")
+	JobSucceeded    = message("ジョブ %s の実行に成功しました", "Succeed %s Job execution")
+	JobFailed       = message("ジョブ %s の実行に失敗しました", "Failed %s execution")
+
+	JenkinsJobExecuted = message("https://%s/job/%s/ を実行しました\n選択されたブランチ: %s", "Execute https://%s/job/%s/ \n selected branch: %s")
+	JenkinsJobFailed   = message("%s のリクエストに失敗しました。ステータス: %d", "%s Request failed. responsed %d")
+
+	PullRequestApproved = message("<@%s> が承認しました。プルリクエストをマージしています...", "Approved by <@%s>. Now merging the pull request...")
+	PullRequestMerged   = message("%s をマージしました\n実行者: <@%s>", "merged %s\nby <@%s>")
+	PullRequestClosed   = message("%s をクローズしました\n実行者: <@%s>", "closed %s\nby <@%s>")
+
+	RequestClosed = message("%s %s のデプロイリクエストをクローズしました\n実行者: <@%s>", "closed the deploy request for %s %s\nby <@%s>")
+)
+
+// Auto deployments notified to the channels of the phases.
+var (
+	AutoDeploying        = message(":hourglass_flowing_sand: 自動デプロイしています", ":hourglass_flowing_sand: Auto deploying")
+	AutoDeploySucceeded  = message(":white_check_mark: 自動デプロイに成功しました", ":white_check_mark: Succeed to auto deploy")
+	AutoDeployFailed     = message(":x: 自動デプロイに失敗しました", ":x: Failed to auto deploy")
+	AutoDeploySkipped    = message(":no_entry: 自動デプロイをスキップしました", ":no_entry: Skipped auto deploy")
+	AutoDeploySkippedFor = message(":no_entry: 自動デプロイをスキップしました: %s", ":no_entry: Skipped auto deploy: %s")
+
+	// The titles of the fields of the auto deployments.
+	ProjectField = message("プロジェクト", "Project")
+	PhaseField   = message("フェーズ", "Phase")
+	TagField     = message("タグ", "Tag")
+	ReasonField  = message("理由", "Reason")
+)
+
+// Progress of deployments followed in the threads.
+var (
+	WaitingForRollout = message(":hourglass_flowing_sand: %s のロールアウトを待っています", ":hourglass_flowing_sand: Waiting for the rollout of %s")
+	RolloutTimedOut   = message(":warning: %s のロールアウトが %s 以内に完了しませんでした\n%s", ":warning: The rollout of %s did not complete within %s\n%s")
+	RolloutFailed     = message(":x: %s のロールアウトに失敗しました\n%s", ":x: The rollout of %s failed\n%s")
+	RolloutCompleted  = message(":white_check_mark: %s に `%s` がロールアウトされました", ":white_check_mark: %s has been rolled out to `%s`")
+	RolloutUnknown    = message(":grey_question: デプロイ前のイメージタグが不明なため、%s のロールアウトを確認できませんでした", ":grey_question: Unable to follow the rollout of %s as the image tag before the deployment is unknown")
+
+	WaitingForSync = message(":hourglass_flowing_sand: ArgoCDが %s を同期するのを待っています", ":hourglass_flowing_sand: Waiting for ArgoCD to sync %s")
+	SyncTimedOut   = message(":warning: %s が %s 以内にHealthyになりませんでした\n%s", ":warning: %s did not become healthy within %s\n%s")
+	SyncHealthy    = message(":white_check_mark: %s は %s です", ":white_check_mark: %s is %s")
+	SyncDegraded   = message(":x: %s は %s です\n%s", ":x: %s is %s\n%s")
+	SyncFailed     = message(":x: %s の同期に失敗しました\n%s", ":x: %s failed to sync\n%s")
+	SyncUnknown    = message(":grey_question: デプロイ前後のリビジョンが不明なため、%s の同期を確認できませんでした", ":grey_question: Unable to follow the sync of %s as the revisions before and after the deployment are unknown")
+)
+
+// Errors shown to the users.
+var (
+	ServerError         = message("サーバーエラー: 不明なエラーが発生しました 実行者: <@%s>", "Server Error: An unknown error occurred actioned by <@%s>")
+	InternalServerError = message("内部サーバーエラー: 管理者に連絡してください 実行者: <@%s>", "Internal Server Error: Please contact admin. actioned by <@%s>")
+	ForbiddenError      = message("権限エラー: 管理者に連絡してください 実行者: <@%s>", "Forbidden Error: Please contact admin. actioned by <@%s>")
+	ClosedBy            = message("<@%s> がクローズしました", "closed by <@%s>")
+
+	DeployLocked          = message("デプロイできません: %s がロックしています", "Deployment failed: locked by %s")
+	DeployFrozen          = message("デプロイできません: %s %s は %s まで凍結されています", "Deployment failed: %s %s is frozen until %s")
+	DeployFrozenFor       = message("デプロイできません: %s %s は %s まで凍結されています: %s", "Deployment failed: %s %s is frozen until %s: %s")
+	DeployOutsideWindows  = message("デプロイできません: %s %s はデプロイ可能時間 %s の外です", "Deployment failed: %s %s is outside the deploy windows %s")
+	DeployInvalidSchedule = message("デプロイできません: %s %s のデプロイ可能時間またはデプロイ禁止期間の設定に誤りがあります: %s", "Deployment failed: %s %s has an invalid deploy schedule: %s")
+	StaleRequest          = message("このデプロイリクエストは期限切れか、すでに処理されています。もう一度デプロイをリクエストしてください", "This deploy request has expired or has already been handled. Please request the deployment again")
+	RequestInProgress     = message("このデプロイリクエストはすでに処理中です", "This deploy request is already being handled")
+	RollbackTagNotFound   = message("%s %s の前のタグが見つかりません。`rollback %s %s to <tag>` でタグを指定してください", "Unable to find the previous tag of %s %s. Please specify the tag by `rollback %s %s to <tag>`")
+)
+
+// Replies to the commands.
+var (
+	InvalidCommand     = message("コマンドが正しくありません。`@bot help` で使い方を確認してください", "Invalid command. Say `@bot help` to see the usage guide")
+	UnknownHelpCommand = message("%q というコマンドはありません。`@bot help` で使い方を確認してください", "Unknown command %q. Say `@bot help` to see the usage guide")
+	InvalidConfigs     = message(":warning: 次の設定に誤りがあります。修正するまで該当するフェーズにはデプロイできません", ":warning: The following configs are invalid. The phases affected can't be deployed until they are fixed")
+	Reloaded           = message("デプロイ対象のプロジェクトとユーザーを再読み込みしました", "Deploy Projects and Users is Reloaded")
+
+	Locked           = message("%s %s をロックしました", "Locked %s %s")
+	LockedUntil      = message("%s %s を %s までロックしました", "Locked %s %s until %s")
+	Unlocked         = message("%s %s のロックを解除しました", "Unlocked %s %s")
+	LockExpiryInPast = message("ロックの期限 %s が過去の時刻です", "the lock expiry %s is in the past")
+	LockForbidden    = message("ロックやロック解除をする権限がありません: %q にDeveloperロールがありません", "you are not allowed to lock/unlock projects: %q is missing the Developer role")
+	LockExpired      = message("%[3]s による %[1]s %[2]s のロック (理由: %[4]s) は期限が来たため自動で解除されました", "The lock of %s %s by %s (for %s) has expired and was released automatically")
+
+	NotLocked = message("%s %s はロックされていません。代わりに `deploy %s %s` でデプロイしてください", "%s %s is not locked. Deploy it with `deploy %s %s` instead")
+	Queued    = message("%s %s のデプロイ待ちに並びました (%d番目)。順番が来たらお知らせします", "Queued for %s %s (position %d). You will be pinged when it is your turn")
+	LeftQueue = message("%s %s のデプロイ待ちから抜けました", "Left the queue for %s %s")
+	Handover  = message("<@%s> 順番が来ました。%s %s を %s まであなたのためにロックしました", "<@%s> It's your turn. %s %s is now locked for you until %s")
+
+	OverrideForbidden    = message("デプロイ可能時間を上書きする権限がありません: %q にAdminロールがありません", "you are not allowed to override deploy schedules: %q is missing the Admin role")
+	OverrideExpiryInPast = message("上書きの期限 %s が過去の時刻です", "the override expiry %s is in the past")
+	Overridden           = message("%s %s は %s までデプロイ可能時間に関わらずデプロイできます", "Deployments of %s %s are allowed regardless of the deploy schedule until %s")
+
+	HistoryNotConfigured = message("デプロイ履歴が設定されていません。CONFIG_HISTORY_CONFIGMAP_NAME か CONFIG_HISTORY_FILE を設定してください。", "Deploy history is not configured. Set CONFIG_HISTORY_CONFIGMAP_NAME or CONFIG_HISTORY_FILE to enable it.")
+
+	RollbackForbidden    = message("ロールバックする権限がありません: %q にDeveloperロールがありません", "you are not allowed to rollback projects: %q is missing the Developer role")
+	RollbackUnsupported  = message("kind %s ではロールバックできません", "rollback is not supported for the kind %s")
+	DeployForbidden      = message("デプロイする権限がありません: %q にDeveloperロールがありません", "you are not allowed to deploy projects: %q is missing the Developer role")
+	TagDeployUnsupported = message("kind %s ではタグを指定したデプロイはできません", "deploying a tag is not supported for the kind %s")
+
+	NoDockerRegistry      = message("%s にはイメージタグを探すDockerRegistryが設定されていません", "%s has no DockerRegistry to look up the image tags from")
+	NoImageTags           = message("イメージタグが見つかりません", "No image tags found")
+	MoreImageTags         = message("... 他 %d 件", "... and %d more")
+	ImageTagPushedAt      = message("`%s` %s にプッシュ `%s`", "`%s` pushed at %s `%s`")
+	UnknownPushedAt       = message("不明な日時", "unknown")
+	ImageTagToBeDeployed  = message(" :point_left: デプロイされるタグ", " :point_left: to be deployed")
+	TooManyImagesForExact = message(":warning: %d 件のイメージが一致しましたが、TagSelectionStrategy %s では1件だけが一致する必要があります", ":warning: %d images match, whereas TagSelectionStrategy %s requires exactly one")
+)
+
+// Deploy wizard.
+var (
+	OpenWizard       = message("プロジェクトやブランチを入力してデプロイします", "Enter the project and the branch to deploy")
+	OpenWizardButton = message("デプロイウィザードを開く", "Open deploy wizard")
+
+	WizardProject    = message("プロジェクト", "Project")
+	WizardPhase      = message("フェーズ", "Phase")
+	WizardBranch     = message("ブランチ", "Branch")
+	WizardBranchHint = message("名前の一部を入力して検索できます。ブランチもタグも指定しない場合はデフォルトブランチをデプロイします", "Type a part of the name to search. The default branch is deployed if neither the branch nor the tag is given")
+	WizardTag        = message("タグ", "Tag")
+	WizardTagHint    = message("イメージレジストリにあるタグを指定すると、ブランチの代わりにそのタグをデプロイします", "The tag in the image registry is deployed instead of the branch if given")
+	WizardReason     = message("理由", "Reason")
+
+	BranchOrTag  = message("ブランチとタグはどちらか一方だけを指定してください", "Specify either the branch or the tag, not both")
+	DeployReason = message("理由: %s", "Reason: %s")
+)
+
+// App Home.
+var (
+	HomePendingRequests = message("*承認待ちのデプロイリクエスト*", "*Your deploy requests waiting for approval*")
+	NoPendingRequests   = message("承認待ちのデプロイリクエストはありません", "No deploy requests waiting for approval")
+	PendingRequestItem  = message("*%s %s*: %s (%s にリクエスト)", "*%s %s*: %s, requested at %s")
+
+	HomeLocks = message("*デプロイロック*", "*Deploy locks*")
+	NoLocks   = message("ロックはありません", "No locks")
+
+	HomeQuickDeploy = message("*クイックデプロイ*", "*Quick deploy*")
+	NoQuickDeploys  = message("デプロイできるプロジェクトはありません", "No projects you can deploy")
+)
+
+// Locks and history described by the deploy package.
+var (
+	LockState     = message("ロック中", "Locked")
+	LockedBy      = message(" (%s によるロック, 理由: %s", " (by %s, for %s")
+	LockedByUntil = message(", 期限: %s", ", until %s")
+	LockQueue     = message("    待ち行列: ", "    queue: ")
+	NoHistory     = message("履歴はありません", "No history found")
+	EventBy       = message(" 実行者 %s", " by %s")
+	EventTag      = message(", タグ %s", ", tag %s")
+	EventBranch   = message(", ブランチ %s", ", branch %s")
+	EventTook     = message(", 所要時間 %s", ", took %s")
+)
@@ -4,6 +4,7 @@ import (
 	"strings"
 
 	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/i18n"
 )
 
 // DeployUsecase, or alternatively, interactor as well call it in our cocdebase, is an interface that defines the usecases of deploy.
@@ -20,15 +21,35 @@ import (
 // do actual deployments by calling DeployModel.
 // However an implementation, such as InteractorKustomize, does prepare for deployments by calling DeployModel,
 // but does not actually deploy.
+//
+// The returned blocks are posted to the channel, or replace the message of the clicked button.
+// Implementations that report the deployment in its own thread, such as InteractorKustomize and InteractorJob,
+// return no blocks, as they post and update the root message of the thread by themselves.
 type DeployUsecase interface {
 	Request(DeployProject, string, string, string, string) (blocks []slack.Block, err error)
-	BranchList(DeployProject, string) (blocks []slack.Block, err error)
-	BranchListFromRaw(string) (blocks []slack.Block, err error)
+	BranchList(DeployProject, string, string, string) (blocks []slack.Block, err error)
+	BranchListFromRaw(string, string, string) (blocks []slack.Block, err error)
 	Approve(string, string, string) (blocks []slack.Block, err error)
 	Reject(string, string) (blocks []slack.Block, err error)
 	SelectBranch(string, string, string, string) (blocks []slack.Block, err error)
 }
 
+// RollbackUsecase is implemented by DeployUsecase implementations that support the rollback command.
+//
+// Rollback deploys the given tag, or the tag that was deployed before the current one if the tag is empty.
+type RollbackUsecase interface {
+	Rollback(pj DeployProject, phase string, tag string, assigner string, channel string) (blocks []slack.Block, err error)
+}
+
+// TagDeployUsecase is implemented by DeployUsecase implementations that can deploy an exact image tag
+// instead of the one resolved from a branch.
+//
+// RequestTag asks for the approval to deploy the tag in the same way as Request.
+// The tag is expected to be validated against the image registry by the caller.
+type TagDeployUsecase interface {
+	RequestTag(pj DeployProject, phase string, tag string, assigner string, channel string) (blocks []slack.Block, err error)
+}
+
 type InteractorFactory struct {
 	kanvas    InteractorGitOps
 	kustomize InteractorGitOps
@@ -90,8 +111,8 @@ func (i InteractorFactory) GetByParams(params string) DeployUsecase {
 	}
 }
 
-func CloseButton() *slack.ActionBlock {
-	closeBtnTxt := slack.NewTextBlockObject("plain_text", "Close", false, false)
+func CloseButton(lang i18n.Lang) *slack.ActionBlock {
+	closeBtnTxt := slack.NewTextBlockObject("plain_text", lang.Text(i18n.CloseButton), false, false)
 	closeBtn := slack.NewButtonBlockElement("", "close", closeBtnTxt)
 	section := slack.NewActionBlock("", closeBtn)
 	return section
@@ -2,83 +2,120 @@ package main
 
 import (
 	"fmt"
-	"log"
-	"strings"
+	"time"
 
 	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type InteractorCombine struct {
 	InteractorContext
-	model ModelCombine
+	model DeployModel
 }
 
 func NewInteractorCombine(i InteractorContext) (o InteractorCombine) {
-	o = InteractorCombine{InteractorContext: i, model: NewModelCombine(&i.github, &i.git, i.projectList)}
+	o = InteractorCombine{InteractorContext: i, model: NewModelCombine(&i.github, &i.git, i.projectList, i.kubernetes)}
 	o.kind = "combine"
 	return
 }
 
 func (self InteractorCombine) Request(pj DeployProject, phase string, branch string, assigner string, channel string) ([]slack.Block, error) {
-	txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*\n*%s*\n*%s* ブランチをデプロイしますか?", pj.ID, phase, branch), false, false)
-	btnTxt := slack.NewTextBlockObject("plain_text", "Deploy", false, false)
-	btn := slack.NewButtonBlockElement("", fmt.Sprintf("%s|%s_%s_%s", self.actionHeader("approve"), pj.ID, phase, branch), btnTxt)
-	section := slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn))
-	return []slack.Block{section, CloseButton()}, nil
+	return self.request(pj, phase, branch, "", assigner, channel)
+}
+
+// RequestTag asks for the approval to deploy the tag to all the steps instead of the one resolved from the branch.
+func (self InteractorCombine) RequestTag(pj DeployProject, phase string, tag string, assigner string, channel string) ([]slack.Block, error) {
+	return self.request(pj, phase, "", tag, assigner, channel)
+}
+
+// request posts the question with the approve button as the root message of a new thread in the channel,
+// so it returns no blocks to reply with.
+func (self InteractorCombine) request(pj DeployProject, phase string, branch string, tag string, assigner string, channel string) ([]slack.Block, error) {
+	if err := self.guard.Check(pj.ID, phase, assigner); err != nil {
+		return nil, err
+	}
+
+	lang := self.lang(assigner, channel)
+	txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*\n*%s*\n%s", pj.ID, phase, confirmDeploy(lang, branch, tag)), false, false)
+	return nil, self.postRequest(lang, txt, deploy.PendingRequest{Project: pj.ID, Phase: phase, Branch: branch, Tag: tag, Requester: assigner, Channel: channel})
 }
 
 func (self InteractorCombine) Approve(params string, userID string, channel string) ([]slack.Block, error) {
-	p := strings.Split(params, "_")
-	return self.approve(p[0], p[1], p[2], userID, channel)
+	return self.approvePendingRequest(params, userID, func(req deploy.PendingRequest) ([]slack.Block, error) {
+		return self.approve(req.Project, req.Phase, req.Branch, req.Tag, userID, self.requestThread(req, channel))
+	})
 }
 
-func (self InteractorCombine) approve(target string, phase string, branch string, userID string, channel string) (blocks []slack.Block, err error) {
+// approve deploys all the steps and follows them in the thread.
+// The root message of the thread is updated with the result, and the details are replied in the thread,
+// so it returns no blocks to reply with.
+func (self InteractorCombine) approve(target string, phase string, branch string, tag string, userID string, thread *deployThread) (blocks []slack.Block, err error) {
 	pj := self.projectList.Find(target)
+	if err = self.guard.Check(pj.ID, phase, userID); err != nil {
+		return
+	}
+
 	user := self.userList.FindBySlackUserID(userID)
+	lang := self.lang(userID, thread.channel)
+	base := self.plainBlocks(lang.Text(i18n.NowDeploying), lang.Sprintf(i18n.ActionedBy, userID))
+	if err = thread.update(slack.MsgOptionBlocks(base...)); err != nil {
+		return nil, err
+	}
 
 	go func() {
-		res, err := self.model.Deploy(pj, phase, DeployOption{Branch: branch, Assigner: user, Wait: true})
-		if err != nil || res.Status() == DeployStatusFail {
+		start := time.Now()
+		res, err := self.model.Deploy(pj, phase, DeployOption{Branch: branch, Tag: tag, Assigner: user, Wait: true})
+		if err == nil && res.Status() == DeployStatusFail {
+			err = fmt.Errorf("failed to deploy: %s", res.Message())
+		}
+		self.recordEvent(userID, deploy.Event{
+			Project:  pj.ID,
+			Phase:    phase,
+			Action:   deploy.EventActionDeploy,
+			Branch:   branch,
+			Tag:      tag,
+			Result:   eventResult(err),
+			Message:  errString(err),
+			Duration: metav1.Duration{Duration: time.Since(start)},
+		})
+		if err != nil {
 			fields := []slack.AttachmentField{
 				{Title: "user", Value: "<@" + userID + ">"},
 				{Title: "error", Value: err.Error()},
 			}
-			msg := slack.Attachment{Color: "#e01e5a", Title: fmt.Sprintf("Failed to deploy %s %s", pj.ID, phase), Fields: fields}
-			if _, _, err := self.client.PostMessage(channel, slack.MsgOptionAttachments(msg)); err != nil {
-				log.Printf("Failed to post message: %s", err.Error())
-			}
+			self.reportResult(thread, base, slack.Attachment{Color: "#e01e5a", Title: lang.Sprintf(i18n.DeployFailed, pj.ID, phase), Fields: fields})
 			return
 		}
 
 		fields := []slack.AttachmentField{{Title: "user", Value: "<@" + userID + ">"}}
-		msg := slack.Attachment{Color: "#36a64f", Title: fmt.Sprintf("Succeed to deploy %s %s", pj.ID, phase), Fields: fields}
-		if _, _, err := self.client.PostMessage(channel, slack.MsgOptionAttachments(msg)); err != nil {
-			log.Printf("Failed to post message: %s", err.Error())
-		}
+		self.reportResult(thread, base, slack.Attachment{Color: "#36a64f", Title: lang.Sprintf(i18n.DeploySucceeded, pj.ID, phase), Fields: fields})
 	}()
 
-	blocks = self.plainBlocks("Now deploying ...")
-	userObject := slack.NewTextBlockObject("mrkdwn", "by <@"+userID+">", false, false)
-	blocks = append(blocks, slack.NewSectionBlock(userObject, nil, nil))
-	return
+	return nil, nil
 }
 
 func (self InteractorCombine) Reject(params string, userID string) (blocks []slack.Block, err error) {
-	return
+	return self.rejectPendingRequest(params, userID)
 }
 
-func (self InteractorCombine) BranchList(pj DeployProject, phase string) ([]slack.Block, error) {
-	return self.branchList(pj, phase)
+func (self InteractorCombine) BranchList(pj DeployProject, phase string, userID string, channel string) ([]slack.Block, error) {
+	return self.branchList(pj, phase, userID, channel)
 }
 
-func (self InteractorCombine) BranchListFromRaw(params string) (blocks []slack.Block, err error) {
-	p := strings.Split(params, "_")
-	pj := self.projectList.Find(p[0])
-	return self.branchList(pj, p[1])
+func (self InteractorCombine) BranchListFromRaw(params string, userID string, channel string) (blocks []slack.Block, err error) {
+	pj, phase, err := self.findActionTarget(params)
+	if err != nil {
+		return nil, err
+	}
+	return self.branchList(pj, phase, userID, channel)
 }
 
 func (self InteractorCombine) SelectBranch(params string, branch string, userID string, channel string) ([]slack.Block, error) {
-	p := strings.Split(params, "_")
-	pj := self.projectList.Find(p[0])
-	return self.Request(pj, p[1], branch, userID, channel)
+	pj, phase, err := self.findActionTarget(params)
+	if err != nil {
+		return nil, err
+	}
+	return self.Request(pj, phase, branch, userID, channel)
 }
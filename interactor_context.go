@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 
 	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
 )
 
 type InteractorContext struct {
@@ -13,15 +18,126 @@ type InteractorContext struct {
 	userList    *UserList
 	github      GitHub
 	git         GitOperator
-	client      *slack.Client
+	client      SlackClient
 	config      CatConfig
+	history     deploy.History
+	guard       *DeployGuard
+	pending     deploy.PendingRequestStore
+	argocd      *ArgoCD
+	rollout     *KubernetesRolloutWatcher
+	kubernetes  *deploy.Kubernetes
+	languages   *LanguageList
+}
+
+// lang returns the language of the messages to the Slack user in the channel.
+// Either of the user and the channel can be empty if unknown.
+func (i InteractorContext) lang(userID string, channel string) i18n.Lang {
+	var name string
+	if i.userList != nil && userID != "" {
+		name = i.userList.FindBySlackUserID(userID).SlackDisplayName
+	}
+	return i.languages.Lang(name, channel)
 }
 
 func (i InteractorContext) actionHeader(nextFunc string) string {
 	return fmt.Sprintf("deploy_%s_%s", i.kind, nextFunc)
 }
 
-func (i InteractorContext) branchList(pj DeployProject, phase string) ([]slack.Block, error) {
+// actionTarget is the project phase to deploy, carried by the values of the buttons and the options.
+// It's encoded in JSON, as both the project IDs and the phase names may contain underscores.
+type actionTarget struct {
+	Project string `json:"project"`
+	Phase   string `json:"phase"`
+	// Index tells apart the options of the same project phase, as their values must be unique.
+	Index int `json:"index,omitempty"`
+}
+
+func (t actionTarget) value() string {
+	b, _ := json.Marshal(t)
+	return string(b)
+}
+
+// parseActionTarget parses the project phase from the params of the action value.
+func parseActionTarget(params string) (actionTarget, error) {
+	var t actionTarget
+	if err := json.Unmarshal([]byte(params), &t); err != nil || t.Project == "" || t.Phase == "" {
+		return actionTarget{}, fmt.Errorf("[ERROR] Invalid action target %q", params)
+	}
+	return t, nil
+}
+
+// findActionTarget returns the project and the phase of the action target in the params of the action value.
+func (i InteractorContext) findActionTarget(params string) (DeployProject, string, error) {
+	t, err := parseActionTarget(params)
+	if err != nil {
+		return DeployProject{}, "", err
+	}
+	return i.projectList.Find(t.Project), t.Phase, nil
+}
+
+// createPendingRequest stores the deploy request waiting for the approval,
+// and returns the ID to be put into the values of the approve and reject buttons.
+func (i InteractorContext) createPendingRequest(req deploy.PendingRequest) (string, error) {
+	if i.pending == nil {
+		return "", fmt.Errorf("[ERROR] The store of pending deploy requests is not configured")
+	}
+
+	req, err := i.pending.Create(context.Background(), req)
+	if err != nil {
+		return "", fmt.Errorf("[ERROR] Unable to store the deploy request of %s %s: %w", req.Project, req.Phase, err)
+	}
+
+	return req.ID, nil
+}
+
+// pendingRequest returns the deploy request identified by the ID in the button value.
+// deploy.ErrStaleRequest is returned if the request has expired or has already been approved or rejected,
+// and deploy.ErrRequestInProgress if the request is being deployed.
+func (i InteractorContext) pendingRequest(id string) (deploy.PendingRequest, error) {
+	if i.pending == nil {
+		return deploy.PendingRequest{}, deploy.ErrStaleRequest
+	}
+
+	req, err := i.pending.Get(context.Background(), id)
+	if err != nil {
+		return deploy.PendingRequest{}, err
+	}
+	if req.Claimed {
+		return deploy.PendingRequest{}, deploy.ErrRequestInProgress
+	}
+	return req, nil
+}
+
+// claimPendingRequest marks the request as being handled, so that it's deployed or rejected only once
+// even if the buttons are clicked twice, or by multiple users at the same time.
+// deploy.ErrRequestInProgress is returned if the request has already been claimed.
+func (i InteractorContext) claimPendingRequest(id string) (deploy.PendingRequest, error) {
+	if i.pending == nil {
+		return deploy.PendingRequest{}, deploy.ErrStaleRequest
+	}
+
+	return i.pending.Modify(context.Background(), id, func(r *deploy.PendingRequest) error {
+		if r.Claimed {
+			return deploy.ErrRequestInProgress
+		}
+		r.Claimed = true
+		return nil
+	})
+}
+
+// deletePendingRequest removes the approved or rejected request so that the buttons can't be clicked twice.
+func (i InteractorContext) deletePendingRequest(id string) {
+	if i.pending == nil {
+		return
+	}
+
+	if err := i.pending.Delete(context.Background(), id); err != nil {
+		log.Printf("[ERROR] Unable to delete the pending deploy request %s: %s", id, err)
+	}
+}
+
+// branchList shows the branches of the project to select the one to deploy, in the language of the user in the channel.
+func (i InteractorContext) branchList(pj DeployProject, phase string, userID string, channel string) ([]slack.Block, error) {
 	repo := pj.GitHubRepository()
 	arr, err := i.github.ListBranch(repo)
 	if err != nil {
@@ -31,14 +147,215 @@ func (i InteractorContext) branchList(pj DeployProject, phase string) ([]slack.B
 	var opts []*slack.OptionBlockObject
 	for n, v := range arr {
 		txt := slack.NewTextBlockObject("plain_text", v, false, false)
-		opt := slack.NewOptionBlockObject(fmt.Sprintf("%s|%s_%s_%d", i.actionHeader("selectbranch"), pj.ID, phase, n), txt, nil)
+		opt := slack.NewOptionBlockObject(i.actionHeader("selectbranch")+"|"+actionTarget{Project: pj.ID, Phase: phase, Index: n}.value(), txt, nil)
 		opts = append(opts, opt)
 	}
-	txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s* branch list", repo), false, false)
+	lang := i.lang(userID, channel)
+	txt := slack.NewTextBlockObject("mrkdwn", lang.Sprintf(i18n.BranchList, repo), false, false)
 	availableOption := slack.NewOptionsSelectBlockElement("static_select", nil, "", opts...)
 	section := slack.NewSectionBlock(txt, nil, slack.NewAccessory(availableOption))
-	fmt.Printf("[INFO] %#v", section)
-	return []slack.Block{section, CloseButton()}, nil
+	return []slack.Block{section, CloseButton(lang)}, nil
+}
+
+// recordEvent records the deploy event to the history, if configured.
+// The user of the event is resolved from the Slack user ID.
+func (i InteractorContext) recordEvent(userID string, ev deploy.Event) {
+	ev.User = userID
+	if i.userList != nil {
+		if user := i.userList.FindBySlackUserID(userID); user.SlackDisplayName != "" {
+			ev.User = user.SlackDisplayName
+		}
+	}
+	recordEvent(i.history, ev)
+}
+
+// MaxRollbackCandidates is the number of past tags looked up in the git log when no deploy history is available.
+const MaxRollbackCandidates = 20
+
+// rollbackTag returns the tag that was deployed before the currently deployed one.
+//
+// The deploy history is consulted first. If it doesn't know the previous tag,
+// the tag is looked up from the git log of the kustomization file for kustomize destinations.
+func (i InteractorContext) rollbackTag(pj DeployProject, phase DeployPhase) (string, error) {
+	current, err := phase.Destination.GetCurrentRevision(GetCurrentRevisionInput{github: &i.github, kubernetes: i.kubernetes, aws: pj.AWSConfig(phase.Name)})
+	if err != nil {
+		log.Printf("[WARNING] Unable to get the current revision of %s %s: %s", pj.ID, phase.Name, err)
+		current = ""
+	}
+
+	if i.history != nil {
+		events, err := i.history.List(context.Background(), pj.ID, phase.Name, 0)
+		if err != nil {
+			log.Printf("[ERROR] Unable to list the history of %s %s: %s", pj.ID, phase.Name, err)
+		}
+		for _, ev := range events {
+			if ev.Result != deploy.EventResultSuccess || !ev.Action.IsDeploy() || ev.Tag == "" {
+				continue
+			}
+			if current == "" {
+				current = ev.Tag
+				continue
+			}
+			if ev.Tag != current {
+				return ev.Tag, nil
+			}
+		}
+	}
+
+	if phase.Destination.Kind == "kustomize" {
+		tags, err := i.git.ImageTagHistory(phase.Destination.Kustomize.Path, phase.Destination.Kustomize.Image, MaxRollbackCandidates)
+		if err != nil {
+			return "", err
+		}
+		for _, tag := range tags {
+			if current == "" {
+				current = tag
+				continue
+			}
+			if tag != current {
+				return tag, nil
+			}
+		}
+	}
+
+	return "", rollbackTagNotFoundError{project: pj.ID, phase: phase.Name}
+}
+
+// rollbackTagNotFoundError is returned by rollbackTag when there's no tag to roll back to.
+type rollbackTagNotFoundError struct {
+	project string
+	phase   string
+}
+
+func (e rollbackTagNotFoundError) Error() string {
+	return fmt.Sprintf("[ERROR] Unable to find the previous tag of %s %s. Please specify the tag by `rollback %s %s to <tag>`", e.project, e.phase, e.project, e.phase)
+}
+
+// followInThread runs follow, which reports the progress of a deployment to the thread.
+//
+// If the deployment has its own thread, the message is replied in the thread,
+// and the root message is finally updated to the state in base with the result returned by follow.
+// Otherwise the message is posted to the channel as the root of a new thread,
+// and is finally replaced with the result, so that the channel shows only the result.
+func (i InteractorContext) followInThread(thread *deployThread, base []slack.Block, message string, follow func(progress func(string)) string) {
+	if thread.started() {
+		thread.reply(slack.MsgOptionBlocks(i.plainBlocks(message)...))
+	} else if err := thread.update(slack.MsgOptionBlocks(i.plainBlocks(message)...)); err != nil {
+		log.Printf("Failed to post message: %s", err)
+		return
+	}
+
+	result := follow(func(progress string) {
+		thread.reply(slack.MsgOptionBlocks(i.plainBlocks(progress)...))
+	})
+
+	blocks := append(append([]slack.Block{}, base...), i.plainBlocks(result)...)
+	if err := thread.update(slack.MsgOptionBlocks(blocks...)); err != nil {
+		log.Printf("Failed to update message: %s", err)
+	}
+}
+
+// postRequest posts the question with the approve and close buttons of the request as the root message of a new thread
+// in the channel of the request.
+// The root message is posted first to store its timestamp with the request, and then updated with the buttons.
+func (i InteractorContext) postRequest(lang i18n.Lang, question *slack.TextBlockObject, req deploy.PendingRequest) error {
+	thread := newDeployThread(i.client, req.Channel, "")
+	if err := thread.update(slack.MsgOptionBlocks(slack.NewSectionBlock(question, nil, nil))); err != nil {
+		return err
+	}
+	req.ThreadTS = thread.ts
+	id, err := i.createPendingRequest(req)
+	if err != nil {
+		return err
+	}
+
+	btnTxt := slack.NewTextBlockObject("plain_text", lang.Text(i18n.DeployButton), false, false)
+	btn := slack.NewButtonBlockElement("", fmt.Sprintf("%s|%s", i.actionHeader("approve"), id), btnTxt)
+	section := slack.NewSectionBlock(question, nil, slack.NewAccessory(btn))
+	return thread.update(slack.MsgOptionBlocks(section, i.rejectButton(lang, id)))
+}
+
+// requestRollback asks for the approval to roll back the phase to the tag in the same way as postRequest,
+// for the phases with the approval policy whose rollbacks are not deployed right away.
+// The request is approved by Approve of the interactor, which deploys it as a rollback.
+func (i InteractorContext) requestRollback(pj DeployProject, phase string, tag string, assigner string, channel string) ([]slack.Block, error) {
+	if err := i.guard.Check(pj.ID, phase, assigner); err != nil {
+		return nil, err
+	}
+
+	lang := i.lang(assigner, channel)
+	txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*\n*%s*\n%s", pj.ID, phase, lang.Sprintf(i18n.ConfirmRollback, tag)), false, false)
+	return nil, i.postRequest(lang, txt, deploy.PendingRequest{Project: pj.ID, Phase: phase, Tag: tag, Action: deploy.EventActionRollback, Requester: assigner, Channel: channel})
+}
+
+// requestAction returns the action of the pending request to record to the history.
+func requestAction(req deploy.PendingRequest) deploy.EventAction {
+	if req.Action == "" {
+		return deploy.EventActionDeploy
+	}
+	return req.Action
+}
+
+// reportResult updates the root message of the thread to the state in base with the title of the result,
+// and replies the details of the result in the thread.
+func (i InteractorContext) reportResult(thread *deployThread, base []slack.Block, msg slack.Attachment) {
+	blocks := append(append([]slack.Block{}, base...), i.plainBlocks(msg.Title)...)
+	if err := thread.update(slack.MsgOptionBlocks(blocks...)); err != nil {
+		log.Printf("Failed to update message: %s", err.Error())
+	}
+	thread.reply(slack.MsgOptionAttachments(msg))
+}
+
+// requestThread returns the thread of the pending request,
+// or a new thread in the channel if the request was made without one.
+func (i InteractorContext) requestThread(req deploy.PendingRequest, channel string) *deployThread {
+	if req.ThreadTS != "" && req.Channel != "" {
+		return newDeployThread(i.client, req.Channel, req.ThreadTS)
+	}
+	return newDeployThread(i.client, channel, "")
+}
+
+// rolloutDestination returns the kubernetes destination of the phase if its rollout is to be followed.
+func (i InteractorContext) rolloutDestination(project, phase string) (DestinationKubernetes, bool) {
+	if i.rollout == nil || i.projectList == nil {
+		return DestinationKubernetes{}, false
+	}
+	dest := i.projectList.Find(project).FindPhase(phase).Destination
+	return dest.Kubernetes, dest.Kind == "kubernetes"
+}
+
+// currentImageTag returns the image tag of the kubernetes destination before the deployment,
+// to tell the rollout of the deployment from the previous one.
+func (i InteractorContext) currentImageTag(dest DestinationKubernetes) string {
+	tag, err := i.rollout.CurrentTag(context.Background(), dest)
+	if err != nil {
+		log.Print(err)
+	}
+	return tag
+}
+
+// trackRollout follows the rollout of the kubernetes destination after a deployment in the thread.
+// The progress is reported in the language of the channel, as the thread is shared by everyone in the channel.
+// before and after are the image tags before and after the deployment, either of which can be empty if unknown.
+func (i InteractorContext) trackRollout(ctx context.Context, thread *deployThread, base []slack.Block, dest DestinationKubernetes, before string, after string) {
+	lang := i.lang("", thread.channel)
+	i.followInThread(thread, base, lang.Sprintf(i18n.WaitingForRollout, dest), func(progress func(string)) string {
+		status, err := i.rollout.Watch(ctx, dest, before, after, func(status KubernetesRolloutStatus) {
+			progress(status.String())
+		})
+
+		switch {
+		case errors.Is(err, ErrUnknownRevision):
+			return lang.Sprintf(i18n.RolloutUnknown, dest)
+		case err != nil:
+			log.Print(err)
+			return lang.Sprintf(i18n.RolloutTimedOut, dest, i.rollout.Timeout, status)
+		case status.Failed:
+			return lang.Sprintf(i18n.RolloutFailed, dest, status)
+		default:
+			return lang.Sprintf(i18n.RolloutCompleted, dest, status.Image)
+		}
+	})
 }
 
 func (i InteractorContext) plainBlocks(texts ...string) (blocks []slack.Block) {
@@ -48,3 +365,16 @@ func (i InteractorContext) plainBlocks(texts ...string) (blocks []slack.Block) {
 	}
 	return
 }
+
+// deployTarget describes what is going to be deployed in the approval questions, either the branch or the tag.
+func deployTarget(lang i18n.Lang, branch string, tag string) string {
+	if tag != "" {
+		return lang.Sprintf(i18n.TargetTag, tag)
+	}
+	return lang.Sprintf(i18n.TargetBranch, branch)
+}
+
+// confirmDeploy asks whether to deploy the branch or the tag.
+func confirmDeploy(lang i18n.Lang, branch string, tag string) string {
+	return lang.Sprintf(i18n.ConfirmDeploy, deployTarget(lang, branch, tag))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/deploy"
+)
+
+func TestInteractorContextRollbackTag(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("v3"))
+	}))
+	defer ts.Close()
+
+	pj := DeployProject{ID: "myproject"}
+	phase := DeployPhase{Name: "production", Destination: Destination{Kind: "api", API: DestinationAPI{RevisionURL: ts.URL}}}
+
+	h := deploy.NewFileHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+	for _, ev := range []deploy.Event{
+		{Action: deploy.EventActionDeploy, Tag: "v1", Result: deploy.EventResultSuccess},
+		{Action: deploy.EventActionDeploy, Tag: "v2", Result: deploy.EventResultSuccess},
+		{Action: deploy.EventActionDeploy, Tag: "v9", Result: deploy.EventResultFailure},
+		{Action: deploy.EventActionLock, Result: deploy.EventResultSuccess},
+		{Action: deploy.EventActionAutoDeploy, Tag: "v3", Result: deploy.EventResultSuccess},
+	} {
+		ev.Project, ev.Phase = pj.ID, phase.Name
+		require.NoError(t, h.Record(context.Background(), ev))
+	}
+
+	i := InteractorContext{history: h}
+
+	tag, err := i.rollbackTag(pj, phase)
+	require.NoError(t, err)
+	require.Equal(t, "v2", tag)
+
+	_, err = InteractorContext{}.rollbackTag(pj, phase)
+	require.EqualError(t, err, "[ERROR] Unable to find the previous tag of myproject production. Please specify the tag by `rollback myproject production to <tag>`")
+}
+
+func TestInteractorPendingRequest(t *testing.T) {
+	store := deploy.NewMemoryPendingRequestStore(deploy.DefaultPendingRequestTTL)
+	client := &fakeSlackClient{}
+	i := NewInteractorJenkins(InteractorContext{pending: store, client: client})
+
+	pj := DeployProject{ID: "my_project"}
+	_, err := i.Request(pj, "staging", "feature_x", "U1234", "C1234")
+	require.NoError(t, err)
+
+	// The button carries only the ID of the pending request.
+	calls := client.Calls()
+	value := calls[len(calls)-1].buttons[0]
+	header, id, ok := strings.Cut(value, "|")
+	require.True(t, ok)
+	require.Equal(t, "deploy_jenkins_approve", header)
+	require.NotContains(t, id, "_")
+
+	req, err := i.pendingRequest(id)
+	require.NoError(t, err)
+	require.Equal(t, "my_project", req.Project)
+	require.Equal(t, "staging", req.Phase)
+	require.Equal(t, "feature_x", req.Branch)
+	require.Equal(t, "U1234", req.Requester)
+	require.Equal(t, "C1234", req.Channel)
+
+	i.deletePendingRequest(id)
+	_, err = i.Approve(id, "U1234", "C1234")
+	require.ErrorIs(t, err, deploy.ErrStaleRequest)
+
+	// Buttons posted before the pending requests were introduced are stale too.
+	_, err = NewInteractorKustomize(InteractorContext{pending: store}).Reject("PR_kwDOabc_2_bot/docker-image-tag-myproject-staging-abc1234", "U1234")
+	require.ErrorIs(t, err, deploy.ErrStaleRequest)
+}
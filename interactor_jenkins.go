@@ -1,93 +1,119 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
 	"net/http"
 
 	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
 )
 
 type InteractorJenkins struct {
 	InteractorContext
+	// httpClient triggers the Jenkins jobs.
+	httpClient *http.Client
 }
 
 func NewInteractorJenkins(i InteractorContext) (o InteractorJenkins) {
-	o = InteractorJenkins{i}
+	o = InteractorJenkins{i, http.DefaultClient}
 	o.kind = "jenkins"
 	return
 }
 
 func (i InteractorJenkins) Request(pj DeployProject, phase string, branch string, assigner string, channel string) ([]slack.Block, error) {
-	var txt *slack.TextBlockObject
+	if err := i.guard.Check(pj.ID, phase, assigner); err != nil {
+		return nil, err
+	}
+
+	lang := i.lang(assigner, channel)
+	text := fmt.Sprintf("*%s*\n*%s*\n%s", pj.GitHubRepository(), phase, confirmDeploy(lang, branch, ""))
 	if phase == "production" && branch != pj.DefaultBranch() {
-		txt = slack.NewTextBlockObject(
-			"mrkdwn",
-			fmt.Sprintf(
-				":star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star:\n本番環境に master ブランチ以外をデプロイしようとしています\n:star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star::star:\n*%s*\n*%s*\n*%s* ブランチ\nをデプロイしますか?",
-				pj.GitHubRepository(),
-				phase,
-				branch,
-			),
-			false,
-			false,
-		)
-	} else {
-		txt = slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*\n*%s*\n*%s* ブランチ\nをデプロイしますか?", pj.GitHubRepository(), phase, branch), false, false)
+		stars := strings.Repeat(":star:", 21)
+		text = fmt.Sprintf("%s\n%s\n%s\n%s", stars, lang.Sprintf(i18n.ProductionBranchWarning, pj.DefaultBranch()), stars, text)
 	}
-	btnTxt := slack.NewTextBlockObject("plain_text", "Deploy", false, false)
-	btn := slack.NewButtonBlockElement("", fmt.Sprintf("%s|%s_%s_%s", i.actionHeader("approve"), pj.ID, phase, branch), btnTxt)
-	section := slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn))
-	return []slack.Block{section, CloseButton()}, nil
+	txt := slack.NewTextBlockObject("mrkdwn", text, false, false)
+	return nil, i.postRequest(lang, txt, deploy.PendingRequest{Project: pj.ID, Phase: phase, Branch: branch, Requester: assigner, Channel: channel})
 }
 
-func (i InteractorJenkins) BranchList(pj DeployProject, phase string) ([]slack.Block, error) {
-	return i.branchList(pj, phase)
+func (i InteractorJenkins) BranchList(pj DeployProject, phase string, userID string, channel string) ([]slack.Block, error) {
+	return i.branchList(pj, phase, userID, channel)
 }
 
-func (i InteractorJenkins) BranchListFromRaw(params string) ([]slack.Block, error) {
-	p := strings.Split(params, "_")
-	pj := i.projectList.Find(p[0])
-	return i.branchList(pj, p[1])
+func (i InteractorJenkins) BranchListFromRaw(params string, userID string, channel string) ([]slack.Block, error) {
+	pj, phase, err := i.findActionTarget(params)
+	if err != nil {
+		return nil, err
+	}
+	return i.branchList(pj, phase, userID, channel)
 }
 
 func (i InteractorJenkins) SelectBranch(params string, branch string, userID string, channel string) ([]slack.Block, error) {
-	p := strings.Split(params, "_")
-	pj := i.projectList.Find(p[0])
-	return i.Request(pj, p[1], branch, userID, channel)
+	pj, phase, err := i.findActionTarget(params)
+	if err != nil {
+		return nil, err
+	}
+	return i.Request(pj, phase, branch, userID, channel)
 }
 
 func (i InteractorJenkins) Approve(params string, userID string, channel string) ([]slack.Block, error) {
-	p := strings.Split(params, "_")
-	return i.approve(p[0], p[1], p[2], userID)
+	return i.approvePendingRequest(params, userID, func(req deploy.PendingRequest) ([]slack.Block, error) {
+		return i.approve(req.Project, req.Phase, req.Branch, userID, i.requestThread(req, channel))
+	})
 }
 
-func (i InteractorJenkins) approve(target string, phase string, branch string, userID string) (blocks []slack.Block, err error) {
+// approve runs the Jenkins job, and updates the root message of the thread with the result.
+// The rollout after the job is followed in the thread, so it returns no blocks to reply with.
+func (i InteractorJenkins) approve(target string, phase string, branch string, userID string, thread *deployThread) (blocks []slack.Block, err error) {
 	pj := i.projectList.Find(target)
+	if err = i.guard.Check(pj.ID, phase, userID); err != nil {
+		return
+	}
+
+	lang := i.lang(userID, thread.channel)
 	jobName := pj.JenkinsJob()
 	url := fmt.Sprintf("https://bot:%s@%s/job/%s/buildWithParameters?token=%s&cause=slack-bot&ENV=%s&BRANCH=%s", i.config.JenkinsBotToken, i.config.JenkinsHost, jobName, i.config.JenkinsJobToken, phase, branch)
-	resp, err := http.Get(url)
+	ev := deploy.Event{Project: pj.ID, Phase: phase, Action: deploy.EventActionDeploy, Branch: branch}
+
+	// The job updates the workload asynchronously, so the rollout is waited for until the image tag changes.
+	dest, rollout := i.rolloutDestination(pj.ID, phase)
+	var before string
+	if rollout {
+		before = i.currentImageTag(dest)
+	}
+
+	resp, err := i.httpClient.Get(url)
 	if err != nil {
+		ev.Result, ev.Message = deploy.EventResultFailure, err.Error()
+		i.recordEvent(userID, ev)
 		return
 	}
 	defer resp.Body.Close()
-	res := fmt.Sprintf("Execute https://%s/job/%s/ \n selected branch: %s", i.config.JenkinsHost, jobName, branch)
+	res := lang.Sprintf(i18n.JenkinsJobExecuted, i.config.JenkinsHost, jobName, branch)
+	ev.Result = deploy.EventResultSuccess
 	if err != nil {
 		res = err.Error()
 	}
 	if resp.StatusCode != 201 {
-		res = jobName + " Request failed. responsed " + fmt.Sprint(resp.StatusCode)
+		res = lang.Sprintf(i18n.JenkinsJobFailed, jobName, resp.StatusCode)
+		ev.Result, ev.Message = deploy.EventResultFailure, fmt.Sprintf("responded %d", resp.StatusCode)
+		rollout = false
 	}
+	i.recordEvent(userID, ev)
 
-	blockObject := slack.NewTextBlockObject("mrkdwn", res, false, false)
-	blocks = append(blocks, slack.NewSectionBlock(blockObject, nil, nil))
-
-	userObject := slack.NewTextBlockObject("mrkdwn", "by <@"+userID+">", false, false)
-	blocks = append(blocks, slack.NewSectionBlock(userObject, nil, nil))
-	return
+	base := i.plainBlocks(res, lang.Sprintf(i18n.ActionedBy, userID))
+	if err = thread.update(slack.MsgOptionBlocks(base...)); err != nil {
+		return nil, err
+	}
+	if rollout {
+		go i.trackRollout(context.Background(), thread, base, dest, before, "")
+	}
+	return nil, nil
 }
 
 func (i InteractorJenkins) Reject(params string, userID string) (blocks []slack.Block, err error) {
-	return
+	return i.rejectPendingRequest(params, userID)
 }
@@ -2,96 +2,155 @@ package main
 
 import (
 	"fmt"
-	"log"
-	"strings"
+	"time"
 
 	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type InteractorJob struct {
 	InteractorContext
-	model ModelJob
+	model jobModel
+}
+
+// jobModel is the DeployModel that runs a job, which can be watched until it completes.
+// It is implemented by ModelJob.
+type jobModel interface {
+	DeployModel
+	Watch(name, namespace string) error
 }
 
 func NewInteractorJob(i InteractorContext) (o InteractorJob) {
-	o = InteractorJob{InteractorContext: i, model: NewModelJob(&i.github)}
+	o = InteractorJob{InteractorContext: i, model: NewModelJob(&i.github, i.kubernetes)}
 	o.kind = "job"
 	return
 }
 
 func (i InteractorJob) Request(pj DeployProject, phase string, branch string, assigner string, channel string) (blocks []slack.Block, err error) {
-	var txt *slack.TextBlockObject
+	return i.request(pj, phase, branch, "", assigner, channel)
+}
+
+// RequestTag asks for the approval to run the job with the tag instead of the one resolved from the branch.
+func (i InteractorJob) RequestTag(pj DeployProject, phase string, tag string, assigner string, channel string) ([]slack.Block, error) {
+	return i.request(pj, phase, "", tag, assigner, channel)
+}
+
+// request posts the question with the approve button as the root message of a new thread in the channel,
+// so it returns no blocks to reply with.
+func (i InteractorJob) request(pj DeployProject, phase string, branch string, tag string, assigner string, channel string) (blocks []slack.Block, err error) {
+	if err = i.guard.Check(pj.ID, phase, assigner); err != nil {
+		return
+	}
+
+	lang := i.lang(assigner, channel)
 	p := pj.FindPhase(phase)
-	txt = slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*\n*%s*\n*%s* ブランチ\nをデプロイしますか?", p.Path, phase, branch), false, false)
-	btnTxt := slack.NewTextBlockObject("plain_text", "Deploy", false, false)
-	btn := slack.NewButtonBlockElement("", fmt.Sprintf("%s|%s_%s_%s", i.actionHeader("approve"), pj.ID, phase, branch), btnTxt)
-	section := slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn))
-	return []slack.Block{section, CloseButton()}, nil
+	txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*\n*%s*\n%s", p.Path, phase, confirmDeploy(lang, branch, tag)), false, false)
+	return nil, i.postRequest(lang, txt, deploy.PendingRequest{Project: pj.ID, Phase: phase, Branch: branch, Tag: tag, Requester: assigner, Channel: channel})
 }
 
-func (i InteractorJob) BranchList(pj DeployProject, phase string) ([]slack.Block, error) {
-	return i.branchList(pj, phase)
+func (i InteractorJob) BranchList(pj DeployProject, phase string, userID string, channel string) ([]slack.Block, error) {
+	return i.branchList(pj, phase, userID, channel)
 }
 
-func (i InteractorJob) BranchListFromRaw(params string) ([]slack.Block, error) {
-	p := strings.Split(params, "_")
-	pj := i.projectList.Find(p[0])
-	return i.branchList(pj, p[1])
+func (i InteractorJob) BranchListFromRaw(params string, userID string, channel string) ([]slack.Block, error) {
+	pj, phase, err := i.findActionTarget(params)
+	if err != nil {
+		return nil, err
+	}
+	return i.branchList(pj, phase, userID, channel)
 }
 
 func (i InteractorJob) SelectBranch(params string, branch string, userID string, channel string) ([]slack.Block, error) {
-	p := strings.Split(params, "_")
-	pj := i.projectList.Find(p[0])
-	return i.Request(pj, p[1], branch, userID, channel)
+	pj, phase, err := i.findActionTarget(params)
+	if err != nil {
+		return nil, err
+	}
+	return i.Request(pj, phase, branch, userID, channel)
 }
 
-func (i InteractorJob) Approve(params string, userID string, channel string) (blocks []slack.Block, err error) {
-	p := strings.Split(params, "_")
-	return i.approve(p[0], p[1], p[2], userID, channel)
+func (i InteractorJob) Approve(params string, userID string, channel string) ([]slack.Block, error) {
+	return i.approvePendingRequest(params, userID, func(req deploy.PendingRequest) ([]slack.Block, error) {
+		pj := i.projectList.Find(req.Project)
+		return i.deploy(pj, req.Phase, DeployOption{Branch: req.Branch, Tag: req.Tag}, requestAction(req), userID, i.requestThread(req, channel))
+	})
+}
+
+// Rollback runs the job with the tag that was used before the current one, or the specified tag.
+// It's run right away unless the phase has the approval policy, in which case the approval is asked for instead.
+func (i InteractorJob) Rollback(pj DeployProject, phase string, tag string, assigner string, channel string) ([]slack.Block, error) {
+	if tag == "" {
+		var err error
+		tag, err = i.rollbackTag(pj, pj.FindPhase(phase))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if pj.FindPhase(phase).Approval != nil {
+		return i.requestRollback(pj, phase, tag, assigner, channel)
+	}
+	return i.deploy(pj, phase, DeployOption{Tag: tag}, deploy.EventActionRollback, assigner, newDeployThread(i.client, channel, ""))
 }
 
-func (i InteractorJob) approve(target string, phase string, branch string, userID string, channel string) (blocks []slack.Block, err error) {
-	pj := i.projectList.Find(target)
+// deploy runs the job and follows it in the thread.
+// The root message of the thread is updated with the result of the job, and the details are replied in the thread,
+// so it returns no blocks to reply with.
+func (i InteractorJob) deploy(pj DeployProject, phase string, option DeployOption, action deploy.EventAction, userID string, thread *deployThread) (blocks []slack.Block, err error) {
+	if err = i.guard.Check(pj.ID, phase, userID); err != nil {
+		return
+	}
 
-	res, err := i.model.Deploy(pj, phase, DeployOption{Branch: branch})
+	lang := i.lang(userID, thread.channel)
+	start := time.Now()
+	ev := deploy.Event{Project: pj.ID, Phase: phase, Action: action, Branch: option.Branch, Tag: option.Tag}
+	res, err := i.model.Deploy(pj, phase, option)
 	if err != nil {
+		ev.Result, ev.Message = deploy.EventResultFailure, err.Error()
+		i.recordEvent(userID, ev)
 		fields := []slack.AttachmentField{
 			{Title: "user", Value: "<@" + userID + ">"},
 			{Title: "error", Value: err.Error()},
 		}
-		msg := slack.Attachment{Color: "#e01e5a", Title: fmt.Sprintf("Failed to deploy %s %s", pj.ID, phase), Fields: fields}
-		if _, _, err := i.client.PostMessage(channel, slack.MsgOptionAttachments(msg)); err != nil {
-			log.Printf("Failed to post message: %s", err.Error())
-		}
+		msg := slack.Attachment{Color: "#e01e5a", Title: lang.Sprintf(i18n.DeployFailed, pj.ID, phase), Fields: fields}
+		thread.reply(slack.MsgOptionAttachments(msg))
 		return
 	}
 
+	base := i.plainBlocks(
+		res.Message(),
+		lang.Sprintf(i18n.ActionedBy, userID),
+	)
+	if err = thread.update(slack.MsgOptionBlocks(base...)); err != nil {
+		return nil, err
+	}
+
 	switch do := res.(type) {
 	case ModelJobDeployOutput:
 		go func() {
 			err := i.model.Watch(do.Name, do.Namespace)
-			fields := []slack.AttachmentField{{Title: "user", Value: "<@" + userID + ">"}}
-			if err != nil {
-				msg := slack.Attachment{Color: "#e01e5a", Title: fmt.Sprintf("Failed %s execution", do.Name), Fields: fields}
-				if _, _, err := i.client.PostMessage(channel, slack.MsgOptionAttachments(msg)); err != nil {
-					log.Printf("Failed to post message: %s", err.Error())
-				}
-				return
-			}
-			msg := slack.Attachment{Color: "#36a64f", Title: fmt.Sprintf("Succeed %s Job execution", do.Name), Fields: fields}
-			if _, _, err := i.client.PostMessage(channel, slack.MsgOptionAttachments(msg)); err != nil {
-				log.Printf("Failed to post message: %s", err.Error())
-			}
+			ev.Tag = do.ImageTag
+			ev.Result, ev.Message = eventResult(err), errString(err)
+			ev.Duration = metav1.Duration{Duration: time.Since(start)}
+			i.recordEvent(userID, ev)
+			i.reportJob(thread, base, lang, do.Name, userID, err)
 		}()
 	}
 
-	blocks = i.plainBlocks(
-		res.Message(),
-		"by <@"+userID+">",
-	)
-	return
+	return nil, nil
+}
+
+// reportJob updates the root message of the thread with the result of the job, and replies the details in the thread.
+func (i InteractorJob) reportJob(thread *deployThread, base []slack.Block, lang i18n.Lang, name string, userID string, err error) {
+	fields := []slack.AttachmentField{{Title: "user", Value: "<@" + userID + ">"}}
+	msg := slack.Attachment{Color: "#36a64f", Title: lang.Sprintf(i18n.JobSucceeded, name), Fields: fields}
+	if err != nil {
+		msg = slack.Attachment{Color: "#e01e5a", Title: lang.Sprintf(i18n.JobFailed, name), Fields: append(fields, slack.AttachmentField{Title: "error", Value: err.Error()})}
+	}
+
+	i.reportResult(thread, base, msg)
 }
 
 func (i InteractorJob) Reject(params string, userID string) (blocks []slack.Block, err error) {
-	return
+	return i.rejectPendingRequest(params, userID)
 }
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type InteractorGitOps struct {
@@ -17,170 +22,337 @@ type InteractorGitOps struct {
 func NewInteractorKustomize(i InteractorContext) (o InteractorGitOps) {
 	o = InteractorGitOps{
 		InteractorContext: i,
-		model:             NewGitOpsPluginKustomize(&o.github, &o.git),
+		model:             NewGitOpsPluginKustomize(&o.github, &o.git, o.kubernetes),
 	}
 	o.kind = "kustomize"
 	return
 }
 
 func (i InteractorGitOps) Request(pj DeployProject, phase string, branch string, assigner string, channel string) (blocks []slack.Block, err error) {
+	return i.prepare(pj, phase, branch, "", assigner, channel, confirmDeploy(i.lang(assigner, channel), branch, ""))
+}
+
+// RequestTag prepares a pull request to deploy the tag, and asks for the approval in the same way as Request.
+func (i InteractorGitOps) RequestTag(pj DeployProject, phase string, tag string, assigner string, channel string) ([]slack.Block, error) {
+	return i.prepare(pj, phase, pj.DefaultBranch(), tag, assigner, channel, confirmDeploy(i.lang(assigner, channel), "", tag))
+}
+
+// Rollback prepares a pull request to deploy the tag that was running before the current one,
+// or the specified tag, and asks for the approval in the same way as Request.
+func (i InteractorGitOps) Rollback(pj DeployProject, phase string, tag string, assigner string, channel string) ([]slack.Block, error) {
+	if tag == "" {
+		var err error
+		tag, err = i.rollbackTag(pj, pj.FindPhase(phase))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return i.prepare(pj, phase, pj.DefaultBranch(), tag, assigner, channel, i.lang(assigner, channel).Sprintf(i18n.ConfirmRollback, tag))
+}
+
+// prepare asynchronously prepares the deployment by creating a pull request in a new thread of the channel.
+// The root message of the thread is updated with the question and the approve and reject buttons
+// once the pull request is created, so it returns no blocks to reply with.
+//
+// If tag is empty, the tag is resolved from the branch.
+func (i InteractorGitOps) prepare(pj DeployProject, phase string, branch string, tag string, assigner string, channel string, question string) ([]slack.Block, error) {
+	if err := i.guard.Check(pj.ID, phase, assigner); err != nil {
+		return nil, err
+	}
+
 	user := i.userList.FindBySlackUserID(assigner)
+	lang := i.lang(assigner, channel)
+
+	thread := newDeployThread(i.client, channel, "")
+	if err := thread.update(slack.MsgOptionBlocks(i.stateBlocks(pj.GitHubRepository(), phase, assigner, question, lang.Text(i18n.NowCreatingPullRequest))...)); err != nil {
+		return nil, err
+	}
 
 	go func() {
 		defer func() {
 			log.Printf("[INFO] Exiting the goroutine for Prepare")
 		}()
 
-		log.Printf("[INFO] Preparing to deploy %s %s %s", pj.ID, phase, branch)
+		log.Printf("[INFO] Preparing to deploy %s %s %s %s", pj.ID, phase, branch, tag)
 
-		o, err := i.model.Prepare(pj, phase, branch, user, "")
+		o, err := i.model.Prepare(pj, phase, branch, user, tag)
 		if err != nil {
 			log.Printf("[ERROR] %s", err.Error())
-
-			blocks := i.plainBlocks(err.Error())
-			if _, _, err := i.client.PostMessage(channel, slack.MsgOptionBlocks(blocks...)); err != nil {
-				log.Printf("Failed to post message: %s", err)
-			}
+			i.updateState(thread, i.stateBlocks(pj.GitHubRepository(), phase, assigner, question, err.Error()))
 			return
 		}
 
 		if o.Status() == DeployStatusAlready {
-			log.Printf("[INFO] Already Deployed in this revision: %s %s %s", pj.ID, phase, branch)
-
-			blocks = i.plainBlocks("Already Deployed in this revision")
-			if _, _, err := i.client.PostMessage(channel, slack.MsgOptionBlocks(blocks...)); err != nil {
-				log.Printf("Failed to post message: %s", err)
-			}
+			log.Printf("[INFO] Already Deployed in this revision: %s %s %s %s", pj.ID, phase, branch, tag)
+			i.updateState(thread, i.stateBlocks(pj.GitHubRepository(), phase, assigner, question, lang.Text(i18n.AlreadyDeployed)))
 			return
 		}
 
-		log.Printf("[INFO] Prepared to deploy %s %s %s", pj.ID, phase, branch)
+		log.Printf("[INFO] Prepared to deploy %s %s %s %s", pj.ID, phase, branch, tag)
 
 		prHTMLURL := o.PullRequestHTMLURL
 		if prHTMLURL == "" {
 			prHTMLURL = fmt.Sprintf("https://github.com/%s/%s/pull/%d", i.github.org, i.github.repo, o.PullRequestNumber)
 		}
 
-		txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("<@%s>\n*%s*\n*%s*\n*%s* ブランチをデプロイしますか?\n%s", assigner, pj.GitHubRepository(), phase, branch, prHTMLURL), false, false)
-		btnTxt := slack.NewTextBlockObject("plain_text", "Deploy", false, false)
-		btn := slack.NewButtonBlockElement("", fmt.Sprintf("%s|%s_%d", i.actionHeader("approve"), o.PullRequestID, o.PullRequestNumber), btnTxt)
-		blocks = append(blocks, slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn)))
-
-		closeBtnTxt := slack.NewTextBlockObject("plain_text", "Close", false, false)
-		closeBtn := slack.NewButtonBlockElement("", fmt.Sprintf("%s|%s_%d_%s", i.actionHeader("reject"), o.PullRequestID, o.PullRequestNumber, o.Branch), closeBtnTxt)
-		blocks = append(blocks, slack.NewActionBlock("", closeBtn))
-		if _, _, err := i.client.PostMessage(channel, slack.MsgOptionBlocks(blocks...)); err != nil {
-			log.Printf("Failed to post message: %s", err)
+		id, err := i.createPendingRequest(deploy.PendingRequest{
+			Project:           pj.ID,
+			Phase:             phase,
+			Branch:            branch,
+			Tag:               tag,
+			Requester:         assigner,
+			Channel:           channel,
+			ThreadTS:          thread.ts,
+			PullRequestID:     o.PullRequestID,
+			PullRequestNumber: o.PullRequestNumber,
+			PullRequestBranch: o.Branch,
+		})
+		if err != nil {
+			log.Print(err)
+			i.updateState(thread, i.stateBlocks(pj.GitHubRepository(), phase, assigner, question, prHTMLURL, err.Error()))
+			return
 		}
+
+		var blocks []slack.Block
+		txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("<@%s>\n*%s*\n*%s*\n%s\n%s", assigner, pj.GitHubRepository(), phase, question, prHTMLURL), false, false)
+		btnTxt := slack.NewTextBlockObject("plain_text", lang.Text(i18n.DeployButton), false, false)
+		btn := slack.NewButtonBlockElement("", fmt.Sprintf("%s|%s", i.actionHeader("approve"), id), btnTxt)
+		blocks = append(blocks, slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn)))
+		blocks = append(blocks, i.rejectButton(lang, id))
+		i.updateState(thread, blocks)
 	}()
 
-	return i.plainBlocks("Now creating pull request..."), nil
+	return nil, nil
+}
+
+// stateBlocks renders the root message of the deploy thread, which shows the requester, the repository, the phase,
+// and the lines describing the current state of the deployment.
+func (i InteractorGitOps) stateBlocks(repo string, phase string, requester string, lines ...string) []slack.Block {
+	header := fmt.Sprintf("<@%s>\n*%s*\n*%s*", requester, repo, phase)
+	return i.plainBlocks(strings.Join(append([]string{header}, lines...), "\n"))
+}
+
+// updateState updates the root message of the deploy thread with the blocks.
+func (i InteractorGitOps) updateState(thread *deployThread, blocks []slack.Block) {
+	if err := thread.update(slack.MsgOptionBlocks(blocks...)); err != nil {
+		log.Printf("Failed to update message: %s", err)
+	}
 }
 
-func (i InteractorGitOps) BranchList(pj DeployProject, phase string) ([]slack.Block, error) {
-	return i.branchList(pj, phase)
+func (i InteractorGitOps) BranchList(pj DeployProject, phase string, userID string, channel string) ([]slack.Block, error) {
+	return i.branchList(pj, phase, userID, channel)
 }
 
-func (i InteractorGitOps) BranchListFromRaw(params string) ([]slack.Block, error) {
-	p := strings.Split(params, "_")
-	pj := i.projectList.Find(p[0])
-	return i.branchList(pj, p[1])
+func (i InteractorGitOps) BranchListFromRaw(params string, userID string, channel string) ([]slack.Block, error) {
+	pj, phase, err := i.findActionTarget(params)
+	if err != nil {
+		return nil, err
+	}
+	return i.branchList(pj, phase, userID, channel)
 }
 
 func (i InteractorGitOps) SelectBranch(params string, branch string, userID string, channel string) ([]slack.Block, error) {
-	p := strings.Split(params, "_")
-	pj := i.projectList.Find(p[0])
-	return i.Request(pj, p[1], branch, userID, channel)
-}
-
-func (i InteractorGitOps) Approve(params string, userID string, channel string) (blocks []slack.Block, err error) {
-	prID := ""
-	prNumber := ""
-	p := strings.Split(params, "_")
-	if len(p) == 2 {
-		prID = p[0]
-		prNumber = p[1]
-	} else if strings.HasPrefix(params, "PR") {
-		// The format of IDs for GitHub PullReques has changed
-		// e.g. PR_hogehoge_fugafuga
-		prID = strings.Join(p[:len(p)-1], "_")
-		prNumber = p[len(p)-1]
-	} else {
-		err = fmt.Errorf("Invalid Arguments")
-		return
+	pj, phase, err := i.findActionTarget(params)
+	if err != nil {
+		return nil, err
 	}
-	if err = i.github.MergePullRequest(prID); err != nil {
+	return i.Request(pj, phase, branch, userID, channel)
+}
+
+// Approve merges the pull request of the pending request.
+//
+// If the request has its own thread, the root message is updated in place through the approved and merged states,
+// and the description of the pull request is replied in the thread, so it returns no blocks to reply with.
+func (i InteractorGitOps) Approve(params string, userID string, channel string) ([]slack.Block, error) {
+	return i.approvePendingRequest(params, userID, func(req deploy.PendingRequest) ([]slack.Block, error) {
+		return i.merge(req, userID, channel)
+	})
+}
+
+// merge merges the pull request of the approved request, and follows the deployment.
+func (i InteractorGitOps) merge(req deploy.PendingRequest, userID string, channel string) (blocks []slack.Block, err error) {
+	prURL := i.pullRequestURL(req.PullRequestNumber)
+
+	// The lock is checked again on approval, as the pull request may have been created before the lock was taken.
+	if err = i.guard.Check(req.Project, req.Phase, userID); err != nil {
 		return
 	}
 
-	blockObject := slack.NewTextBlockObject("mrkdwn", i.config.ArgoCDHost+"/applications", false, false)
-	blocks = append(blocks, slack.NewSectionBlock(blockObject, nil, nil))
+	lang := i.lang(userID, channel)
+	thread := i.requestThread(req, channel)
+	var repo string
+	if i.projectList != nil {
+		repo = i.projectList.Find(req.Project).GitHubRepository()
+	}
+	if thread.started() {
+		i.updateState(thread, i.stateBlocks(repo, req.Phase, req.Requester, confirmDeploy(lang, req.Branch, req.Tag), prURL, lang.Sprintf(i18n.PullRequestApproved, userID)))
+	}
 
-	prMsg := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("merged https://github.com/%s/%s/pull/%s\nby <@%s>", i.github.org, i.github.repo, prNumber, userID), false, false)
-	blocks = append(blocks, slack.NewSectionBlock(prMsg, nil, nil))
+	// The pull request is fetched before merging to show the commit log in the message.
+	pr, prErr := i.github.GetPullRequest(GitHubGetPullRequestInput{Number: req.PullRequestNumber})
 
-	num, err := strconv.Atoi(prNumber)
-	if err != nil {
-		return blocks, nil
+	// The revision of the ArgoCD application or the image tag of the kubernetes destination before merging
+	// tells the rollout of this deployment from the one of the previous deployment.
+	app := i.argoCDApp(req)
+	var before string
+	if app != "" {
+		status, err := i.argocd.GetApplicationStatus(context.Background(), app)
+		if err != nil {
+			log.Print(err)
+		}
+		before = status.Revision
+	}
+	dest, rollout := i.rolloutDestination(req.Project, req.Phase)
+	if app == "" && rollout {
+		before = i.currentImageTag(dest)
 	}
 
-	pr, err := i.github.GetPullRequest(GitHubGetPullRequestInput{Number: num})
+	start := time.Now()
+	mergeCommit, err := i.github.MergePullRequest(req.PullRequestID)
+	i.recordDeployEvent(req, prURL, userID, time.Since(start), err)
 	if err != nil {
-		return blocks, nil
+		return
 	}
 
-	commitLogLimit := 5000
-	prBody := pr.Body
-	if len(pr.Body) >= commitLogLimit {
-		tmp := strings.Split(pr.Body[:commitLogLimit], "\n")
-		prBody = strings.Join(tmp[0:len(tmp)-1], "\n")
+	argoCDURL := i.config.ArgoCDHost + "/applications"
+	if app != "" {
+		argoCDURL = i.argocd.ApplicationURL(app)
 	}
-	prDesc := slack.NewTextBlockObject("mrkdwn", prBody, false, false)
-	blocks = append(blocks, slack.NewSectionBlock(prDesc, nil, nil))
-	return
+	merged := i.stateBlocks(repo, req.Phase, req.Requester, confirmDeploy(lang, req.Branch, req.Tag), argoCDURL, lang.Sprintf(i18n.PullRequestMerged, prURL, userID))
+	if !thread.started() {
+		merged = i.plainBlocks(argoCDURL, lang.Sprintf(i18n.PullRequestMerged, prURL, userID))
+	}
+
+	if app != "" {
+		go i.trackArgoCDSync(context.Background(), thread, merged, app, before, mergeCommit)
+	} else if rollout {
+		go i.trackRollout(context.Background(), thread, merged, dest, before, i.pendingRequestTag(req))
+	}
+
+	var prDesc []slack.Block
+	if prErr == nil {
+		commitLogLimit := 5000
+		prBody := pr.Body
+		if len(pr.Body) >= commitLogLimit {
+			tmp := strings.Split(pr.Body[:commitLogLimit], "\n")
+			prBody = strings.Join(tmp[0:len(tmp)-1], "\n")
+		}
+		prDesc = i.plainBlocks(prBody)
+	}
+
+	if !thread.started() {
+		return append(merged, prDesc...), nil
+	}
+
+	i.updateState(thread, merged)
+	if len(prDesc) > 0 {
+		thread.reply(slack.MsgOptionBlocks(prDesc...))
+	}
+	return nil, nil
+}
+
+// argoCDApp returns the ArgoCD application of the phase of the pending request,
+// or an empty string if the sync is not to be followed.
+func (i InteractorGitOps) argoCDApp(req deploy.PendingRequest) string {
+	if i.argocd == nil || i.projectList == nil {
+		return ""
+	}
+	return i.projectList.Find(req.Project).FindPhase(req.Phase).ArgoCDApp
 }
 
-func (i InteractorGitOps) Reject(params string, userID string) ([]slack.Block, error) {
-	p := strings.Split(params, "_")
-
-	if strings.HasPrefix(params, "PR") {
-		// The format of IDs for GitHub PullReques has changed
-		// e.g. PR_hogehoge as a whole needs to be passed as the ID,
-		// instead of "PR" or "hogehoge" only.
-		//
-		// Let's say you have a pull request with the ID "PR_hogehoge", you'll see the ActionValue like:
-		//
-		// 	deploy_kustomize_reject|PR_hogehoge_2_bot/docker-image-tag-project-foo-staging-14e308b
-		//
-		// If we used the "PR" as the PR ID, we'll see the following error:
-		//
-		// 	Could not resolve to a node with the global id of 'PR'
-		// 	[ERROR] Internal Server Error
-		//
-		// Similarly, if you used "hogehoge" as the PR ID, you'll see the following error:
-		//
-		// 	Could not resolve to a node with the global id of 'hogehoge'
-		// 	[ERROR] Internal Server Error
-		//
-		// See https://docs.github.com/en/graphql/guides/migrating-graphql-global-node-ids#determining-if-you-need-to-take-action
-		var a []string
-		a = append(a, p[0]+"_"+p[1])
-		a = append(a, p[2:]...)
-		p = a
-	}
-
-	return i.reject(p[0], p[1], p[2], userID)
-}
-
-func (i InteractorGitOps) reject(prID string, prNum string, branch string, userID string) (blocks []slack.Block, err error) {
-	if err = i.github.ClosePullRequest(prID); err != nil {
+// trackArgoCDSync follows the sync of the ArgoCD application after the merge in the thread.
+// The progress is reported in the language of the channel in the same way as trackRollout.
+func (i InteractorGitOps) trackArgoCDSync(ctx context.Context, thread *deployThread, base []slack.Block, app string, before string, after string) {
+	link := fmt.Sprintf("<%s|%s>", i.argocd.ApplicationURL(app), app)
+	lang := i.lang("", thread.channel)
+
+	i.followInThread(thread, base, lang.Sprintf(i18n.WaitingForSync, link), func(progress func(string)) string {
+		status, err := i.argocd.Watch(ctx, app, before, after, func(status ArgoCDApplicationStatus) {
+			progress(status.String())
+		})
+
+		switch {
+		case errors.Is(err, ErrUnknownRevision):
+			return lang.Sprintf(i18n.SyncUnknown, link)
+		case err != nil:
+			log.Print(err)
+			return lang.Sprintf(i18n.SyncTimedOut, link, i.argocd.Timeout, status)
+		case status.Healthy():
+			return lang.Sprintf(i18n.SyncHealthy, link, status.Health)
+		case status.Health == ArgoCDHealthStatusDegraded:
+			return lang.Sprintf(i18n.SyncDegraded, link, status.Health, status)
+		default:
+			return lang.Sprintf(i18n.SyncFailed, link, status)
+		}
+	})
+}
+
+// recordDeployEvent records the merge of the pull request for the pending request as a deploy event.
+func (i InteractorGitOps) recordDeployEvent(req deploy.PendingRequest, prURL string, userID string, took time.Duration, err error) {
+	i.recordEvent(userID, deploy.Event{
+		Project:        req.Project,
+		Phase:          req.Phase,
+		Action:         deploy.EventActionDeploy,
+		Tag:            i.pendingRequestTag(req),
+		Branch:         req.Branch,
+		PullRequestURL: prURL,
+		Result:         eventResult(err),
+		Message:        errString(err),
+		Duration:       metav1.Duration{Duration: took},
+	})
+}
+
+// pendingRequestTag returns the tag to be deployed by the pending request.
+// The tag resolved from the branch on Prepare is only known from the head branch of the pull request.
+func (i InteractorGitOps) pendingRequestTag(req deploy.PendingRequest) string {
+	if req.Tag != "" {
+		return req.Tag
+	}
+	_, _, tag, _ := i.projectList.FindByDeployBranch(req.PullRequestBranch)
+	return tag
+}
+
+func (i InteractorGitOps) Reject(params string, userID string) (blocks []slack.Block, err error) {
+	req, err := i.pendingRequest(params)
+	if err != nil {
 		return
 	}
-	if err = i.github.DeleteBranch(branch); err != nil {
+
+	prURL := i.pullRequestURL(req.PullRequestNumber)
+
+	defer func() {
+		i.recordEvent(userID, deploy.Event{
+			Project:        req.Project,
+			Phase:          req.Phase,
+			Action:         deploy.EventActionReject,
+			Tag:            i.pendingRequestTag(req),
+			PullRequestURL: prURL,
+			Result:         eventResult(err),
+			Message:        errString(err),
+		})
+	}()
+
+	if err = i.github.ClosePullRequest(req.PullRequestID); err != nil {
 		return
 	}
+	if err = i.github.DeleteBranch(req.PullRequestBranch); err != nil {
+		return
+	}
+	i.deletePendingRequest(req.ID)
 
-	blockObject := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("closed https://github.com/%s/%s/pull/%s\nby <@%s>", i.github.org, i.github.repo, prNum, userID), false, false)
-	blocks = append(blocks, slack.NewSectionBlock(blockObject, nil, nil))
-	return
+	lang := i.lang(userID, req.Channel)
+	thread := i.requestThread(req, req.Channel)
+	if !thread.started() {
+		blockObject := slack.NewTextBlockObject("mrkdwn", lang.Sprintf(i18n.PullRequestClosed, prURL, userID), false, false)
+		blocks = append(blocks, slack.NewSectionBlock(blockObject, nil, nil))
+		return
+	}
+
+	var repo string
+	if i.projectList != nil {
+		repo = i.projectList.Find(req.Project).GitHubRepository()
+	}
+	i.updateState(thread, i.stateBlocks(repo, req.Phase, req.Requester, confirmDeploy(lang, req.Branch, req.Tag), lang.Sprintf(i18n.PullRequestClosed, prURL, userID)))
+	return nil, nil
 }
@@ -2,15 +2,17 @@ package main
 
 import (
 	"fmt"
-	"log"
-	"strings"
+	"time"
 
 	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type InteractorLambda struct {
 	InteractorContext
-	model ModelLambda
+	model DeployModel
 }
 
 func NewInteractorLambda(i InteractorContext) (o InteractorLambda) {
@@ -20,71 +22,129 @@ func NewInteractorLambda(i InteractorContext) (o InteractorLambda) {
 }
 
 func (self InteractorLambda) Request(pj DeployProject, phase string, branch string, assigner string, channel string) ([]slack.Block, error) {
-	txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*\n*%s*\n*%s* ブランチをデプロイしますか?", pj.ID, phase, branch), false, false)
-	btnTxt := slack.NewTextBlockObject("plain_text", "Deploy", false, false)
-	btn := slack.NewButtonBlockElement("", fmt.Sprintf("%s|%s_%s_%s", self.actionHeader("approve"), pj.ID, phase, branch), btnTxt)
-	section := slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn))
-	return []slack.Block{section, CloseButton()}, nil
+	return self.request(pj, phase, branch, "", assigner, channel)
+}
+
+// RequestTag asks for the approval to invoke the function with the tag instead of the one resolved from the branch.
+func (self InteractorLambda) RequestTag(pj DeployProject, phase string, tag string, assigner string, channel string) ([]slack.Block, error) {
+	return self.request(pj, phase, "", tag, assigner, channel)
+}
+
+// request posts the question with the approve button as the root message of a new thread in the channel,
+// so it returns no blocks to reply with.
+func (self InteractorLambda) request(pj DeployProject, phase string, branch string, tag string, assigner string, channel string) ([]slack.Block, error) {
+	if err := self.guard.Check(pj.ID, phase, assigner); err != nil {
+		return nil, err
+	}
+
+	lang := self.lang(assigner, channel)
+	txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s*\n*%s*\n%s", pj.ID, phase, confirmDeploy(lang, branch, tag)), false, false)
+	return nil, self.postRequest(lang, txt, deploy.PendingRequest{Project: pj.ID, Phase: phase, Branch: branch, Tag: tag, Requester: assigner, Channel: channel})
 }
 
 func (self InteractorLambda) Approve(params string, userID string, channel string) ([]slack.Block, error) {
-	p := strings.Split(params, "_")
-	return self.approve(p[0], p[1], p[2], userID, channel)
+	return self.approvePendingRequest(params, userID, func(req deploy.PendingRequest) ([]slack.Block, error) {
+		pj := self.projectList.Find(req.Project)
+		return self.deploy(pj, req.Phase, DeployOption{Branch: req.Branch, Tag: req.Tag}, requestAction(req), userID, self.requestThread(req, channel))
+	})
+}
+
+// Rollback deploys the tag that was running before the current one, or the specified tag.
+// It's deployed right away unless the phase has the approval policy, in which case the approval is asked for instead.
+func (self InteractorLambda) Rollback(pj DeployProject, phase string, tag string, assigner string, channel string) ([]slack.Block, error) {
+	if tag == "" {
+		var err error
+		tag, err = self.rollbackTag(pj, pj.FindPhase(phase))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if pj.FindPhase(phase).Approval != nil {
+		return self.requestRollback(pj, phase, tag, assigner, channel)
+	}
+	return self.deploy(pj, phase, DeployOption{Tag: tag}, deploy.EventActionRollback, assigner, newDeployThread(self.client, channel, ""))
 }
 
-func (self InteractorLambda) approve(target string, phase string, branch string, userID string, channel string) (blocks []slack.Block, err error) {
-	pj := self.projectList.Find(target)
+// deploy invokes the function and follows it in the thread.
+// The root message of the thread is updated with the result, and the details are replied in the thread,
+// so it returns no blocks to reply with.
+func (self InteractorLambda) deploy(pj DeployProject, phase string, option DeployOption, action deploy.EventAction, userID string, thread *deployThread) (blocks []slack.Block, err error) {
+	if err = self.guard.Check(pj.ID, phase, userID); err != nil {
+		return
+	}
+
+	branch := option.Branch
+	lang := self.lang(userID, thread.channel)
+	base := self.plainBlocks(lang.Text(i18n.NowDeploying), lang.Sprintf(i18n.ActionedBy, userID))
+	if err = thread.update(slack.MsgOptionBlocks(base...)); err != nil {
+		return nil, err
+	}
 
 	go func() {
-		res, err := self.model.Deploy(pj, phase, DeployOption{Branch: branch})
-		if err != nil || res.Status() == DeployStatusFail {
+		start := time.Now()
+		res, err := self.model.Deploy(pj, phase, option)
+		if err == nil && res.Status() == DeployStatusFail {
+			err = fmt.Errorf("lambda responded: %s", res.Message())
+		}
+		self.recordEvent(userID, deploy.Event{
+			Project:  pj.ID,
+			Phase:    phase,
+			Action:   action,
+			Tag:      option.Tag,
+			Branch:   branch,
+			Result:   eventResult(err),
+			Message:  errString(err),
+			Duration: metav1.Duration{Duration: time.Since(start)},
+		})
+		if err != nil {
 			fields := []slack.AttachmentField{
 				{Title: "user", Value: "<@" + userID + ">"},
 				{Title: "error", Value: err.Error()},
 			}
-			msg := slack.Attachment{Color: "#e01e5a", Title: fmt.Sprintf("Failed to deploy %s %s", pj.ID, phase), Fields: fields}
-			if _, _, err := self.client.PostMessage(channel, slack.MsgOptionAttachments(msg)); err != nil {
-				log.Printf("Failed to post message: %s", err.Error())
-			}
+			self.reportResult(thread, base, slack.Attachment{Color: "#e01e5a", Title: lang.Sprintf(i18n.DeployFailed, pj.ID, phase), Fields: fields})
 			return
 		}
 
-		msg := slack.Attachment{Color: "#36a64f", Title: fmt.Sprintf("Succeed to deploy %s %s", pj.ID, phase)}
+		msg := slack.Attachment{Color: "#36a64f", Title: lang.Sprintf(i18n.DeploySucceeded, pj.ID, phase)}
 		msg.Fields = []slack.AttachmentField{
 			{Title: "user", Value: "<@" + userID + ">"},
 			{Title: "phase", Value: phase},
-			{Title: "branch", Value: branch},
+		}
+		if branch != "" {
+			msg.Fields = append(msg.Fields, slack.AttachmentField{Title: "branch", Value: branch})
+		}
+		if option.Tag != "" {
+			msg.Fields = append(msg.Fields, slack.AttachmentField{Title: "tag", Value: option.Tag})
 		}
 		if res.Message() != "" {
 			msg.Fields = append(msg.Fields, slack.AttachmentField{Title: "response", Value: res.Message()})
 		}
-		if _, _, err := self.client.PostMessage(channel, slack.MsgOptionAttachments(msg)); err != nil {
-			log.Printf("Failed to post message: %s", err.Error())
-		}
+		self.reportResult(thread, base, msg)
 	}()
 
-	blocks = self.plainBlocks("Now deploying ...")
-	userObject := slack.NewTextBlockObject("mrkdwn", "by <@"+userID+">", false, false)
-	blocks = append(blocks, slack.NewSectionBlock(userObject, nil, nil))
-	return
+	return nil, nil
 }
 
 func (self InteractorLambda) Reject(params string, userID string) (blocks []slack.Block, err error) {
-	return
+	return self.rejectPendingRequest(params, userID)
 }
 
-func (self InteractorLambda) BranchList(pj DeployProject, phase string) ([]slack.Block, error) {
-	return self.branchList(pj, phase)
+func (self InteractorLambda) BranchList(pj DeployProject, phase string, userID string, channel string) ([]slack.Block, error) {
+	return self.branchList(pj, phase, userID, channel)
 }
 
-func (self InteractorLambda) BranchListFromRaw(params string) (blocks []slack.Block, err error) {
-	p := strings.Split(params, "_")
-	pj := self.projectList.Find(p[0])
-	return self.branchList(pj, p[1])
+func (self InteractorLambda) BranchListFromRaw(params string, userID string, channel string) (blocks []slack.Block, err error) {
+	pj, phase, err := self.findActionTarget(params)
+	if err != nil {
+		return nil, err
+	}
+	return self.branchList(pj, phase, userID, channel)
 }
 
 func (self InteractorLambda) SelectBranch(params string, branch string, userID string, channel string) ([]slack.Block, error) {
-	p := strings.Split(params, "_")
-	pj := self.projectList.Find(p[0])
-	return self.Request(pj, p[1], branch, userID, channel)
+	pj, phase, err := self.findActionTarget(params)
+	if err != nil {
+		return nil, err
+	}
+	return self.Request(pj, phase, branch, userID, channel)
 }
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+)
+
+// fakeGitOpsPlugin is a GitOpsPlugin that returns the pull request without creating it.
+type fakeGitOpsPlugin struct{}
+
+func (fakeGitOpsPlugin) Prepare(pj DeployProject, phase string, branch string, user User, message string) (GitOpsPrepareOutput, error) {
+	return GitOpsPrepareOutput{
+		PullRequestID:      "PR_1",
+		PullRequestNumber:  1,
+		PullRequestHTMLURL: "https://github.com/org/manifests/pull/1",
+		Branch:             "bot/docker-image-tag-api-staging-v1",
+	}, nil
+}
+
+// fakeDeployOutput is the successful output of fakeDeployModel.
+type fakeDeployOutput struct{}
+
+func (fakeDeployOutput) Status() DeployStatus { return DeployStatusSuccess }
+func (fakeDeployOutput) Message() string      { return "" }
+
+// fakeJobModel is a jobModel whose job completes right away.
+type fakeJobModel struct {
+	fakeDeployModel
+}
+
+func (fakeJobModel) Watch(name, namespace string) error {
+	return nil
+}
+
+// deployUsecaseStep is what is expected from a step of a deployment.
+type deployUsecaseStep struct {
+	// blocks are the texts of the sections returned to reply with.
+	blocks []string
+	// calls are the methods of the Slack API called by the step, including the ones made asynchronously.
+	calls []string
+	// text is contained in the messages posted or updated by the step.
+	text string
+}
+
+func TestDeployUsecase(t *testing.T) {
+	en := i18n.English
+	question := confirmDeploy(en, "main", "")
+	closed := en.Sprintf(i18n.RequestClosed, "api", "staging", "U1")
+	prURL := "https://github.com/org/manifests/pull/1"
+
+	// ghts serves the GitHub GraphQL API to get, merge and close the pull request.
+	ghts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "pullRequest(number") {
+			_, _ = w.Write([]byte(`{"data": {"repository": {"pullRequest": {"body": "commit log"}}}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": {}}`))
+	}))
+	defer ghts.Close()
+
+	// jenkins accepts the builds.
+	jenkins := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer jenkins.Close()
+	jenkinsHost := strings.TrimPrefix(jenkins.URL, "https://")
+
+	gitops := func(kind string) func(InteractorContext) DeployUsecase {
+		return func(c InteractorContext) DeployUsecase {
+			i := InteractorGitOps{InteractorContext: c, model: fakeGitOpsPlugin{}}
+			i.kind = kind
+			return i
+		}
+	}
+	gitopsSteps := []deployUsecaseStep{
+		{calls: []string{"chat.postMessage", "chat.update"}, text: prURL},
+		{calls: []string{"chat.update", "chat.update", "chat.postMessage"}, text: en.Sprintf(i18n.PullRequestMerged, prURL, "U1")},
+		{calls: []string{"chat.update"}, text: en.Sprintf(i18n.PullRequestClosed, prURL, "U1")},
+	}
+
+	// threadRequest, threadDeploy and threadReject are the steps of the deployments reported in the threads of their requests.
+	threadRequest := deployUsecaseStep{calls: []string{"chat.postMessage", "chat.update"}, text: "*api*\n*staging*\n" + question}
+	threadDeploy := deployUsecaseStep{calls: []string{"chat.update", "chat.update", "chat.postMessage"}, text: en.Sprintf(i18n.DeploySucceeded, "api", "staging")}
+	threadReject := deployUsecaseStep{calls: []string{"chat.update"}, text: closed}
+
+	testcases := []struct {
+		kind       string
+		newUsecase func(InteractorContext) DeployUsecase
+		// request, approve and reject are the expectations of the request approved,
+		// and the one rejected after it.
+		request deployUsecaseStep
+		approve deployUsecaseStep
+		reject  deployUsecaseStep
+	}{
+		{
+			kind:       "kustomize",
+			newUsecase: gitops("kustomize"),
+			request:    gitopsSteps[0],
+			approve:    gitopsSteps[1],
+			reject:     gitopsSteps[2],
+		},
+		{
+			kind:       "kanvas",
+			newUsecase: gitops("kanvas"),
+			request:    gitopsSteps[0],
+			approve:    gitopsSteps[1],
+			reject:     gitopsSteps[2],
+		},
+		{
+			kind: "jenkins",
+			newUsecase: func(c InteractorContext) DeployUsecase {
+				i := NewInteractorJenkins(c)
+				i.httpClient = jenkins.Client()
+				return i
+			},
+			request: threadRequest,
+			approve: deployUsecaseStep{calls: []string{"chat.update"}, text: en.Sprintf(i18n.JenkinsJobExecuted, jenkinsHost, "api-deploy", "main")},
+			reject:  threadReject,
+		},
+		{
+			kind: "job",
+			newUsecase: func(c InteractorContext) DeployUsecase {
+				i := NewInteractorJob(c)
+				i.model = fakeJobModel{fakeDeployModel{output: ModelJobDeployOutput{Name: "api-migrate", Namespace: "default"}}}
+				return i
+			},
+			request: deployUsecaseStep{calls: []string{"chat.postMessage", "chat.update"}, text: "*jobs/api*\n*staging*\n" + question},
+			approve: deployUsecaseStep{calls: []string{"chat.update", "chat.update", "chat.postMessage"}, text: en.Sprintf(i18n.JobSucceeded, "api-migrate")},
+			reject:  threadReject,
+		},
+		{
+			kind: "lambda",
+			newUsecase: func(c InteractorContext) DeployUsecase {
+				i := NewInteractorLambda(c)
+				i.model = fakeDeployModel{output: fakeDeployOutput{}}
+				return i
+			},
+			request: threadRequest,
+			approve: threadDeploy,
+			reject:  threadReject,
+		},
+		{
+			kind: "combine",
+			newUsecase: func(c InteractorContext) DeployUsecase {
+				i := NewInteractorCombine(c)
+				i.model = fakeDeployModel{output: fakeDeployOutput{}}
+				return i
+			},
+			request: threadRequest,
+			approve: threadDeploy,
+			reject:  threadReject,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.kind, func(t *testing.T) {
+			pj := DeployProject{
+				ID:               "api",
+				Alias:            "^api$",
+				Kind:             tc.kind,
+				gitHubRepository: "api",
+				defaultBranch:    "main",
+				jenkinsJob:       "api-deploy",
+				Phases:           []DeployPhase{{Name: "staging", Kind: tc.kind, Path: "jobs/api"}},
+			}
+			client := &fakeSlackClient{}
+			store := deploy.NewMemoryPendingRequestStore(deploy.DefaultPendingRequestTTL)
+			usecase := tc.newUsecase(InteractorContext{
+				projectList: &ProjectList{Items: []DeployProject{pj}},
+				userList:    &UserList{Items: []User{{SlackUserID: "U1", SlackDisplayName: "user1", isDeveloper: true}}},
+				github:      CreateGitHubInstance(ghts.URL+"/graphql", "token", "org", "manifests", "main", &CatConfig{}),
+				client:      client,
+				config:      CatConfig{JenkinsHost: jenkinsHost, JenkinsBotToken: "bot", JenkinsJobToken: "job"},
+				pending:     store,
+				languages:   NewLanguageList(en),
+			})
+
+			// step runs the step of the deployment, and checks the blocks returned and the Slack API called by it.
+			step := func(want deployUsecaseStep, run func() ([]slack.Block, error)) {
+				t.Helper()
+				before := len(client.Calls())
+				blocks, err := run()
+				require.NoError(t, err)
+				require.Equal(t, want.blocks, blockTexts(blocks))
+
+				var calls []fakeSlackCall
+				require.Eventually(t, func() bool {
+					calls = client.Calls()[before:]
+					return len(calls) >= len(want.calls)
+				}, time.Second, 10*time.Millisecond)
+				// The asynchronous calls not expected are waited for a moment to be caught.
+				time.Sleep(10 * time.Millisecond)
+				calls = client.Calls()[before:]
+
+				var methods, texts []string
+				for _, c := range calls {
+					methods = append(methods, c.method)
+					texts = append(texts, c.texts...)
+				}
+				require.Equal(t, want.calls, methods)
+				require.Contains(t, strings.Join(texts, "\n"), want.text)
+			}
+			pendingID := func() string {
+				reqs, err := store.List(context.Background())
+				require.NoError(t, err)
+				require.Len(t, reqs, 1)
+				require.Equal(t, "C1", reqs[0].Channel)
+				return reqs[0].ID
+			}
+
+			step(tc.request, func() ([]slack.Block, error) { return usecase.Request(pj, "staging", "main", "U1", "C1") })
+			id := pendingID()
+			step(tc.approve, func() ([]slack.Block, error) { return usecase.Approve(id, "U1", "C1") })
+
+			// The approved request can't be approved again.
+			_, err := usecase.Approve(id, "U1", "C1")
+			require.ErrorIs(t, err, deploy.ErrStaleRequest)
+
+			step(tc.request, func() ([]slack.Block, error) { return usecase.Request(pj, "staging", "main", "U1", "C1") })
+			id = pendingID()
+			// The close button of the request rejects it, instead of just closing the message.
+			calls := client.Calls()
+			require.Contains(t, calls[len(calls)-1].buttons, "deploy_"+tc.kind+"_reject|"+id)
+			step(tc.reject, func() ([]slack.Block, error) { return usecase.Reject(id, "U1") })
+
+			// The rejected request can't be approved anymore.
+			_, err = usecase.Approve(id, "U1", "C1")
+			require.ErrorIs(t, err, deploy.ErrStaleRequest)
+		})
+	}
+}
+
+// deployedModel is a jobModel that sends the options it's deployed with to the channel.
+type deployedModel struct {
+	fakeJobModel
+	deployed chan DeployOption
+}
+
+func (m deployedModel) Deploy(pj DeployProject, phase string, option DeployOption) (DeployOutput, error) {
+	m.deployed <- option
+	return m.fakeJobModel.Deploy(pj, phase, option)
+}
+
+func TestRollbackApproval(t *testing.T) {
+	testcases := []struct {
+		kind       string
+		newUsecase func(InteractorContext, deployedModel) RollbackUsecase
+		output     DeployOutput
+	}{
+		{
+			kind: "lambda",
+			newUsecase: func(c InteractorContext, m deployedModel) RollbackUsecase {
+				i := NewInteractorLambda(c)
+				i.model = m
+				return i
+			},
+			output: fakeDeployOutput{},
+		},
+		{
+			kind: "job",
+			newUsecase: func(c InteractorContext, m deployedModel) RollbackUsecase {
+				i := NewInteractorJob(c)
+				i.model = m
+				return i
+			},
+			output: ModelJobDeployOutput{Name: "api-migrate", Namespace: "default"},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.kind, func(t *testing.T) {
+			pj := DeployProject{
+				ID:     "api",
+				Kind:   tc.kind,
+				Phases: []DeployPhase{{Name: "production", Kind: tc.kind, Approval: &ApprovalPolicy{RequiredApprovals: 1}}},
+			}
+			store := deploy.NewMemoryPendingRequestStore(deploy.DefaultPendingRequestTTL)
+			history := deploy.NewFileHistory(filepath.Join(t.TempDir(), "history.jsonl"))
+			model := deployedModel{fakeJobModel{fakeDeployModel{output: tc.output}}, make(chan DeployOption, 1)}
+			usecase := tc.newUsecase(InteractorContext{
+				projectList: &ProjectList{Items: []DeployProject{pj}},
+				userList: &UserList{Items: []User{
+					{SlackUserID: "U1", SlackDisplayName: "user1", isDeveloper: true},
+					{SlackUserID: "U2", SlackDisplayName: "user2", isDeveloper: true},
+				}},
+				client:    &fakeSlackClient{},
+				history:   history,
+				pending:   store,
+				languages: NewLanguageList(i18n.English),
+			}, model)
+
+			// The rollback asks for the approval instead of deploying right away.
+			blocks, err := usecase.Rollback(pj, "production", "v1", "U1", "C1")
+			require.NoError(t, err)
+			require.Empty(t, blocks)
+			require.Empty(t, model.deployed)
+			reqs, err := store.List(context.Background())
+			require.NoError(t, err)
+			require.Len(t, reqs, 1)
+			require.Equal(t, deploy.EventActionRollback, reqs[0].Action)
+
+			// The requester can't approve it by themselves.
+			approver := usecase.(DeployUsecase)
+			blocks, err = approver.Approve(reqs[0].ID, "U1", "C1")
+			require.NoError(t, err)
+			require.Contains(t, strings.Join(blockTexts(blocks), "\n"), i18n.English.Sprintf(i18n.CannotApproveOwnRequest, "U1"))
+			require.Empty(t, model.deployed)
+
+			_, err = approver.Approve(reqs[0].ID, "U2", "C1")
+			require.NoError(t, err)
+			select {
+			case option := <-model.deployed:
+				require.Equal(t, DeployOption{Tag: "v1"}, option)
+			case <-time.After(time.Second):
+				t.Fatal("the rollback was not deployed on approval")
+			}
+
+			require.Eventually(t, func() bool {
+				events, err := history.List(context.Background(), "api", "production", 0)
+				return err == nil && len(events) == 1 && events[0].Action == deploy.EventActionRollback
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+}
@@ -8,8 +8,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func createJob(job *batchv1.Job) (err error) {
-	client, err := newKubernetesClient()
+func (self ModelJob) createJob(job *batchv1.Job) (err error) {
+	client, err := self.kubernetes.ClientSet()
 	if err != nil {
 		log.Print(err)
 		return
@@ -24,8 +24,8 @@ func createJob(job *batchv1.Job) (err error) {
 	return
 }
 
-func getJob(jobName string, ns string) (job *batchv1.Job, err error) {
-	client, err := newKubernetesClient()
+func (self ModelJob) getJob(jobName string, ns string) (job *batchv1.Job, err error) {
+	client, err := self.kubernetes.ClientSet()
 	if err != nil {
 		log.Print(err)
 		return
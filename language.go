@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+	yaml "gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+)
+
+// LanguageList chooses the language of the messages to Slack users and channels.
+//
+// The default language comes from the config, and is overridden by the configmaps labeled
+// `gocat.zaim.net/configmap-type: language` like:
+//
+//	data:
+//	  Users: |
+//	    user1: en
+//	  Channels: |
+//	    C0123456789: ja
+//
+// where the users are identified by their Slack display names as in the rolebinding configmaps,
+// and the channels by their IDs.
+type LanguageList struct {
+	i18n.Settings
+
+	// source provides the ConfigMaps of the languages on Reload.
+	source ConfigSource
+}
+
+func NewLanguageList(defaultLang i18n.Lang) *LanguageList {
+	return &LanguageList{Settings: i18n.Settings{Default: defaultLang}}
+}
+
+// Reload reloads the languages of the users and the channels from the config source.
+// They are kept as they are if the config source fails, or if the list has no config source.
+func (l *LanguageList) Reload() error {
+	if l == nil || l.source == nil {
+		return nil
+	}
+
+	cml, err := l.source.ConfigMaps(context.Background(), "language")
+	if err != nil {
+		return err
+	}
+	l.Settings = createLanguageSettings(l.Default, cml)
+	return nil
+}
+
+// Lang returns the language of the user identified by the display name in the channel.
+// The default language is returned if the list is nil, so that the callers need not care whether it's configured.
+func (l *LanguageList) Lang(user string, channel string) i18n.Lang {
+	if l == nil {
+		return i18n.DefaultLang
+	}
+	return l.Settings.Lang(user, channel)
+}
+
+func createLanguageSettings(defaultLang i18n.Lang, cml *v1.ConfigMapList) i18n.Settings {
+	s := i18n.Settings{Default: defaultLang, Users: map[string]i18n.Lang{}, Channels: map[string]i18n.Lang{}}
+	if cml == nil {
+		return s
+	}
+
+	for _, cm := range cml.Items {
+		parseLanguages(cm.Name, "Users", cm.Data["Users"], s.Users)
+		parseLanguages(cm.Name, "Channels", cm.Data["Channels"], s.Channels)
+	}
+	return s
+}
+
+// parseLanguages parses the YAML map from the names to the language codes into langs.
+// The invalid entries are logged and ignored so that a typo doesn't break the others.
+func parseLanguages(configMap string, key string, raw string, langs map[string]i18n.Lang) {
+	var codes map[string]string
+	if err := yaml.Unmarshal([]byte(raw), &codes); err != nil {
+		log.Printf("[ERROR] Unable to parse %s of the language configmap %s: %s", key, configMap, err)
+		return
+	}
+
+	for name, code := range codes {
+		l, err := i18n.ParseLang(code)
+		if err != nil {
+			log.Printf("[ERROR] Invalid language of %s in the language configmap %s: %s", name, configMap, err)
+			continue
+		}
+		langs[name] = l
+	}
+}
+
+// errorText returns the message of the error shown to Slack users in the language.
+// The errors the users can act on, like locks and deploy schedules, are translated,
+// and the others are shown as they are.
+func errorText(lang i18n.Lang, err error) string {
+	var (
+		lockedErr   deploy.LockedError
+		scheduleErr deploy.ScheduleError
+		rollbackErr rollbackTagNotFoundError
+	)
+	switch {
+	case errors.As(err, &lockedErr):
+		return lang.Sprintf(i18n.DeployLocked, lockedErr.User)
+	case errors.As(err, &scheduleErr):
+		if scheduleErr.Invalid != nil {
+			return lang.Sprintf(i18n.DeployInvalidSchedule, scheduleErr.Project, scheduleErr.Environment, scheduleErr.Invalid)
+		}
+		if f := scheduleErr.Freeze; f != nil {
+			until := f.To.Local().Format("2006-01-02 15:04")
+			if f.Reason != "" {
+				return lang.Sprintf(i18n.DeployFrozenFor, scheduleErr.Project, scheduleErr.Environment, until, f.Reason)
+			}
+			return lang.Sprintf(i18n.DeployFrozen, scheduleErr.Project, scheduleErr.Environment, until)
+		}
+		var windows []string
+		for _, w := range scheduleErr.DeployWindows {
+			windows = append(windows, w.String())
+		}
+		return lang.Sprintf(i18n.DeployOutsideWindows, scheduleErr.Project, scheduleErr.Environment, strings.Join(windows, ", "))
+	case errors.Is(err, deploy.ErrStaleRequest):
+		return lang.Text(i18n.StaleRequest)
+	case errors.Is(err, deploy.ErrRequestInProgress):
+		return lang.Text(i18n.RequestInProgress)
+	case errors.As(err, &rollbackErr):
+		return lang.Sprintf(i18n.RollbackTagNotFound, rollbackErr.project, rollbackErr.phase, rollbackErr.project, rollbackErr.phase)
+	default:
+		return err.Error()
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCreateLanguageSettings(t *testing.T) {
+	cml := &v1.ConfigMapList{
+		Items: []v1.ConfigMap{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "language1",
+					Labels: map[string]string{"gocat.zaim.net/configmap-type": "language"},
+				},
+				Data: map[string]string{
+					"Users":    "user1: en\nuser2: fr\n",
+					"Channels": "C1: en\n",
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "language2",
+					Labels: map[string]string{"gocat.zaim.net/configmap-type": "language"},
+				},
+				Data: map[string]string{
+					"Users":    "user3: ja",
+					"Channels": "invalid",
+				},
+			},
+		},
+	}
+
+	s := createLanguageSettings(i18n.Japanese, cml)
+	require.Equal(t, i18n.Settings{
+		Default:  i18n.Japanese,
+		Users:    map[string]i18n.Lang{"user1": i18n.English, "user3": i18n.Japanese},
+		Channels: map[string]i18n.Lang{"C1": i18n.English},
+	}, s)
+
+	l := &LanguageList{Settings: s}
+	require.Equal(t, i18n.English, l.Lang("user1", "C2"))
+	require.Equal(t, i18n.English, l.Lang("user2", "C1"))
+	require.Equal(t, i18n.Japanese, l.Lang("user3", "C1"))
+	require.Equal(t, i18n.Japanese, l.Lang("user2", "C2"))
+
+	var nilList *LanguageList
+	require.Equal(t, i18n.DefaultLang, nilList.Lang("user1", "C1"))
+}
+
+func TestLanguageListReload(t *testing.T) {
+	source := &fakeConfigSource{configMaps: map[string][]v1.ConfigMap{
+		"language": {{ObjectMeta: metav1.ObjectMeta{Name: "language"}, Data: map[string]string{"Users": "user1: en"}}},
+	}}
+	l := NewLanguageList(i18n.Japanese)
+	l.source = source
+	require.NoError(t, l.Reload())
+	require.Equal(t, i18n.English, l.Lang("user1", "C1"))
+
+	// The languages loaded before are kept if the config source fails.
+	source.err = errors.New("unavailable")
+	require.EqualError(t, l.Reload(), "unavailable")
+	require.Equal(t, i18n.English, l.Lang("user1", "C1"))
+
+	var nilList *LanguageList
+	require.NoError(t, nilList.Reload())
+}
+
+func TestErrorText(t *testing.T) {
+	locked := fmt.Errorf("wrapped: %w", deploy.LockedError{Project: "api", Environment: "staging", User: "user1"})
+	require.Equal(t, "Deployment failed: locked by user1", errorText(i18n.English, locked))
+	require.Equal(t, "デプロイできません: user1 がロックしています", errorText(i18n.Japanese, locked))
+
+	outside := deploy.ScheduleError{Project: "api", Environment: "production", DeployWindows: []deploy.DeployWindow{{Cron: "* 10-17 * * Mon-Thu"}}}
+	require.Equal(t, "Deployment failed: api production is outside the deploy windows `* 10-17 * * Mon-Thu`", errorText(i18n.English, outside))
+	require.Equal(t, outside.Error(), errorText(i18n.English, outside))
+
+	invalid := deploy.ScheduleError{Project: "api", Environment: "production", Invalid: errors.New("the policy freeze is invalid")}
+	require.Equal(t, invalid.Error(), errorText(i18n.English, invalid))
+
+	require.Equal(t, deploy.ErrStaleRequest.Error(), errorText(i18n.English, deploy.ErrStaleRequest))
+	require.Equal(t, "このデプロイリクエストはすでに処理中です", errorText(i18n.Japanese, deploy.ErrRequestInProgress))
+	require.Equal(t, "api production の前のタグが見つかりません。`rollback api production to <tag>` でタグを指定してください", errorText(i18n.Japanese, rollbackTagNotFoundError{project: "api", phase: "production"}))
+
+	// The other errors are shown as they are.
+	require.Equal(t, "unavailable", errorText(i18n.Japanese, errors.New("unavailable")))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+)
+
+// LockReaper periodically releases expired deployment locks,
+// and notifies the channels in which the locks were taken.
+type LockReaper struct {
+	client      SlackClient
+	coordinator *deploy.Coordinator
+	languages   *LanguageList
+}
+
+func NewLockReaper(client SlackClient, coordinator *deploy.Coordinator, languages *LanguageList) LockReaper {
+	return LockReaper{client, coordinator, languages}
+}
+
+func (r LockReaper) Watch(sec int64) {
+	log.Printf("[INFO] LockReaper is started. Interval is %d seconds.", sec)
+	go func() {
+		// We don't stop the ticker as this is a long-running process
+		// with no way to cancel it.
+		t := time.NewTicker(time.Duration(sec) * time.Second)
+		for range t.C {
+			r.reap()
+		}
+	}()
+}
+
+func (r LockReaper) reap() {
+	expired, err := r.coordinator.ReapExpiredLocks(context.Background())
+	if err != nil {
+		log.Printf("[ERROR] Failed to release expired locks: %s", err)
+		return
+	}
+
+	for _, l := range expired {
+		log.Printf("[INFO] The lock of %s %s by %s has expired", l.Project, l.Environment, l.Lock.User)
+
+		if l.Lock.Channel == "" {
+			continue
+		}
+
+		msg := r.languages.Lang("", l.Lock.Channel).Sprintf(i18n.LockExpired, l.Project, l.Environment, l.Lock.User, l.Lock.Reason)
+		block := slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", msg, false, false), nil, nil)
+		if _, _, err := r.client.PostMessage(l.Lock.Channel, slack.MsgOptionBlocks(block)); err != nil {
+			log.Printf("[ERROR] Failed to post message: %s", err)
+		}
+	}
+}
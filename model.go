@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+
+	"github.com/zaiminc/gocat/deploy"
 )
 
 // DeployModel, or more simply, a deploy model, is a model that can be deployed.
@@ -48,22 +50,22 @@ type DeployOutput interface {
 // See respective NewDeployModelList* functions for more details.
 type DeployModelList map[string]DeployModel
 
-func NewDeployModelList(github *GitHub, git *GitOperator, projectList *ProjectList) *DeployModelList {
+func NewDeployModelList(github *GitHub, git *GitOperator, projectList *ProjectList, k *deploy.Kubernetes) *DeployModelList {
 	return &DeployModelList{
 		"lambda":    NewModelLambda(),
-		"kustomize": NewModelKustomize(github, git),
+		"kustomize": NewModelKustomize(github, git, k),
 		"kanvas":    NewModelKanvas(github, git),
-		"combine":   NewModelCombine(github, git, projectList),
-		"job":       NewModelJob(github),
+		"combine":   NewModelCombine(github, git, projectList, k),
+		"job":       NewModelJob(github, k),
 	}
 }
 
-func NewDeployModelListWithoutCombine(github *GitHub, git *GitOperator) *DeployModelList {
+func NewDeployModelListWithoutCombine(github *GitHub, git *GitOperator, k *deploy.Kubernetes) *DeployModelList {
 	return &DeployModelList{
 		"lambda":    NewModelLambda(),
-		"kustomize": NewModelKustomize(github, git),
+		"kustomize": NewModelKustomize(github, git, k),
 		"kanvas":    NewModelKanvas(github, git),
-		"job":       NewModelJob(github),
+		"job":       NewModelJob(github, k),
 	}
 }
 
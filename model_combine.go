@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"strings"
+
+	"github.com/zaiminc/gocat/deploy"
 )
 
 type ModelCombine struct {
@@ -10,8 +12,8 @@ type ModelCombine struct {
 	projectList *ProjectList
 }
 
-func NewModelCombine(github *GitHub, git *GitOperator, pl *ProjectList) ModelCombine {
-	return ModelCombine{modelList: NewDeployModelListWithoutCombine(github, git), projectList: pl}
+func NewModelCombine(github *GitHub, git *GitOperator, pl *ProjectList, k *deploy.Kubernetes) ModelCombine {
+	return ModelCombine{modelList: NewDeployModelListWithoutCombine(github, git, k), projectList: pl}
 }
 
 type ModelCombineOutput struct {
@@ -41,13 +43,12 @@ func (self ModelCombineOutput) Message() string {
 
 func (self ModelCombine) Deploy(pj DeployProject, phase string, option DeployOption) (DeployOutput, error) {
 	o := ModelCombineOutput{}
-	ecr, err := CreateECRInstance()
-	if err != nil {
-		return o, err
-	}
-	option.Tag, err = ecr.FindImageTagByRegexp(pj.ECRRegistryId(), pj.ECRRepository(), pj.ImageTagRegexp(), pj.TargetRegexp(), ImageTagVars{Branch: option.Branch, Phase: phase})
-	if err != nil {
-		return o, err
+	if option.Tag == "" {
+		var err error
+		option.Tag, err = findImageTag(pj, ImageTagVars{Branch: option.Branch, Phase: phase})
+		if err != nil {
+			return o, err
+		}
 	}
 
 	steps := self.projectList.FindAll(pj.Steps())
@@ -28,8 +28,8 @@ func (self GitOpsPrepareOutput) Message() string {
 }
 
 func (self ModelGitOps) Commit(pullRequestID string) error {
-	return self.github.MergePullRequest(pullRequestID)
-
+	_, err := self.github.MergePullRequest(pullRequestID)
+	return err
 }
 
 func (self ModelGitOps) Deploy(pj DeployProject, phase string, option DeployOption) (do DeployOutput, err error) {
@@ -8,16 +8,18 @@ import (
 
 	"encoding/json"
 
+	"github.com/zaiminc/gocat/deploy"
 	batchv1 "k8s.io/api/batch/v1"
 	yaml "k8s.io/apimachinery/pkg/util/yaml"
 )
 
 type ModelJob struct {
-	github *GitHub
+	github     *GitHub
+	kubernetes *deploy.Kubernetes
 }
 
-func NewModelJob(github *GitHub) ModelJob {
-	return ModelJob{github}
+func NewModelJob(github *GitHub, k *deploy.Kubernetes) ModelJob {
+	return ModelJob{github, k}
 }
 
 type ModelJobDeployOutput struct {
@@ -46,11 +48,7 @@ func (self ModelJob) Deploy(pj DeployProject, phase string, option DeployOption)
 
 	tag := option.Tag
 	if tag == "" {
-		ecr, err := CreateECRInstance()
-		if err != nil {
-			return o, err
-		}
-		tag, err = ecr.FindImageTagByRegexp(pj.ECRRegistryId(), pj.ECRRepository(), pj.ImageTagRegexp(), pj.TargetRegexp(), ImageTagVars{Branch: option.Branch, Phase: phase})
+		tag, err = findImageTag(pj, ImageTagVars{Branch: option.Branch, Phase: phase})
 		if err != nil {
 			return o, err
 		}
@@ -76,7 +74,7 @@ func (self ModelJob) Deploy(pj DeployProject, phase string, option DeployOption)
 		}
 	}
 
-	if err = createJob(&job); err != nil {
+	if err = self.createJob(&job); err != nil {
 		return o, err
 	}
 
@@ -101,9 +99,9 @@ func (self ModelJob) Watch(name, namespace string) error {
 	t := time.NewTicker(time.Duration(20) * time.Second)
 	log.Println("[INFO] Watch job", name)
 	for range t.C {
-		job, err := getJob(name, namespace)
+		job, err := self.getJob(name, namespace)
 		if err != nil {
-			log.Println("[ERROR] Quit watching job ", job.Name)
+			log.Println("[ERROR] Quit watching job ", name)
 			t.Stop()
 			return err
 		}
@@ -1,9 +1,11 @@
 package main
 
-func NewModelKustomize(github *GitHub, git *GitOperator) ModelGitOps {
+import "github.com/zaiminc/gocat/deploy"
+
+func NewModelKustomize(github *GitHub, git *GitOperator, k *deploy.Kubernetes) ModelGitOps {
 	return ModelGitOps{
 		github: github,
 		git:    git,
-		plugin: NewGitOpsPluginKustomize(github, git),
+		plugin: NewGitOpsPluginKustomize(github, git, k),
 	}
 }
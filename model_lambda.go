@@ -26,17 +26,13 @@ func (self ModelLambdaDeployOutput) Message() string {
 }
 
 func (self ModelLambda) Deploy(pj DeployProject, phase string, option DeployOption) (o DeployOutput, err error) {
-	lambda, err := CreateLambdaInstance()
+	lambda, err := CreateLambdaInstance(pj.AWSConfig(phase))
 	if err != nil {
 		return
 	}
 	tag := option.Tag
 	if tag == "" {
-		ecr, err := CreateECRInstance()
-		if err != nil {
-			return o, err
-		}
-		tag, err = ecr.FindImageTagByRegexp(pj.ECRRegistryId(), pj.ECRRepository(), pj.ImageTagRegexp(), pj.TargetRegexp(), ImageTagVars{Branch: option.Branch, Phase: phase})
+		tag, err = findImageTag(pj, ImageTagVars{Branch: option.Branch, Phase: phase})
 		if err != nil {
 			return o, err
 		}
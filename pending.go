@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/zaiminc/gocat/deploy"
+)
+
+// newPendingRequestStore returns the deploy.PendingRequestStore configured by the config.
+// The requests are kept in memory unless the ConfigMap is configured.
+func newPendingRequestStore(config *CatConfig, k *deploy.Kubernetes) deploy.PendingRequestStore {
+	ttl := config.PendingRequestTTL
+	if ttl == 0 {
+		ttl = deploy.DefaultPendingRequestTTL
+	}
+
+	if config.PendingRequestsConfigMapName != "" {
+		return deploy.NewConfigMapPendingRequestStore(k, config.Namespace, config.PendingRequestsConfigMapName, ttl)
+	}
+
+	return deploy.NewMemoryPendingRequestStore(ttl)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrUnknownRevision is returned by the watchers when neither the revision before the deployment
+// nor the one to be deployed is known, so that the deployment can't be told from the previous one.
+var ErrUnknownRevision = errors.New("[ERROR] Unable to tell the deployment from the previous one")
+
+// poll calls get every interval until done returns true for the result, and returns the last result.
+// It's how the watchers follow a deployment that is applied asynchronously, like a sync of ArgoCD or a rollout of Kubernetes.
+//
+// onChange is called with the result whenever it changes, including the first one.
+// The errors from get are logged and retried, as they are usually temporary like the API server restarting.
+// The last result is returned with the error of the context if done doesn't become true within the timeout.
+func poll[T comparable](ctx context.Context, interval time.Duration, timeout time.Duration, get func(context.Context) (T, error), onChange func(T), done func(T) bool) (T, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var last T
+	for {
+		v, err := get(ctx)
+		if err != nil {
+			log.Print(err)
+		} else {
+			if v != last {
+				onChange(v)
+			}
+			last = v
+
+			if done(v) {
+				return v, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return last, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"regexp"
 	"strings"
 	"text/template"
 
+	"github.com/zaiminc/gocat/deploy"
 	yaml "gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
 )
 
 type PayloadVars struct {
@@ -25,19 +30,103 @@ func (self PayloadVars) Parse(s string) (string, error) {
 }
 
 type DeployPhase struct {
-	Name          string      `yaml:"name"`
+	Name string `yaml:"name"`
+	// Aliases are the other names accepted in the commands, like `[prd, pro]` for production.
+	// The phases named production and staging have the default aliases if not set. See defaultPhaseAliases.
+	Aliases       []string    `yaml:"aliases"`
 	Kind          string      `yaml:"kind"`
 	Path          string      `yaml:"path"` // for job
 	AutoDeploy    bool        `yaml:"autoDeploy"`
 	NotifyChannel string      `yaml:"notifyChannel"`
 	Payload       string      `yaml:"payload"`
 	Destination   Destination `yaml:"destination"`
+	// ArgoCDApp is the name of the ArgoCD application synced from the manifests of the phase.
+	// If set, gocat follows the sync and the health of the application after merging the deploy pull request.
+	ArgoCDApp string `yaml:"argocdApp"`
+	// AWSRegion and AWSRoleArn override the ones of the project for the phase.
+	AWSRegion  string `yaml:"awsRegion"`
+	AWSRoleArn string `yaml:"awsRoleArn"`
+	// Approval is the optional approval policy of the phase.
+	// Anyone who can deploy can approve their own request if it's not set.
+	Approval *ApprovalPolicy `yaml:"approval"`
+	// Schedule is the deploy windows and the freezes of the phase.
+	// They are combined with the global ones defined by DeployPolicy.
+	deploy.Schedule `yaml:",inline"`
+}
+
+// ApprovalPolicy defines who and how many users need to approve a deploy request of the phase
+// before the deployment happens.
+//
+//	approval:
+//	  requiredApprovals: 2
+//	  allowSelfApproval: false
+//	  approvers: [Admin]
+type ApprovalPolicy struct {
+	// RequiredApprovals is the number of approvals needed. Defaults to 1.
+	RequiredApprovals int `yaml:"requiredApprovals"`
+	// AllowSelfApproval allows the requester to approve their own request.
+	AllowSelfApproval bool `yaml:"allowSelfApproval"`
+	// Approvers is the list of roles allowed to approve. Any developer can approve if it's empty.
+	Approvers []Role `yaml:"approvers"`
+}
+
+// Validate returns an error if the policy has an unknown role among the approvers,
+// which would match nobody and make the phase unapprovable.
+func (p *ApprovalPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	for _, r := range p.Approvers {
+		if !r.Valid() {
+			return fmt.Errorf("unknown approver role %q. It must be either %s or %s", r, RoleDeveloper, RoleAdmin)
+		}
+	}
+	return nil
+}
+
+// validate returns an error if the phase is configured in a way that gocat can't enforce.
+func (p DeployPhase) validate() error {
+	if err := p.Approval.Validate(); err != nil {
+		return fmt.Errorf("invalid approval: %w", err)
+	}
+	if err := p.Schedule.Validate(); err != nil {
+		return fmt.Errorf("invalid schedule: %w", err)
+	}
+	return nil
 }
 
 func (p DeployPhase) None() bool {
 	return p.Name == ""
 }
 
+// defaultPhaseAliases are the aliases of the phases without the aliases configured,
+// which gocat has accepted since before the aliases became configurable.
+var defaultPhaseAliases = map[string][]string{
+	"production": {"pro", "prd"},
+	"staging":    {"stg"},
+}
+
+// HasName returns true if the name is the name or one of the aliases of the phase.
+func (p DeployPhase) HasName(name string) bool {
+	if p.None() {
+		return false
+	}
+	if p.Name == name {
+		return true
+	}
+
+	aliases := p.Aliases
+	if len(aliases) == 0 {
+		aliases = defaultPhaseAliases[p.Name]
+	}
+	for _, a := range aliases {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
 type DeployProject struct {
 	// ID is the name of the configmap that defines the project.
 	ID                  string
@@ -49,6 +138,9 @@ type DeployProject struct {
 	dockerRegistry      string
 	filterRegexp        string
 	targetRegexp        string
+	tagSelection        string
+	awsRegion           string
+	awsRoleArn          string
 	DisableBranchDeploy bool
 	steps               []string
 	Alias               string
@@ -64,6 +156,25 @@ func (p DeployProject) FindPhase(name string) DeployPhase {
 	return DeployPhase{}
 }
 
+// FindPhaseByAlias returns the phase that has the name or the alias.
+// The exact name takes precedence over the aliases of the other phases.
+func (p DeployProject) FindPhaseByAlias(name string) (DeployPhase, error) {
+	if phase := p.FindPhase(name); !phase.None() {
+		return phase, nil
+	}
+	for _, phase := range p.Phases {
+		if phase.HasName(name) {
+			return phase, nil
+		}
+	}
+
+	names := make([]string, len(p.Phases))
+	for i, phase := range p.Phases {
+		names[i] = phase.Name
+	}
+	return DeployPhase{}, fmt.Errorf("[ERROR] No Such Phase %q of %s. Valid phases are %s", name, p.ID, strings.Join(names, ", "))
+}
+
 func (pj DeployProject) JenkinsJob() string {
 	return pj.jenkinsJob
 }
@@ -81,13 +192,13 @@ func (pj DeployProject) Steps() []string {
 }
 
 // ImageTagRegexp returns the regexp to filter image tags.
-// This regexp is used to find the image tag from ECR.
+// This regexp is used to find the image tag from the image registry.
 // The regexp is parsed as a template, and the following variables are available:
 //
 //	{{.Branch}}: The branch name of the target commit.
 //	{{.Phase}}: The phase name.
 //
-// See ECRClient.FindImageTagByRegexp for more details on how the regexp is used.
+// See FindImageTagByRegexp for more details on how the regexp is used.
 func (pj DeployProject) ImageTagRegexp() string {
 	if pj.filterRegexp == "" {
 		return "^{{.Branch}}$"
@@ -102,10 +213,27 @@ func (pj DeployProject) TargetRegexp() string {
 	return pj.targetRegexp
 }
 
+// TagSelectionStrategy returns how the image to deploy is chosen when multiple images match ImageTagRegexp.
+// It defaults to TagSelectionLatestPushed.
+func (pj DeployProject) TagSelectionStrategy() TagSelectionStrategy {
+	if pj.tagSelection == "" {
+		return TagSelectionLatestPushed
+	}
+	return TagSelectionStrategy(pj.tagSelection)
+}
+
 func (pj DeployProject) DockerRepository() string {
 	return pj.dockerRegistry
 }
 
+// AWSConfig returns the AWS region and role to deploy the phase with.
+// The settings of the phase take precedence over the ones of the project,
+// and the global ones in CatConfig are used if neither is set.
+func (pj DeployProject) AWSConfig(phase string) AWSConfig {
+	p := pj.FindPhase(phase)
+	return AWSConfig{Region: p.AWSRegion, RoleArn: p.AWSRoleArn}.Or(AWSConfig{Region: pj.awsRegion, RoleArn: pj.awsRoleArn})
+}
+
 func (pj DeployProject) DefaultBranch() string {
 	if pj.defaultBranch == "" {
 		return "master"
@@ -113,34 +241,102 @@ func (pj DeployProject) DefaultBranch() string {
 	return pj.defaultBranch
 }
 
-func (pj DeployProject) ECRRepository() string {
-	path := strings.Split(pj.dockerRegistry, "/")
-	if len(path) < 2 {
-		return ""
-	}
-	return strings.Join(path[1:], "/")
+type ProjectList struct {
+	Items    []DeployProject
+	Policies []DeployPolicy
+	// Errors are the errors of the invalid configs found on the last Reload,
+	// like the phases skipped and the policies that keep the phases they apply to from being deployed.
+	Errors []string
+
+	// source provides the ConfigMaps of the projects and the policies on Reload.
+	source ConfigSource
 }
 
-func (pj DeployProject) ECRRegistryId() string {
-	path := strings.Split(pj.dockerRegistry, ".")
-	if len(path) < 2 {
-		return ""
+// DeployPolicy is a deploy schedule shared by all the projects,
+// defined by a ConfigMap labeled `gocat.zaim.net/configmap-type=policy` like:
+//
+//	data:
+//	  Phases: |
+//	    - production
+//	  DeployWindows: |
+//	    - cron: "* 10-17 * * Mon-Thu"
+//	      timeZone: Asia/Tokyo
+//	  Freezes: |
+//	    - from: 2021-12-28T00:00:00+09:00
+//	      to: 2022-01-04T00:00:00+09:00
+//	      reason: year-end holidays
+type DeployPolicy struct {
+	// Name is the name of the ConfigMap that defines the policy.
+	Name string
+	// Phases is the list of phase names the policy applies to. It applies to all the phases if empty.
+	Phases []string
+	deploy.Schedule
+	// err is the error of the policy if it's invalid.
+	// The phases the policy applies to can't be deployed then, as their schedules are unknown.
+	err error
+}
+
+// AppliesTo returns true if the policy applies to the phase.
+func (p DeployPolicy) AppliesTo(phase string) bool {
+	if len(p.Phases) == 0 {
+		return true
+	}
+	for _, name := range p.Phases {
+		if name == phase {
+			return true
+		}
 	}
-	return path[0]
+	return false
 }
 
-type ProjectList struct {
-	Items []DeployProject
+// Schedule returns the schedule of the phase combined with the global policies.
+// It returns an error if any of the policies is invalid.
+func (p ProjectList) Schedule(phase DeployPhase) (deploy.Schedule, error) {
+	var global deploy.Schedule
+	for _, policy := range p.Policies {
+		if policy.AppliesTo(phase.Name) {
+			if policy.err != nil {
+				return deploy.Schedule{}, fmt.Errorf("the policy %s is invalid: %w", policy.Name, policy.err)
+			}
+			global.DeployWindows = append(global.DeployWindows, policy.DeployWindows...)
+			global.Freezes = append(global.Freezes, policy.Freezes...)
+		}
+	}
+	return phase.Schedule.Merge(global), nil
 }
 
-func NewProjectList() (pl ProjectList) {
-	pl.Reload()
+func NewProjectList(source ConfigSource) (pl ProjectList) {
+	pl.source = source
+	if err := pl.Reload(); err != nil {
+		log.Print(err)
+	}
 	return
 }
 
-func (p *ProjectList) Reload() {
-	var tmp []DeployProject
-	cml := getConfigMapList("project")
+// Reload reloads the projects and the policies from the config source.
+// They are kept as they are if the config source fails, or if the list has no config source.
+//
+// The invalid phases are skipped so that nobody can deploy to them, while the other phases and projects are loaded.
+// The invalid policies are loaded to refuse the deployments to the phases they apply to. See Schedule.
+// Their errors are logged and kept in Errors to be shown to the users.
+func (p *ProjectList) Reload() error {
+	if p.source == nil {
+		return nil
+	}
+
+	cml, err := p.source.ConfigMaps(context.Background(), "project")
+	if err != nil {
+		return err
+	}
+	policies, err := p.source.ConfigMaps(context.Background(), "policy")
+	if err != nil {
+		return err
+	}
+
+	var (
+		tmp  []DeployProject
+		errs []string
+	)
 	for _, cm := range cml.Items {
 		pj := DeployProject{}
 		pj.ID = cm.Name
@@ -151,7 +347,10 @@ func (p *ProjectList) Reload() {
 		pj.defaultBranch = cm.Data["DefaultBranch"]
 		pj.filterRegexp = cm.Data["FilterRegexp"]
 		pj.targetRegexp = cm.Data["TargetRegexp"]
+		pj.tagSelection = cm.Data["TagSelectionStrategy"]
 		pj.funcName = cm.Data["FuncName"]
+		pj.awsRegion = cm.Data["AWSRegion"]
+		pj.awsRoleArn = cm.Data["AWSRoleArn"]
 		// Note that, although this is named Alias, it is actually treated as a
 		// mandatory ID of the project, which is used to identify the project.
 		// to be deployed in some places.
@@ -180,9 +379,62 @@ func (p *ProjectList) Reload() {
 				pj.Phases[i].Destination.ECS.Image = pj.DockerRepository()
 			}
 		}
+		var phases []DeployPhase
+		for _, phase := range pj.Phases {
+			if err := phase.validate(); err != nil {
+				msg := fmt.Sprintf("The phase %s of %s is skipped: %s", phase.Name, pj.ID, err)
+				log.Printf("[ERROR] %s", msg)
+				errs = append(errs, msg)
+				continue
+			}
+			phases = append(phases, phase)
+		}
+		pj.Phases = phases
 		tmp = append(tmp, pj)
 	}
+	p.Policies, err = loadPolicies(policies)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
 	p.Items = tmp
+	p.Errors = errs
+	return nil
+}
+
+// loadPolicies loads the policies from the ConfigMaps.
+// The invalid policies are loaded too, so that the phases they apply to can't be deployed,
+// and their errors are returned joined.
+func loadPolicies(cml *v1.ConfigMapList) ([]DeployPolicy, error) {
+	if cml == nil {
+		return nil, nil
+	}
+
+	var (
+		policies []DeployPolicy
+		errs     []string
+	)
+	for _, cm := range cml.Items {
+		policy := DeployPolicy{Name: cm.Name}
+		if err := yaml.Unmarshal([]byte(cm.Data["Phases"]), &policy.Phases); err != nil {
+			policy.err = fmt.Errorf("failed to parse phases: %w", err)
+		} else if err := yaml.Unmarshal([]byte(cm.Data["DeployWindows"]), &policy.DeployWindows); err != nil {
+			policy.err = fmt.Errorf("failed to parse deploy windows: %w", err)
+		} else if err := yaml.Unmarshal([]byte(cm.Data["Freezes"]), &policy.Freezes); err != nil {
+			policy.err = fmt.Errorf("failed to parse freezes: %w", err)
+		} else {
+			policy.err = policy.Validate()
+		}
+		if policy.err != nil {
+			msg := fmt.Sprintf("The phases the policy %s applies to can't be deployed: %s", cm.Name, policy.err)
+			log.Printf("[ERROR] %s", msg)
+			errs = append(errs, msg)
+		}
+		policies = append(policies, policy)
+	}
+	if len(errs) > 0 {
+		return policies, errors.New(strings.Join(errs, "\n"))
+	}
+	return policies, nil
 }
 
 func (p ProjectList) FindAll(ids []string) (o []DeployProject) {
@@ -206,6 +458,42 @@ func (p ProjectList) Find(id string) DeployProject {
 	return DeployProject{}
 }
 
+// FindByDeployBranch finds the project, the phase, and the image tag from the name of the branch
+// created by GitOps plugins, which is in the form of `bot/docker-image-tag-<project>-<phase>-<tag>`.
+//
+// As both the project ID and the phase name can contain hyphens,
+// we match the branch against the known projects and phases instead of splitting it.
+func (p ProjectList) FindByDeployBranch(branch string) (DeployProject, DeployPhase, string, bool) {
+	rest := strings.TrimPrefix(branch, "bot/docker-image-tag-")
+	if rest == branch {
+		return DeployProject{}, DeployPhase{}, "", false
+	}
+
+	for _, pj := range p.Items {
+		if !strings.HasPrefix(rest, pj.ID+"-") {
+			continue
+		}
+		for _, phase := range pj.Phases {
+			prefix := pj.ID + "-" + phase.Name + "-"
+			if strings.HasPrefix(rest, prefix) && len(rest) > len(prefix) {
+				return pj, phase, strings.TrimPrefix(rest, prefix), true
+			}
+		}
+	}
+
+	return DeployProject{}, DeployPhase{}, "", false
+}
+
+// FindPhaseName returns the name of the phase that has the name or the alias in any of the projects.
+func (p ProjectList) FindPhaseName(name string) (string, error) {
+	for _, pj := range p.Items {
+		if phase, err := pj.FindPhaseByAlias(name); err == nil {
+			return phase.Name, nil
+		}
+	}
+	return "", fmt.Errorf("[ERROR] No Such Phase %q in any project", name)
+}
+
 func (p ProjectList) FindByAlias(id string) (DeployProject, error) {
 	for _, pj := range p.Items {
 		if regexp.MustCompile(pj.Alias).Match([]byte(id)) {
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/deploy"
+	yaml "gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestProjectFind(t *testing.T) {
@@ -22,3 +27,213 @@ func TestProjectFind(t *testing.T) {
 	got := pl.Find("testid")
 	require.Equal(t, want, got)
 }
+
+func TestProjectFindByDeployBranch(t *testing.T) {
+	pl := &ProjectList{
+		Items: []DeployProject{
+			{ID: "myproject", Phases: []DeployPhase{{Name: "staging"}, {Name: "production"}}},
+			{ID: "myproject-api", Phases: []DeployPhase{{Name: "staging"}, {Name: "pre-production"}}},
+		},
+	}
+
+	pj, phase, tag, ok := pl.FindByDeployBranch("bot/docker-image-tag-myproject-api-pre-production-abc1234")
+	require.True(t, ok)
+	require.Equal(t, "myproject-api", pj.ID)
+	require.Equal(t, "pre-production", phase.Name)
+	require.Equal(t, "abc1234", tag)
+
+	pj, phase, tag, ok = pl.FindByDeployBranch("bot/docker-image-tag-myproject-staging-feature-x-abc1234")
+	require.True(t, ok)
+	require.Equal(t, "myproject", pj.ID)
+	require.Equal(t, "staging", phase.Name)
+	require.Equal(t, "feature-x-abc1234", tag)
+
+	_, _, _, ok = pl.FindByDeployBranch("bot/docker-image-tag-unknown-staging-abc1234")
+	require.False(t, ok)
+
+	_, _, _, ok = pl.FindByDeployBranch("feature/foo")
+	require.False(t, ok)
+}
+
+func TestProjectFindPhaseByAlias(t *testing.T) {
+	var phases []DeployPhase
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- name: staging
+- name: production
+- name: qa
+  aliases: [test]
+- name: preprod
+  aliases: [pre, prd]
+`), &phases))
+	pj := DeployProject{ID: "myproject", Phases: phases}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "staging", want: "staging"},
+		{name: "stg", want: "staging"},
+		{name: "pro", want: "production"},
+		{name: "qa", want: "qa"},
+		{name: "test", want: "qa"},
+		{name: "pre", want: "preprod"},
+		// The default aliases of production are not overridden by the aliases of the other phases.
+		{name: "prd", want: "production"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			phase, err := pj.FindPhaseByAlias(tt.name)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, phase.Name)
+		})
+	}
+
+	_, err := pj.FindPhaseByAlias("sandbox")
+	require.EqualError(t, err, `[ERROR] No Such Phase "sandbox" of myproject. Valid phases are staging, production, qa, preprod`)
+
+	// The configured aliases replace the default ones.
+	pj = DeployProject{ID: "myproject", Phases: []DeployPhase{{Name: "production", Aliases: []string{"live"}}}}
+	_, err = pj.FindPhaseByAlias("prd")
+	require.Error(t, err)
+	phase, err := pj.FindPhaseByAlias("live")
+	require.NoError(t, err)
+	require.Equal(t, "production", phase.Name)
+}
+
+func TestProjectListFindPhaseName(t *testing.T) {
+	pl := ProjectList{
+		Items: []DeployProject{
+			{ID: "myproject", Phases: []DeployPhase{{Name: "staging"}, {Name: "production"}}},
+			{ID: "myproject-api", Phases: []DeployPhase{{Name: "staging"}, {Name: "qa", Aliases: []string{"test"}}}},
+		},
+	}
+
+	name, err := pl.FindPhaseName("prd")
+	require.NoError(t, err)
+	require.Equal(t, "production", name)
+
+	name, err = pl.FindPhaseName("test")
+	require.NoError(t, err)
+	require.Equal(t, "qa", name)
+
+	_, err = pl.FindPhaseName("sandbox")
+	require.EqualError(t, err, `[ERROR] No Such Phase "sandbox" in any project`)
+}
+
+func TestProjectListSchedule(t *testing.T) {
+	var phases []DeployPhase
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- name: production
+  deployWindows:
+  - cron: "* 10-17 * * Mon-Thu"
+    timeZone: Asia/Tokyo
+  freezes:
+  - from: 2021-12-28T00:00:00+09:00
+    to: 2022-01-04T00:00:00+09:00
+    reason: year-end holidays
+- name: staging
+`), &phases))
+
+	policies, err := loadPolicies(&v1.ConfigMapList{Items: []v1.ConfigMap{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "production-policy"},
+			Data: map[string]string{
+				"Phases":        "- production\n",
+				"DeployWindows": "- cron: \"* 0-23 * * *\"\n",
+				"Freezes":       "- from: 2021-09-01T00:00:00Z\n  to: 2021-09-02T00:00:00Z\n  reason: migration\n",
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "global-policy"},
+			Data: map[string]string{
+				"DeployWindows": "- cron: \"* 9-18 * * Mon-Fri\"\n",
+			},
+		},
+	}})
+	require.NoError(t, err)
+	require.Len(t, policies, 2)
+
+	pl := ProjectList{
+		Items:    []DeployProject{{ID: "myproject", Phases: phases}},
+		Policies: policies,
+	}
+
+	production, err := pl.Schedule(pl.Find("myproject").FindPhase("production"))
+	require.NoError(t, err)
+	require.Equal(t, []deploy.DeployWindow{{Cron: "* 10-17 * * Mon-Thu", TimeZone: "Asia/Tokyo"}}, production.DeployWindows)
+	require.Len(t, production.Freezes, 2)
+	require.Equal(t, "year-end holidays", production.Freezes[0].Reason)
+	require.Equal(t, "migration", production.Freezes[1].Reason)
+
+	staging, err := pl.Schedule(pl.Find("myproject").FindPhase("staging"))
+	require.NoError(t, err)
+	require.Equal(t, []deploy.DeployWindow{{Cron: "* 9-18 * * Mon-Fri"}}, staging.DeployWindows)
+	require.Empty(t, staging.Freezes)
+
+	// The invalid policies are loaded to refuse the deployments to the phases they apply to.
+	policies, err = loadPolicies(&v1.ConfigMapList{Items: []v1.ConfigMap{{
+		ObjectMeta: metav1.ObjectMeta{Name: "production-policy"},
+		Data: map[string]string{
+			"Phases":        "- production\n",
+			"DeployWindows": "- cron: \"* 9-18 * * Weekdays\"\n",
+		},
+	}}})
+	require.EqualError(t, err, `The phases the policy production-policy applies to can't be deployed: invalid deploy window "* 9-18 * * Weekdays": value "Weekdays" must be between 0 and 7`)
+	pl.Policies = policies
+	_, err = pl.Schedule(pl.Find("myproject").FindPhase("production"))
+	require.Error(t, err)
+	_, err = pl.Schedule(pl.Find("myproject").FindPhase("staging"))
+	require.NoError(t, err)
+}
+
+func TestProjectListReload(t *testing.T) {
+	source := &fakeConfigSource{configMaps: map[string][]v1.ConfigMap{
+		"project": {{
+			ObjectMeta: metav1.ObjectMeta{Name: "api"},
+			Data: map[string]string{
+				"Kind":   "kustomize",
+				"Alias":  "^api$",
+				"Phases": "- name: staging\n  path: api/overlays/staging\n- name: production\n  kind: jenkins\n",
+			},
+		}},
+		"policy": {{ObjectMeta: metav1.ObjectMeta{Name: "global-policy"}}},
+	}}
+
+	pl := NewProjectList(source)
+	require.Len(t, pl.Items, 1)
+	require.Equal(t, "kustomize", pl.Find("api").FindPhase("staging").Kind)
+	require.Equal(t, "api/overlays/staging", pl.Find("api").FindPhase("staging").Destination.Kustomize.Path)
+	require.Equal(t, "jenkins", pl.Find("api").FindPhase("production").Kind)
+	require.Len(t, pl.Policies, 1)
+
+	// The projects loaded before are kept if the config source fails.
+	source.err = errors.New("unavailable")
+	require.EqualError(t, pl.Reload(), "unavailable")
+	require.Len(t, pl.Items, 1)
+	require.Len(t, pl.Policies, 1)
+
+	// Only the phase with an unknown approver role is skipped.
+	source.err = nil
+	source.configMaps["project"] = append(source.configMaps["project"], v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Data:       map[string]string{"Kind": "kustomize", "Phases": "- name: staging\n- name: production\n  approval:\n    approvers: [Admins]\n"},
+	})
+	require.NoError(t, pl.Reload())
+	require.Len(t, pl.Items, 2)
+	require.Equal(t, "jenkins", pl.Find("api").FindPhase("production").Kind)
+	require.Equal(t, "staging", pl.Find("web").FindPhase("staging").Name)
+	require.True(t, pl.Find("web").FindPhase("production").None())
+	require.Equal(t, []string{`The phase production of web is skipped: invalid approval: unknown approver role "Admins". It must be either Developer or Admin`}, pl.Errors)
+
+	// So is the phase with an invalid deploy window.
+	source.configMaps["project"][1].Data["Phases"] = "- name: staging\n- name: production\n  deployWindows:\n  - cron: \"* 10-17 * *\"\n"
+	require.NoError(t, pl.Reload())
+	require.Equal(t, "staging", pl.Find("web").FindPhase("staging").Name)
+	require.True(t, pl.Find("web").FindPhase("production").None())
+	require.Equal(t, []string{"The phase production of web is skipped: invalid schedule: invalid deploy window \"* 10-17 * *\": expected 5 fields of `minute hour day-of-month month day-of-week`"}, pl.Errors)
+
+	// The list without the config source is kept as it is.
+	pl = ProjectList{Items: []DeployProject{{ID: "web"}}}
+	require.NoError(t, pl.Reload())
+	require.Len(t, pl.Items, 1)
+}
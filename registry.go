@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// ImageRegistry is a container image registry gocat looks up the image tags to deploy from.
+//
+// The implementation is chosen by the host of the project's DockerRegistry. See NewImageRegistry.
+type ImageRegistry interface {
+	// ListImages returns the images in the repository that have at least one tag for which match returns true.
+	// Each image has the tags matched, followed by the other tags of the image for which related returns true.
+	// related can be nil if no other tags are needed.
+	//
+	// The images are found by the matched tags first, and the related tags are looked up only for those images,
+	// so that registries without an API to list the images, like OCI Distribution ones, resolve only the tags of interest.
+	// Such registries may return only the first related tag found for each image.
+	ListImages(repository string, match func(tag string) bool, related func(tag string) bool) ([]Image, error)
+}
+
+// Image is an image in a repository, which can have multiple tags like the branch name and the commit ID.
+type Image struct {
+	Digest string
+	Tags   []string
+	// PushedAt is when the image was pushed. It's zero if the registry doesn't tell.
+	PushedAt time.Time
+}
+
+type ImageTagVars struct {
+	Branch string
+	Phase  string
+}
+
+func (self ImageTagVars) Parse(s string) (string, error) {
+	b := bytes.NewBuffer([]byte(""))
+	tmpl, err := template.New("").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	err = tmpl.Execute(b, self)
+	return b.String(), err
+}
+
+var ecrHostRegexp = regexp.MustCompile(`^([0-9]{12})\.dkr\.ecr\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+
+// ociRegistries are the OCI registries keyed by the host, shared by all the projects
+// so that the bearer tokens are reused across the lookups.
+var ociRegistries sync.Map
+
+// NewImageRegistry returns the registry and the repository of the DockerRegistry like
+// `123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/myapp` or `ghcr.io/myorg/myapp`.
+//
+// ECR is used for the hosts of ECR, and the OCI Distribution API is used for the others.
+// The ECR registry is accessed in the region of the host, with the role of the AWS config if any.
+func NewImageRegistry(dockerRegistry string, aws AWSConfig) (ImageRegistry, string, error) {
+	host, repo, ok := strings.Cut(dockerRegistry, "/")
+	if !ok || host == "" || repo == "" {
+		return nil, "", fmt.Errorf("[ERROR] Invalid DockerRegistry %q. Set like `ghcr.io/org/repo`", dockerRegistry)
+	}
+
+	if m := ecrHostRegexp.FindStringSubmatch(host); m != nil {
+		aws.Region = m[2]
+		ecr, err := CreateECRInstance(aws)
+		if err != nil {
+			return nil, "", err
+		}
+		return ECRRegistry{ECRClient: ecr, RegistryID: m[1]}, repo, nil
+	}
+
+	if r, ok := ociRegistries.Load(host); ok {
+		return r.(*OCIRegistry), repo, nil
+	}
+	r, _ := ociRegistries.LoadOrStore(host, NewOCIRegistry(host))
+	return r.(*OCIRegistry), repo, nil
+}
+
+// TagSelectionStrategy is how the image to deploy is chosen among the images matching the filter regexp.
+type TagSelectionStrategy string
+
+const (
+	// TagSelectionLatestPushed chooses the image pushed most recently.
+	TagSelectionLatestPushed TagSelectionStrategy = "latest-pushed"
+	// TagSelectionHighestSemver chooses the image whose target tag is the highest semantic version like `v1.2.3`.
+	// Images without such a tag are ignored.
+	TagSelectionHighestSemver TagSelectionStrategy = "highest-semver"
+	// TagSelectionExact requires exactly one image to match, so that an ambiguous filter is an error.
+	TagSelectionExact TagSelectionStrategy = "exact"
+)
+
+// ParseTagSelectionStrategy returns the strategy named s, which defaults to TagSelectionLatestPushed if empty.
+func ParseTagSelectionStrategy(s string) (TagSelectionStrategy, error) {
+	switch strategy := TagSelectionStrategy(s); strategy {
+	case "":
+		return TagSelectionLatestPushed, nil
+	case TagSelectionLatestPushed, TagSelectionHighestSemver, TagSelectionExact:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("[ERROR] Unknown TagSelectionStrategy %q. It must be one of %s, %s and %s", s, TagSelectionLatestPushed, TagSelectionHighestSemver, TagSelectionExact)
+	}
+}
+
+// ImageCandidate is an image matching the filter regexp, with the tag matching the target regexp.
+type ImageCandidate struct {
+	Image
+	Tag string
+}
+
+// findImageTag finds the image tag to deploy from the registry of the project.
+func findImageTag(pj DeployProject, vars ImageTagVars) (string, error) {
+	registry, repo, err := NewImageRegistry(pj.DockerRepository(), pj.AWSConfig(vars.Phase))
+	if err != nil {
+		return "", err
+	}
+	return FindImageTagByRegexp(registry, repo, pj.ImageTagRegexp(), pj.TargetRegexp(), pj.TagSelectionStrategy(), vars)
+}
+
+// findImageCandidates returns the candidates of the image tag to deploy from the registry of the project,
+// in the order of preference.
+func findImageCandidates(pj DeployProject, vars ImageTagVars) ([]ImageCandidate, error) {
+	registry, repo, err := NewImageRegistry(pj.DockerRepository(), pj.AWSConfig(vars.Phase))
+	if err != nil {
+		return nil, err
+	}
+	return FindImageCandidates(registry, repo, pj.ImageTagRegexp(), pj.TargetRegexp(), pj.TagSelectionStrategy(), vars)
+}
+
+// FindImageTagByRegexp finds the image that has a tag matching the filter regexp,
+// and returns the tag of the image matching the target regexp.
+//
+// Both regexps are parsed as templates with vars. The slashes in vars.Branch are replaced with underscores,
+// as tags can't contain slashes.
+// For example, the filter `^{{.Branch}}$` and the target `\b[0-9a-f]{5,40}\b` return the commit ID tag of
+// the image that is tagged with the branch name too.
+//
+// If multiple images match, the image is chosen by the strategy. See FindImageCandidates.
+func FindImageTagByRegexp(registry ImageRegistry, repo string, rawFilterRegexp string, rawTargetRegexp string, strategy TagSelectionStrategy, vars ImageTagVars) (string, error) {
+	candidates, err := FindImageCandidates(registry, repo, rawFilterRegexp, rawTargetRegexp, strategy, vars)
+	if err != nil {
+		return "", err
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("[ERROR] NotFound specified image tag")
+	}
+	if strategy == TagSelectionExact && len(candidates) > 1 {
+		tags := make([]string, len(candidates))
+		for i, c := range candidates {
+			tags[i] = c.Tag
+		}
+		return "", fmt.Errorf("[ERROR] %d images match the filter, whereas TagSelectionStrategy %s requires exactly one: %s", len(candidates), strategy, strings.Join(tags, ", "))
+	}
+	return candidates[0].Tag, nil
+}
+
+// FindImageCandidates returns the images that have a tag matching the filter regexp and another matching the target regexp,
+// ordered by the strategy so that the first one is deployed.
+// See FindImageTagByRegexp for the regexps.
+//
+// The images are ordered by the push time, newest first, for TagSelectionLatestPushed and TagSelectionExact,
+// and by the semantic version of the target tag, highest first, for TagSelectionHighestSemver.
+// Images of the same order keep the order returned by the registry.
+func FindImageCandidates(registry ImageRegistry, repo string, rawFilterRegexp string, rawTargetRegexp string, strategy TagSelectionStrategy, vars ImageTagVars) ([]ImageCandidate, error) {
+	strategy, err := ParseTagSelectionStrategy(string(strategy))
+	if err != nil {
+		return nil, err
+	}
+
+	vars.Branch = strings.Replace(vars.Branch, "/", "_", -1)
+	filterRegexp, err := vars.Parse(rawFilterRegexp)
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] filterRegexp cannot be parsed: %s", rawFilterRegexp)
+	}
+	targetRegexp, err := vars.Parse(rawTargetRegexp)
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] targetRegexp cannot be parsed: %s", rawTargetRegexp)
+	}
+	filter, err := regexp.Compile(filterRegexp)
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] Invalid filterRegexp %q: %w", filterRegexp, err)
+	}
+	target, err := regexp.Compile(targetRegexp)
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] Invalid targetRegexp %q: %w", targetRegexp, err)
+	}
+
+	// The tags matching the filter find the images, whose tags matching the target are the candidates.
+	images, err := registry.ListImages(repo, filter.MatchString, func(tag string) bool {
+		if strategy == TagSelectionHighestSemver {
+			if _, ok := parseSemver(tag); !ok {
+				return false
+			}
+		}
+		return target.MatchString(tag)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []ImageCandidate
+	for _, image := range images {
+		if tag, ok := candidateTag(image, filter, target, strategy); ok {
+			candidates = append(candidates, ImageCandidate{Image: image, Tag: tag})
+		}
+	}
+
+	sortImageCandidates(candidates, strategy)
+	return candidates, nil
+}
+
+func sortImageCandidates(candidates []ImageCandidate, strategy TagSelectionStrategy) {
+	switch strategy {
+	case TagSelectionHighestSemver:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			vi, _ := parseSemver(candidates[i].Tag)
+			vj, _ := parseSemver(candidates[j].Tag)
+			return vi.compare(vj) > 0
+		})
+	default:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			ti, tj := candidates[i].PushedAt, candidates[j].PushedAt
+			// Images of unknown push time come last.
+			if ti.IsZero() || tj.IsZero() {
+				return !ti.IsZero() && tj.IsZero()
+			}
+			return ti.After(tj)
+		})
+	}
+}
+
+// findImageByTag returns the image of the tag in the registry of the project.
+func findImageByTag(pj DeployProject, phase string, tag string) (Image, error) {
+	registry, repo, err := NewImageRegistry(pj.DockerRepository(), pj.AWSConfig(phase))
+	if err != nil {
+		return Image{}, err
+	}
+	return FindImageByTag(registry, repo, tag)
+}
+
+// findImageTagByCommit returns the tag of the image built from the commit in the registry of the project.
+func findImageTagByCommit(pj DeployProject, phase string, commit string) (string, error) {
+	registry, repo, err := NewImageRegistry(pj.DockerRepository(), pj.AWSConfig(phase))
+	if err != nil {
+		return "", err
+	}
+	return FindImageTagByCommit(registry, repo, pj.TargetRegexp(), commit, ImageTagVars{Phase: phase})
+}
+
+// FindImageByTag returns the image of the tag, or an error if the tag doesn't exist in the repository.
+func FindImageByTag(registry ImageRegistry, repo string, tag string) (Image, error) {
+	images, err := registry.ListImages(repo, func(t string) bool { return t == tag }, nil)
+	if err != nil {
+		return Image{}, err
+	}
+	if len(images) == 0 {
+		return Image{}, fmt.Errorf("[ERROR] NotFound image tag %q in %s", tag, repo)
+	}
+	return images[0], nil
+}
+
+var commitIDRegexp = regexp.MustCompile(`[0-9a-f]{7,40}`)
+
+// FindImageTagByCommit returns the tag matching the target regexp that contains the ID of the commit,
+// either abbreviated or not, like `abc1234` or `staging-abc1234` for the commit `abc1234def...`.
+// See FindImageTagByRegexp for the target regexp.
+//
+// If multiple images are built from the commit, the tag of the one pushed most recently is returned.
+func FindImageTagByCommit(registry ImageRegistry, repo string, rawTargetRegexp string, commit string, vars ImageTagVars) (string, error) {
+	commit = strings.ToLower(commit)
+	targetRegexp, err := vars.Parse(rawTargetRegexp)
+	if err != nil {
+		return "", fmt.Errorf("[ERROR] targetRegexp cannot be parsed: %s", rawTargetRegexp)
+	}
+	target, err := regexp.Compile(targetRegexp)
+	if err != nil {
+		return "", fmt.Errorf("[ERROR] Invalid targetRegexp %q: %w", targetRegexp, err)
+	}
+
+	match := func(tag string) bool {
+		if !target.MatchString(tag) {
+			return false
+		}
+		for _, id := range commitIDRegexp.FindAllString(tag, -1) {
+			if strings.HasPrefix(commit, id) || strings.HasPrefix(id, commit) {
+				return true
+			}
+		}
+		return false
+	}
+	images, err := registry.ListImages(repo, match, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var candidates []ImageCandidate
+	for _, image := range images {
+		for _, tag := range image.Tags {
+			if match(tag) {
+				candidates = append(candidates, ImageCandidate{Image: image, Tag: tag})
+				break
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("[ERROR] NotFound image tag of the commit %s in %s", commit, repo)
+	}
+	sortImageCandidates(candidates, TagSelectionLatestPushed)
+	return candidates[0].Tag, nil
+}
+
+// candidateTag returns the tag of the image matching the target regexp if the image has a tag matching the filter regexp.
+// For TagSelectionHighestSemver, only the tags of semantic versions are returned.
+func candidateTag(image Image, filter, target *regexp.Regexp, strategy TagSelectionStrategy) (string, bool) {
+	filtered := false
+	for _, tag := range image.Tags {
+		if filter.MatchString(tag) {
+			filtered = true
+			break
+		}
+	}
+	if !filtered {
+		return "", false
+	}
+
+	for _, tag := range image.Tags {
+		if !target.MatchString(tag) {
+			continue
+		}
+		if strategy == TagSelectionHighestSemver {
+			if _, ok := parseSemver(tag); !ok {
+				continue
+			}
+		}
+		return tag, true
+	}
+	return "", false
+}
+
+var semverRegexp = regexp.MustCompile(`^v?(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// semver is a semantic version like `v1.2.3-rc.1`. The build metadata is ignored.
+type semver struct {
+	numbers    [3]int
+	prerelease []string
+}
+
+func parseSemver(s string) (semver, bool) {
+	m := semverRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return semver{}, false
+	}
+
+	var v semver
+	for i := range v.numbers {
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return semver{}, false
+		}
+		v.numbers[i] = n
+	}
+	if m[4] != "" {
+		v.prerelease = strings.Split(m[4], ".")
+	}
+	return v, true
+}
+
+// compare returns a positive number if v is higher than other, a negative number if lower, and 0 if equal,
+// following the precedence of Semantic Versioning 2.0.0.
+func (v semver) compare(other semver) int {
+	for i := range v.numbers {
+		if d := v.numbers[i] - other.numbers[i]; d != 0 {
+			return d
+		}
+	}
+
+	// A pre-release version is lower than the normal version.
+	switch {
+	case len(v.prerelease) == 0 && len(other.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(other.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		a, b := v.prerelease[i], other.prerelease[i]
+		if a == b {
+			continue
+		}
+		na, errA := strconv.Atoi(a)
+		nb, errB := strconv.Atoi(b)
+		switch {
+		case errA == nil && errB == nil:
+			return na - nb
+		case errA == nil:
+			// Numeric identifiers are lower than alphanumeric ones.
+			return -1
+		case errB == nil:
+			return 1
+		default:
+			return strings.Compare(a, b)
+		}
+	}
+	return len(v.prerelease) - len(other.prerelease)
+}
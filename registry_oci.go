@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OCIRegistry is the ImageRegistry that uses the OCI Distribution (Docker Registry HTTP API v2) API,
+// like GHCR and self-hosted registries.
+//
+// Anonymous access is tried first, and the credentials for the host in the Docker config file are used if any.
+// Both the basic and the bearer token authentication are supported.
+type OCIRegistry struct {
+	// baseURL is the URL of the registry like `https://ghcr.io`.
+	baseURL  string
+	username string
+	password string
+	client   *http.Client
+
+	mu sync.Mutex
+	// tokens are the bearer tokens keyed by the scope.
+	tokens map[string]string
+}
+
+// NewOCIRegistry returns the registry at the host, using the credentials in the Docker config file if any.
+// See dockerCredentials for the location of the file.
+func NewOCIRegistry(host string) *OCIRegistry {
+	username, password, err := dockerCredentials(host)
+	if err != nil {
+		log.Printf("[WARNING] Unable to read the credentials for %s: %s", host, err)
+	}
+
+	return &OCIRegistry{
+		baseURL:  "https://" + host,
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		tokens:   map[string]string{},
+	}
+}
+
+// ociManifestMediaTypes are the media types of the manifests accepted on resolving tags.
+// An image index and a manifest list are resolved as is, so that a multi-platform image has a single digest.
+var ociManifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+func (r *OCIRegistry) ListImages(repository string, match func(tag string) bool, related func(tag string) bool) ([]Image, error) {
+	tags, err := r.listTags(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		images []Image
+		index  = map[string]int{}
+		others []string
+	)
+	for _, tag := range tags {
+		if !match(tag) {
+			if related != nil && related(tag) {
+				others = append(others, tag)
+			}
+			continue
+		}
+
+		digest, err := r.resolve(repository, tag)
+		if err != nil {
+			return nil, err
+		}
+
+		if i, ok := index[digest]; ok {
+			images[i].Tags = append(images[i].Tags, tag)
+			continue
+		}
+		index[digest] = len(images)
+		images = append(images, Image{Digest: digest, Tags: []string{tag}})
+	}
+
+	// The API doesn't tell the tags of a digest, so the related tags are resolved one by one
+	// only until every image has one, skipping the ones of the images not matched.
+	found := map[string]bool{}
+	for _, image := range images {
+		for _, tag := range image.Tags {
+			if related != nil && related(tag) {
+				found[image.Digest] = true
+			}
+		}
+	}
+	for _, tag := range others {
+		if len(found) == len(images) {
+			break
+		}
+
+		digest, err := r.resolve(repository, tag)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := index[digest]; ok && !found[digest] {
+			images[i].Tags = append(images[i].Tags, tag)
+			found[digest] = true
+		}
+	}
+
+	for i := range images {
+		if images[i].PushedAt, err = r.created(repository, images[i].Digest); err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
+
+// listTags returns all the tags in the repository, following the pagination by the Link header.
+func (r *OCIRegistry) listTags(repository string) ([]string, error) {
+	var tags []string
+	next := fmt.Sprintf("/v2/%s/tags/list", repository)
+	for next != "" {
+		resp, err := r.do(http.MethodGet, next, repository, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var body struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("[ERROR] Invalid tag list of %s: %w", repository, err)
+		}
+		tags = append(tags, body.Tags...)
+
+		next = nextLink(resp.Header.Get("Link"))
+	}
+	return tags, nil
+}
+
+// resolve returns the digest of the manifest the tag points to.
+func (r *OCIRegistry) resolve(repository, tag string) (string, error) {
+	header := http.Header{"Accept": {strings.Join(ociManifestMediaTypes, ", ")}}
+	resp, err := r.do(http.MethodHead, fmt.Sprintf("/v2/%s/manifests/%s", repository, tag), repository, header)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("[ERROR] The registry did not return the digest of %s:%s", repository, tag)
+	}
+	return digest, nil
+}
+
+// ociManifest is the part of an image manifest, an image index or a manifest list used by gocat.
+type ociManifest struct {
+	MediaType string `json:"mediaType"`
+	Config    struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Manifests []struct {
+		Digest string `json:"digest"`
+	} `json:"manifests"`
+}
+
+// created returns the creation time in the image config of the manifest, which is used as the push time
+// as the Distribution API doesn't tell when the image was pushed.
+// The first manifest is used for an image index, as all the platforms are built at once.
+// A zero time is returned if the image config doesn't have the creation time.
+func (r *OCIRegistry) created(repository, digest string) (time.Time, error) {
+	manifest, err := r.manifest(repository, digest)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if manifest.Config.Digest == "" && len(manifest.Manifests) > 0 {
+		if manifest, err = r.manifest(repository, manifest.Manifests[0].Digest); err != nil {
+			return time.Time{}, err
+		}
+	}
+	if manifest.Config.Digest == "" {
+		return time.Time{}, nil
+	}
+
+	resp, err := r.do(http.MethodGet, fmt.Sprintf("/v2/%s/blobs/%s", repository, manifest.Config.Digest), repository, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	var config struct {
+		Created time.Time `json:"created"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return time.Time{}, fmt.Errorf("[ERROR] Invalid image config of %s@%s: %w", repository, digest, err)
+	}
+	return config.Created, nil
+}
+
+func (r *OCIRegistry) manifest(repository, digest string) (ociManifest, error) {
+	var manifest ociManifest
+	header := http.Header{"Accept": {strings.Join(ociManifestMediaTypes, ", ")}}
+	resp, err := r.do(http.MethodGet, fmt.Sprintf("/v2/%s/manifests/%s", repository, digest), repository, header)
+	if err != nil {
+		return manifest, err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return manifest, fmt.Errorf("[ERROR] Invalid manifest of %s@%s: %w", repository, digest, err)
+	}
+	return manifest, nil
+}
+
+// do sends the request to the path of the registry, authenticating for the repository if the registry requires.
+// The response is returned only if the status is 2xx.
+func (r *OCIRegistry) do(method, path, repository string, header http.Header) (*http.Response, error) {
+	scope := fmt.Sprintf("repository:%s:pull", repository)
+
+	send := func() (*http.Response, error) {
+		req, err := http.NewRequest(method, r.baseURL+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		r.mu.Lock()
+		token := r.tokens[scope]
+		r.mu.Unlock()
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if r.username != "" {
+			req.SetBasicAuth(r.username, r.password)
+		}
+		return r.client.Do(req)
+	}
+
+	resp, err := send()
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to request %s%s: %w", r.baseURL, path, err)
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := r.authenticate(challenge, scope); err != nil {
+			return nil, err
+		}
+		if resp, err = send(); err != nil {
+			return nil, fmt.Errorf("[ERROR] Failed to request %s%s: %w", r.baseURL, path, err)
+		}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("[ERROR] Unexpected status %d from %s%s: %s", resp.StatusCode, r.baseURL, path, truncate(string(body), 200))
+	}
+	return resp, nil
+}
+
+// authenticate gets the bearer token for the scope following the challenge in the WWW-Authenticate header.
+func (r *OCIRegistry) authenticate(challenge, scope string) error {
+	scheme, params := parseChallenge(challenge)
+	if !strings.EqualFold(scheme, "Bearer") {
+		// The basic authentication is already tried if the credentials exist.
+		return fmt.Errorf("[ERROR] Unauthorized by %s. Set the credentials in the Docker config file", r.baseURL)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("[ERROR] Invalid authentication challenge from %s: %s", r.baseURL, challenge)
+	}
+	q := realm.Query()
+	if s := params["service"]; s != "" {
+		q.Set("service", s)
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Failed to get the token from %s: %w", realm.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("[ERROR] Unexpected status %d on getting the token from %s", resp.StatusCode, realm.Host)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("[ERROR] Invalid token response from %s: %w", realm.Host, err)
+	}
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+
+	r.mu.Lock()
+	r.tokens[scope] = token
+	r.mu.Unlock()
+	return nil
+}
+
+var challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// parseChallenge parses the WWW-Authenticate header like `Bearer realm="https://ghcr.io/token",service="ghcr.io"`.
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for _, m := range challengeParamRegexp.FindAllStringSubmatch(rest, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+	return scheme, params
+}
+
+var linkRegexp = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+
+// nextLink returns the path and the query of the next page in the Link header, or an empty string if there's none.
+func nextLink(link string) string {
+	m := linkRegexp.FindStringSubmatch(link)
+	if m == nil {
+		return ""
+	}
+	u, err := url.Parse(m[1])
+	if err != nil {
+		return ""
+	}
+	return u.RequestURI()
+}
+
+// dockerCredentials returns the username and the password for the registry host
+// in `config.json` under the DOCKER_CONFIG directory, which defaults to `~/.docker`.
+// Empty strings are returned if the file or the credentials don't exist.
+func dockerCredentials(host string) (string, string, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return "", "", nil
+	} else if err != nil {
+		return "", "", err
+	}
+
+	var config struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return "", "", err
+	}
+
+	auth, ok := config.Auths[host]
+	if !ok {
+		auth, ok = config.Auths["https://"+host]
+	}
+	if !ok {
+		return "", "", nil
+	}
+	if auth.Auth == "" {
+		return auth.Username, auth.Password, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid auth of %s: %w", host, err)
+	}
+	username, password, _ := strings.Cut(string(decoded), ":")
+	return username, password, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOCIRegistry is an in-process registry serving the tags of the repository `myorg/myapp`
+// by the Distribution API, which requires a bearer token issued to the user `bot`.
+//
+// Every manifest is an image index pointing to the platform manifest `<digest>-amd64`,
+// whose image config is created at the time in created, or lacks the creation time if missing.
+type fakeOCIRegistry struct {
+	// digests are the digests of the manifests keyed by the tags.
+	digests map[string]string
+	created map[string]time.Time
+	// pageSize is the number of tags in a page of the tag list.
+	pageSize int
+	url      string
+
+	mu sync.Mutex
+	// requests are the paths of the manifests and the blobs requested.
+	requests []string
+}
+
+func (f *fakeOCIRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "bot" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("scope") != "repository:myorg/myapp:pull" || r.URL.Query().Get("service") != "fake" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"token": "mytoken"}`))
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer mytoken" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake",scope="repository:myorg/myapp:pull"`, f.url))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if !strings.HasSuffix(r.URL.Path, "/tags/list") {
+		f.mu.Lock()
+		f.requests = append(f.requests, r.URL.Path)
+		f.mu.Unlock()
+	}
+
+	switch {
+	case r.URL.Path == "/v2/myorg/myapp/tags/list":
+		var tags []string
+		for tag := range f.digests {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+
+		start := 0
+		if last := r.URL.Query().Get("last"); last != "" {
+			start = sort.SearchStrings(tags, last) + 1
+		}
+		end := start + f.pageSize
+		if end < len(tags) {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/myorg/myapp/tags/list?n=%d&last=%s>; rel="next"`, f.pageSize, tags[end-1]))
+		} else {
+			end = len(tags)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": "myorg/myapp", "tags": tags[start:end]})
+	case strings.HasPrefix(r.URL.Path, "/v2/myorg/myapp/manifests/sha256:"):
+		if !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		digest := strings.TrimPrefix(r.URL.Path, "/v2/myorg/myapp/manifests/")
+		if strings.HasSuffix(digest, "-amd64") {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"mediaType": "application/vnd.oci.image.manifest.v1+json", "config": map[string]string{"digest": strings.TrimSuffix(digest, "-amd64") + "-config"}})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"mediaType": "application/vnd.oci.image.index.v1+json", "manifests": []map[string]string{{"digest": digest + "-amd64"}}})
+	case strings.HasPrefix(r.URL.Path, "/v2/myorg/myapp/manifests/"):
+		if !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		digest, ok := f.digests[strings.TrimPrefix(r.URL.Path, "/v2/myorg/myapp/manifests/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+	case strings.HasPrefix(r.URL.Path, "/v2/myorg/myapp/blobs/"):
+		digest := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v2/myorg/myapp/blobs/"), "-config")
+		config := map[string]interface{}{"architecture": "amd64"}
+		if created, ok := f.created[digest]; ok {
+			config["created"] = created
+		}
+		_ = json.NewEncoder(w).Encode(config)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestOCIRegistry(t *testing.T, digests map[string]string, created map[string]time.Time) (*OCIRegistry, *fakeOCIRegistry) {
+	fake := &fakeOCIRegistry{digests: digests, created: created, pageSize: 2}
+	ts := httptest.NewTLSServer(fake)
+	t.Cleanup(ts.Close)
+	fake.url = ts.URL
+
+	host := strings.TrimPrefix(ts.URL, "https://")
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"auths": {"`+host+`": {"auth": "Ym90OnNlY3JldA=="}}}`), 0600))
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	registry, repo, err := NewImageRegistry(host+"/myorg/myapp", AWSConfig{})
+	require.NoError(t, err)
+	require.Equal(t, "myorg/myapp", repo)
+
+	r := registry.(*OCIRegistry)
+	r.client = ts.Client()
+	return r, fake
+}
+
+func TestOCIRegistryFindImageTagByRegexp(t *testing.T) {
+	digests := map[string]string{
+		"master":           "sha256:2",
+		"abc1234":          "sha256:1",
+		"def5678":          "sha256:2",
+		"feature_foo":      "sha256:3",
+		"0123abc":          "sha256:3",
+		"staging-0123abc":  "sha256:3",
+		"latest":           "sha256:2",
+		"untagged-release": "sha256:4",
+		"release-1":        "sha256:5",
+		"aaa1111":          "sha256:5",
+		"release-2":        "sha256:6",
+		"bbb2222":          "sha256:6",
+		"release-3":        "sha256:7",
+		"ccc3333":          "sha256:7",
+	}
+	for i := 0; i < 11; i++ {
+		digests["v1."+strconv.Itoa(i)+".0"] = "sha256:v" + strconv.Itoa(i)
+	}
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	created := map[string]time.Time{
+		"sha256:5": now.Add(-2 * time.Hour),
+		"sha256:6": now,
+		// sha256:7 lacks the creation time, so comes last.
+	}
+	r, fake := newTestOCIRegistry(t, digests, created)
+
+	testcases := []struct {
+		filter   string
+		target   string
+		strategy TagSelectionStrategy
+		vars     ImageTagVars
+		want     string
+		wantErr  string
+	}{
+		{filter: "^{{.Branch}}$", target: `\b[0-9a-f]{5,40}\b`, vars: ImageTagVars{Branch: "master"}, want: "def5678"},
+		{filter: "^{{.Branch}}$", target: `\b[0-9a-f]{5,40}\b`, vars: ImageTagVars{Branch: "feature/foo"}, want: "0123abc"},
+		{filter: "^{{.Branch}}$", target: `^{{.Phase}}-`, vars: ImageTagVars{Branch: "feature/foo", Phase: "staging"}, want: "staging-0123abc"},
+		{filter: "^{{.Branch}}$", target: `\b[0-9a-f]{5,40}\b`, vars: ImageTagVars{Branch: "unknown"}, wantErr: "NotFound specified image tag"},
+		{filter: "^(", target: `.*`, vars: ImageTagVars{Branch: "master"}, wantErr: "Invalid filterRegexp"},
+		{filter: "^{{.Branch}}-", target: `\b[0-9a-f]{5,40}\b`, vars: ImageTagVars{Branch: "release"}, want: "bbb2222"},
+		{filter: "^{{.Branch}}-", target: `\b[0-9a-f]{5,40}\b`, strategy: TagSelectionLatestPushed, vars: ImageTagVars{Branch: "release"}, want: "bbb2222"},
+		{filter: "^{{.Branch}}-", target: `\b[0-9a-f]{5,40}\b`, strategy: TagSelectionExact, vars: ImageTagVars{Branch: "release"}, wantErr: "3 images match the filter, whereas TagSelectionStrategy exact requires exactly one: bbb2222, aaa1111, ccc3333"},
+		{filter: "^{{.Branch}}$", target: `\b[0-9a-f]{5,40}\b`, strategy: TagSelectionExact, vars: ImageTagVars{Branch: "master"}, want: "def5678"},
+		{filter: `^v1\.`, target: `^v`, strategy: TagSelectionHighestSemver, vars: ImageTagVars{Branch: "v1"}, want: "v1.10.0"},
+		{filter: "^{{.Branch}}$", target: `.*`, strategy: "newest", vars: ImageTagVars{Branch: "master"}, wantErr: `Unknown TagSelectionStrategy "newest"`},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.vars.Branch+" "+tc.target+" "+string(tc.strategy), func(t *testing.T) {
+			got, err := FindImageTagByRegexp(r, "myorg/myapp", tc.filter, tc.target, tc.strategy, tc.vars)
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+
+	images, err := r.ListImages("myorg/myapp", func(tag string) bool { return strings.HasPrefix(tag, "release-") }, nil)
+	require.NoError(t, err)
+	require.Equal(t, []Image{
+		{Digest: "sha256:5", Tags: []string{"release-1"}, PushedAt: now.Add(-2 * time.Hour)},
+		{Digest: "sha256:6", Tags: []string{"release-2"}, PushedAt: now},
+		{Digest: "sha256:7", Tags: []string{"release-3"}},
+	}, images)
+
+	// Only the image of the branch is resolved, and the commit tags are resolved until the one of the image is found.
+	fake.requests = nil
+	images, err = r.ListImages("myorg/myapp", func(tag string) bool { return tag == "master" }, regexp.MustCompile(`\b[0-9a-f]{5,40}\b`).MatchString)
+	require.NoError(t, err)
+	require.Equal(t, []Image{{Digest: "sha256:2", Tags: []string{"master", "def5678"}}}, images)
+	require.Equal(t, []string{
+		"/v2/myorg/myapp/manifests/master",
+		"/v2/myorg/myapp/manifests/0123abc",
+		"/v2/myorg/myapp/manifests/aaa1111",
+		"/v2/myorg/myapp/manifests/abc1234",
+		"/v2/myorg/myapp/manifests/bbb2222",
+		"/v2/myorg/myapp/manifests/ccc3333",
+		"/v2/myorg/myapp/manifests/def5678",
+		"/v2/myorg/myapp/manifests/sha256:2",
+		"/v2/myorg/myapp/manifests/sha256:2-amd64",
+		"/v2/myorg/myapp/blobs/sha256:2-config",
+	}, fake.requests)
+}
+
+func TestOCIRegistryUnauthorized(t *testing.T) {
+	r, _ := newTestOCIRegistry(t, map[string]string{"master": "sha256:1"}, nil)
+	r.password = "invalid"
+
+	_, err := r.ListImages("myorg/myapp", func(string) bool { return true }, nil)
+	require.ErrorContains(t, err, "Unexpected status 401 on getting the token")
+}
+
+func TestNewImageRegistry(t *testing.T) {
+	registry, repo, err := NewImageRegistry("123456789012.dkr.ecr.us-east-1.amazonaws.com/myorg/myapp", AWSConfig{Region: "ap-northeast-1"})
+	require.NoError(t, err)
+	require.Equal(t, "myorg/myapp", repo)
+	require.Equal(t, "123456789012", registry.(ECRRegistry).RegistryID)
+	require.Equal(t, "us-east-1", *registry.(ECRRegistry).client.Config.Region)
+
+	registry, repo, err = NewImageRegistry("ghcr.io/myorg/myapp", AWSConfig{})
+	require.NoError(t, err)
+	require.Equal(t, "myorg/myapp", repo)
+	require.Equal(t, "https://ghcr.io", registry.(*OCIRegistry).baseURL)
+
+	// The registry of the same host is reused to reuse the bearer tokens.
+	other, repo, err := NewImageRegistry("ghcr.io/myorg/other", AWSConfig{})
+	require.NoError(t, err)
+	require.Equal(t, "myorg/other", repo)
+	require.Same(t, registry, other)
+
+	_, _, err = NewImageRegistry("myapp", AWSConfig{})
+	require.ErrorContains(t, err, "Invalid DockerRegistry")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemverCompare(t *testing.T) {
+	// In the ascending order of precedence, from the example in Semantic Versioning 2.0.0.
+	versions := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "v1.0.0", "1.0.1+build.5", "1.2.0", "1.10.0", "2.0.0"}
+	for i := 1; i < len(versions); i++ {
+		lower, ok := parseSemver(versions[i-1])
+		require.True(t, ok, versions[i-1])
+		higher, ok := parseSemver(versions[i])
+		require.True(t, ok, versions[i])
+		require.Greater(t, higher.compare(lower), 0, "%s > %s", versions[i], versions[i-1])
+		require.Less(t, lower.compare(higher), 0, "%s < %s", versions[i-1], versions[i])
+	}
+
+	for _, s := range []string{"1.0", "01.0.0", "main-1.0.0", "abc1234"} {
+		_, ok := parseSemver(s)
+		require.False(t, ok, s)
+	}
+}
+
+// fakeImageRegistry is an ImageRegistry of the images in memory, listed in the order of the slice.
+type fakeImageRegistry []Image
+
+func (f fakeImageRegistry) ListImages(repository string, match func(tag string) bool, related func(tag string) bool) ([]Image, error) {
+	var images []Image
+	for _, image := range f {
+		var tags, others []string
+		for _, tag := range image.Tags {
+			if match(tag) {
+				tags = append(tags, tag)
+			} else if related != nil && related(tag) {
+				others = append(others, tag)
+			}
+		}
+		if len(tags) > 0 {
+			images = append(images, Image{Digest: image.Digest, Tags: append(tags, others...), PushedAt: image.PushedAt})
+		}
+	}
+	return images, nil
+}
+
+func TestFindImageByTagAndCommit(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	registry := fakeImageRegistry{
+		{Digest: "sha256:1", Tags: []string{"main", "a1b2c3d", "staging-a1b2c3d"}, PushedAt: now.Add(-time.Hour)},
+		// Rebuilt from the same commit later.
+		{Digest: "sha256:2", Tags: []string{"a1b2c3d4e5f60718293a4b5c6d7e8f9012345678"}, PushedAt: now},
+		{Digest: "sha256:3", Tags: []string{"v1.2.3", "0123abc"}},
+	}
+
+	image, err := FindImageByTag(registry, "myapp", "v1.2.3")
+	require.NoError(t, err)
+	require.Equal(t, "sha256:3", image.Digest)
+
+	_, err = FindImageByTag(registry, "myapp", "v1.2")
+	require.EqualError(t, err, `[ERROR] NotFound image tag "v1.2" in myapp`)
+
+	testcases := []struct {
+		commit  string
+		target  string
+		vars    ImageTagVars
+		want    string
+		wantErr string
+	}{
+		{commit: "a1b2c3d4e5f60718293a4b5c6d7e8f9012345678", target: `\b[0-9a-f]{5,40}\b`, want: "a1b2c3d4e5f60718293a4b5c6d7e8f9012345678"},
+		{commit: "A1B2C3D", target: `^[0-9a-f]{7}$`, want: "a1b2c3d"},
+		{commit: "a1b2c3d4e5f6", target: `^{{.Phase}}-`, vars: ImageTagVars{Phase: "staging"}, want: "staging-a1b2c3d"},
+		{commit: "0123abcdef", target: `\b[0-9a-f]{5,40}\b`, want: "0123abc"},
+		{commit: "fedcba9", target: `\b[0-9a-f]{5,40}\b`, wantErr: "[ERROR] NotFound image tag of the commit fedcba9 in myapp"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.commit+" "+tc.target, func(t *testing.T) {
+			got, err := FindImageTagByCommit(registry, "myapp", tc.target, tc.commit, tc.vars)
+			if tc.wantErr != "" {
+				require.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
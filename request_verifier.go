@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// SignatureMaxAge is how long a signed request is accepted after its X-Slack-Request-Timestamp.
+// It matches the window enforced by slack.NewSecretsVerifier.
+const SignatureMaxAge = 5 * time.Minute
+
+var ErrReplayedRequest = errors.New("the request has already been received")
+
+// SlackRequestVerifier verifies that the HTTP requests to /events and /interaction come from Slack.
+//
+// When the signing secret is set, it verifies the X-Slack-Signature header of the request,
+// and rejects requests older than SignatureMaxAge and requests with an already seen signature.
+// Otherwise it falls back to the deprecated verification token contained in the payload.
+//
+// See https://api.slack.com/authentication/verifying-requests-from-slack for more details.
+type SlackRequestVerifier struct {
+	signingSecret     string
+	verificationToken string
+
+	mu sync.Mutex
+	// seen is the set of the signatures received within SignatureMaxAge, keyed by the signature.
+	seen map[string]time.Time
+	now  func() time.Time
+}
+
+func NewSlackRequestVerifier(signingSecret, verificationToken string) *SlackRequestVerifier {
+	return &SlackRequestVerifier{
+		signingSecret:     signingSecret,
+		verificationToken: verificationToken,
+		seen:              map[string]time.Time{},
+		now:               time.Now,
+	}
+}
+
+// Verify reads the body of the request and verifies it.
+// The body is returned and also restored to r.Body so that the request can be parsed afterwards.
+func (v *SlackRequestVerifier) Verify(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read request body: %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if v.signingSecret == "" {
+		return body, v.verifyToken(r.Header, body)
+	}
+
+	sv, err := slack.NewSecretsVerifier(r.Header, v.signingSecret)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := sv.Write(body); err != nil {
+		return nil, err
+	}
+	if err := sv.Ensure(); err != nil {
+		return nil, err
+	}
+
+	if err := v.remember(r.Header.Get("X-Slack-Signature")); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// remember records the signature, and returns ErrReplayedRequest if it has already been recorded.
+// Signatures older than SignatureMaxAge are forgotten, as such requests are rejected by their timestamp.
+func (v *SlackRequestVerifier) remember(signature string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	for s, at := range v.seen {
+		if now.Sub(at) > SignatureMaxAge {
+			delete(v.seen, s)
+		}
+	}
+
+	if _, ok := v.seen[signature]; ok {
+		return ErrReplayedRequest
+	}
+	v.seen[signature] = now
+
+	return nil
+}
+
+// verifyToken compares the verification token of the payload with the configured one.
+func (v *SlackRequestVerifier) verifyToken(header http.Header, body []byte) error {
+	token, err := verificationToken(header, body)
+	if err != nil {
+		return err
+	}
+
+	if v.verificationToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(v.verificationToken)) != 1 {
+		return errors.New("invalid verification token")
+	}
+
+	return nil
+}
+
+// verificationToken returns the verification token of the payload.
+// Events are JSON payloads, interactions are JSON payloads in the `payload` form field,
+// and slash commands are forms with the `token` field.
+func verificationToken(header http.Header, body []byte) (string, error) {
+	payload := body
+	if strings.HasPrefix(header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return "", fmt.Errorf("unable to parse form: %w", err)
+		}
+		if !form.Has("payload") {
+			return form.Get("token"), nil
+		}
+		payload = []byte(form.Get("payload"))
+	}
+
+	var p struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return "", fmt.Errorf("unable to unmarshal payload: %w", err)
+	}
+	return p.Token, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func signedRequest(t *testing.T, secret string, at time.Time, body string) *http.Request {
+	t.Helper()
+
+	ts := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, err := mac.Write([]byte(fmt.Sprintf("v0:%s:%s", ts, body)))
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	r.Header.Set("X-Slack-Request-Timestamp", ts)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestSlackRequestVerifierSignature(t *testing.T) {
+	body := `{"type": "event_callback"}`
+
+	t.Run("valid", func(t *testing.T) {
+		v := NewSlackRequestVerifier("secret", "")
+		r := signedRequest(t, "secret", time.Now(), body)
+
+		got, err := v.Verify(r)
+		require.NoError(t, err)
+		require.Equal(t, body, string(got))
+
+		// The body is restored for the later parsing.
+		restored, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, body, string(restored))
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		v := NewSlackRequestVerifier("secret", "")
+		_, err := v.Verify(signedRequest(t, "other", time.Now(), body))
+		require.Error(t, err)
+	})
+
+	t.Run("tampered body", func(t *testing.T) {
+		v := NewSlackRequestVerifier("secret", "")
+		r := signedRequest(t, "secret", time.Now(), body)
+		r.Body = io.NopCloser(strings.NewReader(`{"type": "url_verification"}`))
+		_, err := v.Verify(r)
+		require.Error(t, err)
+	})
+
+	t.Run("missing headers", func(t *testing.T) {
+		v := NewSlackRequestVerifier("secret", "token")
+		_, err := v.Verify(httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(`{"token": "token"}`)))
+		require.Error(t, err)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		v := NewSlackRequestVerifier("secret", "")
+		_, err := v.Verify(signedRequest(t, "secret", time.Now().Add(-SignatureMaxAge-time.Minute), body))
+		require.Error(t, err)
+	})
+
+	t.Run("replayed", func(t *testing.T) {
+		v := NewSlackRequestVerifier("secret", "")
+		now := time.Now()
+
+		_, err := v.Verify(signedRequest(t, "secret", now, body))
+		require.NoError(t, err)
+
+		_, err = v.Verify(signedRequest(t, "secret", now, body))
+		require.ErrorIs(t, err, ErrReplayedRequest)
+
+		// The signature is forgotten once the request expires by its timestamp.
+		v.now = func() time.Time { return now.Add(SignatureMaxAge + time.Second) }
+		_, err = v.Verify(signedRequest(t, "secret", time.Now(), `{"type": "app_rate_limited"}`))
+		require.NoError(t, err)
+		require.Len(t, v.seen, 1)
+	})
+}
+
+func TestSlackRequestVerifierToken(t *testing.T) {
+	interaction := func(token string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/interaction", strings.NewReader(url.Values{"payload": {`{"type": "block_actions", "token": "` + token + `"}`}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+	event := func(token string) *http.Request {
+		return httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(`{"type": "event_callback", "token": "`+token+`"}`))
+	}
+	slashCommand := func(token string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/command", strings.NewReader(url.Values{"command": {"/gocat"}, "token": {token}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	v := NewSlackRequestVerifier("", "token")
+
+	_, err := v.Verify(event("token"))
+	require.NoError(t, err)
+	_, err = v.Verify(event("other"))
+	require.EqualError(t, err, "invalid verification token")
+
+	r := interaction("token")
+	_, err = v.Verify(r)
+	require.NoError(t, err)
+	require.NoError(t, r.ParseForm())
+	require.Contains(t, r.PostForm.Get("payload"), "block_actions")
+
+	_, err = v.Verify(interaction("other"))
+	require.EqualError(t, err, "invalid verification token")
+
+	r = slashCommand("token")
+	_, err = v.Verify(r)
+	require.NoError(t, err)
+	require.NoError(t, r.ParseForm())
+	require.Equal(t, "/gocat", r.PostForm.Get("command"))
+
+	_, err = v.Verify(slashCommand("other"))
+	require.EqualError(t, err, "invalid verification token")
+
+	_, err = NewSlackRequestVerifier("", "").Verify(event(""))
+	require.EqualError(t, err, "invalid verification token")
+}
@@ -1,42 +1,48 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
 	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
 	"github.com/zaiminc/gocat/slackcmd"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // SlackListener is a http.Handler that can handle slack events.
 // See https://api.slack.com/apis/connections/events-api for more details about events.
 type SlackListener struct {
-	client            *slack.Client
-	verificationToken string
+	client            SlackClient
+	verifier          *SlackRequestVerifier
 	projectList       *ProjectList
 	userList          *UserList
 	interactorFactory *InteractorFactory
+	languages         *LanguageList
 
 	coordinator *deploy.Coordinator
+	history     deploy.History
+	guard       *DeployGuard
+	pending     deploy.PendingRequestStore
 }
 
 func (s SlackListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	buf := new(bytes.Buffer)
-	if _, err := buf.ReadFrom(r.Body); err != nil {
-		fmt.Printf("[ERROR] Failed to read request body: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	b, err := s.verifier.Verify(r)
+	if err != nil {
+		log.Printf("[ERROR] Failed to verify the request: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	body := buf.String()
+	body := string(b)
 	header := r.Header
 
 	if header.Get("X-Slack-Retry-Num") != "" {
@@ -46,7 +52,7 @@ func (s SlackListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	eventsAPIEvent, err := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionVerifyToken(&slackevents.TokenComparator{VerificationToken: s.verificationToken}))
+	eventsAPIEvent, err := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionNoVerifyToken())
 	if err != nil {
 		fmt.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -68,209 +74,150 @@ func (s SlackListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if eventsAPIEvent.Type == slackevents.CallbackEvent {
-		innerEvent := eventsAPIEvent.InnerEvent
-		switch ev := innerEvent.Data.(type) {
-		case *slackevents.AppMentionEvent:
-			if err := s.handleMessageEvent(ev); err != nil {
-				log.Println("[ERROR] ", err)
-				w.WriteHeader(http.StatusInternalServerError)
-			}
-		}
+	if err := s.handleEventsAPIEvent(eventsAPIEvent); err != nil {
+		log.Println("[ERROR] ", err)
+		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
-func (s *SlackListener) handleMessageEvent(ev *slackevents.AppMentionEvent) error {
-	// Only response mention to bot. Ignore else.
-	log.Print(ev.Text)
-	if regexp.MustCompile(`help`).MatchString(ev.Text) {
-		if _, _, err := s.client.PostMessage(ev.Channel, s.helpMessage()); err != nil {
-			log.Println("[ERROR] ", err)
-		}
-		return nil
-	}
-	if regexp.MustCompile(`ls`).MatchString(ev.Text) {
-		if _, _, err := s.client.PostMessage(ev.Channel, s.projectListMessage()); err != nil {
-			log.Println("[ERROR] ", err)
-		}
-		return nil
-	}
-	if regexp.MustCompile(`reload`).MatchString(ev.Text) {
-		s.projectList.Reload()
-		s.userList.Reload()
-		section := slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", "Deploy Projects and Users is Reloaded", false, false), nil, nil)
-		if _, _, err := s.client.PostMessage(ev.Channel, slack.MsgOptionBlocks(section)); err != nil {
-			log.Println("[ERROR] ", err)
-		}
+// handleEventsAPIEvent handles the Events API event delivered either over HTTP or Socket Mode.
+func (s *SlackListener) handleEventsAPIEvent(eventsAPIEvent slackevents.EventsAPIEvent) error {
+	if eventsAPIEvent.Type != slackevents.CallbackEvent {
 		return nil
 	}
 
-	s.projectList.Reload()
-	s.userList.Reload()
-	if match := regexp.MustCompile(`deploy ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd) branch`).FindAllStringSubmatch(ev.Text, -1); match != nil {
-		log.Println("[INFO] Deploy command is Called")
-		commands := strings.Split(match[0][0], " ")
-		target, err := s.projectList.FindByAlias(commands[1])
-		if err != nil {
-			log.Println("[ERROR] ", err)
-			if _, _, err := s.client.PostMessage(ev.Channel, s.errorMessage(err.Error())); err != nil {
-				log.Println("[ERROR] ", err)
-			}
+	switch ev := eventsAPIEvent.InnerEvent.Data.(type) {
+	case *slackevents.AppMentionEvent:
+		return s.handleMessageEvent(ev)
+	case *slackevents.AppHomeOpenedEvent:
+		if ev.Tab != "home" {
 			return nil
 		}
-
-		phase := s.toPhase(commands[2])
-		interactor := s.interactorFactory.Get(target, phase)
-		blocks, err := interactor.BranchList(target, phase)
-		if err != nil {
-			log.Println("[ERROR] ", err)
-			if _, _, err := s.client.PostMessage(ev.Channel, s.errorMessage(err.Error())); err != nil {
-				log.Println("[ERROR] ", err)
-			}
-			return nil
-		}
-
-		if _, _, err := s.client.PostMessage(ev.Channel, slack.MsgOptionBlocks(blocks...)); err != nil {
-			log.Println("[ERROR] ", err)
-		}
-		return nil
+		return s.publishHome(ev.User)
 	}
-	if match := regexp.MustCompile(`deploy ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd)`).FindAllStringSubmatch(ev.Text, -1); match != nil {
-		log.Println("[INFO] Deploy command is Called")
-		commands := strings.Split(match[0][0], " ")
-		target, err := s.projectList.FindByAlias(commands[1])
-		if err != nil {
-			log.Println("[ERROR] ", err)
-			if _, _, err := s.client.PostMessage(ev.Channel, s.errorMessage(err.Error())); err != nil {
-				log.Println("[ERROR] ", err)
-			}
-			return nil
-		}
 
-		phase := s.toPhase(commands[2])
+	return nil
+}
 
-		if msg, locked := s.checkDeploymentLock(target.ID, phase, ev.User, ev.Channel); locked {
-			if _, _, err := s.client.PostMessage(ev.Channel, msg); err != nil {
-				log.Println("[ERROR] ", err)
-			}
-			return nil
-		}
+func (s *SlackListener) handleMessageEvent(ev *slackevents.AppMentionEvent) error {
+	// Only response mention to bot. Ignore else.
+	log.Print(ev.Text)
 
-		interactor := s.interactorFactory.Get(target, phase)
-		blocks, err := interactor.Request(target, phase, target.DefaultBranch(), ev.User, ev.Channel)
-		if err != nil {
-			log.Println("[ERROR] ", err)
-			if _, _, err := s.client.PostMessage(ev.Channel, s.errorMessage(err.Error())); err != nil {
-				log.Println("[ERROR] ", err)
-			}
-			return nil
-		}
+	return s.handleCommand(ev.Text, ev.User, ev.Channel)
+}
 
-		if _, _, err := s.client.PostMessage(ev.Channel, slack.MsgOptionBlocks(blocks...)); err != nil {
-			log.Println("[ERROR] ", err)
-		}
-		return nil
-	}
-	if regexp.MustCompile(`deploy staging`).MatchString(ev.Text) {
-		msgOpt := s.SelectDeployTarget("staging")
-		if _, _, err := s.client.PostMessage(ev.Channel, msgOpt); err != nil {
-			log.Println("[ERROR] ", err)
-		}
-		return nil
-	}
-	if regexp.MustCompile(`deploy production`).MatchString(ev.Text) {
-		msgOpt := s.SelectDeployTarget("production")
-		if _, _, err := s.client.PostMessage(ev.Channel, msgOpt); err != nil {
-			log.Println("[ERROR] ", err)
-		}
-		return nil
-	}
-	if regexp.MustCompile(`deploy sandbox`).MatchString(ev.Text) {
-		msgOpt := s.SelectDeployTarget("sandbox")
-		if _, _, err := s.client.PostMessage(ev.Channel, msgOpt); err != nil {
-			log.Println("[ERROR] ", err)
+// handleCommand parses the text of the mention or the slash command, and runs the command.
+// See runCommand for the other arguments.
+func (s *SlackListener) handleCommand(text string, triggeredBy string, replyIn string, replyOpts ...slack.MsgOption) error {
+	cmd, err := slackcmd.Parse(text)
+	if err != nil {
+		msg := err.Error()
+		if errors.As(err, &slackcmd.UnknownCommandError{}) {
+			log.Println("[INFO] invalid command", text)
+			msg = s.lang(s.userList.FindBySlackUserID(triggeredBy), replyIn).Text(i18n.InvalidCommand)
 		}
+		s.reply(replyIn, s.errorMessage(msg), replyOpts...)
 		return nil
 	}
-	if cmd, _ := slackcmd.Parse(ev.Text); cmd != nil {
-		log.Printf("[INFO] %s command is Called", cmd.Name())
-		return s.runCommand(cmd, ev.User, ev.Channel)
-	}
 
-	if _, _, err := s.client.PostMessage(ev.Channel, s.errorMessage("Invalid command. Say `@bot help` to see the usage guide")); err != nil {
-		log.Println("[INFO] invalid command", ev.Text)
+	log.Printf("[INFO] %s command is Called", cmd.Name())
+	switch cmd.(type) {
+	case *slackcmd.Help, *slackcmd.List, *slackcmd.Reload:
+		// These commands don't depend on the latest projects and users, or reload them by themselves.
+	default:
+		// The command goes on with the projects and users loaded before if the reload fails.
+		_ = s.reload()
 	}
-
-	return nil
+	return s.runCommand(cmd, triggeredBy, replyIn, replyOpts...)
 }
 
-func (s *SlackListener) helpMessage() slack.MsgOption {
-	deployMasterText := slack.NewTextBlockObject("mrkdwn", "*masterのデプロイ*\n`@bot-name deploy api staging`\napiの部分はその他アプリケーションに置換可能です。stagingの部分はproductionやsandboxに置換可能です。\nコマンド入力後にデプロイするかの確認ボタンが出てきます。", false, false)
-	deployMasterSection := slack.NewSectionBlock(deployMasterText, nil, nil)
-
-	deployBranchText := slack.NewTextBlockObject("mrkdwn", "*ブランチのデプロイ*\n`@bot-name deploy api staging branch`\napiの部分はその他アプリケーションに置換可能です。stagingの部分はproductionやsandboxに置換可能です。\nブランチを選択するドロップダウンが出てきます。\nブランチ選択後にデプロイするかの確認ボタンが出てきます。", false, false)
-	deployBranchSection := slack.NewSectionBlock(deployBranchText, nil, nil)
+// reload reloads the projects, the users and the languages from the config source.
+// The ones failed to reload are kept as they were, and the first error is returned.
+func (s *SlackListener) reload() error {
+	var reloadErr error
+	for _, reload := range []func() error{s.projectList.Reload, s.userList.Reload, s.languages.Reload} {
+		if err := reload(); err != nil {
+			log.Print(err)
+			if reloadErr == nil {
+				reloadErr = err
+			}
+		}
+	}
+	return reloadErr
+}
 
-	deployText := slack.NewTextBlockObject("mrkdwn", "*デプロイ対象の選択をSlackのUIから選択するデプロイ手法*\n`@bot-name deploy staging`\nstagingの部分はproductionやsandboxに置換可能です。\nデプロイ対象の選択後にデプロイするブランチの選択肢が出てきます。", false, false)
-	deploySection := slack.NewSectionBlock(deployText, nil, nil)
+// lang returns the language of the messages to the user in the channel.
+func (s *SlackListener) lang(user User, channel string) i18n.Lang {
+	return s.languages.Lang(user.SlackDisplayName, channel)
+}
 
-	lockText := slack.NewTextBlockObject("mrkdwn", "*デプロイロックをとる*\n`@bot-name lock api staging for REASON`\napiの部分はその他アプリケーションに置換可能です。stagingの部分はproductionやsandboxに置換可能です。\nREASON部分にロックする理由を指定する必要があります。", false, false)
-	lockSection := slack.NewSectionBlock(lockText, nil, nil)
+// helpMessage describes the usages of the command, or all the commands if command is empty.
+func (s *SlackListener) helpMessage(command string, lang i18n.Lang) slack.MsgOption {
+	usages := slackcmd.Usages(command)
+	if len(usages) == 0 {
+		return s.errorMessage(lang.Sprintf(i18n.UnknownHelpCommand, command))
+	}
 
-	unlockText := slack.NewTextBlockObject("mrkdwn", "*デプロイロックを解除する*\n`@bot-name unlock api staging`\napiの部分はその他アプリケーションに置換可能です。stagingの部分はproductionやsandboxに置換可能です。", false, false)
-	unlockSection := slack.NewSectionBlock(unlockText, nil, nil)
+	var blocks []slack.Block
+	for _, u := range usages {
+		lines := []string{"*" + lang.Text(u.Title) + "*"}
+		for _, ex := range u.Examples {
+			lines = append(lines, "`@bot-name "+ex+"`")
+		}
+		lines = append(lines, lang.Text(u.Description))
 
-	describeLocksText := slack.NewTextBlockObject("mrkdwn", "*デプロイロックの状態を確認する*\n`@bot-name describe locks`\nデプロイロックの状態を確認します。", false, false)
-	describeLocksSection := slack.NewSectionBlock(describeLocksText, nil, nil)
+		txt := slack.NewTextBlockObject("mrkdwn", strings.Join(lines, "\n"), false, false)
+		blocks = append(blocks, slack.NewSectionBlock(txt, nil, nil))
+	}
+	blocks = append(blocks, CloseButton(lang))
 
-	return slack.MsgOptionBlocks(
-		deployMasterSection,
-		deployBranchSection,
-		deploySection,
-		lockSection,
-		unlockSection,
-		describeLocksSection,
-		CloseButton(),
-	)
+	return slack.MsgOptionBlocks(blocks...)
 }
 
-func (s *SlackListener) projectListMessage() slack.MsgOption {
+func (s *SlackListener) projectListMessage(lang i18n.Lang) slack.MsgOption {
 	text := ""
 	for _, pj := range s.projectList.Items {
 		text = text + fmt.Sprintf("*%s* (%s)\n", pj.ID, pj.GitHubRepository())
 	}
 
 	listText := slack.NewTextBlockObject("mrkdwn", text, false, false)
-	listSection := slack.NewSectionBlock(listText, nil, nil)
+	blocks := []slack.Block{slack.NewSectionBlock(listText, nil, nil)}
+	if len(s.projectList.Errors) > 0 {
+		errText := lang.Text(i18n.InvalidConfigs) + "\n" + strings.Join(s.projectList.Errors, "\n")
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", errText, false, false), nil, nil))
+	}
 
-	return slack.MsgOptionBlocks(
-		listSection,
-		CloseButton(),
-	)
+	return slack.MsgOptionBlocks(append(blocks, CloseButton(lang))...)
 }
 
 // SelectDeployTarget デプロイ対象を選択するボタンを表示する
-func (s *SlackListener) SelectDeployTarget(phase string) slack.MsgOption {
+func (s *SlackListener) SelectDeployTarget(phase string, lang i18n.Lang) slack.MsgOption {
 	headerText := slack.NewTextBlockObject("mrkdwn", ":cat:", false, false)
 	headerSection := slack.NewSectionBlock(headerText, nil, nil)
-	sections := make([]slack.Block, len(s.projectList.Items)+2)
-	sections[0] = headerSection
-	for i, pj := range s.projectList.Items {
-		sections[i+1] = createDeployButtonSection(pj, phase)
+	sections := []slack.Block{headerSection}
+	for _, pj := range s.projectList.Items {
+		// Only the projects that have the phase can be deployed.
+		if pj.FindPhase(phase).None() {
+			continue
+		}
+		sections = append(sections, createDeployButtonSection(pj, phase, lang))
 	}
-	sections[len(sections)-1] = CloseButton()
+	sections = append(sections, CloseButton(lang))
 	return slack.MsgOptionBlocks(sections...)
 }
 
-func createDeployButtonSection(pj DeployProject, phaseName string) *slack.SectionBlock {
-	action := "branchlist"
+// createDeployButtonSection returns the section with the button to deploy the project phase.
+// The button opens the deploy wizard prefilled with the project phase,
+// or requests the default branch right away if the project can't deploy other branches.
+func createDeployButtonSection(pj DeployProject, phaseName string, lang i18n.Lang) *slack.SectionBlock {
+	phase := pj.FindPhase(phaseName)
+	value := wizardActionValue(pj.ID, phase.Name)
 	if pj.DisableBranchDeploy {
-		action = "request"
+		value = fmt.Sprintf("deploy_%s_request|%s", phase.Kind, actionTarget{Project: pj.ID, Phase: phase.Name}.value())
 	}
-	phase := pj.FindPhase(phaseName)
 	txt := slack.NewTextBlockObject("mrkdwn", fmt.Sprintf("*%s* (%s)", pj.ID, pj.GitHubRepository()), false, false)
-	btnTxt := slack.NewTextBlockObject("plain_text", "Deploy", false, false)
-	btn := slack.NewButtonBlockElement("", fmt.Sprintf("deploy_%s_%s|%s_%s", phase.Kind, action, pj.ID, phase.Name), btnTxt)
+	btnTxt := slack.NewTextBlockObject("plain_text", lang.Text(i18n.DeployButton), false, false)
+	btn := slack.NewButtonBlockElement("", value, btnTxt)
 	section := slack.NewSectionBlock(txt, nil, slack.NewAccessory(btn))
 	return section
 }
@@ -279,122 +226,492 @@ func createDeployButtonSection(pj DeployProject, phaseName string) *slack.Sectio
 //
 // triggeredBy is the ID of the Slack user who triggered the command,
 // and replyIn is the ID of the Slack channel to reply to.
-func (s *SlackListener) runCommand(cmd slackcmd.Command, triggeredBy string, replyIn string) error {
-	var msgOpt slack.MsgOption
+// replyOpts are added to the reply, like slack.MsgOptionResponseURL to reply to a slash command ephemerally.
+// They are not added to the deploy requests, which are posted in the channel for the approvers to see their buttons.
+func (s *SlackListener) runCommand(cmd slackcmd.Command, triggeredBy string, replyIn string, replyOpts ...slack.MsgOption) error {
+	var (
+		msgOpt    slack.MsgOption
+		requested bool
+	)
 
 	user := s.userList.FindBySlackUserID(triggeredBy)
+	lang := s.lang(user, replyIn)
 
 	switch cmd := cmd.(type) {
+	case *slackcmd.Help:
+		msgOpt = s.helpMessage(cmd.Command, lang)
+	case *slackcmd.List:
+		msgOpt = s.projectListMessage(lang)
+	case *slackcmd.Reload:
+		if err := s.reload(); err != nil {
+			msgOpt = s.errorMessage(err.Error())
+			break
+		}
+		// The language may have been changed by the reload.
+		msgOpt = s.infoMessage(s.lang(s.userList.FindBySlackUserID(triggeredBy), replyIn).Text(i18n.Reloaded))
+	case *slackcmd.SelectDeployTarget:
+		if phase, err := s.projectList.FindPhaseName(cmd.Env); err != nil {
+			msgOpt = s.errorMessage(err.Error())
+		} else {
+			msgOpt = s.SelectDeployTarget(phase, lang)
+		}
+	case *slackcmd.Wizard:
+		msgOpt = slack.MsgOptionBlocks(openWizardSection(lang))
+	case *slackcmd.DeployBranch:
+		msgOpt = s.deployBranch(cmd, triggeredBy, replyIn)
 	case *slackcmd.Lock:
-		msgOpt = s.lock(cmd, user, replyIn)
+		msgOpt = s.lock(cmd, user, replyIn, lang)
 	case *slackcmd.Unlock:
-		msgOpt = s.unlock(cmd, user, replyIn)
+		msgOpt = s.unlock(cmd, user, lang)
 	case *slackcmd.DescribeLocks:
-		msgOpt = s.describeLocks()
+		msgOpt = s.describeLocks(lang)
+	case *slackcmd.History:
+		msgOpt = s.showHistory(cmd, lang)
+	case *slackcmd.Rollback:
+		msgOpt, requested = s.rollback(cmd, user, triggeredBy, replyIn, lang)
+	case *slackcmd.Queue:
+		msgOpt = s.queue(cmd, user, triggeredBy, replyIn, lang)
+	case *slackcmd.LeaveQueue:
+		msgOpt = s.leaveQueue(cmd, user, lang)
+	case *slackcmd.Override:
+		msgOpt = s.override(cmd, user, lang)
+	case *slackcmd.Tags:
+		msgOpt = s.tags(cmd, lang)
+	case *slackcmd.Deploy:
+		if cmd.Tag == "" && cmd.Commit == "" {
+			msgOpt, requested = s.deployDefaultBranch(cmd, triggeredBy, replyIn, lang)
+		} else {
+			msgOpt, requested = s.deployTag(cmd, user, triggeredBy, replyIn, lang)
+		}
 	default:
 		panic("unreachable")
 	}
 
-	if _, _, err := s.client.PostMessage(replyIn, msgOpt); err != nil {
-		log.Println("[ERROR] ", err)
+	if msgOpt == nil {
+		return nil
 	}
+	if requested {
+		replyOpts = nil
+	}
+	s.reply(replyIn, msgOpt, replyOpts...)
 
 	return nil
 }
 
+// blocksMessage returns the reply with the blocks returned by a DeployUsecase,
+// or nil if there's nothing to reply as the deployment is reported in its own thread.
+func (s *SlackListener) blocksMessage(blocks []slack.Block) slack.MsgOption {
+	if len(blocks) == 0 {
+		return nil
+	}
+	return slack.MsgOptionBlocks(blocks...)
+}
+
+// reply posts the reply to a command to the channel.
+func (s *SlackListener) reply(channel string, msgOpt slack.MsgOption, replyOpts ...slack.MsgOption) {
+	if _, _, err := s.client.PostMessage(channel, append([]slack.MsgOption{msgOpt}, replyOpts...)...); err != nil {
+		log.Println("[ERROR] ", err)
+	}
+}
+
 // lock locks the given project and environment, and replies to the given channel.
-func (s *SlackListener) lock(cmd *slackcmd.Lock, triggeredBy User, replyIn string) slack.MsgOption {
-	if err := s.validateProjectEnvUser(cmd.Project, cmd.Env, triggeredBy, replyIn); err != nil {
+func (s *SlackListener) lock(cmd *slackcmd.Lock, triggeredBy User, replyIn string, lang i18n.Lang) slack.MsgOption {
+	pj, phase, err := s.validateProjectEnvUser(cmd.Project, cmd.Env, triggeredBy, lang)
+	if err != nil {
 		return s.errorMessage(err.Error())
 	}
 
-	if err := s.coordinator.Lock(context.Background(), cmd.Project, cmd.Env, triggeredBy.SlackDisplayName, cmd.Reason); err != nil {
+	opts := []deploy.LockOption{deploy.WithChannel(replyIn)}
+
+	// The expiry is computed with the coordinator's clock so that it is consistent with the expiry checks.
+	var expiresAt time.Time
+	if cmd.Duration > 0 {
+		expiresAt = s.coordinator.Now().Add(cmd.Duration)
+	} else if !cmd.Until.IsZero() {
+		if !cmd.Until.After(s.coordinator.Now().Time) {
+			return s.errorMessage(lang.Sprintf(i18n.LockExpiryInPast, cmd.Until.Format("2006-01-02 15:04")))
+		}
+		expiresAt = cmd.Until
+	}
+	if !expiresAt.IsZero() {
+		opts = append(opts, deploy.WithExpiry(metav1.NewTime(expiresAt)))
+	}
+
+	if err := s.coordinator.Lock(context.Background(), pj.ID, phase, triggeredBy.SlackDisplayName, cmd.Reason, opts...); err != nil {
 		return s.errorMessage(err.Error())
 	}
 
-	return s.infoMessage(fmt.Sprintf("Locked %s %s", cmd.Project, cmd.Env))
+	if !expiresAt.IsZero() {
+		return s.infoMessage(lang.Sprintf(i18n.LockedUntil, pj.ID, phase, expiresAt.Format("2006-01-02 15:04")))
+	}
+
+	return s.infoMessage(lang.Sprintf(i18n.Locked, pj.ID, phase))
 }
 
 // unlock unlocks the given project and environment, and replies to the given channel.
-func (s *SlackListener) unlock(cmd *slackcmd.Unlock, triggeredBy User, replyIn string) slack.MsgOption {
-	if err := s.validateProjectEnvUser(cmd.Project, cmd.Env, triggeredBy, replyIn); err != nil {
+func (s *SlackListener) unlock(cmd *slackcmd.Unlock, triggeredBy User, lang i18n.Lang) slack.MsgOption {
+	pj, phase, err := s.validateProjectEnvUser(cmd.Project, cmd.Env, triggeredBy, lang)
+	if err != nil {
+		return s.errorMessage(err.Error())
+	}
+
+	if err := s.coordinator.Unlock(context.Background(), pj.ID, phase, triggeredBy.SlackDisplayName, triggeredBy.IsAdmin()); err != nil {
+		return s.errorMessage(err.Error())
+	}
+
+	return s.infoMessage(lang.Sprintf(i18n.Unlocked, pj.ID, phase))
+}
+
+// queue adds the user to the queue of the given project and environment, and replies to the given channel.
+func (s *SlackListener) queue(cmd *slackcmd.Queue, triggeredBy User, triggeredByID string, replyIn string, lang i18n.Lang) slack.MsgOption {
+	pj, phase, err := s.validateProjectEnvUser(cmd.Project, cmd.Env, triggeredBy, lang)
+	if err != nil {
+		return s.errorMessage(err.Error())
+	}
+
+	pos, err := s.coordinator.Enqueue(context.Background(), pj.ID, phase, deploy.QueueItem{
+		User:    triggeredBy.SlackDisplayName,
+		UserID:  triggeredByID,
+		Branch:  cmd.Branch,
+		Channel: replyIn,
+	})
+	if errors.Is(err, deploy.ErrNotLocked) {
+		return s.errorMessage(lang.Sprintf(i18n.NotLocked, pj.ID, phase, pj.ID, phase))
+	} else if err != nil {
+		return s.errorMessage(err.Error())
+	}
+
+	return s.infoMessage(lang.Sprintf(i18n.Queued, pj.ID, phase, pos))
+}
+
+// leaveQueue removes the user from the queue of the given project and environment, and replies to the given channel.
+func (s *SlackListener) leaveQueue(cmd *slackcmd.LeaveQueue, triggeredBy User, lang i18n.Lang) slack.MsgOption {
+	pj, phase, err := s.validateProjectEnvUser(cmd.Project, cmd.Env, triggeredBy, lang)
+	if err != nil {
 		return s.errorMessage(err.Error())
 	}
 
-	if err := s.coordinator.Unlock(context.Background(), cmd.Project, cmd.Env, triggeredBy.SlackDisplayName, triggeredBy.IsAdmin()); err != nil {
+	if err := s.coordinator.Dequeue(context.Background(), pj.ID, phase, triggeredBy.SlackDisplayName); err != nil {
 		return s.errorMessage(err.Error())
 	}
 
-	return s.infoMessage(fmt.Sprintf("Unlocked %s %s", cmd.Project, cmd.Env))
+	return s.infoMessage(lang.Sprintf(i18n.LeftQueue, pj.ID, phase))
+}
+
+// override allows deployments into the given project and environment regardless of the deploy schedule,
+// and records it to the history.
+func (s *SlackListener) override(cmd *slackcmd.Override, triggeredBy User, lang i18n.Lang) slack.MsgOption {
+	if !triggeredBy.IsAdmin() {
+		return s.errorMessage(lang.Sprintf(i18n.OverrideForbidden, triggeredBy.SlackDisplayName))
+	}
+
+	pj, err := s.projectList.FindByAlias(cmd.Project)
+	if err != nil {
+		return s.errorMessage(err.Error())
+	}
+
+	phase, err := s.toPhase(pj, cmd.Env)
+	if err != nil {
+		return s.errorMessage(err.Error())
+	}
+
+	until := cmd.Until
+	if cmd.Duration > 0 {
+		until = time.Now().Add(cmd.Duration)
+	}
+	if !until.After(time.Now()) {
+		return s.errorMessage(lang.Sprintf(i18n.OverrideExpiryInPast, until.Format("2006-01-02 15:04")))
+	}
+
+	s.guard.Override(pj.ID, phase, ScheduleOverride{User: triggeredBy.SlackDisplayName, Reason: cmd.Reason, Until: until})
+	recordEvent(s.history, deploy.Event{
+		Project: pj.ID,
+		Phase:   phase,
+		Action:  deploy.EventActionOverride,
+		User:    triggeredBy.SlackDisplayName,
+		Result:  deploy.EventResultSuccess,
+		Message: fmt.Sprintf("until %s: %s", until.Format("2006-01-02 15:04"), cmd.Reason),
+	})
+
+	return s.infoMessage(lang.Sprintf(i18n.Overridden, pj.ID, phase, until.Format("2006-01-02 15:04")))
+}
+
+// handover pings the user who got the lock from the queue,
+// and asks for the deployment with the usual interactor Request blocks.
+//
+// This is called back by the coordinator via deploy.Coordinator.OnHandover.
+func (s *SlackListener) handover(ctx context.Context, h deploy.Handover) {
+	if h.Channel == "" {
+		return
+	}
+
+	msg := s.lang(s.userList.FindBySlackUserID(h.UserID), h.Channel).Sprintf(i18n.Handover, h.UserID, h.Project, h.Environment, h.ExpiresAt.Format("2006-01-02 15:04"))
+	if _, _, err := s.client.PostMessage(h.Channel, s.infoMessage(msg)); err != nil {
+		log.Println("[ERROR] ", err)
+	}
+
+	pj, err := s.projectList.FindByAlias(h.Project)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		return
+	}
+
+	branch := h.Branch
+	if branch == "" {
+		branch = pj.DefaultBranch()
+	}
+
+	blocks, err := s.interactorFactory.Get(pj, h.Environment).Request(pj, h.Environment, branch, h.UserID, h.Channel)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		lang := s.lang(s.userList.FindBySlackUserID(h.UserID), h.Channel)
+		if _, _, err := s.client.PostMessage(h.Channel, s.errorMessage(errorText(lang, err))); err != nil {
+			log.Println("[ERROR] ", err)
+		}
+		return
+	}
+	if len(blocks) == 0 {
+		return
+	}
+
+	if _, _, err := s.client.PostMessage(h.Channel, slack.MsgOptionBlocks(blocks...)); err != nil {
+		log.Println("[ERROR] ", err)
+	}
 }
 
 // describeLocks describes the locks of all projects and environments, and replies to the given channel.
-func (s *SlackListener) describeLocks() slack.MsgOption {
+func (s *SlackListener) describeLocks(lang i18n.Lang) slack.MsgOption {
 	projects, err := s.coordinator.DescribeLocks(context.Background())
 	if err != nil {
 		return s.errorMessage(err.Error())
 	}
 
-	msg := deploy.FormatProjectDescs(projects)
+	msg := deploy.FormatProjectDescs(projects, lang)
 
 	return s.infoMessage(msg)
 }
 
-func (s *SlackListener) checkDeploymentLock(projectID, env string, triggeredBy string, replyIn string) (slack.MsgOption, bool) {
-	locks, err := s.coordinator.FetchLocks(context.Background(), projectID, env)
+// showHistory describes the last deploy events of the given project and environment.
+func (s *SlackListener) showHistory(cmd *slackcmd.History, lang i18n.Lang) slack.MsgOption {
+	if s.history == nil {
+		return s.errorMessage(lang.Text(i18n.HistoryNotConfigured))
+	}
+
+	pj, err := s.projectList.FindByAlias(cmd.Project)
+	if err != nil {
+		return s.errorMessage(err.Error())
+	}
+
+	phase, err := s.toPhase(pj, cmd.Env)
+	if err != nil {
+		return s.errorMessage(err.Error())
+	}
+	events, err := s.history.List(context.Background(), pj.ID, phase, cmd.Limit)
+	if err != nil {
+		return s.errorMessage(err.Error())
+	}
+
+	return s.infoMessage(fmt.Sprintf("*%s %s*\n%s", pj.ID, phase, deploy.FormatEvents(events, lang)))
+}
+
+// rollback deploys the previous tag, or the tag specified in the command, of the given project and environment.
+//
+// GitOps projects get the usual approve and reject buttons for the rollback pull request,
+// whereas the other kinds of projects are rolled back right away unless the phase has the approval policy.
+func (s *SlackListener) rollback(cmd *slackcmd.Rollback, user User, triggeredBy string, replyIn string, lang i18n.Lang) (slack.MsgOption, bool) {
+	if !user.IsDeveloper() {
+		return s.errorMessage(lang.Sprintf(i18n.RollbackForbidden, user.SlackDisplayName)), false
+	}
+
+	pj, err := s.projectList.FindByAlias(cmd.Project)
+	if err != nil {
+		return s.errorMessage(err.Error()), false
+	}
+
+	phase, err := s.toPhase(pj, cmd.Env)
+	if err != nil {
+		return s.errorMessage(err.Error()), false
+	}
+
+	interactor, ok := s.interactorFactory.Get(pj, phase).(RollbackUsecase)
+	if !ok {
+		return s.errorMessage(lang.Sprintf(i18n.RollbackUnsupported, pj.FindPhase(phase).Kind)), false
+	}
+
+	blocks, err := interactor.Rollback(pj, phase, cmd.Tag, triggeredBy, replyIn)
 	if err != nil {
 		log.Println("[ERROR] ", err)
-		if _, _, err := s.client.PostMessage(replyIn, s.infoMessage(err.Error())); err != nil {
-			log.Println("[ERROR] ", err)
-		}
-		return nil, false
+		return s.errorMessage(errorText(lang, err)), false
+	}
+
+	return s.blocksMessage(blocks), true
+}
+
+// deployDefaultBranch asks for the approval to deploy the default branch of the given project and environment.
+func (s *SlackListener) deployDefaultBranch(cmd *slackcmd.Deploy, triggeredBy string, replyIn string, lang i18n.Lang) (slack.MsgOption, bool) {
+	pj, err := s.projectList.FindByAlias(cmd.Project)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		return s.errorMessage(err.Error()), false
+	}
+
+	phase, err := s.toPhase(pj, cmd.Env)
+	if err != nil {
+		return s.errorMessage(err.Error()), false
+	}
+	interactor := s.interactorFactory.Get(pj, phase)
+	blocks, err := interactor.Request(pj, phase, pj.DefaultBranch(), triggeredBy, replyIn)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		return s.errorMessage(errorText(lang, err)), false
+	}
+
+	return s.blocksMessage(blocks), true
+}
+
+// deployBranch shows the branches of the given project to select the one to deploy.
+func (s *SlackListener) deployBranch(cmd *slackcmd.DeployBranch, triggeredBy string, replyIn string) slack.MsgOption {
+	pj, err := s.projectList.FindByAlias(cmd.Project)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		return s.errorMessage(err.Error())
 	}
 
-	if len(locks) == 0 {
-		// Missing lock means it has never been locked.
-		return nil, false
+	phase, err := s.toPhase(pj, cmd.Env)
+	if err != nil {
+		return s.errorMessage(err.Error())
+	}
+	interactor := s.interactorFactory.Get(pj, phase)
+	blocks, err := interactor.BranchList(pj, phase, triggeredBy, replyIn)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		return s.errorMessage(err.Error())
 	}
 
-	lock, ok := locks[projectID][env]
+	return slack.MsgOptionBlocks(blocks...)
+}
+
+// deployTag asks for the approval to deploy the tag, or the image built from the commit, of the given project and environment.
+// The tag is validated against the image registry before the approve button is shown.
+func (s *SlackListener) deployTag(cmd *slackcmd.Deploy, user User, triggeredBy string, replyIn string, lang i18n.Lang) (slack.MsgOption, bool) {
+	if !user.IsDeveloper() {
+		return s.errorMessage(lang.Sprintf(i18n.DeployForbidden, user.SlackDisplayName)), false
+	}
+
+	pj, err := s.projectList.FindByAlias(cmd.Project)
+	if err != nil {
+		return s.errorMessage(err.Error()), false
+	}
+
+	phase, err := s.toPhase(pj, cmd.Env)
+	if err != nil {
+		return s.errorMessage(err.Error()), false
+	}
+
+	interactor, ok := s.interactorFactory.Get(pj, phase).(TagDeployUsecase)
 	if !ok {
-		// Missing lock means it has never been locked.
-		return nil, false
+		return s.errorMessage(lang.Sprintf(i18n.TagDeployUnsupported, pj.FindPhase(phase).Kind)), false
 	}
 
-	user := s.userList.FindBySlackUserID(triggeredBy)
+	tag := cmd.Tag
+	if cmd.Commit != "" {
+		tag, err = findImageTagByCommit(pj, phase, cmd.Commit)
+	} else {
+		_, err = findImageByTag(pj, phase, tag)
+	}
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		return s.errorMessage(err.Error()), false
+	}
 
-	if lock.Locked && lock.LockHistory[len(lock.LockHistory)-1].User != user.SlackDisplayName {
-		cause := fmt.Sprintf("locked by %s", lock.LockHistory[len(lock.LockHistory)-1].User)
-		return s.infoMessage(fmt.Sprintf("Deployment failed: %s", cause)), true
+	blocks, err := interactor.RequestTag(pj, phase, tag, triggeredBy, replyIn)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		return s.errorMessage(errorText(lang, err)), false
 	}
 
-	return nil, false
+	return s.blocksMessage(blocks), true
 }
 
-func (s *SlackListener) validateProjectEnvUser(projectID, env string, user User, replyIn string) error {
-	pj, err := s.projectList.FindByAlias(projectID)
+// maxTagCandidates is the maximum number of image tags listed by the tags command.
+const maxTagCandidates = 10
+
+// tags lists the image tags that can be deployed for the branch of the given project,
+// marking the one that would be deployed.
+func (s *SlackListener) tags(cmd *slackcmd.Tags, lang i18n.Lang) slack.MsgOption {
+	pj, err := s.projectList.FindByAlias(cmd.Project)
+	if err != nil {
+		return s.errorMessage(err.Error())
+	}
+	if pj.DockerRepository() == "" {
+		return s.errorMessage(lang.Sprintf(i18n.NoDockerRegistry, pj.ID))
+	}
+
+	branch := cmd.Branch
+	if branch == "" {
+		branch = pj.DefaultBranch()
+	}
+
+	candidates, err := findImageCandidates(pj, ImageTagVars{Branch: branch})
 	if err != nil {
 		log.Println("[ERROR] ", err)
-		if _, _, err := s.client.PostMessage(replyIn, s.errorMessage(err.Error())); err != nil {
-			log.Println("[ERROR] ", err)
+		return s.errorMessage(err.Error())
+	}
+
+	return s.infoMessage(fmt.Sprintf("*%s %s* (%s)\n%s", pj.ID, branch, pj.TagSelectionStrategy(), formatImageCandidates(candidates, pj.TagSelectionStrategy(), maxTagCandidates, lang)))
+}
+
+// formatImageCandidates formats up to limit candidates, one per line, with the push time and the digest.
+func formatImageCandidates(candidates []ImageCandidate, strategy TagSelectionStrategy, limit int, lang i18n.Lang) string {
+	if len(candidates) == 0 {
+		return lang.Text(i18n.NoImageTags)
+	}
+
+	var lines []string
+	for i, c := range candidates {
+		if i == limit {
+			lines = append(lines, lang.Sprintf(i18n.MoreImageTags, len(candidates)-limit))
+			break
 		}
-		return fmt.Errorf("find by alias %q: %w", projectID, err)
+
+		pushedAt := lang.Text(i18n.UnknownPushedAt)
+		if !c.PushedAt.IsZero() {
+			pushedAt = c.PushedAt.Local().Format("2006-01-02 15:04")
+		}
+		line := lang.Sprintf(i18n.ImageTagPushedAt, c.Tag, pushedAt, truncate(c.Digest, len("sha256:")+12))
+		if i == 0 && (strategy != TagSelectionExact || len(candidates) == 1) {
+			line += lang.Text(i18n.ImageTagToBeDeployed)
+		}
+		lines = append(lines, line)
 	}
 
-	if phase := pj.FindPhase(env); phase.None() {
-		err = fmt.Errorf("phase %s is not found", env)
+	if strategy == TagSelectionExact && len(candidates) > 1 {
+		lines = append(lines, lang.Sprintf(i18n.TooManyImagesForExact, len(candidates), strategy))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validateProjectEnvUser validates the project, the env and the role of the user for the lock and queue commands,
+// and returns the project and the name of the phase of the env.
+// The locks and the queues are keyed by the ID of the project, as the deploy guard checks them by the ID.
+func (s *SlackListener) validateProjectEnvUser(projectID, env string, user User, lang i18n.Lang) (DeployProject, string, error) {
+	pj, err := s.projectList.FindByAlias(projectID)
+	if err != nil {
 		log.Println("[ERROR] ", err)
-		if _, _, err := s.client.PostMessage(replyIn, s.errorMessage(err.Error())); err != nil {
-			log.Println("[ERROR] ", err)
-		}
-		return fmt.Errorf("find phase %q: %w", env, err)
+		return DeployProject{}, "", err
+	}
+
+	phase, err := s.toPhase(pj, env)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		return DeployProject{}, "", err
 	}
 
 	if !user.IsDeveloper() {
-		return fmt.Errorf("you are not allowed to lock/unlock projects: %q is missing the Developer role", user.SlackDisplayName)
+		return DeployProject{}, "", errors.New(lang.Sprintf(i18n.LockForbidden, user.SlackDisplayName))
 	}
 
-	return nil
+	return pj, phase, nil
 }
 
 func (s *SlackListener) infoMessage(message string) slack.MsgOption {
@@ -409,15 +726,11 @@ func (s *SlackListener) errorMessage(message string) slack.MsgOption {
 	return slack.MsgOptionBlocks(section)
 }
 
-func (s *SlackListener) toPhase(str string) string {
-	switch str {
-	case "pro", "prd", "production":
-		return "production"
-	case "stg", "staging":
-		return "staging"
-	case "sandbox":
-		return "sandbox"
-	default:
-		return "staging"
+// toPhase returns the name of the phase of the project that has the name or the alias env.
+func (s *SlackListener) toPhase(pj DeployProject, env string) (string, error) {
+	phase, err := pj.FindPhaseByAlias(env)
+	if err != nil {
+		return "", err
 	}
+	return phase.Name, nil
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/slack-go/slack"
+)
+
+// SlackClient is the part of the Slack Web API used by gocat.
+//
+// It lets SlackListener, interactionHandler, the interactors, AutoDeploy and UserList be tested
+// with a fake that records the messages instead of calling Slack.
+// Use NewSlackClient to get the one calling the real API.
+type SlackClient interface {
+	slackMessenger
+
+	GetUsers(options ...slack.GetUsersOption) ([]slack.User, error)
+
+	// Respond replies to an interaction or a slash command by posting the message to its response URL,
+	// which replaces or deletes the original message if the message says so.
+	Respond(responseURL string, msg slack.Message) error
+
+	OpenView(triggerID string, view slack.ModalViewRequest) (*slack.ViewResponse, error)
+	PublishView(userID string, view slack.HomeTabViewRequest, hash string) (*slack.ViewResponse, error)
+}
+
+// NewSlackClient returns the SlackClient calling the Slack API with the client.
+func NewSlackClient(client *slack.Client) SlackClient {
+	return slackWebClient{client}
+}
+
+type slackWebClient struct {
+	*slack.Client
+}
+
+func (c slackWebClient) Respond(responseURL string, msg slack.Message) error {
+	// The mentions like <@U1234> are kept as they are rather than escaped.
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(msg); err != nil {
+		return err
+	}
+
+	resp, err := http.Post(responseURL, "application/json", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("[ERROR] The response URL responded %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSlackCall is a call to the Slack API recorded by fakeSlackClient.
+type fakeSlackCall struct {
+	method string
+	// channel is the channel of the message, the user of the view, or the response URL.
+	channel  string
+	ts       string
+	threadTS string
+	// responseURL is the response URL the message is posted to instead of the channel, like the ephemeral replies.
+	responseURL string
+	// texts are the texts of the sections and the titles of the attachments.
+	texts []string
+	// buttons are the values of the buttons in the sections and the action blocks.
+	buttons []string
+}
+
+// fakeSlackClient is a SlackClient that records the calls instead of calling the Slack API.
+type fakeSlackClient struct {
+	users []slack.User
+
+	mu    sync.Mutex
+	calls []fakeSlackCall
+}
+
+func (f *fakeSlackClient) PostMessage(channelID string, options ...slack.MsgOption) (string, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ts := fmt.Sprintf("1000.%04d", len(f.calls))
+	f.calls = append(f.calls, f.record("chat.postMessage", channelID, "", options))
+	return channelID, ts, nil
+}
+
+func (f *fakeSlackClient) UpdateMessage(channelID, timestamp string, options ...slack.MsgOption) (string, string, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, f.record("chat.update", channelID, timestamp, options))
+	return channelID, timestamp, "", nil
+}
+
+func (f *fakeSlackClient) GetUsers(options ...slack.GetUsersOption) ([]slack.User, error) {
+	return f.users, nil
+}
+
+func (f *fakeSlackClient) Respond(responseURL string, msg slack.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	call := fakeSlackCall{method: "response_url", channel: responseURL, texts: blockTexts(msg.Blocks.BlockSet)}
+	if msg.Text != "" {
+		call.texts = append(call.texts, msg.Text)
+	}
+	f.calls = append(f.calls, call)
+	return nil
+}
+
+func (f *fakeSlackClient) OpenView(triggerID string, view slack.ModalViewRequest) (*slack.ViewResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, fakeSlackCall{method: "views.open", channel: triggerID})
+	return &slack.ViewResponse{}, nil
+}
+
+func (f *fakeSlackClient) PublishView(userID string, view slack.HomeTabViewRequest, hash string) (*slack.ViewResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, fakeSlackCall{method: "views.publish", channel: userID, texts: blockTexts(view.Blocks.BlockSet)})
+	return &slack.ViewResponse{}, nil
+}
+
+func (f *fakeSlackClient) record(method string, channel string, ts string, options []slack.MsgOption) fakeSlackCall {
+	endpoint, values, _ := slack.UnsafeApplyMsgOptions("", channel, "", options...)
+	call := fakeSlackCall{method: method, channel: channel, ts: ts, threadTS: values.Get("thread_ts")}
+	if endpoint != "chat.postMessage" {
+		call.responseURL = endpoint
+	}
+
+	var blocks []block
+	_ = json.Unmarshal([]byte(values.Get("blocks")), &blocks)
+	for _, b := range blocks {
+		if b.Type == "section" {
+			call.texts = append(call.texts, b.Text.Text)
+		}
+	}
+	type button struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	var buttons []struct {
+		Accessory *button  `json:"accessory"`
+		Elements  []button `json:"elements"`
+	}
+	_ = json.Unmarshal([]byte(values.Get("blocks")), &buttons)
+	for _, b := range buttons {
+		elements := b.Elements
+		if b.Accessory != nil {
+			elements = append(elements, *b.Accessory)
+		}
+		for _, e := range elements {
+			if e.Type == "button" {
+				call.buttons = append(call.buttons, e.Value)
+			}
+		}
+	}
+	var attachments []slack.Attachment
+	_ = json.Unmarshal([]byte(values.Get("attachments")), &attachments)
+	for _, a := range attachments {
+		call.texts = append(call.texts, a.Title)
+	}
+	if text := values.Get("text"); text != "" {
+		call.texts = append(call.texts, text)
+	}
+	return call
+}
+
+// Calls returns the calls recorded so far.
+func (f *fakeSlackClient) Calls() []fakeSlackCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]fakeSlackCall{}, f.calls...)
+}
+
+// blockTexts returns the texts of the section blocks.
+func blockTexts(blocks []slack.Block) (texts []string) {
+	for _, b := range blocks {
+		if s, ok := b.(*slack.SectionBlock); ok && s.Text != nil {
+			texts = append(texts, s.Text.Text)
+		}
+	}
+	return texts
+}
+
+func TestSlackClientRespond(t *testing.T) {
+	var got map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &got)
+		if got["text"] == "fail" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	client := NewSlackClient(slack.New("token"))
+
+	msg := slack.NewBlockMessage(slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", "deployed", false, false), nil, nil))
+	msg.ReplaceOriginal = true
+	require.NoError(t, client.Respond(ts.URL, msg))
+	require.Equal(t, true, got["replace_original"])
+	require.Len(t, got["blocks"], 1)
+
+	require.Error(t, client.Respond(ts.URL, slack.Message{Msg: slack.Msg{Text: "fail"}}))
+}
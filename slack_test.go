@@ -16,11 +16,14 @@ import (
 	"github.com/slack-go/slack/slacktest"
 	"github.com/stretchr/testify/require"
 	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+	"github.com/zaiminc/gocat/slackcmd"
 
 	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 var project1ConfigMap = corev1.ConfigMap{
@@ -180,9 +183,9 @@ func TestSlackLockUnlock(t *testing.T) {
 	}))
 	defer ghts.Close()
 
-	s := slack.New("no-need-to-use-a-token-because-we-are-using-a-fake-server",
+	s := NewSlackClient(slack.New("no-need-to-use-a-token-because-we-are-using-a-fake-server",
 		slack.OptionAPIURL(ts.GetAPIURL()),
-	)
+	))
 
 	// You usually do:
 	//   config, err := InitConfig()
@@ -211,12 +214,10 @@ func TestSlackLockUnlock(t *testing.T) {
 		os.Getenv("GOCAT_GITROOT"),
 	)
 
-	userList := UserList{github: gh, slackClient: s}
-	// Set LOCAL so that NewProjectList uses the local kubeconfig
-	local := os.Getenv("LOCAL")
-	os.Setenv("LOCAL", "true")
-	defer os.Setenv("LOCAL", local)
-	projectList := NewProjectList()
+	source := NewKubernetesConfigSource(k, ns)
+	userList := UserList{github: gh, slackClient: s, source: source}
+	projectList := NewProjectList(source)
+	coordinator := deploy.NewCoordinator(k, ns, "deploylocks")
 	interactorContext := InteractorContext{
 		projectList: &projectList,
 		userList:    &userList,
@@ -224,16 +225,16 @@ func TestSlackLockUnlock(t *testing.T) {
 		git:         git,
 		client:      s,
 		config:      *config,
+		guard:       NewDeployGuard(coordinator, &userList, &projectList),
 	}
 	interactorFactory := NewInteractorFactory(interactorContext)
 
 	var l = &SlackListener{
 		client:            s,
-		verificationToken: "token",
 		projectList:       &projectList,
 		userList:          &userList,
 		interactorFactory: &interactorFactory,
-		coordinator:       deploy.NewCoordinator(ns, "deploylocks"),
+		coordinator:       coordinator,
 	}
 
 	require.NoError(t, l.handleMessageEvent(&slackevents.AppMentionEvent{
@@ -244,41 +245,101 @@ func TestSlackLockUnlock(t *testing.T) {
 	require.Equal(t, []string{
 		"*masterのデプロイ*\n" +
 			"`@bot-name deploy api staging`\n" +
-			"apiの部分はその他アプリケーションに置換可能です。stagingの部分はproductionやsandboxに置換可能です。\n" +
+			"apiの部分はその他アプリケーションに置換可能です。stagingの部分はプロジェクトに設定されたフェーズ名や別名(stgなど)に置換可能です。\n" +
 			"コマンド入力後にデプロイするかの確認ボタンが出てきます。",
 		"*ブランチのデプロイ*\n" +
 			"`@bot-name deploy api staging branch`\n" +
-			"apiの部分はその他アプリケーションに置換可能です。stagingの部分はproductionやsandboxに置換可能です。\n" +
+			"apiの部分はその他アプリケーションに置換可能です。stagingの部分はプロジェクトに設定されたフェーズ名や別名(stgなど)に置換可能です。\n" +
 			"ブランチを選択するドロップダウンが出てきます。\n" +
 			"ブランチ選択後にデプロイするかの確認ボタンが出てきます。",
+		"*タグやコミットを指定したデプロイ*\n" +
+			"`@bot-name deploy api staging tag TAG`\n" +
+			"`@bot-name deploy api staging commit SHA`\n" +
+			"apiの部分はその他アプリケーションに置換可能です。stagingの部分はプロジェクトに設定されたフェーズ名や別名(stgなど)に置換可能です。\n" +
+			"イメージレジストリにタグやコミットのイメージがあることを確認した後にデプロイするかの確認ボタンが出てきます。",
 		"*デプロイ対象の選択をSlackのUIから選択するデプロイ手法*\n" +
-			"`@bot-name deploy staging`\nstagingの部分はproductionやsandboxに置換可能です。\n" +
-			"デプロイ対象の選択後にデプロイするブランチの選択肢が出てきます。",
+			"`@bot-name deploy staging`\nstagingの部分はプロジェクトに設定されたフェーズ名や別名(stgなど)に置換可能です。\n" +
+			"デプロイ対象を選択すると、ブランチやタグを入力するデプロイウィザードが開きます。",
+		"*デプロイウィザードを開く*\n" +
+			"`@bot-name wizard`\n" +
+			"プロジェクト、フェーズ、ブランチやタグ、理由を入力してデプロイするモーダルを開くボタンが出てきます。\n" +
+			"ブランチは名前で検索できます。",
 		"*デプロイロックをとる*\n" +
 			"`@bot-name lock api staging for REASON`\n" +
-			"apiの部分はその他アプリケーションに置換可能です。stagingの部分はproductionやsandboxに置換可能です。\n" +
-			"REASON部分にロックする理由を指定する必要があります。",
+			"apiの部分はその他アプリケーションに置換可能です。stagingの部分はプロジェクトに設定されたフェーズ名や別名(stgなど)に置換可能です。\n" +
+			"REASON部分にロックする理由を指定する必要があります。\n" +
+			"`for 2h because REASON` や `until 2026-10-20 18:00 because REASON` のように指定すると、期限が来たときに自動でロックが解除されます。",
 		"*デプロイロックを解除する*\n" +
 			"`@bot-name unlock api staging`\n" +
-			"apiの部分はその他アプリケーションに置換可能です。stagingの部分はproductionやsandboxに置換可能です。",
+			"apiの部分はその他アプリケーションに置換可能です。stagingの部分はプロジェクトに設定されたフェーズ名や別名(stgなど)に置換可能です。",
 		"*デプロイロックの状態を確認する*\n" +
 			"`@bot-name describe locks`\n" +
 			"デプロイロックの状態を確認します。",
+		"*デプロイ履歴を確認する*\n" +
+			"`@bot-name history api staging 10`\n" +
+			"apiの部分はその他アプリケーションに置換可能です。stagingの部分はプロジェクトに設定されたフェーズ名や別名(stgなど)に置換可能です。\n" +
+			"新しい順に最大10件(省略時)の履歴を表示します。",
+		"*ロールバックする*\n" +
+			"`@bot-name rollback api staging`\n" +
+			"apiの部分はその他アプリケーションに置換可能です。stagingの部分はプロジェクトに設定されたフェーズ名や別名(stgなど)に置換可能です。\n" +
+			"現在の一つ前にデプロイされていたタグをデプロイします。`to TAG` を付けるとタグを指定できます。",
+		"*ロック解除を待ってデプロイする*\n" +
+			"`@bot-name queue deploy api staging BRANCH`\n" +
+			"apiの部分はその他アプリケーションに置換可能です。stagingの部分はプロジェクトに設定されたフェーズ名や別名(stgなど)に置換可能です。BRANCHは省略可能です。\n" +
+			"ロックが解除されると順番にロックが引き継がれ、デプロイするかの確認ボタンが出てきます。",
+		"*デプロイの待ち行列から抜ける*\n" +
+			"`@bot-name leave queue api staging`\n" +
+			"apiの部分はその他アプリケーションに置換可能です。stagingの部分はプロジェクトに設定されたフェーズ名や別名(stgなど)に置換可能です。\n" +
+			"`queue deploy` で入った待ち行列から抜けます。",
+		"*デプロイ可能時間外やフリーズ期間中にデプロイを許可する(管理者のみ)*\n" +
+			"`@bot-name override api production for 2h because REASON`\n" +
+			"apiの部分はその他アプリケーションに置換可能です。productionの部分はプロジェクトに設定されたフェーズ名や別名(prdなど)に置換可能です。\n" +
+			"`until 2026-10-20 18:00 because REASON` のように期限を時刻で指定することもできます。許可した記録は履歴に残ります。",
+		"*デプロイされるイメージタグを確認する*\n" +
+			"`@bot-name tags api BRANCH`\n" +
+			"apiの部分はその他アプリケーションに置換可能です。BRANCHは省略可能で、省略時はデフォルトブランチになります。\n" +
+			"デプロイ候補のタグをプッシュ日時とダイジェストとともに優先順に表示します。",
+		"*デプロイ対象のプロジェクトを一覧する*\n" +
+			"`@bot-name ls`\n" +
+			"プロジェクトとGitHubリポジトリの一覧を表示します。",
+		"*プロジェクトとユーザーを再読み込みする*\n" +
+			"`@bot-name reload`\n" +
+			"ConfigMapからプロジェクトとユーザーの設定を読み込み直します。",
+		"*使い方を表示する*\n" +
+			"`@bot-name help`\n" +
+			"`@bot-name help deploy`\n" +
+			"コマンドを指定するとそのコマンドの使い方だけを表示します。",
 	}, nextMessage().Text())
 
+	require.NoError(t, l.handleMessageEvent(&slackevents.AppMentionEvent{
+		User:    "U1234",
+		Channel: "C1234",
+		Text:    "<@U0LAN0Z89> help unlock",
+	}))
+	require.Equal(t, "*デプロイロックを解除する*\n"+
+		"`@bot-name unlock api staging`\n"+
+		"apiの部分はその他アプリケーションに置換可能です。stagingの部分はプロジェクトに設定されたフェーズ名や別名(stgなど)に置換可能です。", nextMessage().Text())
+
+	require.NoError(t, l.handleMessageEvent(&slackevents.AppMentionEvent{
+		User:    "U1234",
+		Channel: "C1234",
+		Text:    "<@U0LAN0Z89> the tools are false",
+	}))
+	require.Equal(t, "コマンドが正しくありません。`@bot help` で使い方を確認してください", nextMessage().Text())
+
 	require.NoError(t, l.handleMessageEvent(&slackevents.AppMentionEvent{
 		User:    "U1234",
 		Channel: "C1234",
 		Text:    "<@U0LAN0Z89> lock myproject1 production",
 	}))
-	require.Equal(t, "Invalid command. Say `@bot help` to see the usage guide", nextMessage().Text())
+	require.Equal(t, `invalid command "<@U0LAN0Z89> lock myproject1 production": lock command requires reason`, nextMessage().Text())
 
 	require.NoError(t, l.handleMessageEvent(&slackevents.AppMentionEvent{
 		User:    "U1234",
 		Channel: "C1234",
 		Text:    "<@U0LAN0Z89> lock myproject1 production for deployment of revision a",
 	}))
-	require.Equal(t, "Locked myproject1 production", nextMessage().Text())
+	require.Equal(t, "myproject1 production をロックしました", nextMessage().Text())
 
 	require.NoError(t, l.handleMessageEvent(&slackevents.AppMentionEvent{
 		User:    "U1234",
@@ -292,7 +353,7 @@ func TestSlackLockUnlock(t *testing.T) {
 		Channel: "C1234",
 		Text:    "<@U0LAN0Z89> unlock myproject1 production",
 	}))
-	require.Equal(t, "Unlocked myproject1 production", nextMessage().Text())
+	require.Equal(t, "myproject1 production のロックを解除しました", nextMessage().Text())
 
 	require.NoError(t, l.handleMessageEvent(&slackevents.AppMentionEvent{
 		User:    "U1234",
@@ -325,7 +386,7 @@ func TestSlackLockUnlock(t *testing.T) {
 		Channel: "C1234",
 		Text:    "<@U0LAN0Z89> lock myproject1 production for deployment of revision a",
 	}))
-	require.Equal(t, "Locked myproject1 production", nextMessage().Text())
+	require.Equal(t, "myproject1 production をロックしました", nextMessage().Text())
 
 	// Describe locks
 	require.NoError(t, l.handleMessageEvent(&slackevents.AppMentionEvent{
@@ -334,7 +395,7 @@ func TestSlackLockUnlock(t *testing.T) {
 		Text:    "<@U0LAN0Z89> describe locks",
 	}))
 	require.Equal(t, `myproject1
-  production: Locked (by user2, for deployment of revision a)
+  production: ロック中 (user2 によるロック, 理由: deployment of revision a)
 `, nextMessage().Text())
 
 	// Lock staging
@@ -343,7 +404,7 @@ func TestSlackLockUnlock(t *testing.T) {
 		Channel: "C1234",
 		Text:    "<@U0LAN0Z89> lock myproject1 staging for deployment of revision b",
 	}))
-	require.Equal(t, "Locked myproject1 staging", nextMessage().Text())
+	require.Equal(t, "myproject1 staging をロックしました", nextMessage().Text())
 
 	// Describe locks
 	require.NoError(t, l.handleMessageEvent(&slackevents.AppMentionEvent{
@@ -352,8 +413,8 @@ func TestSlackLockUnlock(t *testing.T) {
 		Text:    "<@U0LAN0Z89> describe locks",
 	}))
 	require.Equal(t, `myproject1
-  production: Locked (by user2, for deployment of revision a)
-  staging: Locked (by user2, for deployment of revision b)
+  production: ロック中 (user2 によるロック, 理由: deployment of revision a)
+  staging: ロック中 (user2 によるロック, 理由: deployment of revision b)
 `, nextMessage().Text())
 
 	// Lock project 2 staging
@@ -362,7 +423,7 @@ func TestSlackLockUnlock(t *testing.T) {
 		Channel: "C1234",
 		Text:    "<@U0LAN0Z89> lock myproject2 staging for deployment of revision c",
 	}))
-	require.Equal(t, "Locked myproject2 staging", nextMessage().Text())
+	require.Equal(t, "myproject2 staging をロックしました", nextMessage().Text())
 
 	// Describe locks
 	require.NoError(t, l.handleMessageEvent(&slackevents.AppMentionEvent{
@@ -371,10 +432,10 @@ func TestSlackLockUnlock(t *testing.T) {
 		Text:    "<@U0LAN0Z89> describe locks",
 	}))
 	require.Equal(t, `myproject1
-  production: Locked (by user2, for deployment of revision a)
-  staging: Locked (by user2, for deployment of revision b)
+  production: ロック中 (user2 によるロック, 理由: deployment of revision a)
+  staging: ロック中 (user2 によるロック, 理由: deployment of revision b)
 myproject2
-  staging: Locked (by user2, for deployment of revision c)
+  staging: ロック中 (user2 によるロック, 理由: deployment of revision c)
 `, nextMessage().Text())
 
 	// User 1 is a developer so cannot unlock the project forcefully
@@ -391,7 +452,7 @@ myproject2
 		Channel: "C1234",
 		Text:    "<@U0LAN0Z89> unlock myproject1 production",
 	}))
-	require.Equal(t, "Unlocked myproject1 production", nextMessage().Text())
+	require.Equal(t, "myproject1 production のロックを解除しました", nextMessage().Text())
 
 	// Describe locks
 	require.NoError(t, l.handleMessageEvent(&slackevents.AppMentionEvent{
@@ -400,9 +461,9 @@ myproject2
 		Text:    "<@U0LAN0Z89> describe locks",
 	}))
 	require.Equal(t, `myproject1
-  staging: Locked (by user2, for deployment of revision b)
+  staging: ロック中 (user2 によるロック, 理由: deployment of revision b)
 myproject2
-  staging: Locked (by user2, for deployment of revision c)
+  staging: ロック中 (user2 によるロック, 理由: deployment of revision c)
 `, nextMessage().Text())
 
 	// Unlock project 2 staging
@@ -411,7 +472,7 @@ myproject2
 		Channel: "C1234",
 		Text:    "<@U0LAN0Z89> unlock myproject2 staging",
 	}))
-	require.Equal(t, "Unlocked myproject2 staging", nextMessage().Text())
+	require.Equal(t, "myproject2 staging のロックを解除しました", nextMessage().Text())
 
 	// Describe locks
 	require.NoError(t, l.handleMessageEvent(&slackevents.AppMentionEvent{
@@ -420,7 +481,7 @@ myproject2
 		Text:    "<@U0LAN0Z89> describe locks",
 	}))
 	require.Equal(t, `myproject1
-  staging: Locked (by user2, for deployment of revision b)
+  staging: ロック中 (user2 によるロック, 理由: deployment of revision b)
 `, nextMessage().Text())
 
 	// Deployment to myproject1/staging by user1 should fail because it is locked by user2
@@ -437,7 +498,7 @@ myproject2
 		Channel: "C1234",
 		Text:    "<@U0LAN0Z89> deploy myproject1 staging",
 	}))
-	require.Equal(t, "**\n*staging*\n*master* ブランチ\nをデプロイしますか?", nextMessage().Text())
+	require.Equal(t, "**\n*staging*\n*master* ブランチをデプロイしますか?", nextMessage().Text())
 
 	// Deployment to myproject1/production by user1 should fail because it is not locked
 	require.NoError(t, l.handleMessageEvent(&slackevents.AppMentionEvent{
@@ -445,7 +506,7 @@ myproject2
 		Channel: "C1234",
 		Text:    "<@U0LAN0Z89> deploy myproject1 production",
 	}))
-	require.Equal(t, "**\n*production*\n*master* ブランチ\nをデプロイしますか?", nextMessage().Text())
+	require.Equal(t, "**\n*production*\n*master* ブランチをデプロイしますか?", nextMessage().Text())
 
 	// Deployment to myproject2/staging by user1 should succeed because it is not locked
 	require.NoError(t, l.handleMessageEvent(&slackevents.AppMentionEvent{
@@ -453,7 +514,7 @@ myproject2
 		Channel: "C1234",
 		Text:    "<@U0LAN0Z89> deploy myproject2 staging",
 	}))
-	require.Equal(t, "**\n*staging*\n*master* ブランチ\nをデプロイしますか?", nextMessage().Text())
+	require.Equal(t, "**\n*staging*\n*master* ブランチをデプロイしますか?", nextMessage().Text())
 }
 
 // Message is a message posted to the fake Slack API's chat.postMessage endpoint
@@ -530,3 +591,50 @@ func getNamespace() string {
 	}
 	return ns
 }
+
+func TestSlackLockByAlias(t *testing.T) {
+	userList := &UserList{Items: []User{
+		{SlackUserID: "U1", SlackDisplayName: "user1", isDeveloper: true},
+		{SlackUserID: "U2", SlackDisplayName: "user2", isDeveloper: true},
+	}}
+	projectList := &ProjectList{Items: []DeployProject{
+		{ID: "myproject", Alias: "^(myproject|mp)$", Phases: []DeployPhase{{Name: "staging"}}},
+	}}
+	coordinator := deploy.NewCoordinator(deploy.NewKubernetes(fake.NewSimpleClientset()), "default", "gocat-test")
+	guard := NewDeployGuard(coordinator, userList, projectList)
+	s := &SlackListener{projectList: projectList, userList: userList, coordinator: coordinator}
+	user1 := userList.FindBySlackUserID("U1")
+
+	// The lock by the aliases is keyed by the ID of the project, which the guard checks.
+	s.lock(&slackcmd.Lock{Project: "mp", Env: "stg", Reason: "testing"}, user1, "C1", i18n.English)
+	var lockedErr deploy.LockedError
+	require.ErrorAs(t, guard.Check("myproject", "staging", "U2"), &lockedErr)
+	require.NoError(t, guard.Check("myproject", "staging", "U1"))
+
+	s.unlock(&slackcmd.Unlock{Project: "mp", Env: "stg"}, user1, i18n.English)
+	require.NoError(t, guard.Check("myproject", "staging", "U2"))
+}
+
+func TestFormatImageCandidates(t *testing.T) {
+	pushedAt := time.Date(2026, 10, 17, 12, 0, 0, 0, time.Local)
+	candidates := []ImageCandidate{
+		{Image: Image{Digest: "sha256:0123456789abcdef", PushedAt: pushedAt}, Tag: "abc1234"},
+		{Image: Image{Digest: "sha256:fedcba9876543210"}, Tag: "def5678"},
+	}
+
+	require.Equal(t, "No image tags found", formatImageCandidates(nil, TagSelectionLatestPushed, 10, i18n.English))
+	require.Equal(t,
+		"`abc1234` pushed at 2026-10-17 12:00 `sha256:0123456789ab...` :point_left: to be deployed\n"+
+			"`def5678` pushed at unknown `sha256:fedcba987654...`",
+		formatImageCandidates(candidates, TagSelectionLatestPushed, 10, i18n.English))
+	require.Equal(t,
+		"`abc1234` pushed at 2026-10-17 12:00 `sha256:0123456789ab...` :point_left: to be deployed\n"+
+			"... and 1 more",
+		formatImageCandidates(candidates, TagSelectionHighestSemver, 1, i18n.English))
+	require.Equal(t,
+		"`abc1234` pushed at 2026-10-17 12:00 `sha256:0123456789ab...`\n"+
+			"`def5678` pushed at unknown `sha256:fedcba987654...`\n"+
+			":warning: 2 images match, whereas TagSelectionStrategy exact requires exactly one",
+		formatImageCandidates(candidates, TagSelectionExact, 10, i18n.English))
+	require.Equal(t, "イメージタグが見つかりません", formatImageCandidates(nil, TagSelectionLatestPushed, 10, i18n.Japanese))
+}
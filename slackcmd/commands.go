@@ -0,0 +1,268 @@
+package slackcmd
+
+import (
+	"strings"
+
+	"github.com/zaiminc/gocat/i18n"
+)
+
+// command is a command registered to Parse and the help.
+type command struct {
+	// words are the leading words of the command, like `queue deploy`.
+	words  []string
+	usages []Usage
+	// parse parses the words following the command words.
+	parse func(args []string) (Command, error)
+}
+
+// Name returns the words of the command joined by spaces, which is used by `help <command>`.
+func (c command) Name() string {
+	return strings.Join(c.words, " ")
+}
+
+// Usage is a usage of a command shown in the help.
+type Usage struct {
+	Title i18n.Message
+	// Examples are the texts following the mention to the bot.
+	Examples    []string
+	Description i18n.Message
+}
+
+const (
+	deploySyntax        = "deploy [<project>] <env> [branch|tag <tag>|commit <sha>]"
+	wizardSyntax        = "wizard"
+	lockSyntax          = "lock <project> <env> for <reason>"
+	unlockSyntax        = "unlock <project> <env>"
+	describeLocksSyntax = "describe locks"
+	historySyntax       = "history <project> <env> [n]"
+	rollbackSyntax      = "rollback <project> <env> [to <tag>]"
+	queueSyntax         = "queue deploy <project> <env> [branch]"
+	leaveQueueSyntax    = "leave queue <project> <env>"
+	overrideSyntax      = "override <project> <env> for <duration> because <reason>"
+	tagsSyntax          = "tags <project> [branch]"
+	listSyntax          = "ls"
+	reloadSyntax        = "reload"
+	helpSyntax          = "help [command]"
+)
+
+const (
+	replaceProjectEnvJa = "apiの部分はその他アプリケーションに置換可能です。stagingの部分はプロジェクトに設定されたフェーズ名や別名(stgなど)に置換可能です。"
+	replaceProjectEnvEn = "Replace api with the other applications, and staging with the names or the aliases (like stg) of the phases configured for the project."
+)
+
+// projectEnvDescription returns the description of a usage whose example has the project and the env.
+func projectEnvDescription(ja, en string) i18n.Message {
+	return i18n.Message{
+		i18n.Japanese: replaceProjectEnvJa + ja,
+		i18n.English:  replaceProjectEnvEn + en,
+	}
+}
+
+// commands are all the commands in the order shown in the help.
+var commands = []command{
+	{
+		words: []string{"deploy"},
+		parse: parseDeploy,
+		usages: []Usage{
+			{
+				Title:    i18n.Message{i18n.Japanese: "masterのデプロイ", i18n.English: "Deploy master"},
+				Examples: []string{"deploy api staging"},
+				Description: projectEnvDescription(
+					"\nコマンド入力後にデプロイするかの確認ボタンが出てきます。",
+					"\nThe button to confirm the deployment is shown.",
+				),
+			},
+			{
+				Title:    i18n.Message{i18n.Japanese: "ブランチのデプロイ", i18n.English: "Deploy a branch"},
+				Examples: []string{"deploy api staging branch"},
+				Description: projectEnvDescription(
+					"\nブランチを選択するドロップダウンが出てきます。\nブランチ選択後にデプロイするかの確認ボタンが出てきます。",
+					"\nThe dropdown to select the branch is shown.\nThe button to confirm the deployment is shown after the branch is selected.",
+				),
+			},
+			{
+				Title:    i18n.Message{i18n.Japanese: "タグやコミットを指定したデプロイ", i18n.English: "Deploy a tag or a commit"},
+				Examples: []string{"deploy api staging tag TAG", "deploy api staging commit SHA"},
+				Description: projectEnvDescription(
+					"\nイメージレジストリにタグやコミットのイメージがあることを確認した後にデプロイするかの確認ボタンが出てきます。",
+					"\nThe button to confirm the deployment is shown after the image of the tag or the commit is found in the image registry.",
+				),
+			},
+			{
+				Title:    i18n.Message{i18n.Japanese: "デプロイ対象の選択をSlackのUIから選択するデプロイ手法", i18n.English: "Select the project to deploy on Slack"},
+				Examples: []string{"deploy staging"},
+				Description: i18n.Message{
+					i18n.Japanese: "stagingの部分はプロジェクトに設定されたフェーズ名や別名(stgなど)に置換可能です。\nデプロイ対象を選択すると、ブランチやタグを入力するデプロイウィザードが開きます。",
+					i18n.English:  "Replace staging with the names or the aliases (like stg) of the phases configured for the projects.\nThe deploy wizard to enter the branch or the tag is opened after the project is selected.",
+				},
+			},
+		},
+	},
+	{
+		words: []string{"wizard"},
+		parse: parseWizard,
+		usages: []Usage{{
+			Title:    i18n.Message{i18n.Japanese: "デプロイウィザードを開く", i18n.English: "Open the deploy wizard"},
+			Examples: []string{"wizard"},
+			Description: i18n.Message{
+				i18n.Japanese: "プロジェクト、フェーズ、ブランチやタグ、理由を入力してデプロイするモーダルを開くボタンが出てきます。\nブランチは名前で検索できます。",
+				i18n.English:  "The button to open the modal is shown, in which the project, the phase, the branch or the tag, and the reason to deploy are entered.\nThe branches can be searched by name.",
+			},
+		}},
+	},
+	{
+		words: []string{"lock"},
+		parse: parseLock,
+		usages: []Usage{{
+			Title:    i18n.Message{i18n.Japanese: "デプロイロックをとる", i18n.English: "Lock deployments"},
+			Examples: []string{"lock api staging for REASON"},
+			Description: projectEnvDescription(
+				"\nREASON部分にロックする理由を指定する必要があります。\n`for 2h because REASON` や `until 2026-10-20 18:00 because REASON` のように指定すると、期限が来たときに自動でロックが解除されます。",
+				"\nREASON is required to tell why it is locked.\nThe lock is released automatically on expiry if specified like `for 2h because REASON` or `until 2026-10-20 18:00 because REASON`.",
+			),
+		}},
+	},
+	{
+		words: []string{"unlock"},
+		parse: parseUnlock,
+		usages: []Usage{{
+			Title:       i18n.Message{i18n.Japanese: "デプロイロックを解除する", i18n.English: "Unlock deployments"},
+			Examples:    []string{"unlock api staging"},
+			Description: projectEnvDescription("", ""),
+		}},
+	},
+	{
+		words: []string{"describe", "locks"},
+		parse: parseDescribeLocks,
+		usages: []Usage{{
+			Title:       i18n.Message{i18n.Japanese: "デプロイロックの状態を確認する", i18n.English: "Describe the locks"},
+			Examples:    []string{"describe locks"},
+			Description: i18n.Message{i18n.Japanese: "デプロイロックの状態を確認します。", i18n.English: "Shows the locks of all the projects and their queues."},
+		}},
+	},
+	{
+		words: []string{"history"},
+		parse: parseHistory,
+		usages: []Usage{{
+			Title:    i18n.Message{i18n.Japanese: "デプロイ履歴を確認する", i18n.English: "Show the deploy history"},
+			Examples: []string{"history api staging 10"},
+			Description: projectEnvDescription(
+				"\n新しい順に最大10件(省略時)の履歴を表示します。",
+				"\nShows up to 10 (by default) events, newest first.",
+			),
+		}},
+	},
+	{
+		words: []string{"rollback"},
+		parse: parseRollback,
+		usages: []Usage{{
+			Title:    i18n.Message{i18n.Japanese: "ロールバックする", i18n.English: "Roll back"},
+			Examples: []string{"rollback api staging"},
+			Description: projectEnvDescription(
+				"\n現在の一つ前にデプロイされていたタグをデプロイします。`to TAG` を付けるとタグを指定できます。",
+				"\nDeploys the tag deployed before the current one. Add `to TAG` to specify the tag.",
+			),
+		}},
+	},
+	{
+		words: []string{"queue", "deploy"},
+		parse: parseQueue,
+		usages: []Usage{{
+			Title:    i18n.Message{i18n.Japanese: "ロック解除を待ってデプロイする", i18n.English: "Deploy after the lock is released"},
+			Examples: []string{"queue deploy api staging BRANCH"},
+			Description: projectEnvDescription(
+				"BRANCHは省略可能です。\nロックが解除されると順番にロックが引き継がれ、デプロイするかの確認ボタンが出てきます。",
+				" BRANCH is optional.\nWhen the lock is released, it is handed over to the users in the queue in turn, with the button to confirm the deployment.",
+			),
+		}},
+	},
+	{
+		words: []string{"leave", "queue"},
+		parse: parseLeaveQueue,
+		usages: []Usage{{
+			Title:    i18n.Message{i18n.Japanese: "デプロイの待ち行列から抜ける", i18n.English: "Leave the deploy queue"},
+			Examples: []string{"leave queue api staging"},
+			Description: projectEnvDescription(
+				"\n`queue deploy` で入った待ち行列から抜けます。",
+				"\nLeaves the queue joined by `queue deploy`.",
+			),
+		}},
+	},
+	{
+		words: []string{"override"},
+		parse: parseOverride,
+		usages: []Usage{{
+			Title:    i18n.Message{i18n.Japanese: "デプロイ可能時間外やフリーズ期間中にデプロイを許可する(管理者のみ)", i18n.English: "Allow deployments outside the deploy schedule or during freezes (admins only)"},
+			Examples: []string{"override api production for 2h because REASON"},
+			Description: i18n.Message{
+				i18n.Japanese: "apiの部分はその他アプリケーションに置換可能です。productionの部分はプロジェクトに設定されたフェーズ名や別名(prdなど)に置換可能です。\n`until 2026-10-20 18:00 because REASON` のように期限を時刻で指定することもできます。許可した記録は履歴に残ります。",
+				i18n.English:  "Replace api with the other applications, and production with the names or the aliases (like prd) of the phases configured for the project.\nThe expiry can also be specified like `until 2026-10-20 18:00 because REASON`. The override is recorded in the history.",
+			},
+		}},
+	},
+	{
+		words: []string{"tags"},
+		parse: parseTags,
+		usages: []Usage{{
+			Title:    i18n.Message{i18n.Japanese: "デプロイされるイメージタグを確認する", i18n.English: "Show the image tags to be deployed"},
+			Examples: []string{"tags api BRANCH"},
+			Description: i18n.Message{
+				i18n.Japanese: "apiの部分はその他アプリケーションに置換可能です。BRANCHは省略可能で、省略時はデフォルトブランチになります。\nデプロイ候補のタグをプッシュ日時とダイジェストとともに優先順に表示します。",
+				i18n.English:  "Replace api with the other applications. BRANCH defaults to the default branch.\nShows the candidate tags in the order of priority, with their push times and digests.",
+			},
+		}},
+	},
+	{
+		words: []string{"ls"},
+		parse: parseList,
+		usages: []Usage{{
+			Title:       i18n.Message{i18n.Japanese: "デプロイ対象のプロジェクトを一覧する", i18n.English: "List the projects"},
+			Examples:    []string{"ls"},
+			Description: i18n.Message{i18n.Japanese: "プロジェクトとGitHubリポジトリの一覧を表示します。", i18n.English: "Shows the projects and their GitHub repositories."},
+		}},
+	},
+	{
+		words: []string{"reload"},
+		parse: parseReload,
+		usages: []Usage{{
+			Title:       i18n.Message{i18n.Japanese: "プロジェクトとユーザーを再読み込みする", i18n.English: "Reload the projects and the users"},
+			Examples:    []string{"reload"},
+			Description: i18n.Message{i18n.Japanese: "ConfigMapからプロジェクトとユーザーの設定を読み込み直します。", i18n.English: "Reloads the settings of the projects and the users from the ConfigMaps."},
+		}},
+	},
+	{
+		words: []string{"help"},
+		parse: parseHelp,
+		usages: []Usage{{
+			Title:       i18n.Message{i18n.Japanese: "使い方を表示する", i18n.English: "Show the usage"},
+			Examples:    []string{"help", "help deploy"},
+			Description: i18n.Message{i18n.Japanese: "コマンドを指定するとそのコマンドの使い方だけを表示します。", i18n.English: "Shows only the usage of the command if specified."},
+		}},
+	},
+}
+
+// Usages returns the usages of the command named name, like `lock` or `queue deploy`,
+// or the usages of all the commands if name is empty.
+// The first word of the name is enough if no command has the exact name, so that `help queue` shows `queue deploy`.
+// Nil is returned if the command is unknown.
+func Usages(name string) []Usage {
+	var usages []Usage
+	if name == "" {
+		for _, c := range commands {
+			usages = append(usages, c.usages...)
+		}
+		return usages
+	}
+
+	for _, c := range commands {
+		if c.Name() == name {
+			return c.usages
+		}
+	}
+	for _, c := range commands {
+		if c.words[0] == name {
+			usages = append(usages, c.usages...)
+		}
+	}
+	return usages
+}
@@ -0,0 +1,35 @@
+package slackcmd
+
+// Deploy is a command to deploy the default branch of a project, an exact image tag,
+// or the image built from a commit.
+// At most one of Tag and Commit is set.
+type Deploy struct {
+	Project string
+	Env     string
+	Tag     string
+	// Commit is the ID of the commit, either abbreviated or not.
+	Commit string
+}
+
+func (d *Deploy) Name() string {
+	return "Deploy"
+}
+
+// DeployBranch is a command to select the branch of a project to deploy.
+type DeployBranch struct {
+	Project string
+	Env     string
+}
+
+func (d *DeployBranch) Name() string {
+	return "DeployBranch"
+}
+
+// SelectDeployTarget is a command to select the project to deploy into the env.
+type SelectDeployTarget struct {
+	Env string
+}
+
+func (s *SelectDeployTarget) Name() string {
+	return "SelectDeployTarget"
+}
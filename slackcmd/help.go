@@ -0,0 +1,11 @@
+package slackcmd
+
+// Help is a command to show the usages of the commands.
+type Help struct {
+	// Command is the name of the command to show the usage of. Empty means all the commands.
+	Command string
+}
+
+func (h *Help) Name() string {
+	return "Help"
+}
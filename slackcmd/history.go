@@ -0,0 +1,12 @@
+package slackcmd
+
+type History struct {
+	Project string
+	Env     string
+	// Limit is the maximum number of events to show.
+	Limit int
+}
+
+func (h *History) Name() string {
+	return "History"
+}
@@ -0,0 +1,9 @@
+package slackcmd
+
+// List is a command to list the projects.
+type List struct {
+}
+
+func (l *List) Name() string {
+	return "List"
+}
@@ -1,9 +1,17 @@
 package slackcmd
 
+import "time"
+
 type Lock struct {
 	Project string
 	Env     string
 	Reason  string
+	// Duration is the duration after which the lock expires, like `for 2h because <reason>`.
+	// Zero means the lock expires at Until, or never expires.
+	Duration time.Duration
+	// Until is the time at which the lock expires, like `until 2026-10-20 18:00 because <reason>`.
+	// The time is interpreted in the local time zone of gocat.
+	Until time.Time
 }
 
 func (l *Lock) Name() string {
@@ -0,0 +1,17 @@
+package slackcmd
+
+import "time"
+
+// Override is a command for admins to allow deployments into a project phase
+// during a freeze or outside the deploy windows, for the given duration or until the given time.
+type Override struct {
+	Project  string
+	Env      string
+	Duration time.Duration
+	Until    time.Time
+	Reason   string
+}
+
+func (o *Override) Name() string {
+	return "Override"
+}
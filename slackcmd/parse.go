@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type PatternError struct {
@@ -19,78 +21,410 @@ func patternError(pattern string) PatternError {
 	return PatternError{Pattern: pattern}
 }
 
-var lockUnlockPattern = regexp.MustCompile(`(unlock|lock) ([0-9a-zA-Z-]+) (staging|production|sandbox|stg|pro|prd)\s*(.*)`)
+// UnknownCommandError is returned by Parse if the text does not start with any of the commands.
+type UnknownCommandError struct {
+	Text string
+}
+
+func (e UnknownCommandError) Error() string {
+	return fmt.Sprintf("invalid command %q: unknown command", e.Text)
+}
+
+var projectPattern = regexp.MustCompile(`^[0-9a-zA-Z-]+$`)
+
+// envPattern is the pattern of phase names and aliases, which are configured per project
+// and resolved against the project config by the listener.
+var envPattern = regexp.MustCompile(`^[0-9a-zA-Z-]+$`)
+
+var imageTagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+var commitPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+
+var mentionPattern = regexp.MustCompile(`^<@[0-9A-Z]+(\|[^>]*)?>$`)
 
+const (
+	DefaultHistoryLimit = 10
+	MaxHistoryLimit     = 50
+)
+
+// Parse parses the text of a mention like `<@U0LAN0Z89> lock myproject staging for release`.
+//
+// The text is split into words, and the command is looked up by the leading words other than mentions.
+// UnknownCommandError is returned if no command matches, and the usage of the command is returned
+// as a PatternError if the arguments do not follow it.
 func Parse(text string) (Command, error) {
-	cmd, err1 := parseLockUnlock(text)
-	if err1 == nil {
-		return cmd, nil
-	} else if !errors.As(err1, &PatternError{}) {
-		return nil, fmt.Errorf("invalid command %q: %w", text, err1)
+	words := tokenize(text)
+	cmd, args := lookup(words)
+	if cmd == nil {
+		return nil, UnknownCommandError{Text: text}
 	}
 
-	cmd, err2 := parseDescribeLocks(text)
-	if err2 == nil {
-		return cmd, nil
-	} else if !errors.As(err2, &PatternError{}) {
-		return nil, fmt.Errorf("invalid command %q: %w", text, err2)
+	c, err := cmd.parse(args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid command %q: %w", text, err)
 	}
+	return c, nil
+}
 
-	return nil, fmt.Errorf("invalid command %q: %v, %v", text, err1, err2)
+// tokenize splits the text into words, dropping the mentions to users and the bot.
+func tokenize(text string) []string {
+	var words []string
+	for _, w := range strings.Fields(text) {
+		if mentionPattern.MatchString(w) {
+			continue
+		}
+		words = append(words, w)
+	}
+	return words
 }
 
-func parseLockUnlock(text string) (Command, error) {
-	match := findLockUnlock(text)
-	if match == nil {
-		return nil, patternError("lock|unlock <project> <env> [for <reason>]")
+// lookup returns the command whose words the given words start with, and the rest of the words as the arguments.
+// The command with the most words wins, so that `queue deploy` is not taken as `queue`.
+func lookup(words []string) (*command, []string) {
+	var found *command
+	for i := range commands {
+		c := &commands[i]
+		if len(words) < len(c.words) || (found != nil && len(found.words) >= len(c.words)) {
+			continue
+		}
+		if strings.Join(words[:len(c.words)], " ") == c.Name() {
+			found = c
+		}
+	}
+	if found == nil {
+		return nil, nil
 	}
+	return found, words[len(found.words):]
+}
 
-	var (
-		command = match[0][1]
-		project = match[0][2]
-		env     = match[0][3]
-		reason  = match[0][4]
-	)
+// projectEnv returns the project and the env at the head of the arguments,
+// or the usage error of the command if they are missing or invalid.
+func projectEnv(syntax string, args []string) (string, string, error) {
+	if len(args) < 2 || !projectPattern.MatchString(args[0]) || !envPattern.MatchString(args[1]) {
+		return "", "", patternError(syntax)
+	}
+	return args[0], args[1], nil
+}
 
-	switch command {
-	case "unlock":
-		if reason != "" {
-			return nil, errors.New("unlock command does not accept reason")
-		}
+func parseLock(args []string) (Command, error) {
+	project, env, err := projectEnv(lockSyntax, args)
+	if err != nil {
+		return nil, err
+	}
 
-		return &Unlock{
-			Project: project,
-			Env:     env,
-		}, nil
-	case "lock":
-		if reason == "" {
-			return nil, errors.New("lock command requires reason")
+	reason := strings.Join(args[2:], " ")
+	if reason == "" {
+		return nil, errors.New("lock command requires reason")
+	}
+
+	lock := &Lock{
+		Project: project,
+		Env:     env,
+	}
+
+	switch {
+	case strings.HasPrefix(reason, "until "):
+		at, because, ok := strings.Cut(strings.TrimPrefix(reason, "until "), " because ")
+		if !ok || strings.TrimSpace(because) == "" {
+			return nil, errors.New("lock command with expiry requires reason: `until <time> because <reason>`")
 		}
 
-		if !strings.HasPrefix(reason, "for ") {
-			return nil, errors.New("reason must start with 'for'")
+		until, err := parseUntil(at)
+		if err != nil {
+			return nil, err
 		}
 
+		lock.Until = until
+		reason = because
+	case strings.HasPrefix(reason, "for "):
 		reason = strings.TrimPrefix(reason, "for ")
 
-		return &Lock{
-			Project: project,
-			Env:     env,
-			Reason:  reason,
-		}, nil
+		// `for 2h because <reason>` is a lock with expiry, whereas `for <reason>` is a lock without expiry.
+		if d, because, ok := strings.Cut(reason, " because "); ok {
+			if duration, err := parseDuration(d); err == nil {
+				lock.Duration = duration
+				reason = because
+			}
+		}
 	default:
-		panic("unreachable")
+		return nil, errors.New("reason must start with 'for' or 'until'")
+	}
+
+	lock.Reason = strings.TrimSpace(reason)
+
+	return lock, nil
+}
+
+func parseUnlock(args []string) (Command, error) {
+	project, env, err := projectEnv(unlockSyntax, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) > 2 {
+		return nil, errors.New("unlock command does not accept reason")
+	}
+
+	return &Unlock{
+		Project: project,
+		Env:     env,
+	}, nil
+}
+
+func parseWizard(args []string) (Command, error) {
+	if len(args) > 0 {
+		return nil, patternError(wizardSyntax)
 	}
+
+	return &Wizard{}, nil
 }
 
-func parseDescribeLocks(text string) (Command, error) {
-	if !strings.Contains(text, "describe locks") {
-		return nil, patternError("describe locks")
+func parseDescribeLocks(args []string) (Command, error) {
+	if len(args) > 0 {
+		return nil, patternError(describeLocksSyntax)
 	}
 
 	return &DescribeLocks{}, nil
 }
 
-func findLockUnlock(text string) [][]string {
-	return lockUnlockPattern.FindAllStringSubmatch(text, -1)
+func parseHistory(args []string) (Command, error) {
+	project, env, err := projectEnv(historySyntax, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 3 {
+		return nil, patternError(historySyntax)
+	}
+
+	limit := DefaultHistoryLimit
+	if len(args) == 3 {
+		n := args[2]
+		limit, err = strconv.Atoi(n)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("n must be a positive number: %q", n)
+		}
+		if limit > MaxHistoryLimit {
+			limit = MaxHistoryLimit
+		}
+	}
+
+	return &History{
+		Project: project,
+		Env:     env,
+		Limit:   limit,
+	}, nil
+}
+
+func parseRollback(args []string) (Command, error) {
+	project, env, err := projectEnv(rollbackSyntax, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var tag string
+	if rest := args[2:]; len(rest) > 0 {
+		if len(rest) != 2 || rest[0] != "to" {
+			return nil, errors.New("rollback command accepts only `to <tag>` after the env")
+		}
+		tag = rest[1]
+	}
+
+	return &Rollback{
+		Project: project,
+		Env:     env,
+		Tag:     tag,
+	}, nil
+}
+
+func parseQueue(args []string) (Command, error) {
+	project, env, err := projectEnv(queueSyntax, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) > 3 {
+		return nil, errors.New("queue command accepts only one branch")
+	}
+
+	var branch string
+	if len(args) == 3 {
+		branch = args[2]
+	}
+
+	return &Queue{
+		Project: project,
+		Env:     env,
+		Branch:  branch,
+	}, nil
+}
+
+func parseLeaveQueue(args []string) (Command, error) {
+	project, env, err := projectEnv(leaveQueueSyntax, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) > 2 {
+		return nil, errors.New("leave queue command does not accept arguments after the env")
+	}
+
+	return &LeaveQueue{
+		Project: project,
+		Env:     env,
+	}, nil
+}
+
+func parseOverride(args []string) (Command, error) {
+	project, env, err := projectEnv(overrideSyntax, args)
+	if err != nil {
+		return nil, err
+	}
+
+	override := &Override{
+		Project: project,
+		Env:     env,
+	}
+
+	rest := strings.Join(args[2:], " ")
+	period, because, ok := strings.Cut(rest, " because ")
+	if !ok || strings.TrimSpace(because) == "" {
+		return nil, errors.New("override command requires reason: `for <duration> because <reason>` or `until <time> because <reason>`")
+	}
+
+	switch {
+	case strings.HasPrefix(period, "for "):
+		override.Duration, err = parseDuration(strings.TrimPrefix(period, "for "))
+	case strings.HasPrefix(period, "until "):
+		override.Until, err = parseUntil(strings.TrimPrefix(period, "until "))
+	default:
+		err = errors.New("override command requires the period: `for <duration>` or `until <time>`")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	override.Reason = strings.TrimSpace(because)
+
+	return override, nil
+}
+
+// parseDeploy parses the variants of the deploy command:
+//
+//	deploy <env>
+//	deploy <project> <env>
+//	deploy <project> <env> branch
+//	deploy <project> <env> tag <tag>
+//	deploy <project> <env> commit <sha>
+func parseDeploy(args []string) (Command, error) {
+	if len(args) == 1 && envPattern.MatchString(args[0]) {
+		return &SelectDeployTarget{Env: args[0]}, nil
+	}
+
+	project, env, err := projectEnv(deploySyntax, args)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := args[2:]
+	switch {
+	case len(rest) == 0:
+		return &Deploy{Project: project, Env: env}, nil
+	case len(rest) == 1 && rest[0] == "branch":
+		return &DeployBranch{Project: project, Env: env}, nil
+	case rest[0] == "tag":
+		value := strings.Join(rest[1:], " ")
+		if !imageTagPattern.MatchString(value) {
+			return nil, fmt.Errorf("tag must be a valid image tag: %q", value)
+		}
+		return &Deploy{Project: project, Env: env, Tag: value}, nil
+	case rest[0] == "commit":
+		value := strings.Join(rest[1:], " ")
+		if !commitPattern.MatchString(value) {
+			return nil, fmt.Errorf("commit must be a commit ID of 7 to 40 hex digits: %q", value)
+		}
+		return &Deploy{Project: project, Env: env, Commit: strings.ToLower(value)}, nil
+	default:
+		return nil, patternError(deploySyntax)
+	}
+}
+
+func parseTags(args []string) (Command, error) {
+	if len(args) == 0 || !projectPattern.MatchString(args[0]) {
+		return nil, patternError(tagsSyntax)
+	}
+
+	if len(args) > 2 {
+		return nil, errors.New("tags command accepts only one branch")
+	}
+
+	var branch string
+	if len(args) == 2 {
+		branch = args[1]
+	}
+
+	return &Tags{
+		Project: args[0],
+		Branch:  branch,
+	}, nil
+}
+
+func parseList(args []string) (Command, error) {
+	if len(args) > 0 {
+		return nil, patternError(listSyntax)
+	}
+
+	return &List{}, nil
+}
+
+func parseReload(args []string) (Command, error) {
+	if len(args) > 0 {
+		return nil, patternError(reloadSyntax)
+	}
+
+	return &Reload{}, nil
+}
+
+func parseHelp(args []string) (Command, error) {
+	return &Help{Command: strings.Join(args, " ")}, nil
+}
+
+// untilLayouts are the time layouts accepted by `lock ... until <time> because <reason>`.
+var untilLayouts = []string{
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	time.RFC3339,
+}
+
+func parseUntil(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range untilLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("time must be in the format of `2006-01-02 15:04`: %q", s)
+}
+
+// parseDuration parses a positive duration like `2h`, `30m`, or `1d`.
+// In addition to time.ParseDuration, this accepts `d` as the unit of days.
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+
+	var (
+		d   time.Duration
+		err error
+	)
+	if strings.HasSuffix(s, "d") {
+		var n int
+		n, err = strconv.Atoi(strings.TrimSuffix(s, "d"))
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("duration must be positive like `2h` or `1d`: %q", s)
+	}
+
+	return d, nil
 }
@@ -3,15 +3,17 @@ package slackcmd
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/zaiminc/gocat/i18n"
 )
 
 var (
 	validProjects   = []string{"myproject1", "myproject-2"}
 	invalidProjects = []string{"myproject#3", "myproject_4"}
-	validEnvs       = []string{"staging", "production", "sandbox", "stg", "pro", "prd"}
-	invalidENvs     = []string{"stg1", "pro1", "prd1", "prod", "test"}
+	validEnvs       = []string{"staging", "production", "sandbox", "stg", "pro", "prd", "qa", "pre-prod"}
+	invalidENvs     = []string{"stg_1", "pro#1", "prd.1", "prod!", "<test>"}
 )
 
 func TestParse(t *testing.T) {
@@ -39,7 +41,7 @@ func TestParse(t *testing.T) {
 			tests = append(tests, test{
 				name:   fmt.Sprintf("lock with invalid project %d and env %d", i, j),
 				text:   fmt.Sprintf("lock %s %s for deployment of revision a", p, e),
-				errMsg: fmt.Sprintf("invalid command %q: valid pattern is `lock|unlock <project> <env> [for <reason>]`, valid pattern is `describe locks`", fmt.Sprintf("lock %s %s for deployment of revision a", p, e)),
+				errMsg: fmt.Sprintf("invalid command %q: valid pattern is `lock <project> <env> for <reason>`", fmt.Sprintf("lock %s %s for deployment of revision a", p, e)),
 			})
 		}
 	}
@@ -59,7 +61,7 @@ func TestParse(t *testing.T) {
 			tests = append(tests, test{
 				name:   fmt.Sprintf("unlock with invalid project %d and env %d", i, j),
 				text:   fmt.Sprintf("unlock %s %s", p, e),
-				errMsg: fmt.Sprintf("invalid command %q: valid pattern is `lock|unlock <project> <env> [for <reason>]`, valid pattern is `describe locks`", fmt.Sprintf("unlock %s %s", p, e)),
+				errMsg: fmt.Sprintf("invalid command %q: valid pattern is `unlock <project> <env>`", fmt.Sprintf("unlock %s %s", p, e)),
 			})
 		}
 	}
@@ -79,7 +81,277 @@ func TestParse(t *testing.T) {
 	tests = append(tests, test{
 		name:   "unknown command",
 		text:   "unknown myproject1 production for deployment of revision a",
-		errMsg: fmt.Sprintf("invalid command %q: valid pattern is `lock|unlock <project> <env> [for <reason>]`, valid pattern is `describe locks`", "unknown myproject1 production for deployment of revision a"),
+		errMsg: fmt.Sprintf("invalid command %q: unknown command", "unknown myproject1 production for deployment of revision a"),
+	})
+
+	tests = append(tests, test{
+		name: "history",
+		text: "history myproject1 production",
+		want: &History{Project: "myproject1", Env: "production", Limit: DefaultHistoryLimit},
+	})
+
+	tests = append(tests, test{
+		name: "history with n",
+		text: "history myproject1 stg 20",
+		want: &History{Project: "myproject1", Env: "stg", Limit: 20},
+	})
+
+	tests = append(tests, test{
+		name: "history with too large n",
+		text: "history myproject1 stg 1000",
+		want: &History{Project: "myproject1", Env: "stg", Limit: MaxHistoryLimit},
+	})
+
+	tests = append(tests, test{
+		name:   "history with invalid n",
+		text:   "history myproject1 stg ten",
+		errMsg: fmt.Sprintf("invalid command %q: n must be a positive number: \"ten\"", "history myproject1 stg ten"),
+	})
+
+	tests = append(tests, test{
+		name: "lock for duration",
+		text: "lock myproject1 production for 2h because release freeze",
+		want: &Lock{Project: "myproject1", Env: "production", Reason: "release freeze", Duration: 2 * time.Hour},
+	})
+
+	tests = append(tests, test{
+		name: "lock for days",
+		text: "lock myproject1 production for 1d because release freeze",
+		want: &Lock{Project: "myproject1", Env: "production", Reason: "release freeze", Duration: 24 * time.Hour},
+	})
+
+	tests = append(tests, test{
+		name: "lock for reason containing because",
+		text: "lock myproject1 production for testing because of a bug",
+		want: &Lock{Project: "myproject1", Env: "production", Reason: "testing because of a bug"},
+	})
+
+	tests = append(tests, test{
+		name: "lock until",
+		text: "lock myproject1 production until 2026-10-20 18:00 because release freeze",
+		want: &Lock{Project: "myproject1", Env: "production", Reason: "release freeze", Until: time.Date(2026, 10, 20, 18, 0, 0, 0, time.Local)},
+	})
+
+	tests = append(tests, test{
+		name:   "lock until without reason",
+		text:   "lock myproject1 production until 2026-10-20 18:00",
+		errMsg: fmt.Sprintf("invalid command %q: lock command with expiry requires reason: `until <time> because <reason>`", "lock myproject1 production until 2026-10-20 18:00"),
+	})
+
+	tests = append(tests, test{
+		name:   "lock until invalid time",
+		text:   "lock myproject1 production until tomorrow because release freeze",
+		errMsg: fmt.Sprintf("invalid command %q: time must be in the format of `2006-01-02 15:04`: \"tomorrow\"", "lock myproject1 production until tomorrow because release freeze"),
+	})
+
+	tests = append(tests, test{
+		name:   "lock with invalid reason",
+		text:   "lock myproject1 production because release freeze",
+		errMsg: fmt.Sprintf("invalid command %q: reason must start with 'for' or 'until'", "lock myproject1 production because release freeze"),
+	})
+
+	tests = append(tests, test{
+		name: "rollback",
+		text: "rollback myproject1 production",
+		want: &Rollback{Project: "myproject1", Env: "production"},
+	})
+
+	tests = append(tests, test{
+		name: "rollback to tag",
+		text: "rollback myproject1 prd to abc1234",
+		want: &Rollback{Project: "myproject1", Env: "prd", Tag: "abc1234"},
+	})
+
+	tests = append(tests, test{
+		name:   "rollback with garbage",
+		text:   "rollback myproject1 prd please",
+		errMsg: fmt.Sprintf("invalid command %q: rollback command accepts only `to <tag>` after the env", "rollback myproject1 prd please"),
+	})
+
+	tests = append(tests, test{
+		name: "queue deploy",
+		text: "queue deploy myproject1 staging",
+		want: &Queue{Project: "myproject1", Env: "staging"},
+	})
+
+	tests = append(tests, test{
+		name: "queue deploy with branch",
+		text: "queue deploy myproject1 stg feature/a",
+		want: &Queue{Project: "myproject1", Env: "stg", Branch: "feature/a"},
+	})
+
+	tests = append(tests, test{
+		name:   "queue deploy with multiple branches",
+		text:   "queue deploy myproject1 stg feature/a feature/b",
+		errMsg: fmt.Sprintf("invalid command %q: queue command accepts only one branch", "queue deploy myproject1 stg feature/a feature/b"),
+	})
+
+	tests = append(tests, test{
+		name: "leave queue",
+		text: "leave queue myproject1 staging",
+		want: &LeaveQueue{Project: "myproject1", Env: "staging"},
+	})
+
+	tests = append(tests, test{
+		name:   "leave queue with garbage",
+		text:   "leave queue myproject1 staging now",
+		errMsg: fmt.Sprintf("invalid command %q: leave queue command does not accept arguments after the env", "leave queue myproject1 staging now"),
+	})
+
+	tests = append(tests, test{
+		name: "override for duration",
+		text: "override myproject1 production for 2h because hotfix of the outage",
+		want: &Override{Project: "myproject1", Env: "production", Duration: 2 * time.Hour, Reason: "hotfix of the outage"},
+	})
+
+	tests = append(tests, test{
+		name: "override until time",
+		text: "override myproject1 prd until 2021-09-03 20:00 because hotfix",
+		want: &Override{Project: "myproject1", Env: "prd", Until: time.Date(2021, 9, 3, 20, 0, 0, 0, time.Local), Reason: "hotfix"},
+	})
+
+	tests = append(tests, test{
+		name:   "override without reason",
+		text:   "override myproject1 production for 2h",
+		errMsg: fmt.Sprintf("invalid command %q: override command requires reason: `for <duration> because <reason>` or `until <time> because <reason>`", "override myproject1 production for 2h"),
+	})
+
+	tests = append(tests, test{
+		name:   "override without period",
+		text:   "override myproject1 production because hotfix",
+		errMsg: fmt.Sprintf("invalid command %q: override command requires reason: `for <duration> because <reason>` or `until <time> because <reason>`", "override myproject1 production because hotfix"),
+	})
+
+	tests = append(tests, test{
+		name:   "override with invalid duration",
+		text:   "override myproject1 production for ever because hotfix",
+		errMsg: fmt.Sprintf("invalid command %q: duration must be positive like `2h` or `1d`: %q", "override myproject1 production for ever because hotfix", "ever"),
+	})
+
+	tests = append(tests, test{
+		name: "deploy tag",
+		text: "deploy myproject1 staging tag v1.2.3",
+		want: &Deploy{Project: "myproject1", Env: "staging", Tag: "v1.2.3"},
+	})
+
+	tests = append(tests, test{
+		name: "deploy commit",
+		text: "deploy myproject-2 prd commit A1B2C3D",
+		want: &Deploy{Project: "myproject-2", Env: "prd", Commit: "a1b2c3d"},
+	})
+
+	tests = append(tests, test{
+		name: "deploy",
+		text: "<@U0LAN0Z89> deploy myproject1 staging",
+		want: &Deploy{Project: "myproject1", Env: "staging"},
+	})
+
+	tests = append(tests, test{
+		name: "deploy branch",
+		text: "<@U0LAN0Z89>  deploy  myproject1 pro branch",
+		want: &DeployBranch{Project: "myproject1", Env: "pro"},
+	})
+
+	tests = append(tests, test{
+		name: "select deploy target",
+		text: "deploy sandbox",
+		want: &SelectDeployTarget{Env: "sandbox"},
+	})
+
+	tests = append(tests, test{
+		name:   "deploy with invalid env",
+		text:   "deploy myproject1 prod_1",
+		errMsg: fmt.Sprintf("invalid command %q: valid pattern is `deploy [<project>] <env> [branch|tag <tag>|commit <sha>]`", "deploy myproject1 prod_1"),
+	})
+
+	tests = append(tests, test{
+		name:   "deploy with garbage",
+		text:   "deploy myproject1 staging now",
+		errMsg: fmt.Sprintf("invalid command %q: valid pattern is `deploy [<project>] <env> [branch|tag <tag>|commit <sha>]`", "deploy myproject1 staging now"),
+	})
+
+	tests = append(tests, test{
+		name:   "deploy tag without tag",
+		text:   "deploy myproject1 staging tag",
+		errMsg: fmt.Sprintf("invalid command %q: tag must be a valid image tag: %q", "deploy myproject1 staging tag", ""),
+	})
+
+	tests = append(tests, test{
+		name:   "deploy tag with multiple tags",
+		text:   "deploy myproject1 staging tag v1 v2",
+		errMsg: fmt.Sprintf("invalid command %q: tag must be a valid image tag: %q", "deploy myproject1 staging tag v1 v2", "v1 v2"),
+	})
+
+	tests = append(tests, test{
+		name:   "deploy invalid commit",
+		text:   "deploy myproject1 staging commit main",
+		errMsg: fmt.Sprintf("invalid command %q: commit must be a commit ID of 7 to 40 hex digits: %q", "deploy myproject1 staging commit main", "main"),
+	})
+
+	tests = append(tests, test{
+		name: "tags",
+		text: "tags myproject1",
+		want: &Tags{Project: "myproject1"},
+	})
+
+	tests = append(tests, test{
+		name: "tags with branch",
+		text: "tags myproject-2 feature/a",
+		want: &Tags{Project: "myproject-2", Branch: "feature/a"},
+	})
+
+	tests = append(tests, test{
+		name:   "tags with multiple branches",
+		text:   "tags myproject1 feature/a feature/b",
+		errMsg: fmt.Sprintf("invalid command %q: tags command accepts only one branch", "tags myproject1 feature/a feature/b"),
+	})
+
+	tests = append(tests, test{
+		name: "ls",
+		text: "<@U0LAN0Z89> ls",
+		want: &List{},
+	})
+
+	tests = append(tests, test{
+		name:   "words containing ls",
+		text:   "<@U0LAN0Z89> tools are false",
+		errMsg: fmt.Sprintf("invalid command %q: unknown command", "<@U0LAN0Z89> tools are false"),
+	})
+
+	tests = append(tests, test{
+		name: "wizard",
+		text: "<@U0LAN0Z89> wizard",
+		want: &Wizard{},
+	})
+
+	tests = append(tests, test{
+		name:   "wizard with arguments",
+		text:   "wizard myproject",
+		errMsg: fmt.Sprintf("invalid command %q: valid pattern is `wizard`", "wizard myproject"),
+	})
+
+	tests = append(tests, test{
+		name: "reload",
+		text: "reload",
+		want: &Reload{},
+	})
+
+	tests = append(tests, test{
+		name: "help",
+		text: "help",
+		want: &Help{},
+	})
+
+	tests = append(tests, test{
+		name: "help command",
+		text: "help queue deploy",
+		want: &Help{Command: "queue deploy"},
+	})
+
+	tests = append(tests, test{
+		name:   "empty",
+		text:   "<@U0LAN0Z89>",
+		errMsg: fmt.Sprintf("invalid command %q: unknown command", "<@U0LAN0Z89>"),
 	})
 
 	for _, tt := range tests {
@@ -99,4 +371,29 @@ func TestParse(t *testing.T) {
 		assert.NoError(t, err)
 		assert.IsType(t, &DescribeLocks{}, got)
 	})
+
+	t.Run("unknown command", func(t *testing.T) {
+		_, err := Parse("describe lock")
+		assert.ErrorAs(t, err, &UnknownCommandError{})
+	})
+}
+
+func TestUsages(t *testing.T) {
+	assert.Len(t, Usages(""), 17)
+
+	assert.Len(t, Usages("deploy"), 4)
+
+	queue := Usages("queue deploy")
+	assert.Len(t, queue, 1)
+	assert.Equal(t, queue, Usages("queue"))
+	assert.Equal(t, []string{"queue deploy api staging BRANCH"}, queue[0].Examples)
+
+	assert.Nil(t, Usages("unknown"))
+
+	for _, u := range Usages("") {
+		for _, l := range i18n.Langs {
+			assert.NotEmpty(t, u.Title[l], "%s of %v", l, u.Examples)
+			assert.Contains(t, u.Description, l, "%s of %v", l, u.Examples)
+		}
+	}
 }
@@ -0,0 +1,24 @@
+package slackcmd
+
+// Queue is a command to wait for the lock of a project phase.
+// When the lock is released, the lock is handed over to the user to deploy the branch.
+type Queue struct {
+	Project string
+	Env     string
+	// Branch is the branch to deploy. Empty means the default branch of the project.
+	Branch string
+}
+
+func (q *Queue) Name() string {
+	return "Queue"
+}
+
+// LeaveQueue is a command to leave the queue of a project phase.
+type LeaveQueue struct {
+	Project string
+	Env     string
+}
+
+func (q *LeaveQueue) Name() string {
+	return "LeaveQueue"
+}
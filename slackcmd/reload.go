@@ -0,0 +1,9 @@
+package slackcmd
+
+// Reload is a command to reload the projects and the users.
+type Reload struct {
+}
+
+func (r *Reload) Name() string {
+	return "Reload"
+}
@@ -0,0 +1,13 @@
+package slackcmd
+
+type Rollback struct {
+	Project string
+	Env     string
+	// Tag is the image tag to roll back to.
+	// If empty, the tag that was deployed before the current one is used.
+	Tag string
+}
+
+func (r *Rollback) Name() string {
+	return "Rollback"
+}
@@ -0,0 +1,13 @@
+package slackcmd
+
+// Tags is a command to list the image tags that can be deployed for a branch of a project,
+// in the order of preference so that the first one is deployed.
+type Tags struct {
+	Project string
+	// Branch is the branch to list the tags of. Empty means the default branch of the project.
+	Branch string
+}
+
+func (t *Tags) Name() string {
+	return "Tags"
+}
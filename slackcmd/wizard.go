@@ -0,0 +1,9 @@
+package slackcmd
+
+// Wizard is a command to open the deploy wizard, the modal to enter what to deploy.
+type Wizard struct {
+}
+
+func (w *Wizard) Name() string {
+	return "Wizard"
+}
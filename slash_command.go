@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// slashCommandHandler is a http.Handler that can handle the `/gocat` slash command.
+// See https://api.slack.com/interactivity/slash-commands for more details about slash commands.
+//
+// The text of the slash command is the same as the one of the mention, like `/gocat deploy api staging`,
+// and the commands are run by the SlackListener that handles the mentions.
+type slashCommandHandler struct {
+	verifier *SlackRequestVerifier
+	events   *SlackListener
+}
+
+func (h slashCommandHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.verifier.Verify(r); err != nil {
+		log.Printf("[ERROR] Failed to verify the request: %s", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	cmd, err := slack.SlashCommandParse(r)
+	if err != nil {
+		log.Printf("[ERROR] Failed to parse slash command: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Slack shows a timeout error to the user unless the slash command is acknowledged within 3 seconds,
+	// so the command is run after acknowledging it with the empty response, and replies via the response URL.
+	go func() {
+		if err := h.events.handleSlashCommand(cmd); err != nil {
+			log.Println("[ERROR] ", err)
+		}
+	}()
+}
+
+// handleSlashCommand runs the slash command delivered either over HTTP or Socket Mode.
+//
+// The replies are ephemeral, that is, visible only to the user who ran the command,
+// so that checking locks or pending deployments doesn't fill shared channels with bot noise.
+// The deploy requests are posted in the channel instead so that the approvers can click their buttons,
+// and the deployments themselves are reported to the channel as usual.
+func (s *SlackListener) handleSlashCommand(cmd slack.SlashCommand) error {
+	log.Printf("%s %s", cmd.Command, cmd.Text)
+
+	text := cmd.Text
+	if strings.TrimSpace(text) == "" {
+		text = "help"
+	}
+
+	return s.handleCommand(text, cmd.UserID, cmd.ChannelID, slack.MsgOptionResponseURL(cmd.ResponseURL, slack.ResponseTypeEphemeral))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/deploy"
+	"github.com/zaiminc/gocat/i18n"
+)
+
+func TestHandleSlashCommand(t *testing.T) {
+	projectList := &ProjectList{Items: []DeployProject{{
+		ID:               "api",
+		Alias:            "^api$",
+		Kind:             "jenkins",
+		gitHubRepository: "api",
+		defaultBranch:    "main",
+		Phases:           []DeployPhase{{Name: "staging"}},
+	}}}
+	userList := &UserList{Items: []User{{SlackUserID: "U1", SlackDisplayName: "user1", isDeveloper: true}}}
+	client := &fakeSlackClient{}
+	factory := NewInteractorFactory(InteractorContext{
+		projectList: projectList,
+		userList:    userList,
+		client:      client,
+		pending:     deploy.NewMemoryPendingRequestStore(deploy.DefaultPendingRequestTTL),
+		languages:   NewLanguageList(i18n.English),
+	})
+	listener := &SlackListener{
+		client:            client,
+		projectList:       projectList,
+		userList:          userList,
+		interactorFactory: &factory,
+		languages:         NewLanguageList(i18n.English),
+	}
+
+	// run runs the slash command, and returns the first call to the Slack API made by it.
+	run := func(text string) fakeSlackCall {
+		t.Helper()
+		before := len(client.Calls())
+		require.NoError(t, listener.handleSlashCommand(slack.SlashCommand{Text: text, UserID: "U1", ChannelID: "C1", ResponseURL: "https://hooks.slack.com/commands/1"}))
+		calls := client.Calls()[before:]
+		require.NotEmpty(t, calls)
+		return calls[0]
+	}
+
+	// The informational replies are ephemeral.
+	call := run("list")
+	require.Equal(t, "https://hooks.slack.com/commands/1", call.responseURL)
+
+	call = run("deploy unknown staging")
+	require.Equal(t, "https://hooks.slack.com/commands/1", call.responseURL)
+
+	// The deploy requests are posted in the channel, so that the approvers can click their buttons.
+	call = run("deploy api staging")
+	require.Equal(t, "chat.postMessage", call.method)
+	require.Equal(t, "C1", call.channel)
+	require.Empty(t, call.responseURL)
+}
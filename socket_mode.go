@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+const (
+	SlackModeHTTP   = "http"
+	SlackModeSocket = "socket"
+)
+
+// SocketModeRunner receives Slack events, interactions and slash commands over a Socket Mode websocket connection,
+// so that gocat works without a public HTTPS endpoint.
+//
+// The events, the interactions and the slash commands are dispatched to the same SlackListener and interactionHandler
+// used by the /events, /interaction and /command HTTP endpoints.
+type SocketModeRunner struct {
+	client       *socketmode.Client
+	events       *SlackListener
+	interactions interactionHandler
+}
+
+func NewSocketModeRunner(client *socketmode.Client, events *SlackListener, interactions interactionHandler) SocketModeRunner {
+	return SocketModeRunner{client, events, interactions}
+}
+
+// Run connects to Slack and dispatches the received events until the context is canceled.
+// Each event is handled in its own goroutine, so that a slow one, like a deployment waiting for the API,
+// doesn't delay the acknowledgements of the others beyond the 3 seconds Slack waits for.
+func (r SocketModeRunner) Run(ctx context.Context) error {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case evt := <-r.client.Events:
+				go r.handle(evt)
+			}
+		}
+	}()
+
+	return r.client.RunContext(ctx)
+}
+
+// handle acknowledges the Socket Mode event if it's a request from Slack, and dispatches it.
+func (r SocketModeRunner) handle(evt socketmode.Event) {
+	switch evt.Type {
+	case socketmode.EventTypeConnecting:
+		log.Print("[INFO] Connecting to Slack with Socket Mode")
+	case socketmode.EventTypeConnected:
+		log.Print("[INFO] Connected to Slack with Socket Mode")
+	case socketmode.EventTypeConnectionError:
+		log.Printf("[ERROR] Socket Mode connection error: %v", evt.Data)
+	case socketmode.EventTypeEventsAPI:
+		eventsAPIEvent, ok := evt.Data.(slackevents.EventsAPIEvent)
+		if !ok {
+			log.Printf("[ERROR] Unexpected events_api payload: %T", evt.Data)
+			return
+		}
+		r.ack(evt, nil)
+
+		if err := r.events.handleEventsAPIEvent(eventsAPIEvent); err != nil {
+			log.Println("[ERROR] ", err)
+		}
+	case socketmode.EventTypeInteractive:
+		callback, ok := evt.Data.(slack.InteractionCallback)
+		if !ok {
+			log.Printf("[ERROR] Unexpected interactive payload: %T", evt.Data)
+			return
+		}
+		switch callback.Type {
+		case slack.InteractionTypeBlockSuggestion, slack.InteractionTypeViewSubmission:
+			// The response to these interactions is sent with the acknowledgement.
+			r.ack(evt, r.interactions.handleInteraction(callback))
+		default:
+			r.ack(evt, nil)
+
+			r.interactions.handleInteraction(callback)
+		}
+	case socketmode.EventTypeSlashCommand:
+		cmd, ok := evt.Data.(slack.SlashCommand)
+		if !ok {
+			log.Printf("[ERROR] Unexpected slash command payload: %T", evt.Data)
+			return
+		}
+		r.ack(evt, nil)
+
+		if err := r.events.handleSlashCommand(cmd); err != nil {
+			log.Println("[ERROR] ", err)
+		}
+	}
+}
+
+// ack acknowledges the request of the event.
+// Slack retries the request if it's not acknowledged within 3 seconds,
+// so this must be called before the possibly slow handling of the event.
+func (r SocketModeRunner) ack(evt socketmode.Event, payload interface{}) {
+	if evt.Request == nil {
+		return
+	}
+	if payload == nil {
+		r.client.Ack(*evt.Request)
+		return
+	}
+	r.client.Ack(*evt.Request, payload)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+	"github.com/stretchr/testify/require"
+	"github.com/zaiminc/gocat/i18n"
+)
+
+// TestTransports ensures that the HTTP endpoints and Socket Mode dispatch
+// the same events, interactions and slash commands to the same logic.
+func TestTransports(t *testing.T) {
+	type request struct {
+		path string
+		body string
+	}
+
+	requests := make(chan request, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		requests <- request{path: r.URL.Path, body: string(body)}
+		_, _ = w.Write([]byte(`{"ok": true}`))
+	}))
+	defer ts.Close()
+
+	api := slack.New("token", slack.OptionAPIURL(ts.URL+"/api/"))
+	client := NewSlackClient(api)
+	projectList := &ProjectList{}
+	userList := &UserList{}
+	interactorFactory := NewInteractorFactory(InteractorContext{projectList: projectList, userList: userList, client: client})
+
+	verifier := NewSlackRequestVerifier("", "token")
+	listener := &SlackListener{
+		client:            client,
+		verifier:          verifier,
+		projectList:       projectList,
+		userList:          userList,
+		interactorFactory: &interactorFactory,
+	}
+	interactions := interactionHandler{
+		verifier:          verifier,
+		client:            client,
+		projectList:       projectList,
+		userList:          userList,
+		interactorFactory: &interactorFactory,
+		languages:         NewLanguageList(i18n.English),
+	}
+	slashCommands := slashCommandHandler{verifier: verifier, events: listener}
+	runner := NewSocketModeRunner(socketmode.New(api), listener, interactions)
+
+	mention := `{
+  "token": "token",
+  "type": "event_callback",
+  "event": {"type": "app_mention", "user": "U1234", "text": "<@U0LAN0Z89> help", "channel": "C1234"}
+}`
+	interaction := `{
+  "type": "block_actions",
+  "token": "token",
+  "user": {"id": "U1234"},
+  "response_url": "` + ts.URL + `/response",
+  "actions": [{"type": "button", "block_id": "close", "value": "close"}]
+}`
+
+	slashCommand := url.Values{
+		"token":        {"token"},
+		"command":      {"/gocat"},
+		"text":         {"help unlock"},
+		"user_id":      {"U1234"},
+		"channel_id":   {"C1234"},
+		"response_url": {ts.URL + "/command-response"},
+	}
+
+	type transport struct {
+		name     string
+		mention  func(t *testing.T)
+		interact func(t *testing.T)
+		slash    func(t *testing.T)
+	}
+
+	transports := []transport{
+		{
+			name: "http",
+			mention: func(t *testing.T) {
+				w := httptest.NewRecorder()
+				listener.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(mention)))
+				require.Equal(t, http.StatusOK, w.Code)
+			},
+			interact: func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodPost, "/interaction", strings.NewReader(url.Values{"payload": {interaction}}.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				interactions.ServeHTTP(httptest.NewRecorder(), r)
+			},
+			slash: func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodPost, "/command", strings.NewReader(slashCommand.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w := httptest.NewRecorder()
+				slashCommands.ServeHTTP(w, r)
+				require.Equal(t, http.StatusOK, w.Code)
+			},
+		},
+		{
+			name: "socket",
+			mention: func(t *testing.T) {
+				ev, err := slackevents.ParseEvent(json.RawMessage(mention), slackevents.OptionNoVerifyToken())
+				require.NoError(t, err)
+				runner.handle(socketmode.Event{Type: socketmode.EventTypeEventsAPI, Data: ev, Request: &socketmode.Request{EnvelopeID: "1"}})
+			},
+			interact: func(t *testing.T) {
+				var callback slack.InteractionCallback
+				require.NoError(t, json.Unmarshal([]byte(interaction), &callback))
+				runner.handle(socketmode.Event{Type: socketmode.EventTypeInteractive, Data: callback, Request: &socketmode.Request{EnvelopeID: "2"}})
+			},
+			slash: func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodPost, "/command", strings.NewReader(slashCommand.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				cmd, err := slack.SlashCommandParse(r)
+				require.NoError(t, err)
+				runner.handle(socketmode.Event{Type: socketmode.EventTypeSlashCommand, Data: cmd, Request: &socketmode.Request{EnvelopeID: "3"}})
+			},
+		},
+	}
+
+	next := func(t *testing.T) request {
+		t.Helper()
+		select {
+		case req := <-requests:
+			return req
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a request")
+			return request{}
+		}
+	}
+
+	for _, tr := range transports {
+		t.Run(tr.name, func(t *testing.T) {
+			tr.mention(t)
+			req := next(t)
+			require.Equal(t, "/api/chat.postMessage", req.path)
+			form, err := url.ParseQuery(req.body)
+			require.NoError(t, err)
+			require.Equal(t, "C1234", form.Get("channel"))
+			require.Contains(t, form.Get("blocks"), "masterのデプロイ")
+
+			tr.interact(t)
+			req = next(t)
+			require.Equal(t, "/response", req.path)
+			require.Contains(t, req.body, "closed by <@U1234>")
+
+			// The slash command replies only to the user via the response URL.
+			tr.slash(t)
+			req = next(t)
+			require.Equal(t, "/command-response", req.path)
+			require.Contains(t, req.body, `"response_type":"ephemeral"`)
+			require.Contains(t, req.body, "デプロイロックを解除する")
+		})
+	}
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
-	"github.com/slack-go/slack"
 	v1 "k8s.io/api/core/v1"
 )
 
@@ -21,6 +21,11 @@ const (
 	RoleAdmin     Role = "Admin"
 )
 
+// Valid returns true if the role is one of the known roles.
+func (r Role) Valid() bool {
+	return r == RoleDeveloper || r == RoleAdmin
+}
+
 type User struct {
 	SlackUserID      string
 	SlackDisplayName string
@@ -41,31 +46,55 @@ func (u User) IsAdmin() bool {
 	return u.isAdmin
 }
 
+// HasRole returns true if the user has the role.
+// Admins are considered to have the Developer role as well.
+func (u User) HasRole(r Role) bool {
+	switch r {
+	case RoleDeveloper:
+		return u.isDeveloper || u.isAdmin
+	case RoleAdmin:
+		return u.isAdmin
+	default:
+		return false
+	}
+}
+
 type UserList struct {
 	Items       []User
 	github      GitHub
-	slackClient *slack.Client
+	slackClient SlackClient
+	// source provides the ConfigMaps of the GitHub user mappings and the role bindings on Reload.
+	source ConfigSource
 }
 
-func (ul *UserList) Reload() {
-	ul.Items = []User{}
+// Reload reloads the users from Slack, GitHub and the config source.
+// The users are kept as they are if any of them fails, or if the list has no config source.
+func (ul *UserList) Reload() error {
+	if ul.source == nil {
+		return nil
+	}
 
 	slackUsers, err := ul.slackClient.GetUsers()
 	if err != nil {
-		fmt.Println("[ERROR] Cannot load slack users")
-		return
+		return fmt.Errorf("[ERROR] Cannot load slack users: %w", err)
 	}
 
 	githubUsers, err := ul.github.GetUsers()
 	if err != nil {
-		fmt.Println("[ERROR] Cannot load GitHub users")
-		return
+		return fmt.Errorf("[ERROR] Cannot load GitHub users: %w", err)
 	}
 
-	cml := getConfigMapList("githubuser-mapping")
-	rolebindings := getConfigMapList("rolebinding")
+	cml, err := ul.source.ConfigMaps(context.Background(), "githubuser-mapping")
+	if err != nil {
+		return err
+	}
+	rolebindings, err := ul.source.ConfigMaps(context.Background(), "rolebinding")
+	if err != nil {
+		return err
+	}
 	userNamesInGroups := ul.createUserNamesInGroups(rolebindings)
 
+	items := []User{}
 	for _, slackUser := range slackUsers {
 		if slackUser.IsBot || slackUser.Deleted {
 			continue
@@ -80,8 +109,10 @@ func (ul *UserList) Reload() {
 		user.GitHubNodeID = githubUsers[user.GitHubUserName]
 		_, user.isDeveloper = userNamesInGroups[RoleDeveloper][user.SlackDisplayName]
 		_, user.isAdmin = userNamesInGroups[RoleAdmin][user.SlackDisplayName]
-		ul.Items = append(ul.Items, user)
+		items = append(items, user)
 	}
+	ul.Items = items
+	return nil
 }
 
 func (ul UserList) createUserNamesInGroups(rolebindings *v1.ConfigMapList) map[Role]map[string]struct{} {